@@ -0,0 +1,451 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/dlauncher/launcher"
+	"github.com/streamingfast/dmetrics"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/nodemanager"
+)
+
+var ConfigCmd = &cobra.Command{Use: "config", Short: "Config file related commands"}
+
+var ConfigCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Validates the config and flags of the apps launched by 'fireaptos start' without starting them",
+	Long: string(cli.Description(`
+		Loads the config file (see '--config-file') and network preset (see '--network') exactly like 'fireaptos start'
+		does and validates the flags of each app that would be launched: required values, store URLs being absolute and
+		reachable, listen addresses not conflicting with each other and referenced files and executables existing.
+
+		Every problem found is printed at once and the command exits with a non-zero status code if there is any. Apps
+		are taken from the config file unless provided as arguments, like for 'fireaptos start'.
+	`)),
+	Args: cobra.ArbitraryArgs,
+	RunE: configCheckE,
+	Example: string(cli.ExamplePrefixed("fireaptos config check", `
+		# Apps of the config file
+		--config-file ./firehose.yaml
+
+		# Specific apps only
+		reader-node merger
+	`)),
+}
+
+func init() {
+	RootCmd.AddCommand(ConfigCmd)
+	ConfigCmd.AddCommand(ConfigCheckCmd)
+
+	ConfigCheckCmd.Flags().Duration("store-timeout", 15*time.Second, "Maximum time allowed to list a remote store when checking that it's reachable")
+}
+
+func configCheckE(cmd *cobra.Command, args []string) error {
+	storeTimeout, _ := cmd.Flags().GetDuration("store-timeout")
+
+	if len(args) == 0 && launcher.Config["start"] != nil {
+		args = launcher.Config["start"].Args
+	}
+	apps := launcher.ParseAppsFromArgs(args, func(string) bool { return true })
+	sort.Strings(apps)
+
+	checker := newConfigChecker(viper.GetString("global-data-dir"), storeTimeout)
+	problems := checker.check(cmd.Context(), apps)
+
+	return printConfigProblems(cmd.OutOrStdout(), apps, problems)
+}
+
+func printConfigProblems(out io.Writer, apps []string, problems []*configProblem) error {
+	if len(problems) == 0 {
+		fmt.Fprintf(out, "Config of apps %s is valid\n", strings.Join(apps, ", "))
+		return nil
+	}
+
+	fmt.Fprintf(out, "Found %d problem(s) in config of apps %s:\n", len(problems), strings.Join(apps, ", "))
+	for _, problem := range problems {
+		fmt.Fprintf(out, "  - %s\n", problem)
+	}
+
+	return fmt.Errorf("config check found %d problem(s)", len(problems))
+}
+
+// configProblem is a problem with the value of a flag, reported once even if the flag is
+// used by multiple apps.
+type configProblem struct {
+	Flag    string
+	Apps    []string
+	Message string
+}
+
+func (p *configProblem) String() string {
+	var subject []string
+	if p.Flag != "" {
+		subject = append(subject, "--"+strings.TrimPrefix(p.Flag, "global-"))
+	}
+	if len(p.Apps) > 0 {
+		subject = append(subject, "("+strings.Join(p.Apps, ", ")+")")
+	}
+
+	if len(subject) == 0 {
+		return p.Message
+	}
+
+	return strings.Join(subject, " ") + ": " + p.Message
+}
+
+// appConfigSpec lists the flags of an app that are checked by kind, `validate` running the
+// checks specific to the app.
+type appConfigSpec struct {
+	storeFlags         []string
+	optionalStoreFlags []string
+	listenAddrFlags    []string
+	fileFlags          []string
+	executableFlags    []string
+	validate           func(c *configChecker, app string)
+}
+
+var appConfigSpecs = map[string]*appConfigSpec{
+	"reader-node": {
+		storeFlags:         []string{"common-one-block-store-url"},
+		optionalStoreFlags: []string{"reader-node-backup-store-url", "reader-node-sync-state-store-url"},
+		listenAddrFlags:    []string{"reader-node-grpc-listen-addr", "reader-node-manager-api-addr"},
+		fileFlags:          []string{"reader-node-config-file", "reader-node-genesis-file", "reader-node-waypoint-file", "reader-node-validator-identity-file", "reader-node-vfn-identity-file"},
+		executableFlags:    []string{"reader-node-path"},
+		validate: func(c *configChecker, app string) {
+			c.checkChainID(app)
+
+			if viper.GetString("reader-node-backup-store-url") == "" {
+				for _, flag := range []string{"reader-node-backup-interval", "reader-node-backup-blocks-interval", "reader-node-restore-backup-name"} {
+					if !isZeroFlagValue(viper.GetString(flag)) {
+						c.report(app, flag, "requires --reader-node-backup-store-url to be set")
+					}
+				}
+			}
+
+			if viper.GetBool("reader-node-log-metrics-enabled") {
+				c.checkLogMetrics(app, "reader-node-log-metrics-config-file")
+			}
+
+			for _, flag := range []string{"reader-node-start-time", "reader-node-stop-time"} {
+				if _, err := parseReaderNodeTime(flag); err != nil {
+					c.report(app, flag, err.Error())
+				}
+			}
+		},
+	},
+	"reader-node-stdin": {
+		storeFlags:      []string{"common-one-block-store-url"},
+		listenAddrFlags: []string{"reader-node-grpc-listen-addr"},
+		validate: func(c *configChecker, app string) {
+			if _, err := readerNodeStdinSource(c.dataDir); err != nil {
+				c.report(app, "reader-node-stdin-source-url", err.Error())
+			}
+		},
+	},
+	"merger": {
+		storeFlags:      []string{"common-one-block-store-url", "common-merged-blocks-store-url"},
+		listenAddrFlags: []string{"merger-grpc-listen-addr"},
+	},
+	"relayer": {
+		storeFlags:      []string{"common-one-block-store-url"},
+		listenAddrFlags: []string{"relayer-grpc-listen-addr"},
+		validate: func(c *configChecker, app string) {
+			if len(viper.GetStringSlice("relayer-source")) == 0 {
+				c.report(app, "relayer-source", "at least one source is required")
+			}
+		},
+	},
+	"firehose": {
+		storeFlags:      []string{"common-one-block-store-url", "common-merged-blocks-store-url", "common-forked-blocks-store-url"},
+		listenAddrFlags: []string{"firehose-grpc-listen-addr"},
+		validate: func(c *configChecker, app string) {
+			c.checkChainID(app)
+
+			if viper.GetBool("substreams-enabled") {
+				c.checkStore(app, "substreams-state-store-url", true)
+			}
+		},
+	},
+}
+
+// globalListenAddrFlags are listened on by the process itself whatever the apps launched.
+var globalListenAddrFlags = []string{"global-metrics-listen-addr", "global-pprof-listen-addr", "global-log-level-switcher-listen-addr"}
+
+type configChecker struct {
+	ctx          context.Context
+	dataDir      string
+	storeTimeout time.Duration
+
+	problems    []*configProblem
+	storeErrors map[string]error
+	listenAddrs []*listenAddrUsage
+}
+
+type listenAddrUsage struct {
+	flag string
+	apps []string
+	host string
+	port string
+}
+
+func newConfigChecker(dataDir string, storeTimeout time.Duration) *configChecker {
+	return &configChecker{
+		dataDir:      dataDir,
+		storeTimeout: storeTimeout,
+		storeErrors:  map[string]error{},
+	}
+}
+
+// check validates the flags of `apps` and returns all problems found, in a stable order.
+func (c *configChecker) check(ctx context.Context, apps []string) []*configProblem {
+	c.ctx = ctx
+
+	if len(apps) == 0 {
+		c.report("", "", "no apps to launch, define them under 'start.args' in the config file or as arguments")
+	}
+
+	for _, flag := range globalListenAddrFlags {
+		c.checkListenAddr("", flag)
+	}
+
+	for _, app := range apps {
+		spec, found := appConfigSpecs[app]
+		if !found {
+			if _, registered := launcher.AppRegistry[app]; !registered {
+				c.report(app, "", fmt.Sprintf("unknown app %q", app))
+			}
+			continue
+		}
+
+		for _, flag := range spec.storeFlags {
+			c.checkStore(app, flag, true)
+		}
+		for _, flag := range spec.optionalStoreFlags {
+			c.checkStore(app, flag, false)
+		}
+		for _, flag := range spec.listenAddrFlags {
+			c.checkListenAddr(app, flag)
+		}
+		for _, flag := range spec.fileFlags {
+			c.checkFile(app, flag)
+		}
+		for _, flag := range spec.executableFlags {
+			c.checkExecutable(app, flag)
+		}
+
+		if spec.validate != nil {
+			spec.validate(c, app)
+		}
+	}
+
+	c.checkListenAddrConflicts()
+
+	return c.problems
+}
+
+// report records a problem with `flag`, merging it with the same problem reported by another app.
+func (c *configChecker) report(app, flag, message string) {
+	for _, problem := range c.problems {
+		if problem.Flag == flag && problem.Message == message {
+			if app != "" && !containsString(problem.Apps, app) {
+				problem.Apps = append(problem.Apps, app)
+			}
+			return
+		}
+	}
+
+	problem := &configProblem{Flag: flag, Message: message}
+	if app != "" {
+		problem.Apps = []string{app}
+	}
+	c.problems = append(c.problems, problem)
+}
+
+func (c *configChecker) checkChainID(app string) {
+	chainID := viper.GetInt64("common-chain-id")
+	if chainID < 0 {
+		c.report(app, "common-chain-id", fmt.Sprintf("required, it must be explicitly provided (got %d)", chainID))
+	} else if chainID > math.MaxUint32 {
+		c.report(app, "common-chain-id", fmt.Sprintf("chain ID %d is out of the uint32 range", chainID))
+	}
+}
+
+func (c *configChecker) checkStore(app, flag string, required bool) {
+	storeURL := mustReplaceDataDir(c.dataDir, viper.GetString(flag))
+	if storeURL == "" {
+		if required {
+			c.report(app, flag, "required, a store URL must be provided")
+		}
+		return
+	}
+
+	scheme, storePath, hasScheme := strings.Cut(storeURL, "://")
+	if !hasScheme || scheme == "file" {
+		if !hasScheme {
+			storePath = storeURL
+		}
+
+		if !filepath.IsAbs(storePath) {
+			c.report(app, flag, fmt.Sprintf("relative store URL %q, use an absolute path or one starting with '{data-dir}'", storeURL))
+			return
+		}
+
+		// Local stores are created on startup, only an existing file in the way is a problem
+		if stat, err := os.Stat(storePath); err == nil && !stat.IsDir() {
+			c.report(app, flag, fmt.Sprintf("store path %q exists but is not a directory", storePath))
+		}
+		return
+	}
+
+	err, checked := c.storeErrors[storeURL]
+	if !checked {
+		err = c.listStore(storeURL)
+		c.storeErrors[storeURL] = err
+	}
+
+	if err != nil {
+		c.report(app, flag, fmt.Sprintf("store %q is not reachable: %s", storeURL, err))
+	}
+}
+
+func (c *configChecker) listStore(storeURL string) error {
+	store, err := dstore.NewStore(storeURL, "", "", false)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.storeTimeout)
+	defer cancel()
+
+	_, err = store.ListFiles(ctx, "", 1)
+	return err
+}
+
+func (c *configChecker) checkListenAddr(app, flag string) {
+	addr := strings.TrimSuffix(viper.GetString(flag), "*")
+	if addr == "" {
+		return
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		c.report(app, flag, fmt.Sprintf("invalid listen address %q: %s", addr, err))
+		return
+	}
+
+	for _, usage := range c.listenAddrs {
+		if usage.flag == flag {
+			usage.apps = append(usage.apps, app)
+			return
+		}
+	}
+
+	c.listenAddrs = append(c.listenAddrs, &listenAddrUsage{flag: flag, apps: []string{app}, host: host, port: port})
+}
+
+func (c *configChecker) checkListenAddrConflicts() {
+	for i, usage := range c.listenAddrs {
+		for _, other := range c.listenAddrs[i+1:] {
+			if !listenAddrsConflict(usage, other) {
+				continue
+			}
+
+			for _, app := range other.apps {
+				c.report(app, other.flag, fmt.Sprintf("listen address %q conflicts with --%s", viper.GetString(other.flag), strings.TrimPrefix(usage.flag, "global-")))
+			}
+		}
+	}
+}
+
+func listenAddrsConflict(left, right *listenAddrUsage) bool {
+	// Port 0 picks a random available port
+	if left.port != right.port || left.port == "0" {
+		return false
+	}
+
+	return isWildcardHost(left.host) || isWildcardHost(right.host) || normalizeHost(left.host) == normalizeHost(right.host)
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+func normalizeHost(host string) string {
+	if host == "localhost" {
+		return "127.0.0.1"
+	}
+
+	return host
+}
+
+// checkLogMetrics loads the log metrics config file and creates its metrics in a throwaway set,
+// catching invalid patterns and types.
+func (c *configChecker) checkLogMetrics(app, flag string) {
+	logMetrics, err := nodemanager.LoadLogMetrics(mustReplaceDataDir(c.dataDir, viper.GetString(flag)))
+	if err == nil {
+		_, err = nodemanager.NewLogMetricsPlugin(dmetrics.NewSet(), logMetrics)
+	}
+
+	if err != nil {
+		c.report(app, flag, err.Error())
+	}
+}
+
+func (c *configChecker) checkFile(app, flag string) {
+	file, _, err := splitChecksumPin(mustReplaceDataDir(c.dataDir, viper.GetString(flag)))
+	if err != nil {
+		c.report(app, flag, err.Error())
+		return
+	}
+
+	if file == "" || strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://") {
+		return
+	}
+
+	if strings.HasPrefix(file, networkFileScheme) {
+		if _, err := networksFS.ReadFile(path.Join("networks", strings.TrimPrefix(file, networkFileScheme))); err != nil {
+			c.report(app, flag, fmt.Sprintf("built-in network file %q does not exist", file))
+		}
+		return
+	}
+
+	stat, err := os.Stat(file)
+	switch {
+	case os.IsNotExist(err):
+		c.report(app, flag, fmt.Sprintf("file %q does not exist", file))
+	case err != nil:
+		c.report(app, flag, fmt.Sprintf("unable to check file %q: %s", file, err))
+	case stat.IsDir():
+		c.report(app, flag, fmt.Sprintf("file %q is a directory", file))
+	}
+}
+
+func (c *configChecker) checkExecutable(app, flag string) {
+	executable := viper.GetString(flag)
+	if executable == "" {
+		c.report(app, flag, "required, an executable must be provided")
+		return
+	}
+
+	if _, err := exec.LookPath(executable); err != nil {
+		c.report(app, flag, fmt.Sprintf("executable %q not found: %s", executable, err))
+	}
+}
+
+func isZeroFlagValue(value string) bool {
+	return value == "" || value == "0" || value == "0s"
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigChecker(t *testing.T) {
+	dataDir := t.TempDir()
+	genesisFile := filepath.Join(dataDir, "genesis.blob")
+	require.NoError(t, os.WriteFile(genesisFile, []byte("genesis"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "merged"), []byte("not a directory"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "log-metrics.yaml"), []byte("metrics: [{name: peers, type: gauge, pattern: 'peers'}]"), 0644))
+
+	validFlags := map[string]interface{}{
+		"common-chain-id":                "1",
+		"common-one-block-store-url":     "file://{data-dir}/storage/one-blocks",
+		"common-merged-blocks-store-url": "{data-dir}/storage/merged-blocks",
+		"common-forked-blocks-store-url": "file://{data-dir}/storage/forked-blocks",
+		"reader-node-path":               "go",
+		"reader-node-config-file":        "network://mainnet/full_node.yaml",
+		"reader-node-genesis-file":       "{data-dir}/genesis.blob",
+		"reader-node-waypoint-file":      "https://example.com/waypoint.txt",
+		"reader-node-grpc-listen-addr":   ":18010",
+		"reader-node-manager-api-addr":   ":18011",
+		"merger-grpc-listen-addr":        ":18012",
+		"relayer-grpc-listen-addr":       ":18014",
+		"relayer-source":                 []string{":18010"},
+		"firehose-grpc-listen-addr":      ":18015",
+		"substreams-enabled":             "true",
+		"substreams-state-store-url":     "{data-dir}/substreams",
+		"global-metrics-listen-addr":     ":9102",
+		"global-pprof-listen-addr":       "localhost:6060",
+	}
+
+	tests := []struct {
+		name             string
+		apps             []string
+		flags            map[string]interface{}
+		expectedProblems []string
+	}{
+		{
+			"valid",
+			[]string{"firehose", "merger", "reader-node", "relayer"},
+			nil,
+			nil,
+		},
+		{
+			"no apps",
+			nil,
+			nil,
+			[]string{"no apps to launch, define them under 'start.args' in the config file or as arguments"},
+		},
+		{
+			"required values",
+			[]string{"firehose", "reader-node"},
+			map[string]interface{}{
+				"common-chain-id":             "-1",
+				"substreams-state-store-url":  "",
+				"reader-node-path":            "",
+				"reader-node-backup-interval": "1h",
+			},
+			[]string{
+				"--common-chain-id (firehose, reader-node): required, it must be explicitly provided (got -1)",
+				"--substreams-state-store-url (firehose): required, a store URL must be provided",
+				"--reader-node-path (reader-node): required, an executable must be provided",
+				"--reader-node-backup-interval (reader-node): requires --reader-node-backup-store-url to be set",
+			},
+		},
+		{
+			"invalid stores",
+			[]string{"firehose", "merger"},
+			map[string]interface{}{
+				"common-chain-id":                "4294967296",
+				"common-one-block-store-url":     "./storage/one-blocks",
+				"common-merged-blocks-store-url": "{data-dir}/merged",
+				"common-forked-blocks-store-url": "ftp://example.com/forked-blocks",
+			},
+			[]string{
+				`--common-one-block-store-url (firehose, merger): relative store URL "./storage/one-blocks", use an absolute path or one starting with '{data-dir}'`,
+				`--common-merged-blocks-store-url (firehose, merger): store path "` + filepath.Join(dataDir, "merged") + `" exists but is not a directory`,
+				`--common-forked-blocks-store-url (firehose): store "ftp://example.com/forked-blocks" is not reachable: archive store only supports, file://, gs:// or local path`,
+				"--common-chain-id (firehose): chain ID 4294967296 is out of the uint32 range",
+			},
+		},
+		{
+			"listen address conflicts",
+			[]string{"merger", "reader-node", "relayer"},
+			map[string]interface{}{
+				"merger-grpc-listen-addr":      "0.0.0.0:18010",
+				"relayer-grpc-listen-addr":     "localhost:6060",
+				"reader-node-manager-api-addr": "18011",
+			},
+			[]string{
+				`--reader-node-manager-api-addr (reader-node): invalid listen address "18011": address 18011: missing port in address`,
+				`--relayer-grpc-listen-addr (relayer): listen address "localhost:6060" conflicts with --pprof-listen-addr`,
+				`--reader-node-grpc-listen-addr (reader-node): listen address ":18010" conflicts with --merger-grpc-listen-addr`,
+			},
+		},
+		{
+			"missing files",
+			[]string{"reader-node"},
+			map[string]interface{}{
+				"reader-node-path":              "fireaptos-test-unknown-executable",
+				"reader-node-config-file":       "network://localnet/full_node.yaml",
+				"reader-node-genesis-file":      "{data-dir}/missing.blob",
+				"reader-node-waypoint-file":     "{data-dir}#sha256:abc",
+				"reader-node-vfn-identity-file": "{data-dir}",
+			},
+			[]string{
+				`--reader-node-config-file (reader-node): built-in network file "network://localnet/full_node.yaml" does not exist`,
+				`--reader-node-genesis-file (reader-node): file "` + filepath.Join(dataDir, "missing.blob") + `" does not exist`,
+				`--reader-node-waypoint-file (reader-node): invalid checksum pin "` + dataDir + `#sha256:abc", expecting '#sha256:<64 hex characters>'`,
+				`--reader-node-vfn-identity-file (reader-node): file "` + dataDir + `" is a directory`,
+				`--reader-node-path (reader-node): executable "fireaptos-test-unknown-executable" not found: exec: "fireaptos-test-unknown-executable": executable file not found in $PATH`,
+			},
+		},
+		{
+			"invalid log metrics",
+			[]string{"reader-node"},
+			map[string]interface{}{
+				"reader-node-log-metrics-enabled":     "true",
+				"reader-node-log-metrics-config-file": "{data-dir}/log-metrics.yaml",
+			},
+			[]string{`--reader-node-log-metrics-config-file (reader-node): log metric "peers": gauge pattern must have a "value" named group`},
+		},
+		{
+			"invalid bounds",
+			[]string{"reader-node"},
+			map[string]interface{}{
+				"reader-node-start-time": "2022-10-12T00:00:00Z",
+				"reader-node-stop-time":  "2022-10-13",
+			},
+			[]string{`--reader-node-stop-time (reader-node): invalid time "2022-10-13", expecting an RFC3339 timestamp like '2022-10-12T00:00:00Z'`},
+		},
+		{
+			"invalid stdin source",
+			[]string{"reader-node-stdin"},
+			map[string]interface{}{"reader-node-stdin-source-url": "http://localhost:9000"},
+			[]string{`--reader-node-stdin-source-url (reader-node-stdin): invalid log source "http://localhost:9000", scheme must be one of 'stdin', 'file', 'fifo', 'unix' or 'tcp'`},
+		},
+		{
+			"unknown app",
+			[]string{"indexer"},
+			nil,
+			[]string{`(indexer): unknown app "indexer"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer viper.Reset()
+			for flag, value := range validFlags {
+				viper.Set(flag, value)
+			}
+			for flag, value := range test.flags {
+				viper.Set(flag, value)
+			}
+
+			problems := newConfigChecker(dataDir, time.Second).check(context.Background(), test.apps)
+
+			var actual []string
+			for _, problem := range problems {
+				actual = append(actual, problem.String())
+			}
+			assert.Equal(t, test.expectedProblems, actual)
+		})
+	}
+}
+
+func TestPrintConfigProblems(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	require.NoError(t, printConfigProblems(out, []string{"merger"}, nil))
+	assert.Equal(t, "Config of apps merger is valid\n", out.String())
+
+	out.Reset()
+	problems := []*configProblem{
+		{Flag: "common-chain-id", Apps: []string{"firehose", "reader-node"}, Message: "required"},
+		{Flag: "global-metrics-listen-addr", Message: "invalid"},
+	}
+	assert.EqualError(t, printConfigProblems(out, []string{"firehose", "reader-node"}, problems), "config check found 2 problem(s)")
+	assert.Equal(t, "Found 2 problem(s) in config of apps firehose, reader-node:\n  - --common-chain-id (firehose, reader-node): required\n  - --metrics-listen-addr: invalid\n", out.String())
+}
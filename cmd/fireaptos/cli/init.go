@@ -0,0 +1,396 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/firehose-aptos/internal/fileutil"
+)
+
+var InitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Scaffolds a working 'fireaptos' deployment in the current directory",
+	Long: string(cli.Description(`
+		Writes the config file (see '--config-file') starting the selected apps, the aptos-node config template used by
+		the reader node and creates the data directories. The selected network preset (see '--network') provides the
+		chain ID, genesis and waypoint files as well as the initial aptos-node config.
+
+		Values not provided through flags are asked interactively unless '--non-interactive' is used, in which case
+		defaults are used.
+	`)),
+	Args: cobra.NoArgs,
+	RunE: initE,
+	Example: string(cli.ExamplePrefixed("fireaptos init", `
+		# Interactively
+		--network testnet
+
+		# Non-interactively, reading Firehose logs from standard input instead of managing aptos-node
+		--network mainnet --reader reader-node-stdin --non-interactive
+	`)),
+}
+
+func init() {
+	RootCmd.AddCommand(InitCmd)
+
+	InitCmd.Flags().String("reader", "reader-node", "Reader app to use, either 'reader-node' (managing an aptos-node process) or 'reader-node-stdin' (reading Firehose logs from standard input)")
+	InitCmd.Flags().StringSlice("apps", []string{"merger", "relayer", "firehose"}, "Apps to start alongside the reader, any of 'merger', 'relayer' and 'firehose'")
+	InitCmd.Flags().Int64("chain-id", -1, "Chain ID of the network, required for networks whose preset doesn't define it (devnet)")
+	InitCmd.Flags().Bool("non-interactive", false, "Do not ask for values not provided through flags, using defaults instead")
+	InitCmd.Flags().Bool("force", false, "Overwrite the config file and aptos-node config template if they already exist")
+}
+
+const (
+	initNodeConfigFile = "aptos-node.yaml"
+	initDefaultNetwork = "mainnet"
+)
+
+var initReaderApps = []string{"reader-node", "reader-node-stdin"}
+var initOptionalApps = []string{"merger", "relayer", "firehose"}
+
+type initOptions struct {
+	ConfigFile string
+	DataDir    string
+	Network    string
+	ChainID    int64
+	Reader     string
+	Apps       []string
+	Force      bool
+}
+
+func initE(cmd *cobra.Command, args []string) error {
+	// Flags of this command are read directly, their names being too generic to be bound in viper
+	chainID, _ := cmd.Flags().GetInt64("chain-id")
+	reader, _ := cmd.Flags().GetString("reader")
+	apps, _ := cmd.Flags().GetStringSlice("apps")
+	force, _ := cmd.Flags().GetBool("force")
+	nonInteractive, _ := cmd.Flags().GetBool("non-interactive")
+
+	options := &initOptions{
+		ConfigFile: viper.GetString("global-config-file"),
+		DataDir:    viper.GetString("global-data-dir"),
+		Network:    viper.GetString("global-network"),
+		ChainID:    chainID,
+		Reader:     reader,
+		Apps:       apps,
+		Force:      force,
+	}
+
+	if options.ConfigFile == "" {
+		return fmt.Errorf("the --config-file flag is required")
+	}
+
+	if !nonInteractive {
+		prompter := &initPrompter{in: bufio.NewReader(cmd.InOrStdin()), out: cmd.OutOrStdout()}
+		if err := prompter.prompt(options, cmd.Flags().Changed); err != nil {
+			return err
+		}
+	}
+
+	if options.Network == "" {
+		options.Network = initDefaultNetwork
+	}
+
+	return initDeployment(options, cmd.OutOrStdout())
+}
+
+// initDeployment validates the options and writes the deployment's files, printing the next
+// steps to `out`.
+func initDeployment(options *initOptions, out io.Writer) error {
+	preset, err := loadNetworkPreset(options.Network)
+	if err != nil {
+		return err
+	}
+
+	if !containsString(initReaderApps, options.Reader) {
+		return fmt.Errorf("invalid reader %q, valid readers are %s", options.Reader, strings.Join(initReaderApps, ", "))
+	}
+
+	for _, app := range options.Apps {
+		if !containsString(initOptionalApps, app) {
+			return fmt.Errorf("invalid app %q, valid apps are %s", app, strings.Join(initOptionalApps, ", "))
+		}
+	}
+
+	if _, found := preset.Flags["common-chain-id"]; !found && options.ChainID < 0 {
+		return fmt.Errorf("network %q preset has no chain ID, it must be provided with --chain-id", options.Network)
+	}
+
+	// Paths are written absolute in the config file, 'fireaptos start' possibly running from another directory
+	dataDir, err := filepath.Abs(options.DataDir)
+	if err != nil {
+		return fmt.Errorf("invalid data directory: %w", err)
+	}
+
+	configDir, err := filepath.Abs(filepath.Dir(options.ConfigFile))
+	if err != nil {
+		return fmt.Errorf("invalid config file directory: %w", err)
+	}
+
+	nodeConfigFile := filepath.Join(configDir, initNodeConfigFile)
+	managedNode := options.Reader == "reader-node"
+
+	toWrite := []string{options.ConfigFile}
+	if managedNode {
+		toWrite = append(toWrite, nodeConfigFile)
+	}
+
+	if !options.Force {
+		for _, file := range toWrite {
+			if _, err := os.Stat(file); err == nil {
+				return fmt.Errorf("file %q already exists, use --force to overwrite it", file)
+			}
+		}
+	}
+
+	config, err := renderInitConfig(options, dataDir, nodeConfigFile, managedNode)
+	if err != nil {
+		return err
+	}
+
+	if err := makeDirs([]string{configDir}); err != nil {
+		return err
+	}
+
+	if err := fileutil.WriteFileAtomically(options.ConfigFile, config); err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	fmt.Fprintf(out, "Wrote config file %s\n", options.ConfigFile)
+
+	if managedNode {
+		nodeConfig, err := readNodeConfigFile(fmt.Sprintf("%s%s/full_node.yaml", networkFileScheme, options.Network))
+		if err != nil {
+			return err
+		}
+
+		if err := fileutil.WriteFileAtomically(nodeConfigFile, nodeConfig); err != nil {
+			return fmt.Errorf("write aptos-node config template: %w", err)
+		}
+		fmt.Fprintf(out, "Wrote aptos-node config template %s\n", nodeConfigFile)
+	}
+
+	dataDirs := []string{dataDir, filepath.Join(dataDir, "storage")}
+	if err := makeDirs(dataDirs); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Created data directory %s\n", dataDir)
+
+	_, lookPathErr := exec.LookPath(ChainExecutableName)
+	nodeMissing := managedNode && lookPathErr != nil
+	if nodeMissing {
+		fmt.Fprintf(out, "\nWarning: '%s' was not found in your PATH, the reader node cannot start until it's installed\n", ChainExecutableName)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Next steps:")
+
+	step := 1
+	if managedNode {
+		if nodeMissing {
+			fmt.Fprintf(out, "  %d. Install the Firehose instrumented '%s' binary in your PATH\n", step, ChainExecutableName)
+			step++
+		}
+
+		fmt.Fprintf(out, "  %d. Review the aptos-node config template %s (seeds, ports, pruning, etc.)\n", step, nodeConfigFile)
+		step++
+	}
+
+	startCommand := "fireaptos start"
+	if options.ConfigFile != "./firehose.yaml" && options.ConfigFile != "firehose.yaml" {
+		startCommand += " --config-file " + options.ConfigFile
+	}
+
+	if options.Reader == "reader-node-stdin" {
+		fmt.Fprintf(out, "  %d. Pipe the Firehose logs of an instrumented %s in: %s <args> | %s\n", step, ChainExecutableName, ChainExecutableName, startCommand)
+	} else {
+		fmt.Fprintf(out, "  %d. Start the deployment: %s\n", step, startCommand)
+	}
+
+	return nil
+}
+
+var initConfigTemplate = template.Must(template.New("firehose.yaml").Parse(`# Generated by 'fireaptos init', see 'fireaptos start --help' for all available flags
+start:
+  args:
+{{- range .Apps }}
+  - {{ . }}
+{{- end }}
+  flags:
+    # Network preset providing the chain ID, first streamable block, genesis and waypoint files
+    network: {{ .Network }}
+    data-dir: "{{ .DataDir }}"
+{{- if ge .ChainID 0 }}
+    common-chain-id: {{ .ChainID }}
+{{- end }}
+{{- if .ManagedNode }}
+    # Rendered as a Go template by the reader node before being passed to aptos-node
+    reader-node-config-file: "{{ .NodeConfigFile }}"
+{{- end }}
+{{- if .Firehose }}
+    substreams-enabled: true
+    substreams-state-store-url: "{data-dir}/substreams/states"
+{{- end }}
+`))
+
+func renderInitConfig(options *initOptions, dataDir string, nodeConfigFile string, managedNode bool) ([]byte, error) {
+	apps := append([]string{options.Reader}, options.Apps...)
+
+	buffer := bytes.NewBuffer(nil)
+	err := initConfigTemplate.Execute(buffer, map[string]interface{}{
+		"Apps":           apps,
+		"Network":        options.Network,
+		"DataDir":        dataDir,
+		"ChainID":        options.ChainID,
+		"ManagedNode":    managedNode,
+		"NodeConfigFile": nodeConfigFile,
+		"Firehose":       containsString(options.Apps, "firehose"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("render config file: %w", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// initPrompter asks for the init options that were not provided explicitly through flags.
+type initPrompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (p *initPrompter) prompt(options *initOptions, provided func(flag string) bool) (err error) {
+	if options.Network == "" {
+		if options.Network, err = p.choice("Network", knownNetworks(), initDefaultNetwork); err != nil {
+			return err
+		}
+	}
+
+	if !provided("chain-id") {
+		if preset, err := loadNetworkPreset(options.Network); err == nil {
+			if _, found := preset.Flags["common-chain-id"]; !found {
+				if options.ChainID, err = p.integer(fmt.Sprintf("Chain ID of %s", options.Network)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if !provided("reader") {
+		if options.Reader, err = p.choice("Reader", initReaderApps, options.Reader); err != nil {
+			return err
+		}
+	}
+
+	if !provided("apps") {
+		var apps []string
+		for _, app := range initOptionalApps {
+			selected, err := p.confirm(fmt.Sprintf("Start %s", app), containsString(options.Apps, app))
+			if err != nil {
+				return err
+			}
+
+			if selected {
+				apps = append(apps, app)
+			}
+		}
+		options.Apps = apps
+	}
+
+	return nil
+}
+
+func (p *initPrompter) ask(question string) (string, error) {
+	fmt.Fprintf(p.out, "%s: ", question)
+
+	line, err := p.in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("no answer provided for %q, use --non-interactive to use defaults", question)
+		}
+
+		return "", fmt.Errorf("read answer: %w", err)
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+func (p *initPrompter) choice(question string, choices []string, defaultChoice string) (string, error) {
+	for {
+		answer, err := p.ask(fmt.Sprintf("%s (%s) [%s]", question, strings.Join(choices, "/"), defaultChoice))
+		if err != nil {
+			return "", err
+		}
+
+		if answer == "" {
+			return defaultChoice, nil
+		}
+
+		if containsString(choices, answer) {
+			return answer, nil
+		}
+
+		fmt.Fprintf(p.out, "Invalid choice %q\n", answer)
+	}
+}
+
+func (p *initPrompter) confirm(question string, defaultValue bool) (bool, error) {
+	defaultAnswer := "y/N"
+	if defaultValue {
+		defaultAnswer = "Y/n"
+	}
+
+	for {
+		answer, err := p.ask(fmt.Sprintf("%s? [%s]", question, defaultAnswer))
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "":
+			return defaultValue, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+
+		fmt.Fprintf(p.out, "Invalid answer %q, expecting 'y' or 'n'\n", answer)
+	}
+}
+
+func (p *initPrompter) integer(question string) (int64, error) {
+	for {
+		answer, err := p.ask(question)
+		if err != nil {
+			return 0, err
+		}
+
+		value, err := strconv.ParseInt(answer, 10, 64)
+		if err == nil && value >= 0 {
+			return value, nil
+		}
+
+		fmt.Fprintf(p.out, "Invalid value %q, expecting a positive integer\n", answer)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
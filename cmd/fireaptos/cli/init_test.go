@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/dlauncher/launcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitDeployment(t *testing.T) {
+	tests := []struct {
+		name            string
+		options         initOptions
+		expectedArgs    []string
+		expectedFlags   map[string]string
+		expectNodeFile  bool
+		expectedOutputs []string
+	}{
+		{
+			"managed reader with all apps",
+			initOptions{Network: "mainnet", ChainID: -1, Reader: "reader-node", Apps: []string{"merger", "relayer", "firehose"}},
+			[]string{"reader-node", "merger", "relayer", "firehose"},
+			map[string]string{
+				"network":                    "mainnet",
+				"reader-node-config-file":    "{dir}/aptos-node.yaml",
+				"substreams-enabled":         "true",
+				"substreams-state-store-url": "{data-dir}/substreams/states",
+				"data-dir":                   "{dir}/firehose-data",
+			},
+			true,
+			[]string{"Review the aptos-node config template", "Start the deployment: fireaptos start --config-file"},
+		},
+		{
+			"stdin reader on devnet",
+			initOptions{Network: "devnet", ChainID: 47, Reader: "reader-node-stdin", Apps: []string{"merger"}},
+			[]string{"reader-node-stdin", "merger"},
+			map[string]string{
+				"network":         "devnet",
+				"common-chain-id": "47",
+				"data-dir":        "{dir}/firehose-data",
+			},
+			false,
+			[]string{"Pipe the Firehose logs of an instrumented aptos-node in"},
+		},
+	}
+
+	cmd := &cobra.Command{}
+	cmd.PersistentFlags().String("network", "", "")
+	cmd.PersistentFlags().String("data-dir", "", "")
+	require.NoError(t, launcher.RegisterFlags(rootLog, cmd))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			options := test.options
+			options.ConfigFile = filepath.Join(dir, "firehose.yaml")
+			options.DataDir = filepath.Join(dir, "firehose-data")
+
+			out := bytes.NewBuffer(nil)
+			require.NoError(t, initDeployment(&options, out))
+
+			require.NoError(t, launcher.LoadConfigFile(options.ConfigFile))
+			startConfig := launcher.Config["start"]
+			require.NotNil(t, startConfig)
+			assert.Equal(t, test.expectedArgs, startConfig.Args)
+			// Generated paths are absolute, `{dir}` standing for the deployment's directory
+			expectedFlags := map[string]string{}
+			for flag, value := range test.expectedFlags {
+				expectedFlags[flag] = strings.ReplaceAll(value, "{dir}", dir)
+			}
+			assert.Equal(t, expectedFlags, startConfig.Flags)
+
+			// Every generated flag must be a flag known by 'fireaptos start'
+			for flag := range startConfig.Flags {
+				known := cmd.Flags().Lookup(flag) != nil || cmd.PersistentFlags().Lookup(flag) != nil
+				assert.True(t, known, "unknown flag %q", flag)
+			}
+
+			nodeConfigFile := filepath.Join(dir, "aptos-node.yaml")
+			if test.expectNodeFile {
+				content, err := readNodeConfigFile(nodeConfigFile)
+				require.NoError(t, err)
+
+				_, err = renderNodeConfig(content, &nodeConfigValues{DataDir: "/data", GenesisFile: "/data/genesis.blob", WaypointFile: "/data/waypoint.txt", P2PPort: ReaderNodeP2PPort, RPCPort: ReaderNodeRPCPort})
+				require.NoError(t, err)
+			} else {
+				assert.NoFileExists(t, nodeConfigFile)
+			}
+
+			assert.DirExists(t, filepath.Join(dir, "firehose-data", "storage"))
+			for _, expected := range test.expectedOutputs {
+				assert.Contains(t, out.String(), expected)
+			}
+		})
+	}
+}
+
+func TestInitDeployment_Errors(t *testing.T) {
+	dir := t.TempDir()
+	newOptions := func() *initOptions {
+		return &initOptions{ConfigFile: filepath.Join(dir, "firehose.yaml"), DataDir: filepath.Join(dir, "data"), Network: "mainnet", ChainID: -1, Reader: "reader-node"}
+	}
+
+	options := newOptions()
+	options.Network = "devnet"
+	assert.ErrorContains(t, initDeployment(options, bytes.NewBuffer(nil)), `network "devnet" preset has no chain ID, it must be provided with --chain-id`)
+
+	options = newOptions()
+	options.Reader = "reader"
+	assert.ErrorContains(t, initDeployment(options, bytes.NewBuffer(nil)), `invalid reader "reader"`)
+
+	options = newOptions()
+	options.Apps = []string{"firehose", "indexer"}
+	assert.ErrorContains(t, initDeployment(options, bytes.NewBuffer(nil)), `invalid app "indexer"`)
+
+	require.NoError(t, initDeployment(newOptions(), bytes.NewBuffer(nil)))
+	assert.ErrorContains(t, initDeployment(newOptions(), bytes.NewBuffer(nil)), "already exists, use --force to overwrite it")
+
+	options = newOptions()
+	options.Force = true
+	require.NoError(t, initDeployment(options, bytes.NewBuffer(nil)))
+}
+
+func TestInitPrompter(t *testing.T) {
+	// Invalid answers are asked again, empty answers take the default
+	input := strings.Join([]string{"localnet", "devnet", "abc", "47", "reader-node-stdin", "", "maybe", "n", "y"}, "\n") + "\n"
+
+	out := bytes.NewBuffer(nil)
+	prompter := &initPrompter{in: bufio.NewReader(strings.NewReader(input)), out: out}
+
+	options := &initOptions{ChainID: -1, Reader: "reader-node", Apps: []string{"merger", "relayer", "firehose"}}
+	require.NoError(t, prompter.prompt(options, func(string) bool { return false }))
+
+	assert.Equal(t, "devnet", options.Network)
+	assert.Equal(t, int64(47), options.ChainID)
+	assert.Equal(t, "reader-node-stdin", options.Reader)
+	assert.Equal(t, []string{"merger", "firehose"}, options.Apps)
+	assert.Contains(t, out.String(), `Invalid choice "localnet"`)
+	assert.Contains(t, out.String(), `Invalid value "abc"`)
+	assert.Contains(t, out.String(), `Invalid answer "maybe"`)
+
+	// Values provided through flags are not asked, a closed input is reported
+	prompter = &initPrompter{in: bufio.NewReader(strings.NewReader("")), out: bytes.NewBuffer(nil)}
+	options = &initOptions{Network: "mainnet", ChainID: -1, Reader: "reader-node"}
+	require.NoError(t, prompter.prompt(options, func(flag string) bool { return flag == "reader" || flag == "apps" }))
+
+	options = &initOptions{ChainID: -1, Reader: "reader-node"}
+	assert.ErrorContains(t, prompter.prompt(options, func(string) bool { return false }), "use --non-interactive to use defaults")
+}
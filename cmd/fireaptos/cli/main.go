@@ -34,6 +34,11 @@ func Main() {
 	RootCmd.PersistentFlags().StringP("data-dir", "d", "./firehose-data", "Path to data storage for all components of the Firehose stack")
 	RootCmd.PersistentFlags().StringP("config-file", "c", "./firehose.yaml", "Configuration file to use. No config file loaded if set to an empty string.")
 
+	RootCmd.PersistentFlags().String("network", "", FlagDescription(`
+		If non-empty, applies the built-in preset of this network (one of %s), filling in the chain ID, first streamable
+		block, genesis and waypoint files and a public full node config template for the reader node. Any flag of the
+		preset can be overridden by providing it explicitly or in the config file. When set, the config file is optional.
+	`, strings.Join(knownNetworks(), ", ")))
 	RootCmd.PersistentFlags().String("log-format", "text", "Format for logging to stdout. Either 'text' or 'stackdriver'")
 	RootCmd.PersistentFlags().Bool("log-to-file", false, "Also write logs to {data-dir}/firehose.log.json ")
 	RootCmd.PersistentFlags().String("log-level-switcher-listen-addr", "localhost:1065", FlagDescription(`
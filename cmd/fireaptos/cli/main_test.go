@@ -0,0 +1,19 @@
+package cli
+
+import (
+	"os"
+	"testing"
+)
+
+// testRunCLIEnv makes the test binary run the 'fireaptos' command line instead of the tests, so
+// tests can launch it as a child process (along with the processes it launches itself).
+const testRunCLIEnv = "FIREAPTOS_TEST_RUN_CLI"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testRunCLIEnv) == "true" {
+		Main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
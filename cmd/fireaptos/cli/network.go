@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// networksFS holds the built-in network presets, one directory per network containing
+// a `network.yaml` file listing the flags to apply and the files it references.
+//
+//go:embed networks
+var networksFS embed.FS
+
+// networkFileScheme prefixes paths referencing a file of a built-in network preset, like
+// `network://mainnet/full_node.yaml`.
+const networkFileScheme = "network://"
+
+type networkPreset struct {
+	Name  string                 `yaml:"-"`
+	Flags map[string]interface{} `yaml:"flags"`
+}
+
+func knownNetworks() []string {
+	entries, err := fs.ReadDir(networksFS, "networks")
+	if err != nil {
+		panic(fmt.Errorf("read embedded networks: %w", err))
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func loadNetworkPreset(name string) (*networkPreset, error) {
+	content, err := networksFS.ReadFile(path.Join("networks", name, "network.yaml"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("unknown network %q, valid networks are %s", name, strings.Join(knownNetworks(), ", "))
+		}
+
+		return nil, fmt.Errorf("read network %q preset: %w", name, err)
+	}
+
+	preset := &networkPreset{Name: name}
+	if err := yaml.Unmarshal(content, preset); err != nil {
+		return nil, fmt.Errorf("unmarshal network %q preset: %w", name, err)
+	}
+
+	return preset, nil
+}
+
+// applyNetworkPreset sets the flags of the network preset as viper defaults, so flags explicitly
+// provided on the command line still take precedence. Flags in `configured` (the ones set by
+// the config file, which are also viper defaults) are left untouched.
+func applyNetworkPreset(name string, knownFlags map[string]bool, configured map[string]bool) error {
+	preset, err := loadNetworkPreset(name)
+	if err != nil {
+		return err
+	}
+
+	for flag, value := range preset.Flags {
+		if !knownFlags[flag] {
+			return fmt.Errorf("network %q preset defines unknown flag %q", name, flag)
+		}
+
+		if configured[flag] {
+			rootLog.Debug("network preset flag overridden by config file", zap.String("network", name), zap.String("flag", flag))
+			continue
+		}
+
+		viper.SetDefault(flag, value)
+	}
+
+	return nil
+}
+
+// readNodeConfigFile reads the node's config file, which is either a regular path or a
+// file of a built-in network preset.
+func readNodeConfigFile(configFile string) ([]byte, error) {
+	if strings.HasPrefix(configFile, networkFileScheme) {
+		content, err := networksFS.ReadFile(path.Join("networks", strings.TrimPrefix(configFile, networkFileScheme)))
+		if err != nil {
+			return nil, fmt.Errorf("read built-in network file %q: %w", configFile, err)
+		}
+
+		return content, nil
+	}
+
+	return os.ReadFile(configFile)
+}
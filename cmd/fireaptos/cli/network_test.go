@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/streamingfast/dlauncher/launcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkPresets(t *testing.T) {
+	assert.Equal(t, []string{"devnet", "mainnet", "testnet"}, knownNetworks())
+
+	cmd := &cobra.Command{}
+	require.NoError(t, launcher.RegisterFlags(rootLog, cmd))
+
+	for _, network := range knownNetworks() {
+		t.Run(network, func(t *testing.T) {
+			preset, err := loadNetworkPreset(network)
+			require.NoError(t, err)
+
+			for flag := range preset.Flags {
+				assert.NotNil(t, cmd.Flags().Lookup(flag), "unknown flag %q", flag)
+			}
+
+			configFile, ok := preset.Flags["reader-node-config-file"].(string)
+			require.True(t, ok)
+
+			content, err := readNodeConfigFile(configFile)
+			require.NoError(t, err)
+
+			_, err = renderNodeConfig(content, &nodeConfigValues{
+				DataDir:      "/data/reader/data",
+				GenesisFile:  "/data/reader/data/genesis.blob",
+				WaypointFile: "/data/reader/data/waypoint.txt",
+				P2PPort:      ReaderNodeP2PPort,
+				RPCPort:      ReaderNodeRPCPort,
+			})
+			require.NoError(t, err)
+		})
+	}
+
+	_, err := loadNetworkPreset("unknown")
+	assert.ErrorContains(t, err, `unknown network "unknown", valid networks are devnet, mainnet, testnet`)
+}
+
+func TestApplyNetworkPreset(t *testing.T) {
+	defer viper.Reset()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().Int64("common-chain-id", -1, "")
+	cmd.Flags().Int("common-first-streamable-block", 0, "")
+	cmd.Flags().String("reader-node-config-file", "aptos.yaml", "")
+	cmd.Flags().String("reader-node-genesis-file", "", "")
+	cmd.Flags().String("reader-node-waypoint-file", "", "")
+
+	knownFlags := map[string]bool{}
+	for _, flag := range []string{"common-chain-id", "common-first-streamable-block", "reader-node-config-file", "reader-node-genesis-file", "reader-node-waypoint-file"} {
+		require.NoError(t, viper.BindPFlag(flag, cmd.Flags().Lookup(flag)))
+		knownFlags[flag] = true
+	}
+
+	// Explicit flag and config file values take precedence over the preset
+	require.NoError(t, cmd.Flags().Set("reader-node-genesis-file", "./genesis.blob"))
+	viper.SetDefault("reader-node-config-file", "./full_node.yaml")
+
+	require.NoError(t, applyNetworkPreset("mainnet", knownFlags, map[string]bool{"reader-node-config-file": true}))
+
+	assert.Equal(t, int64(1), viper.GetInt64("common-chain-id"))
+	assert.Equal(t, "./genesis.blob", viper.GetString("reader-node-genesis-file"))
+	assert.Equal(t, "./full_node.yaml", viper.GetString("reader-node-config-file"))
+	assert.Equal(t, "https://raw.githubusercontent.com/aptos-labs/aptos-networks/main/mainnet/waypoint.txt", viper.GetString("reader-node-waypoint-file"))
+
+	delete(knownFlags, "reader-node-waypoint-file")
+	assert.ErrorContains(t, applyNetworkPreset("mainnet", knownFlags, nil), `network "mainnet" preset defines unknown flag "reader-node-waypoint-file"`)
+}
@@ -1,16 +1,15 @@
 package cli
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
-	"time"
 
+	"github.com/streamingfast/firehose-aptos/internal/fileutil"
+	"github.com/streamingfast/firehose-aptos/nodemanager"
 	"go.uber.org/zap"
 )
 
@@ -22,116 +21,187 @@ type bootstrapper struct {
 	nodeWaypointFile          string
 	nodeValidatorIdentityFile string
 	nodeVFNIdentityFile       string
+	nodeRole                  string
+	chainID                   int64
+	p2pPort                   string
+	rpcPort                   string
+	configVars                map[string]string
+	downloader                *fileDownloader
 	logger                    *zap.Logger
+
+	// ctx is done when the app shuts down, aborting the downloads of the node's files. The
+	// node manager's `Bootstrap` doesn't receive a context, so it's kept here.
+	ctx context.Context
+
+	// onPeerIDResolved, when defined, receives the peer ID derived from the node's identity file
+	onPeerIDResolved func(peerID string)
+
+	// backupModule, when defined, is used to restore the node's data directory from backup
+	// `restoreBackupName` if the data directory is empty when bootstrapping.
+	backupModule      *nodemanager.DataDirBackupModule
+	restoreBackupName string
 }
 
 func (b *bootstrapper) Bootstrap() error {
+	if b.backupModule != nil && b.restoreBackupName != "" {
+		empty, err := isDirEmpty(b.nodeDataDir)
+		if err != nil {
+			return fmt.Errorf("check node's data dir: %w", err)
+		}
+
+		if empty {
+			b.logger.Info("node's data dir is empty, restoring it from backup", zap.String("backup_name", b.restoreBackupName))
+			// The backup module calls back `resolveConfig` once restored
+			if err := b.backupModule.Restore(b.restoreBackupName); err != nil {
+				return fmt.Errorf("restore node's data dir: %w", err)
+			}
+
+			return nil
+		}
+
+		b.logger.Info("node's data dir is not empty, skipping restore from backup", zap.String("data_dir", b.nodeDataDir))
+	}
+
+	return b.resolveConfig()
+}
+
+// resolveConfig writes the templated node's config file to its resolved location. It's
+// invoked on bootstrap and after each restore, the config file that was part of the
+// backup having been created on another instance possibly with different paths.
+func (b *bootstrapper) resolveConfig() error {
 	b.logger.Info("bootstraping node's configuration")
 
 	if err := makeDirs([]string{b.nodeDataDir}); err != nil {
 		return fmt.Errorf(`create "fireaptos" inside node's data dir: %w`, err)
 	}
 
-	configContent, err := os.ReadFile(b.nodeConfigFile)
+	configContent, err := readNodeConfigFile(b.nodeConfigFile)
 	if err != nil {
 		return fmt.Errorf("read config content: %w", err)
 	}
 
-	dataDir := tryToMakeAbsolutePath(b.logger, b.nodeDataDir)
-
-	b.logger.Info(`replacing occurrences of "{data-dir}" in config file`, zap.String("config_file", b.nodeConfigFile), zap.String("replacement", dataDir))
-	configContent = bytes.ReplaceAll(configContent, []byte("{data-dir}"), []byte(dataDir))
+	values := &nodeConfigValues{
+		DataDir:  tryToMakeAbsolutePath(b.logger, b.nodeDataDir),
+		NodeRole: b.nodeRole,
+		ChainID:  b.chainID,
+		P2PPort:  b.p2pPort,
+		RPCPort:  b.rpcPort,
+		Vars:     b.configVars,
+		Env:      environmentVars(),
+	}
 
-	templatingDirectives := map[string]string{
-		"{genesis-file}":            b.nodeGenesisFile,
-		"{waypoint-file}":           b.nodeWaypointFile,
-		"{validator-identity-file}": b.nodeValidatorIdentityFile,
-		"{vfn-identity-file}":       b.nodeVFNIdentityFile,
+	files := []struct {
+		name  string
+		in    string
+		value *string
+	}{
+		{"genesis", b.nodeGenesisFile, &values.GenesisFile},
+		{"waypoint", b.nodeWaypointFile, &values.WaypointFile},
+		{"validator identity", b.nodeValidatorIdentityFile, &values.ValidatorIdentityFile},
+		{"vfn identity", b.nodeVFNIdentityFile, &values.VFNIdentityFile},
 	}
 
-	for templateDirective, file := range templatingDirectives {
-		if configContent, err = b.maybeTemplateFileInConfig(configContent, templateDirective, dataDir, file); err != nil {
-			return fmt.Errorf("replacing template directive %q: %w", templateDirective, err)
+	for _, file := range files {
+		if file.in == "" {
+			continue
+		}
+
+		if *file.value, err = b.resolveFileToDataDir(values.DataDir, file.in); err != nil {
+			return fmt.Errorf("resolving %s file: %w", file.name, err)
 		}
 	}
 
-	if err := os.WriteFile(b.resolvedNodeConfigFile, configContent, os.ModePerm); err != nil {
+	b.resolvePeerID(values)
+
+	b.logger.Info("rendering config file", zap.String("config_file", b.nodeConfigFile), zap.Reflect("values", values.loggable()))
+	configContent, err = renderNodeConfig(configContent, values)
+	if err != nil {
+		return fmt.Errorf("config file %q: %w", b.nodeConfigFile, err)
+	}
+
+	if err := fileutil.WriteFileAtomically(b.resolvedNodeConfigFile, configContent); err != nil {
 		return fmt.Errorf("write resolved config file: %w", err)
 	}
 
 	return nil
 }
 
-func (b *bootstrapper) maybeTemplateFileInConfig(inConfig []byte, templateDirective string, absDataDir string, in string) ([]byte, error) {
-	if in != "" {
-		resolvedAbsoluteFile, err := b.resolveFileToDataDir(absDataDir, in)
-		if err != nil {
-			return nil, fmt.Errorf("resolving file: %w", err)
-		}
+// resolvePeerID derives the node's peer ID from its identity file, the vfn one being preferred as
+// it's the identity of the full node network the reader runs on. Failing to derive it is not
+// fatal, the peer ID being only informative.
+func (b *bootstrapper) resolvePeerID(values *nodeConfigValues) {
+	identityFile := values.VFNIdentityFile
+	if identityFile == "" {
+		identityFile = values.ValidatorIdentityFile
+	}
+
+	if identityFile == "" || b.onPeerIDResolved == nil {
+		return
+	}
 
-		b.logger.Info(fmt.Sprintf(`replacing occurrences of %s in config file`, templateDirective), zap.String("config_file", b.nodeConfigFile), zap.String("replacement", resolvedAbsoluteFile))
-		return bytes.ReplaceAll(inConfig, []byte(templateDirective), []byte(resolvedAbsoluteFile)), nil
+	peerID, err := nodemanager.PeerIDFromIdentityFile(identityFile)
+	if err != nil {
+		b.logger.Warn("unable to derive node's peer ID from its identity file, it will be unknown", zap.String("identity_file", identityFile), zap.Error(err))
+		return
 	}
 
-	return inConfig, nil
+	b.logger.Info("derived node's peer ID from its identity file", zap.String("identity_file", identityFile), zap.String("peer_id", peerID))
+	b.onPeerIDResolved(peerID)
 }
 
 var httpSchemePrefixRegex = regexp.MustCompile("^https?://")
 
 func (b *bootstrapper) resolveFileToDataDir(absDataDir string, in string) (absolutePath string, err error) {
-	if httpSchemePrefixRegex.MatchString(in) {
-		return b.downloadFileToDataDir(absDataDir, in)
+	location, expectedChecksum, err := splitChecksumPin(in)
+	if err != nil {
+		return "", err
 	}
 
-	return b.copyFileToDataDir(absDataDir, in)
+	if httpSchemePrefixRegex.MatchString(location) {
+		return b.downloadFileToDataDir(absDataDir, location, expectedChecksum)
+	}
+
+	return b.copyFileToDataDir(absDataDir, location, expectedChecksum)
 }
 
-func (b *bootstrapper) copyFileToDataDir(absDataDir string, in string) (absolutePath string, err error) {
+func (b *bootstrapper) copyFileToDataDir(absDataDir string, in string, expectedChecksum string) (absolutePath string, err error) {
 	baseName := filepath.Base(in)
 	destinationPath := filepath.Join(b.nodeDataDir, baseName)
 
-	if err := copyFile(in, destinationPath); err != nil {
+	if err := copyFileAtomically(in, destinationPath, expectedChecksum); err != nil {
 		return "", fmt.Errorf("copy to destination: %w", err)
 	}
 
 	return destinationPath, nil
 }
 
-func (b *bootstrapper) downloadFileToDataDir(absDataDir string, in string) (absolutePath string, err error) {
-	// FIXME: Right now we download it each time we start, should we avoid doing that?
-
-	b.logger.Info("downloading remote file and copying it to node data dir", zap.String("url", in))
-	client := http.Client{Timeout: 60 * time.Second}
-	response, err := client.Get(in)
+func (b *bootstrapper) downloadFileToDataDir(absDataDir string, in string, expectedChecksum string) (absolutePath string, err error) {
+	cachedPath, err := b.downloader.Download(b.ctx, in, expectedChecksum)
 	if err != nil {
-		return "", fmt.Errorf("fetch file %q: %w", in, err)
+		return "", err
 	}
-	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusOK {
-		buf := bytes.NewBuffer(nil)
-		if _, err := buf.ReadFrom(response.Body); err != nil {
-			buf = bytes.NewBufferString("<Unable to read body>")
-		}
+	destinationPath := filepath.Join(b.nodeDataDir, path.Base(in))
+	b.logger.Debug("copying downloaded file to destination", zap.String("url", in), zap.String("destination", destinationPath))
 
-		return "", fmt.Errorf("invalid response %q (body %q)", response.Status, buf.String())
+	if err := copyFileAtomically(cachedPath, destinationPath, expectedChecksum); err != nil {
+		return "", fmt.Errorf("copy to destination: %w", err)
 	}
 
-	destinationPath := filepath.Join(b.nodeDataDir, path.Base(in))
-	b.logger.Debug("copying downloaded file to destination", zap.String("destination", destinationPath))
+	return destinationPath, nil
+}
 
-	outFile, err := os.Create(destinationPath)
+func isDirEmpty(directory string) (bool, error) {
+	entries, err := os.ReadDir(directory)
 	if err != nil {
-		return "", fmt.Errorf("create file: %w", err)
-	}
-	defer outFile.Close()
+		if os.IsNotExist(err) {
+			return true, nil
+		}
 
-	_, err = io.Copy(outFile, response.Body)
-	if err != nil {
-		return "", fmt.Errorf("copy response to %q: %w", destinationPath, err)
+		return false, err
 	}
 
-	return destinationPath, nil
+	return len(entries) == 0, nil
 }
 
 func tryToMakeAbsolutePath(logger *zap.Logger, path string) string {
@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v3"
+)
+
+// nodeConfigValues are the values available when rendering the node's config file as a Go
+// template. Computed values are accessed directly (`{{ .DataDir }}`), variables defined
+// through flags with `{{ .Vars.<name> }}` and environment variables with `{{ .Env.<NAME> }}`
+// (or `{{ env "NAME" }}` which renders an empty string when the variable is not set).
+type nodeConfigValues struct {
+	DataDir               string
+	GenesisFile           string
+	WaypointFile          string
+	ValidatorIdentityFile string
+	VFNIdentityFile       string
+	NodeRole              string
+	ChainID               int64
+	P2PPort               string
+	RPCPort               string
+
+	Vars map[string]string
+	Env  map[string]string
+}
+
+// loggable returns the values without the environment, which may contain secrets and is
+// too large to be logged.
+func (v *nodeConfigValues) loggable() nodeConfigValues {
+	out := *v
+	out.Env = nil
+
+	return out
+}
+
+// legacyPlaceholders maps the placeholders supported before config templating to the value
+// they are replaced with, they are still replaced before the template is rendered. Empty
+// values are left untouched, like before.
+func (v *nodeConfigValues) legacyPlaceholders() map[string]string {
+	return map[string]string{
+		"{data-dir}":                v.DataDir,
+		"{genesis-file}":            v.GenesisFile,
+		"{waypoint-file}":           v.WaypointFile,
+		"{validator-identity-file}": v.ValidatorIdentityFile,
+		"{vfn-identity-file}":       v.VFNIdentityFile,
+	}
+}
+
+// parseNodeConfigVars parses `<name>=<value>` definitions as given to the config vars flag.
+func parseNodeConfigVars(definitions []string) (map[string]string, error) {
+	vars := make(map[string]string, len(definitions))
+	for _, definition := range definitions {
+		name, value, found := strings.Cut(definition, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid config variable %q, expecting '<name>=<value>'", definition)
+		}
+
+		vars[strings.TrimSpace(name)] = value
+	}
+
+	return vars, nil
+}
+
+func environmentVars() map[string]string {
+	env := make(map[string]string)
+	for _, entry := range os.Environ() {
+		if name, value, found := strings.Cut(entry, "="); found {
+			env[name] = value
+		}
+	}
+
+	return env
+}
+
+var nodeConfigTemplateFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"default": func(defaultValue string, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return defaultValue
+		}
+
+		return value
+	},
+}
+
+// renderNodeConfig renders the node's config `content` as a Go template against `values`,
+// replacing legacy placeholders first. Every undefined variable referenced by the template
+// is reported at once and the rendered config must be valid YAML.
+func renderNodeConfig(content []byte, values *nodeConfigValues) ([]byte, error) {
+	for placeholder, value := range values.legacyPlaceholders() {
+		if value != "" {
+			content = bytes.ReplaceAll(content, []byte(placeholder), []byte(value))
+		}
+	}
+
+	tmpl, err := template.New("config").Option("missingkey=error").Funcs(nodeConfigTemplateFuncs).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("parse config template: %w", err)
+	}
+
+	if undefined := undefinedNodeConfigVariables(tmpl, values); len(undefined) > 0 {
+		return nil, fmt.Errorf("config template references undefined variables: %s", strings.Join(undefined, ", "))
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buffer, values); err != nil {
+		return nil, fmt.Errorf("render config template: %w", err)
+	}
+
+	var document interface{}
+	if err := yaml.Unmarshal(buffer.Bytes(), &document); err != nil {
+		return nil, fmt.Errorf("rendered config is not valid YAML: %w", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// undefinedNodeConfigVariables returns the sorted references to undefined values (like
+// `.Vars.seeds`) found in the template. Only references made against the root value are
+// checked, the dot being re-bound inside `range` and `with` blocks.
+func undefinedNodeConfigVariables(tmpl *template.Template, values *nodeConfigValues) []string {
+	undefined := map[string]bool{}
+
+	check := func(idents []string) {
+		if len(idents) == 0 {
+			return
+		}
+
+		switch idents[0] {
+		case "Vars", "Env":
+			if len(idents) < 2 {
+				return
+			}
+
+			source := values.Vars
+			if idents[0] == "Env" {
+				source = values.Env
+			}
+
+			if _, found := source[idents[1]]; !found {
+				undefined["."+idents[0]+"."+idents[1]] = true
+			}
+
+		case "DataDir", "GenesisFile", "WaypointFile", "ValidatorIdentityFile", "VFNIdentityFile", "NodeRole", "ChainID", "P2PPort", "RPCPort":
+
+		default:
+			undefined["."+idents[0]] = true
+		}
+	}
+
+	var walk func(node parse.Node, rootDot bool)
+	walk = func(node parse.Node, rootDot bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n != nil {
+				for _, child := range n.Nodes {
+					walk(child, rootDot)
+				}
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, rootDot)
+		case *parse.PipeNode:
+			if n != nil {
+				for _, command := range n.Cmds {
+					walk(command, rootDot)
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg, rootDot)
+			}
+		case *parse.ChainNode:
+			walk(n.Node, rootDot)
+		case *parse.FieldNode:
+			if rootDot {
+				check(n.Ident)
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				check(n.Ident[1:])
+			}
+		case *parse.IfNode:
+			walk(n.Pipe, rootDot)
+			walk(n.List, rootDot)
+			walk(n.ElseList, rootDot)
+		case *parse.RangeNode:
+			walk(n.Pipe, rootDot)
+			walk(n.List, false)
+			walk(n.ElseList, rootDot)
+		case *parse.WithNode:
+			walk(n.Pipe, rootDot)
+			walk(n.List, false)
+			walk(n.ElseList, rootDot)
+		}
+	}
+
+	if tmpl.Tree != nil {
+		walk(tmpl.Tree.Root, true)
+	}
+
+	out := make([]string, 0, len(undefined))
+	for reference := range undefined {
+		out = append(out, reference)
+	}
+	sort.Strings(out)
+
+	return out
+}
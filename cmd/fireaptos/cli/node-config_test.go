@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderNodeConfig(t *testing.T) {
+	t.Setenv("FIREAPTOS_TEST_PRUNE_WINDOW", "150000000")
+
+	values := &nodeConfigValues{
+		DataDir:     "/data/reader/data",
+		GenesisFile: "/data/reader/data/genesis.blob",
+		NodeRole:    "reader",
+		P2PPort:     ReaderNodeP2PPort,
+		Vars:        map[string]string{"seed": "/dns/seed.aptoslabs.com/tcp/6182"},
+		Env:         environmentVars(),
+	}
+
+	tests := []struct {
+		name        string
+		config      string
+		expected    string
+		expectedErr string
+	}{
+		{
+			"legacy placeholders",
+			"data_dir: \"{data-dir}\"\ngenesis: \"{genesis-file}\"\nwaypoint: \"{waypoint-file}\"\n",
+			"data_dir: \"/data/reader/data\"\ngenesis: \"/data/reader/data/genesis.blob\"\nwaypoint: \"{waypoint-file}\"\n",
+			"",
+		},
+		{
+			"computed values, vars and environment",
+			"role: {{ .NodeRole }}\nlisten: /ip4/0.0.0.0/tcp/{{ .P2PPort }}\nseed: {{ .Vars.seed }}\nprune: {{ .Env.FIREAPTOS_TEST_PRUNE_WINDOW }}\n",
+			"role: reader\nlisten: /ip4/0.0.0.0/tcp/6181\nseed: /dns/seed.aptoslabs.com/tcp/6182\nprune: 150000000\n",
+			"",
+		},
+		{
+			"optional environment variable",
+			"api: {{ env \"FIREAPTOS_TEST_UNDEFINED\" | default \"127.0.0.1:8080\" }}\n",
+			"api: 127.0.0.1:8080\n",
+			"",
+		},
+		{
+			"rebound dot is not checked",
+			"seeds:\n{{- range $name, $value := .Vars }}\n  {{ $name }}: {{ . }}\n{{- end }}\n",
+			"seeds:\n  seed: /dns/seed.aptoslabs.com/tcp/6182\n",
+			"",
+		},
+		{
+			"undefined variables are all reported",
+			"a: {{ .Vars.missing }}\nb: {{ .Unknown }}\nc: {{ $.Env.FIREAPTOS_TEST_UNDEFINED }}\nd: {{ .Vars.missing }}\n",
+			"",
+			"config template references undefined variables: .Env.FIREAPTOS_TEST_UNDEFINED, .Unknown, .Vars.missing",
+		},
+		{
+			"invalid template",
+			"a: {{ .Vars.seed \n",
+			"",
+			"parse config template",
+		},
+		{
+			"invalid yaml",
+			"a: {{ .Vars.seed }}\n b: [\n",
+			"",
+			"rendered config is not valid YAML",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := renderNodeConfig([]byte(test.config), values)
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(out))
+		})
+	}
+}
+
+func TestBootstrapper_ResolveConfig(t *testing.T) {
+	genesisFile := filepath.Join(t.TempDir(), "genesis.blob")
+	require.NoError(t, os.WriteFile(genesisFile, []byte("genesis"), 0644))
+
+	configFile := filepath.Join(t.TempDir(), "full_node.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("base:\n  data_dir: \"{data-dir}\"\nexecution:\n  genesis_file_location: \"{{ .GenesisFile }}\"\nchain_id: {{ .ChainID }}\n"), 0644))
+
+	dataDir := t.TempDir()
+	b := &bootstrapper{
+		nodeDataDir:            dataDir,
+		nodeConfigFile:         configFile,
+		resolvedNodeConfigFile: filepath.Join(dataDir, "node.yaml"),
+		nodeGenesisFile:        genesisFile,
+		chainID:                1,
+		downloader:             newTestFileDownloader(t),
+		logger:                 rootLog,
+	}
+
+	require.NoError(t, b.Bootstrap())
+	assertFileContent(t, filepath.Join(dataDir, "node.yaml"), "base:\n  data_dir: \""+dataDir+"\"\nexecution:\n  genesis_file_location: \""+filepath.Join(dataDir, "genesis.blob")+"\"\nchain_id: 1\n")
+	assertFileContent(t, filepath.Join(dataDir, "genesis.blob"), "genesis")
+}
+
+func TestParseNodeConfigVars(t *testing.T) {
+	vars, err := parseNodeConfigVars([]string{"seed=/dns/host/tcp/6182", "empty=", " padded =a=b"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"seed": "/dns/host/tcp/6182", "empty": "", "padded": "a=b"}, vars)
+
+	_, err = parseNodeConfigVars([]string{"novalue"})
+	assert.ErrorContains(t, err, "invalid config variable")
+
+	_, err = parseNodeConfigVars([]string{"=value"})
+	assert.ErrorContains(t, err, "invalid config variable")
+}
+
+func TestNodeConfigVarFlag(t *testing.T) {
+	defer viper.Reset()
+
+	cmd := &cobra.Command{Use: "start", Run: func(cmd *cobra.Command, args []string) {}}
+	registerCommonNodeFlags(cmd, "reader-node-", ReaderNodeManagerAPIAddr)
+	require.NoError(t, viper.BindPFlags(cmd.Flags()))
+
+	cmd.SetArgs([]string{"--reader-node-config-var", "peers=/dns/a/tcp/6182,/dns/b/tcp/6182", "--reader-node-config-var", "seed=c"})
+	require.NoError(t, cmd.Execute())
+
+	vars, err := parseNodeConfigVars(getStringArray("reader-node-config-var"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"peers": "/dns/a/tcp/6182,/dns/b/tcp/6182", "seed": "c"}, vars)
+}
@@ -0,0 +1,285 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/streamingfast/firehose-aptos/internal/fileutil"
+	"go.uber.org/zap"
+)
+
+const checksumPinSeparator = "#sha256:"
+
+// splitChecksumPin splits a file flag value of the form `<path-or-url>[#sha256:<hex>]` into
+// its location and its optional expected SHA-256 checksum (lower-cased hex).
+func splitChecksumPin(in string) (location string, expectedChecksum string, err error) {
+	location, expectedChecksum, found := strings.Cut(in, checksumPinSeparator)
+	if !found {
+		return in, "", nil
+	}
+
+	expectedChecksum = strings.ToLower(expectedChecksum)
+	if decoded, err := hex.DecodeString(expectedChecksum); err != nil || len(decoded) != sha256.Size {
+		return "", "", fmt.Errorf("invalid checksum pin %q, expecting '%s<64 hex characters>'", in, checksumPinSeparator)
+	}
+
+	return location, expectedChecksum, nil
+}
+
+// fileDownloader downloads remote files into a local cache keyed by URL, revalidating
+// cached entries against the server through their `ETag` and `Last-Modified` headers so
+// that unchanged files are not downloaded again on each start.
+type fileDownloader struct {
+	cacheDir       string
+	client         *http.Client
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	logger         *zap.Logger
+}
+
+func newFileDownloader(cacheDir string, timeout time.Duration, logger *zap.Logger) *fileDownloader {
+	return &fileDownloader{
+		cacheDir:       cacheDir,
+		client:         &http.Client{Timeout: timeout},
+		maxAttempts:    5,
+		initialBackoff: 1 * time.Second,
+		maxBackoff:     30 * time.Second,
+		logger:         logger,
+	}
+}
+
+type cachedFileMetadata struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Checksum     string `json:"sha256"`
+}
+
+// Download returns the path of the cached copy of `url`, downloading it if absent from
+// the cache or modified on the server. When `expectedChecksum` is non-empty, the returned
+// file is guaranteed to match it.
+//
+// If the server cannot be reached after all retries, a valid cached copy is used instead.
+// Retries stop as soon as `ctx` is done.
+func (d *fileDownloader) Download(ctx context.Context, url string, expectedChecksum string) (string, error) {
+	if err := makeDirs([]string{d.cacheDir}); err != nil {
+		return "", fmt.Errorf("create download cache dir: %w", err)
+	}
+
+	key := sha256.Sum256([]byte(url))
+	contentPath := filepath.Join(d.cacheDir, hex.EncodeToString(key[:]))
+	metadataPath := contentPath + ".json"
+
+	cached := d.readCachedMetadata(url, contentPath, metadataPath)
+	if cached != nil && expectedChecksum != "" && cached.Checksum != expectedChecksum {
+		d.logger.Info("cached file does not match pinned checksum, downloading it again", zap.String("url", url))
+		cached = nil
+	}
+
+	var lastErr error
+	backoff := d.initialBackoff
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if attempt > 1 {
+			d.logger.Warn("download failed, retrying", zap.String("url", url), zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(lastErr))
+			select {
+			case <-ctx.Done():
+				return "", fmt.Errorf("download %q: %w", url, ctx.Err())
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > d.maxBackoff {
+				backoff = d.maxBackoff
+			}
+		}
+
+		metadata, err := d.fetch(ctx, url, contentPath, cached, expectedChecksum)
+		if err == nil {
+			if err := fileutil.WriteFileAtomically(metadataPath, mustJSONMarshal(metadata)); err != nil {
+				return "", fmt.Errorf("write cache metadata: %w", err)
+			}
+
+			return contentPath, nil
+		}
+
+		lastErr = err
+		var permanent *permanentDownloadError
+		if errors.As(err, &permanent) {
+			break
+		}
+	}
+
+	if cached != nil {
+		d.logger.Warn("unable to revalidate cached file, using cached copy", zap.String("url", url), zap.Error(lastErr))
+		return contentPath, nil
+	}
+
+	return "", fmt.Errorf("download %q: %w", url, lastErr)
+}
+
+// permanentDownloadError is returned for failures that retrying won't fix, like a 404.
+type permanentDownloadError struct {
+	err error
+}
+
+func (e *permanentDownloadError) Error() string { return e.err.Error() }
+func (e *permanentDownloadError) Unwrap() error { return e.err }
+
+// fetch performs a single download attempt, conditional when `cached` is non-nil. On
+// success, the content at `contentPath` is complete, matches `expectedChecksum` when
+// non-empty and is described by the returned metadata. On failure, it's left untouched.
+func (d *fileDownloader) fetch(ctx context.Context, url string, contentPath string, cached *cachedFileMetadata, expectedChecksum string) (*cachedFileMetadata, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &permanentDownloadError{err}
+	}
+
+	if cached != nil {
+		if cached.ETag != "" {
+			request.Header.Set("If-None-Match", cached.ETag)
+		}
+
+		if cached.LastModified != "" {
+			request.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("fetch file: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotModified && cached != nil {
+		d.logger.Info("cached file is up to date", zap.String("url", url))
+		return cached, nil
+	}
+
+	if response.StatusCode != http.StatusOK {
+		buf := bytes.NewBuffer(nil)
+		if _, err := buf.ReadFrom(io.LimitReader(response.Body, 1024)); err != nil {
+			buf = bytes.NewBufferString("<Unable to read body>")
+		}
+
+		err := fmt.Errorf("invalid response %q (body %q)", response.Status, buf.String())
+		if response.StatusCode >= 400 && response.StatusCode < 500 && response.StatusCode != http.StatusTooManyRequests {
+			return nil, &permanentDownloadError{err}
+		}
+
+		return nil, err
+	}
+
+	d.logger.Info("downloading remote file to cache", zap.String("url", url))
+
+	hasher := sha256.New()
+	written, err := fileutil.WriteReaderAtomically(contentPath, io.TeeReader(response.Body, hasher), func(written int64) error {
+		if response.ContentLength >= 0 && written != response.ContentLength {
+			return fmt.Errorf("truncated download, received %d bytes out of %d", written, response.ContentLength)
+		}
+
+		if checksum := hex.EncodeToString(hasher.Sum(nil)); expectedChecksum != "" && checksum != expectedChecksum {
+			return fmt.Errorf("checksum mismatch, expected sha256 %s, got %s", expectedChecksum, checksum)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.logger.Debug("downloaded remote file to cache", zap.String("url", url), zap.Int64("size", written))
+
+	return &cachedFileMetadata{
+		URL:          url,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+		Checksum:     hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
+
+// readCachedMetadata returns the metadata of the cached copy of `url`, or nil if there is
+// none or if the cached content doesn't match its recorded checksum anymore.
+func (d *fileDownloader) readCachedMetadata(url string, contentPath, metadataPath string) *cachedFileMetadata {
+	content, err := os.ReadFile(metadataPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			d.logger.Warn("unable to read cache metadata, ignoring cached file", zap.String("url", url), zap.Error(err))
+		}
+
+		return nil
+	}
+
+	var metadata cachedFileMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil || metadata.URL != url {
+		d.logger.Warn("invalid cache metadata, ignoring cached file", zap.String("url", url), zap.Error(err))
+		return nil
+	}
+
+	checksum, err := fileChecksum(contentPath)
+	if err != nil || checksum != metadata.Checksum {
+		d.logger.Warn("cached file is missing or corrupted, ignoring it", zap.String("url", url), zap.Error(err))
+		return nil
+	}
+
+	return &metadata
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("hash file %q: %w", path, err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// copyFileAtomically copies `inPath` to `outPath` through a temporary file renamed once
+// complete, verifying the copied content against `expectedChecksum` when non-empty.
+func copyFileAtomically(inPath, outPath string, expectedChecksum string) error {
+	inFile, err := os.Open(inPath)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer inFile.Close()
+
+	hasher := sha256.New()
+	_, err = fileutil.WriteReaderAtomically(outPath, io.TeeReader(inFile, hasher), func(_ int64) error {
+		if checksum := hex.EncodeToString(hasher.Sum(nil)); expectedChecksum != "" && checksum != expectedChecksum {
+			return fmt.Errorf("checksum mismatch for %q, expected sha256 %s, got %s", inPath, expectedChecksum, checksum)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("copy file %q to %q: %w", inPath, outPath, err)
+	}
+
+	return nil
+}
+
+func mustJSONMarshal(v interface{}) []byte {
+	out, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Errorf("marshal json: %w", err))
+	}
+
+	return out
+}
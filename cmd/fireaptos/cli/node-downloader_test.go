@@ -0,0 +1,226 @@
+package cli
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileDownloader_Revalidation(t *testing.T) {
+	content, etag := "genesis v1", `"v1"`
+	var served, notModified int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		atomic.AddInt32(&served, 1)
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	downloader := newTestFileDownloader(t)
+	url := server.URL + "/genesis.blob"
+
+	path, err := downloader.Download(context.Background(), url, "")
+	require.NoError(t, err)
+	assertFileContent(t, path, "genesis v1")
+
+	path, err = downloader.Download(context.Background(), url, "")
+	require.NoError(t, err)
+	assertFileContent(t, path, "genesis v1")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&served))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified))
+
+	content, etag = "genesis v2", `"v2"`
+
+	path, err = downloader.Download(context.Background(), url, "")
+	require.NoError(t, err)
+	assertFileContent(t, path, "genesis v2")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&served))
+
+	// Server unreachable, the cached copy is used
+	server.Close()
+
+	path, err = downloader.Download(context.Background(), url, checksumOf("genesis v2"))
+	require.NoError(t, err)
+	assertFileContent(t, path, "genesis v2")
+}
+
+func TestFileDownloader_Retries(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&attempts, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// Truncated body, the declared length is never reached
+			w.Header().Set("Content-Length", "1000")
+			w.Write([]byte("partial"))
+		default:
+			w.Write([]byte("waypoint"))
+		}
+	}))
+	defer server.Close()
+
+	downloader := newTestFileDownloader(t)
+
+	path, err := downloader.Download(context.Background(), server.URL+"/waypoint.txt", checksumOf("waypoint"))
+	require.NoError(t, err)
+	assertFileContent(t, path, "waypoint")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestFileDownloader_PermanentError(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := newTestFileDownloader(t).Download(context.Background(), server.URL+"/missing.blob", "")
+	assert.ErrorContains(t, err, "404 Not Found")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestFileDownloader_CanceledDuringRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	downloader := newTestFileDownloader(t)
+	downloader.initialBackoff = time.Hour
+
+	_, err := downloader.Download(ctx, server.URL+"/genesis.blob", "")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestFileDownloader_ChecksumMismatch(t *testing.T) {
+	content := "genesis"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	downloader := newTestFileDownloader(t)
+	url := server.URL + "/genesis.blob"
+
+	_, err := downloader.Download(context.Background(), url, checksumOf("genesis"))
+	require.NoError(t, err)
+
+	content = "corrupted"
+
+	// The mismatching downloads must not replace the valid cached copy, which is used instead
+	path, err := downloader.Download(context.Background(), url, checksumOf("genesis"))
+	require.NoError(t, err)
+	assertFileContent(t, path, "genesis")
+
+	_, err = newTestFileDownloader(t).Download(context.Background(), url, checksumOf("genesis"))
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestBootstrapper_ResolveFileToDataDir(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("remote identity"))
+	}))
+	defer server.Close()
+
+	localFile := filepath.Join(t.TempDir(), "waypoint.txt")
+	require.NoError(t, os.WriteFile(localFile, []byte("local waypoint"), 0644))
+
+	b := &bootstrapper{ctx: context.Background(), nodeDataDir: t.TempDir(), downloader: newTestFileDownloader(t), logger: rootLog}
+
+	path, err := b.resolveFileToDataDir(b.nodeDataDir, localFile+"#sha256:"+checksumOf("local waypoint"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(b.nodeDataDir, "waypoint.txt"), path)
+	assertFileContent(t, path, "local waypoint")
+
+	path, err = b.resolveFileToDataDir(b.nodeDataDir, server.URL+"/vfn-identity.yaml#sha256:"+checksumOf("remote identity"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(b.nodeDataDir, "vfn-identity.yaml"), path)
+	assertFileContent(t, path, "remote identity")
+
+	_, err = b.resolveFileToDataDir(b.nodeDataDir, localFile+"#sha256:"+checksumOf("other"))
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	_, err = b.resolveFileToDataDir(b.nodeDataDir, localFile+"#sha256:abc")
+	assert.ErrorContains(t, err, "invalid checksum pin")
+}
+
+func TestSplitChecksumPin(t *testing.T) {
+	checksum := checksumOf("content")
+
+	tests := []struct {
+		in               string
+		expectedLocation string
+		expectedChecksum string
+		expectedErr      bool
+	}{
+		{"genesis.blob", "genesis.blob", "", false},
+		{"https://host/genesis.blob", "https://host/genesis.blob", "", false},
+		{"https://host/genesis.blob#sha256:" + checksum, "https://host/genesis.blob", checksum, false},
+		{"./genesis.blob#sha256:" + strings.ToUpper(checksum), "./genesis.blob", checksum, false},
+		{"genesis.blob#sha256:zz", "", "", true},
+		{"genesis.blob#sha256:", "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			location, checksum, err := splitChecksumPin(test.in)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedLocation, location)
+			assert.Equal(t, test.expectedChecksum, checksum)
+		})
+	}
+}
+
+func newTestFileDownloader(t *testing.T) *fileDownloader {
+	t.Helper()
+
+	downloader := newFileDownloader(t.TempDir(), 5*time.Second, rootLog)
+	downloader.initialBackoff = time.Millisecond
+	downloader.maxBackoff = 10 * time.Millisecond
+
+	return downloader
+}
+
+func checksumOf(content string) string {
+	checksum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(checksum[:])
+}
+
+func assertFileContent(t *testing.T, path string, expected string) {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(content))
+}
@@ -1,9 +1,11 @@
 package cli
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
+	"math"
 	"path/filepath"
 	"strings"
 	"time"
@@ -13,6 +15,7 @@ import (
 	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/bstream/blockstream"
 	"github.com/streamingfast/dlauncher/launcher"
+	"github.com/streamingfast/dmetrics"
 	"github.com/streamingfast/firehose-aptos/nodemanager"
 	"github.com/streamingfast/logging"
 	nodeManager "github.com/streamingfast/node-manager"
@@ -37,11 +40,24 @@ func registerCommonNodeFlags(cmd *cobra.Command, flagPrefix string, managerAPIAd
 		searched for paths listed by the PATH environment variable (following operating system rules around PATH handling).
 	`))
 	cmd.Flags().String(flagPrefix+"data-dir", "{data-dir}/{node-role}/data", "Directory for node data ({node-role} is either reader, peering or dev-miner)")
-	cmd.Flags().String(flagPrefix+"config-file", "aptos.yaml", "Path where to find the node's config file that is passed directly to the executable")
-	cmd.Flags().String(flagPrefix+"genesis-file", "", "Path where to find the node's genesis.blob file for the network, if defined, going to be copied inside node data directory automatically and '{genesis-file}' will be replaced in config automatically to this value.")
-	cmd.Flags().String(flagPrefix+"waypoint-file", "", "Path where to find the node's waypoint.txt file for the network, if defined, going to be copied inside node data directory automatically and '{waypoint-file}' will be replaced in config automatically to this value.")
-	cmd.Flags().String(flagPrefix+"validator-identity-file", "", "Path where to find the node's validator-identity.yaml file for the network, if defined, going to be copied inside node data directory automatically and '{validator-identity-file}' will be replaced in config automatically to this value.")
-	cmd.Flags().String(flagPrefix+"vfn-identity-file", "", "Path where to find the node's vfn-identity.yaml file for the network, if defined, going to be copied inside node data directory automatically and '{vfn-identity-file}' will be replaced in config automatically to this value.")
+	cmd.Flags().String(flagPrefix+"config-file", "aptos.yaml", FlagDescription(`
+		Path where to find the node's config file, rendered as a Go template (https://pkg.go.dev/text/template) before being
+		passed to the executable. Values available are '{{ .DataDir }}', '{{ .GenesisFile }}', '{{ .WaypointFile }}',
+		'{{ .ValidatorIdentityFile }}', '{{ .VFNIdentityFile }}', '{{ .NodeRole }}', '{{ .ChainID }}', '{{ .P2PPort }}',
+		'{{ .RPCPort }}', variables defined with '%s' as '{{ .Vars.<name> }}' and environment variables as '{{ .Env.<NAME> }}'
+		(or '{{ env "NAME" | default "value" }}' when the variable is optional). Referencing an undefined variable or rendering
+		invalid YAML is an error. The '{data-dir}', '{genesis-file}', '{waypoint-file}', '{validator-identity-file}' and
+		'{vfn-identity-file}' placeholders are still replaced.
+	`, flagPrefix+"config-var"))
+	cmd.Flags().String(flagPrefix+"p2p-port", ReaderNodeP2PPort, "Port the node listens on for P2P connections, available to the node's config file template as '{{ .P2PPort }}'")
+	cmd.Flags().String(flagPrefix+"rpc-port", ReaderNodeRPCPort, "Port the node serves its REST API on, available to the node's config file template as '{{ .RPCPort }}'")
+	cmd.Flags().StringArray(flagPrefix+"config-var", nil, "Variable made available to the node's config file template as '{{ .Vars.<name> }}', in the form '<name>=<value>', can be repeated")
+	cmd.Flags().String(flagPrefix+"genesis-file", "", "Path where to find the node's genesis.blob file for the network, if defined, going to be copied inside node data directory automatically and '{genesis-file}' will be replaced in config automatically to this value. Can be a local path or an http(s) URL (cached in '"+flagPrefix+"download-cache-dir'), optionally suffixed with '#sha256:<hex>' to verify the file's checksum.")
+	cmd.Flags().String(flagPrefix+"waypoint-file", "", "Path where to find the node's waypoint.txt file for the network, if defined, going to be copied inside node data directory automatically and '{waypoint-file}' will be replaced in config automatically to this value. Can be a local path or an http(s) URL (cached in '"+flagPrefix+"download-cache-dir'), optionally suffixed with '#sha256:<hex>' to verify the file's checksum.")
+	cmd.Flags().String(flagPrefix+"validator-identity-file", "", "Path where to find the node's validator-identity.yaml file for the network, if defined, going to be copied inside node data directory automatically and '{validator-identity-file}' will be replaced in config automatically to this value. Can be a local path or an http(s) URL (cached in '"+flagPrefix+"download-cache-dir'), optionally suffixed with '#sha256:<hex>' to verify the file's checksum.")
+	cmd.Flags().String(flagPrefix+"vfn-identity-file", "", "Path where to find the node's vfn-identity.yaml file for the network, if defined, going to be copied inside node data directory automatically and '{vfn-identity-file}' will be replaced in config automatically to this value. Can be a local path or an http(s) URL (cached in '"+flagPrefix+"download-cache-dir'), optionally suffixed with '#sha256:<hex>' to verify the file's checksum.")
+	cmd.Flags().String(flagPrefix+"download-cache-dir", "{data-dir}/{node-role}/download-cache", "Directory where remote files referenced by the file flags are cached, cached files being revalidated against the server on each start")
+	cmd.Flags().Duration(flagPrefix+"download-timeout", 5*time.Minute, "Maximum time allowed for each attempt at downloading a remote file referenced by the file flags, failed attempts being retried with backoff")
 	cmd.Flags().Bool(flagPrefix+"debug-firehose-logs", false, "[DEV] Prints Firehose instrumentation logs to standard output, should be use for debugging purposes only")
 	cmd.Flags().Bool(flagPrefix+"log-to-zap", true, FlagDescription(`
 		When sets to 'true', all standard error output emitted by the invoked process defined via '%s'
@@ -50,9 +66,48 @@ func registerCommonNodeFlags(cmd *cobra.Command, flagPrefix string, managerAPIAd
 		of the logs emitted by the blockchain's managed client process. If this is not desirable, disabled the flag
 		and all the invoked process standard error will be redirect to 'fireaptos' standard's output.
 	`, flagPrefix+"path"))
+	cmd.Flags().Bool(flagPrefix+"log-metrics-enabled", true, FlagDescription(`
+		When sets to 'true', the log lines of the invoked process are matched against a set of patterns turned into Prometheus
+		metrics prefixed with 'aptos_node_' (state sync synced version and epoch, committed version, epoch and connected peers
+		by default), see '%s' to define them.
+	`, flagPrefix+"log-metrics-config-file"))
+	cmd.Flags().String(flagPrefix+"log-metrics-config-file", "", FlagDescription(`
+		If non-empty, YAML file defining log metrics under 'metrics', each with a 'name', a 'type' ('gauge' or 'counter'), a regex
+		'pattern' and an optional 'help'. The pattern's 'value' named group is the value set on a gauge or added to a counter
+		(a counter without it is incremented on each match), other named groups being the metric's labels. Metrics are added
+		to the default ones, replacing those with the same name, unless 'disable_defaults: true' is set.
+	`))
 	cmd.Flags().String(flagPrefix+"manager-api-addr", managerAPIAddr, "Aptos node manager API address")
+	cmd.Flags().String(flagPrefix+"ledger-info-url", "", FlagDescription(`
+		If non-empty, aptos-node's REST API URL (like 'http://localhost:8080', '/v1' being appended when missing) polled every
+		'%s' for the node's ledger info, the reader being ready only when the node's ledger is less than '%s' behind the wall
+		clock and the last extracted block is less than '%s' (and '%s' versions when non-zero) behind the node's ledger. The
+		lag and the combined readiness are reported by the node manager API at 'GET /v1/readiness'.
+	`, flagPrefix+"ledger-info-poll-interval", flagPrefix+"ledger-max-lag", flagPrefix+"ledger-max-lag", flagPrefix+"ledger-max-version-lag"))
+	cmd.Flags().Duration(flagPrefix+"ledger-info-poll-interval", 5*time.Second, "Interval at which the node's ledger info is polled, see '"+flagPrefix+"ledger-info-url'")
+	cmd.Flags().Duration(flagPrefix+"ledger-max-lag", 30*time.Second, "Maximum time the node's ledger can be behind the wall clock and the last extracted block behind the node's ledger for the reader to be ready, see '"+flagPrefix+"ledger-info-url'")
+	cmd.Flags().Uint64(flagPrefix+"ledger-max-version-lag", 0, "If non-zero, maximum amount of versions the last extracted block can be behind the node's ledger for the reader to be ready, see '"+flagPrefix+"ledger-info-url'")
 	cmd.Flags().Duration(flagPrefix+"readiness-max-latency", 30*time.Second, "Determine the maximum head block latency at which the instance will be determined healthy. Some chains have more regular block production than others.")
 	cmd.Flags().String(flagPrefix+"arguments", "", "If not empty, overrides the list of default node arguments (computed from node type and role). Start with '+' to append to default args instead of replacing. ")
+	cmd.Flags().String(flagPrefix+"backup-store-url", "", FlagDescription(`
+		If non-empty, enables backups of the node's data directory along with the reader sync state, archived in a tarball
+		saved to this store. Backups are taken through the node manager API ('POST /v1/backup') or periodically (see '%s'
+		and '%s'), the node being stopped while the archive is created. A backup is restored through the node manager API
+		('POST /v1/restore' with optional 'backupName', most recent one by default) or on startup (see '%s').
+	`, flagPrefix+"backup-interval", flagPrefix+"backup-blocks-interval", flagPrefix+"restore-backup-name"))
+	cmd.Flags().Duration(flagPrefix+"backup-interval", 0, "If non-zero, automatically takes a backup of the node's data directory at this interval, requires '"+flagPrefix+"backup-store-url'")
+	cmd.Flags().Uint64(flagPrefix+"backup-blocks-interval", 0, "If non-zero, automatically takes a backup of the node's data directory each time this amount of blocks has been synced, requires '"+flagPrefix+"backup-store-url'")
+	cmd.Flags().Int(flagPrefix+"crash-loop-restarts", 5, FlagDescription(`
+		Amount of restarts of the crashed node process allowed within '%s', past which the node is considered crash looping:
+		it's not restarted anymore, the app is flagged as not ready and the crash is reported by the node manager API
+		('GET /v1/last_crash') until the node is started again ('POST /v1/resume'). When 0, restarts are disabled and the
+		node process exiting shuts down the app.
+	`, flagPrefix+"crash-loop-window"))
+	cmd.Flags().Duration(flagPrefix+"crash-loop-window", 10*time.Minute, "Time window in which crashes of the node process are counted to detect a crash loop")
+	cmd.Flags().Duration(flagPrefix+"restart-backoff", time.Second, "Delay before restarting the node process after a crash, doubled for each other crash within '"+flagPrefix+"crash-loop-window' up to '"+flagPrefix+"restart-max-backoff'")
+	cmd.Flags().Duration(flagPrefix+"restart-max-backoff", time.Minute, "Maximum delay before restarting the node process after a crash")
+	cmd.Flags().Int(flagPrefix+"crash-log-lines", 100, "Amount of node process log lines kept before and after a panic in crash reports")
+	cmd.Flags().String(flagPrefix+"restore-backup-name", "", "If non-empty and the node's data directory is empty on startup, restores this backup (or the most recent one with 'latest') from '"+flagPrefix+"backup-store-url' before starting the node")
 }
 
 func registerNode(kind string, extraFlagRegistration func(cmd *cobra.Command) error, managerAPIaddr string) {
@@ -116,6 +171,11 @@ func nodeFactoryFunc(flagPrefix, kind string) func(*launcher.Runtime) (launcher.
 		// `bootstrapper` is responsible of this replacement as well as placing the resolved config in the right location.
 		resolvedNodeConfigFile := filepath.Join(nodeDataDir, "node.yaml")
 
+		configVars, err := parseNodeConfigVars(getStringArray(flagPrefix + "config-var"))
+		if err != nil {
+			return nil, err
+		}
+
 		readinessMaxLatency := viper.GetDuration(flagPrefix + "readiness-max-latency")
 		debugFirehoseLogs := viper.GetBool(flagPrefix + "debug-firehose-logs")
 		logToZap := viper.GetBool(flagPrefix + "log-to-zap")
@@ -133,19 +193,34 @@ func nodeFactoryFunc(flagPrefix, kind string) func(*launcher.Runtime) (launcher.
 		if err != nil {
 			return nil, fmt.Errorf("cannot build node bootstrap arguments")
 		}
-		metricsAndReadinessManager := buildMetricsAndReadinessManager(flagPrefix, readinessMaxLatency)
+		metricsAndReadinessManager, appReadiness := buildMetricsAndReadinessManager(flagPrefix, readinessMaxLatency)
 
 		readerWorkindDir := mustReplaceDataDir(sfDataDir, viper.GetString("reader-node-working-dir"))
-		syncStateFile := filepath.Join(readerWorkindDir, "sync_state.json")
-		syncState, err := readNodeSyncState(appLogger, syncStateFile)
+		syncStateFile := filepath.Join(readerWorkindDir, syncStateFileName)
+
+		var stateMirror *syncStateMirror
+		if syncStateStoreURL := viper.GetString("reader-node-sync-state-store-url"); syncStateStoreURL != "" {
+			stateMirror, err = newSyncStateMirror(mustReplaceDataDir(sfDataDir, syncStateStoreURL), viper.GetDuration("reader-node-sync-state-mirror-interval"), appLogger)
+			if err != nil {
+				return nil, fmt.Errorf("new sync state mirror: %w", err)
+			}
+		}
+
+		loadCtx, cancelLoad := context.WithTimeout(context.Background(), time.Minute)
+		syncState, err := loadNodeSyncState(loadCtx, appLogger, syncStateFile, stateMirror)
+		cancelLoad()
 		if err != nil {
 			if !errors.Is(err, fs.ErrNotExist) {
 				return nil, fmt.Errorf("read node sync state: %w", err)
 			}
 
 			initialStartBlock := bstream.GetProtocolFirstStreamableBlock
+			if batchStartBlockNum := viper.GetUint64("reader-node-start-block-num"); batchStartBlockNum > initialStartBlock {
+				// Blocks before it are skipped anyway, no need for the node to go through them
+				initialStartBlock = batchStartBlockNum
+			}
 
-			appLogger.Info("overriding initial node sync state based to be first streamable block", zap.Uint64("starting_block", initialStartBlock))
+			appLogger.Info("overriding initial node sync state based to be first streamable block or start block num", zap.Uint64("starting_block", initialStartBlock))
 			syncState = &readerNodeSyncState{BlockNum: initialStartBlock}
 		}
 
@@ -162,6 +237,40 @@ func nodeFactoryFunc(flagPrefix, kind string) func(*launcher.Runtime) (launcher.
 			supervisedProcessLogger,
 		)
 
+		chainID := viper.GetInt64("common-chain-id")
+		if chainID < 0 || chainID > math.MaxUint32 {
+			// Unknown until the node reports it in its 'FIRE INIT' line
+			chainID = 0
+		}
+		superviser.SetNetwork(viper.GetString("global-network"), uint32(chainID))
+
+		if crashLoopRestarts := viper.GetInt(flagPrefix + "crash-loop-restarts"); crashLoopRestarts > 0 {
+			superviser.SetRestartPolicy(&nodemanager.RestartPolicy{
+				InitialBackoff:    viper.GetDuration(flagPrefix + "restart-backoff"),
+				MaxBackoff:        viper.GetDuration(flagPrefix + "restart-max-backoff"),
+				CrashLoopRestarts: crashLoopRestarts,
+				CrashLoopWindow:   viper.GetDuration(flagPrefix + "crash-loop-window"),
+				CrashLogLines:     viper.GetInt(flagPrefix + "crash-log-lines"),
+			})
+			superviser.OnCrashLoop(func(_ *nodemanager.CrashReport) {
+				appReadiness.SetNotReady()
+			})
+		}
+
+		if viper.GetBool(flagPrefix + "log-metrics-enabled") {
+			logMetrics, err := nodemanager.LoadLogMetrics(mustReplaceDataDir(sfDataDir, viper.GetString(flagPrefix+"log-metrics-config-file")))
+			if err != nil {
+				return nil, err
+			}
+
+			logMetricsPlugin, err := nodemanager.NewLogMetricsPlugin(metrics.Metricset, logMetrics)
+			if err != nil {
+				return nil, fmt.Errorf("new log metrics plugin: %w", err)
+			}
+
+			superviser.RegisterLogPlugin(logMetricsPlugin)
+		}
+
 		bootstrapper := &bootstrapper{
 			nodeDataDir:               nodeDataDir,
 			nodeConfigFile:            nodeConfigFile,
@@ -170,13 +279,61 @@ func nodeFactoryFunc(flagPrefix, kind string) func(*launcher.Runtime) (launcher.
 			nodeWaypointFile:          nodeWaypointFile,
 			nodeValidatorIdentityFile: nodeValidatorIdentityFile,
 			nodeVFNIdentityFile:       nodeVFNIdentityFile,
-			logger:                    appLogger,
+			nodeRole:                  kind,
+			chainID:                   viper.GetInt64("common-chain-id"),
+			p2pPort:                   viper.GetString(flagPrefix + "p2p-port"),
+			rpcPort:                   viper.GetString(flagPrefix + "rpc-port"),
+			configVars:                configVars,
+			downloader: newFileDownloader(
+				replaceNodeRole(kind, mustReplaceDataDir(sfDataDir, viper.GetString(flagPrefix+"download-cache-dir"))),
+				viper.GetDuration(flagPrefix+"download-timeout"),
+				appLogger,
+			),
+			logger:            appLogger,
+			restoreBackupName: viper.GetString(flagPrefix + "restore-backup-name"),
+			onPeerIDResolved: func(peerID string) {
+				superviser.SetPeerID(peerID, nodemanager.PeerIDSourceIdentityFile)
+			},
+		}
+
+		if backupStoreURL := viper.GetString(flagPrefix + "backup-store-url"); backupStoreURL != "" {
+			bootstrapper.backupModule, err = nodemanager.NewDataDirBackupModule(
+				mustReplaceDataDir(sfDataDir, backupStoreURL),
+				nodeDataDir,
+				syncStateFile,
+				func(blockNum uint64) error {
+					// The restored sync state is the one matching the restored data, the node must restart from there
+					superviser.SetLastBlockSeen(blockNum)
+					return bootstrapper.resolveConfig()
+				},
+				appLogger,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("new backup module: %w", err)
+			}
+		} else if bootstrapper.restoreBackupName != "" {
+			return nil, fmt.Errorf("flag %q requires flag %q to be set", flagPrefix+"restore-backup-name", flagPrefix+"backup-store-url")
+		}
+
+		var chainReadiness nodeManager.Readiness = metricsAndReadinessManager
+		var ledgerReadiness *nodemanager.LedgerReadiness
+		httpOptions := []operator.HTTPOption{superviser.RegisterHTTPRoutes}
+		if ledgerInfoURL := viper.GetString(flagPrefix + "ledger-info-url"); ledgerInfoURL != "" {
+			ledgerReadiness = nodemanager.NewLedgerReadiness(&nodemanager.LedgerReadinessConfig{
+				URL:           nodemanager.LedgerInfoURL(ledgerInfoURL),
+				PollInterval:  viper.GetDuration(flagPrefix + "ledger-info-poll-interval"),
+				MaxLag:        viper.GetDuration(flagPrefix + "ledger-max-lag"),
+				MaxVersionLag: viper.GetUint64(flagPrefix + "ledger-max-version-lag"),
+			}, metricsAndReadinessManager, appLogger)
+
+			chainReadiness = ledgerReadiness
+			httpOptions = append(httpOptions, ledgerReadiness.RegisterHTTPRoutes)
 		}
 
 		chainOperator, err := operator.New(
 			appLogger,
 			superviser,
-			metricsAndReadinessManager,
+			chainReadiness,
 			&operator.Options{
 				ShutdownDelay:              shutdownDelay,
 				EnableSupervisorMonitoring: true,
@@ -186,13 +343,53 @@ func nodeFactoryFunc(flagPrefix, kind string) func(*launcher.Runtime) (launcher.
 			return nil, fmt.Errorf("unable to create chain operator: %w", err)
 		}
 
+		var cancelBootstrap context.CancelFunc
+		bootstrapper.ctx, cancelBootstrap = context.WithCancel(context.Background())
+		chainOperator.OnTerminating(func(_ error) {
+			cancelBootstrap()
+		})
+
+		stateWriter := &syncStateWriter{
+			path:     syncStateFile,
+			interval: viper.GetDuration("reader-node-sync-state-write-interval"),
+			mirror:   stateMirror,
+			clientVersion: func() string {
+				return superviser.NodeInfo().ClientVersion
+			},
+		}
+		chainOperator.OnTerminating(func(_ error) {
+			if err := stateWriter.flush(); err != nil {
+				appLogger.Warn("unable to write last sync state", zap.Error(err))
+			}
+		})
+
+		if stateMirror != nil {
+			chainOperator.OnTerminating(func(_ error) {
+				stateMirror.Shutdown(nil)
+
+				// Flush the last sync state so the next start, possibly on a fresh volume, resumes from it
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if err := stateMirror.flush(ctx); err != nil {
+					appLogger.Warn("unable to mirror last sync state", zap.Error(err))
+				}
+			})
+			go stateMirror.Launch()
+		}
+
+		if bootstrapper.backupModule != nil {
+			if err := registerNodeBackups(chainOperator, bootstrapper.backupModule, flagPrefix); err != nil {
+				return nil, err
+			}
+		}
+
 		if kind != "reader" {
-			return nodeManagerApp.New(&nodeManagerApp.Config{
+			return nodemanager.NewApp(&nodeManagerApp.Config{
 				HTTPAddr: httpAddr,
 			}, &nodeManagerApp.Modules{
 				Operator:                   chainOperator,
 				MetricsAndReadinessManager: metricsAndReadinessManager,
-			}, appLogger), nil
+			}, httpOptions, ledgerReadiness, appLogger), nil
 		}
 
 		blockStreamServer := blockstream.NewUnmanagedServer(blockstream.ServerOptionWithLogger(appLogger))
@@ -200,6 +397,10 @@ func nodeFactoryFunc(flagPrefix, kind string) func(*launcher.Runtime) (launcher.
 		gprcListenAdrr := viper.GetString("reader-node-grpc-listen-addr")
 		batchStartBlockNum := viper.GetUint64("reader-node-start-block-num")
 		batchStopBlockNum := viper.GetUint64("reader-node-stop-block-num")
+		bounds, err := readerNodeBoundsFromFlags()
+		if err != nil {
+			return nil, err
+		}
 		oneBlockFileSuffix := viper.GetString("reader-node-one-block-suffix")
 		blocksChanCapacity := viper.GetInt("reader-node-blocks-chan-capacity")
 
@@ -209,11 +410,16 @@ func nodeFactoryFunc(flagPrefix, kind string) func(*launcher.Runtime) (launcher.
 			readerWorkindDir,
 			batchStartBlockNum,
 			batchStopBlockNum,
+			bounds,
 			blocksChanCapacity,
 			oneBlockFileSuffix,
 			chainOperator.Shutdown,
-			func(lastBlockSeen uint64) {
-				superviser.SetLastBlockSeen(lastBlockSeen)
+			stateWriter,
+			func(block *bstream.Block) {
+				superviser.SetLastBlockSeen(block.Num())
+				if ledgerReadiness != nil {
+					ledgerReadiness.SetLastBlock(block)
+				}
 			},
 			metricsAndReadinessManager,
 			appLogger,
@@ -225,7 +431,7 @@ func nodeFactoryFunc(flagPrefix, kind string) func(*launcher.Runtime) (launcher.
 
 		superviser.RegisterLogPlugin(readerPlugin)
 
-		return nodeManagerApp.New(&nodeManagerApp.Config{
+		return nodemanager.NewApp(&nodeManagerApp.Config{
 			HTTPAddr: httpAddr,
 			GRPCAddr: gprcListenAdrr,
 		}, &nodeManagerApp.Modules{
@@ -238,10 +444,31 @@ func nodeFactoryFunc(flagPrefix, kind string) func(*launcher.Runtime) (launcher.
 
 				return nil
 			},
-		}, appLogger), nil
+		}, httpOptions, ledgerReadiness, appLogger), nil
 	}
 }
 
+const dataDirBackupModuleName = "data-dir"
+
+func registerNodeBackups(chainOperator *operator.Operator, backupModule operator.BackupModule, flagPrefix string) error {
+	if err := chainOperator.RegisterBackupModule(dataDirBackupModuleName, backupModule); err != nil {
+		return fmt.Errorf("register backup module: %w", err)
+	}
+
+	backupInterval := viper.GetDuration(flagPrefix + "backup-interval")
+	backupBlocksInterval := viper.GetUint64(flagPrefix + "backup-blocks-interval")
+
+	if backupInterval > 0 || backupBlocksInterval > 0 {
+		chainOperator.RegisterBackupSchedule(&operator.BackupSchedule{
+			TimeBetweenRuns:   backupInterval,
+			BlocksBetweenRuns: int(backupBlocksInterval),
+			BackuperName:      dataDirBackupModuleName,
+		})
+	}
+
+	return nil
+}
+
 type nodeArgsByRole map[string]string
 
 func buildNodeArguments(logger *zap.Logger, nodeDataDir, nodeConfigFile, nodeRole string, args string) ([]string, error) {
@@ -275,7 +502,7 @@ func buildNodeArguments(logger *zap.Logger, nodeDataDir, nodeConfigFile, nodeRol
 	return argsSlice, nil
 }
 
-func buildMetricsAndReadinessManager(name string, maxLatency time.Duration) *nodeManager.MetricsAndReadinessManager {
+func buildMetricsAndReadinessManager(name string, maxLatency time.Duration) (*nodeManager.MetricsAndReadinessManager, *dmetrics.AppReadiness) {
 	headBlockTimeDrift := metrics.NewHeadBlockTimeDrift(name)
 	headBlockNumber := metrics.NewHeadBlockNumber(name)
 	appReadiness := metrics.NewAppReadiness(name)
@@ -287,7 +514,7 @@ func buildMetricsAndReadinessManager(name string, maxLatency time.Duration) *nod
 		maxLatency,
 	)
 
-	return metricsAndReadinessManager
+	return metricsAndReadinessManager, appReadiness
 }
 
 func replaceNodeRole(nodeRole, in string) string {
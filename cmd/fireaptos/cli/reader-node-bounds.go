@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/firehose-aptos/codec"
+	"github.com/streamingfast/node-manager/mindreader"
+	"go.uber.org/zap"
+)
+
+// readerNodeBounds are the transaction version and time bounds of the blocks written by the reader,
+// on top of the block num bounds handled by the mindreader plugin itself. Zero values are unbounded.
+type readerNodeBounds struct {
+	startVersion uint64
+	stopVersion  uint64
+	startTime    time.Time
+	stopTime     time.Time
+}
+
+// readerNodeBoundsFromFlags returns the reader's version and time bounds, nil when none is set.
+func readerNodeBoundsFromFlags() (*readerNodeBounds, error) {
+	bounds := &readerNodeBounds{
+		startVersion: viper.GetUint64("reader-node-start-version"),
+		stopVersion:  viper.GetUint64("reader-node-stop-version"),
+	}
+
+	var err error
+	if bounds.startTime, err = parseReaderNodeTime("reader-node-start-time"); err != nil {
+		return nil, fmt.Errorf("invalid --reader-node-start-time: %w", err)
+	}
+
+	if bounds.stopTime, err = parseReaderNodeTime("reader-node-stop-time"); err != nil {
+		return nil, fmt.Errorf("invalid --reader-node-stop-time: %w", err)
+	}
+
+	if bounds.stopVersion != 0 && bounds.startVersion > bounds.stopVersion {
+		return nil, fmt.Errorf("--reader-node-start-version %d is after --reader-node-stop-version %d", bounds.startVersion, bounds.stopVersion)
+	}
+
+	if !bounds.stopTime.IsZero() && bounds.startTime.After(bounds.stopTime) {
+		return nil, fmt.Errorf("--reader-node-start-time %s is after --reader-node-stop-time %s", bounds.startTime.Format(time.RFC3339), bounds.stopTime.Format(time.RFC3339))
+	}
+
+	if *bounds == (readerNodeBounds{}) {
+		return nil, nil
+	}
+
+	return bounds, nil
+}
+
+func parseReaderNodeTime(flag string) (time.Time, error) {
+	value := viper.GetString(flag)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expecting an RFC3339 timestamp like '2022-10-12T00:00:00Z'", value)
+	}
+
+	return parsed, nil
+}
+
+// boundedConsoleReader only returns the blocks of the wrapped console reader that are within the
+// bounds, from the block containing the start version or time to the one containing the stop version
+// or time, calling `onStop` once the latter is read. Blocks read after it are discarded.
+//
+// A block contains the times from its own timestamp up to the next block's one, so the block containing
+// the start or stop time is only known when its next block is read, unless its timestamp is the time itself.
+type boundedConsoleReader struct {
+	mindreader.ConsolerReader
+
+	bounds *readerNodeBounds
+	onStop func()
+	logger *zap.Logger
+
+	started bool
+	stopped bool
+
+	// beforeStart is the last block read before the start time, it contains the start time when
+	// the next block is after it
+	beforeStart *bstream.Block
+	pending     *bstream.Block
+}
+
+func newBoundedConsoleReader(consoleReader mindreader.ConsolerReader, bounds *readerNodeBounds, onStop func(), logger *zap.Logger) *boundedConsoleReader {
+	return &boundedConsoleReader{
+		ConsolerReader: consoleReader,
+		bounds:         bounds,
+		onStop:         onStop,
+		logger:         logger,
+	}
+}
+
+func (r *boundedConsoleReader) ReadBlock() (*bstream.Block, error) {
+	for {
+		block := r.pending
+		r.pending = nil
+
+		if block == nil {
+			var err error
+			if block, err = r.ConsolerReader.ReadBlock(); err != nil {
+				return nil, err
+			}
+		}
+
+		if r.stopped {
+			continue
+		}
+
+		if !r.started {
+			reached, err := r.reachedStart(block)
+			if err != nil {
+				return nil, err
+			}
+
+			if !reached {
+				continue
+			}
+
+			r.started = true
+			if r.beforeStart != nil {
+				block, r.pending, r.beforeStart = r.beforeStart, block, nil
+			}
+
+			r.logger.Info("reader reached its start bounds", zap.Stringer("block", block.AsRef()))
+		}
+
+		keep, err := r.checkStop(block)
+		if err != nil {
+			return nil, err
+		}
+
+		if keep {
+			return block, nil
+		}
+	}
+}
+
+func (r *boundedConsoleReader) reachedStart(block *bstream.Block) (bool, error) {
+	if r.bounds.startVersion != 0 {
+		lastVersion, err := codec.BlockLastVersion(block)
+		if err != nil {
+			return false, fmt.Errorf("block %s last version: %w", block.AsRef(), err)
+		}
+
+		if lastVersion < r.bounds.startVersion {
+			r.beforeStart = nil
+			return false, nil
+		}
+	}
+
+	if !r.bounds.startTime.IsZero() {
+		switch blockTime := block.Time(); {
+		case blockTime.Before(r.bounds.startTime):
+			r.beforeStart = block
+			return false, nil
+		case blockTime.Equal(r.bounds.startTime):
+			r.beforeStart = nil
+		}
+	}
+
+	return true, nil
+}
+
+// checkStop returns whether the block is kept, stopping the reader when it's the last block
+func (r *boundedConsoleReader) checkStop(block *bstream.Block) (bool, error) {
+	if r.bounds.stopVersion != 0 {
+		lastVersion, err := codec.BlockLastVersion(block)
+		if err != nil {
+			return false, fmt.Errorf("block %s last version: %w", block.AsRef(), err)
+		}
+
+		if lastVersion >= r.bounds.stopVersion {
+			r.stop(block, "version")
+			return true, nil
+		}
+	}
+
+	if !r.bounds.stopTime.IsZero() {
+		switch blockTime := block.Time(); {
+		case blockTime.Equal(r.bounds.stopTime):
+			r.stop(block, "time")
+			return true, nil
+		case blockTime.After(r.bounds.stopTime):
+			// The previous block contained the stop time
+			r.stop(block, "time")
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (r *boundedConsoleReader) stop(block *bstream.Block, reason string) {
+	r.stopped = true
+	r.logger.Info("shutting down because requested stop "+reason+" reached", zap.Stringer("block", block.AsRef()))
+
+	// The shutdown waits for the read flow to complete, it must not block it
+	go r.onStop()
+}
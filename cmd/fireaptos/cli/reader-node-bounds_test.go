@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBoundedConsoleReader(t *testing.T) {
+	// Block N has versions 3N to 3N+2 and is produced 10 seconds after block N-1
+	blocksStart := time.Date(2022, 10, 12, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return blocksStart.Add(time.Duration(seconds) * time.Second) }
+
+	tests := []struct {
+		name            string
+		bounds          *readerNodeBounds
+		expectedHeights []uint64
+		expectedStopped bool
+	}{
+		{"stop version", &readerNodeBounds{stopVersion: 10}, []uint64{0, 1, 2, 3}, true},
+		{"stop version on block's last version", &readerNodeBounds{stopVersion: 11}, []uint64{0, 1, 2, 3}, true},
+		{"start and stop version", &readerNodeBounds{startVersion: 10, stopVersion: 16}, []uint64{3, 4, 5}, true},
+		{"start version before first block", &readerNodeBounds{startVersion: 1}, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, false},
+		{"start time", &readerNodeBounds{startTime: at(25)}, []uint64{2, 3, 4, 5, 6, 7, 8, 9}, false},
+		{"start time on block's time", &readerNodeBounds{startTime: at(30)}, []uint64{3, 4, 5, 6, 7, 8, 9}, false},
+		{"start time before first block", &readerNodeBounds{startTime: at(-5)}, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, false},
+		{"stop time", &readerNodeBounds{stopTime: at(25)}, []uint64{0, 1, 2}, true},
+		{"stop time on block's time", &readerNodeBounds{stopTime: at(20)}, []uint64{0, 1, 2}, true},
+		{"start and stop time", &readerNodeBounds{startTime: at(25), stopTime: at(45)}, []uint64{2, 3, 4}, true},
+		{"start and stop time in same block", &readerNodeBounds{startTime: at(22), stopTime: at(28)}, []uint64{2}, true},
+		{"start version after start time", &readerNodeBounds{startVersion: 10, startTime: at(5), stopTime: at(45)}, []uint64{3, 4}, true},
+		{"start time after start version", &readerNodeBounds{startVersion: 1, startTime: at(25), stopVersion: 13}, []uint64{2, 3, 4}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var blocks []*bstream.Block
+			for height := uint64(0); height < 10; height++ {
+				blocks = append(blocks, newTestSyncStateBlock(t, height, 3*height+2, at(10*int(height))))
+			}
+
+			stopped := make(chan struct{})
+			reader := newBoundedConsoleReader(&testConsoleReader{blocks: blocks}, test.bounds, func() { close(stopped) }, zap.NewNop())
+
+			var heights []uint64
+			for {
+				block, err := reader.ReadBlock()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				heights = append(heights, block.Number)
+			}
+
+			assert.Equal(t, test.expectedHeights, heights)
+			select {
+			case <-stopped:
+				assert.True(t, test.expectedStopped, "reader stopped")
+			case <-time.After(100 * time.Millisecond):
+				assert.False(t, test.expectedStopped, "reader not stopped")
+			}
+		})
+	}
+}
+
+func TestReaderNodeBoundsFromFlags(t *testing.T) {
+	tests := []struct {
+		name          string
+		flags         map[string]interface{}
+		expected      *readerNodeBounds
+		expectedError string
+	}{
+		{"unbounded", nil, nil, ""},
+		{
+			"bounded",
+			map[string]interface{}{"reader-node-start-version": "10", "reader-node-stop-version": "20", "reader-node-stop-time": "2022-10-12T00:00:00Z"},
+			&readerNodeBounds{startVersion: 10, stopVersion: 20, stopTime: time.Date(2022, 10, 12, 0, 0, 0, 0, time.UTC)},
+			"",
+		},
+		{
+			"invalid time",
+			map[string]interface{}{"reader-node-start-time": "yesterday"},
+			nil,
+			`invalid --reader-node-start-time: invalid time "yesterday", expecting an RFC3339 timestamp like '2022-10-12T00:00:00Z'`,
+		},
+		{
+			"versions out of order",
+			map[string]interface{}{"reader-node-start-version": "20", "reader-node-stop-version": "10"},
+			nil,
+			"--reader-node-start-version 20 is after --reader-node-stop-version 10",
+		},
+		{
+			"times out of order",
+			map[string]interface{}{"reader-node-start-time": "2022-10-13T00:00:00Z", "reader-node-stop-time": "2022-10-12T00:00:00Z"},
+			nil,
+			"--reader-node-start-time 2022-10-13T00:00:00Z is after --reader-node-stop-time 2022-10-12T00:00:00Z",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer viper.Reset()
+			for flag, value := range test.flags {
+				viper.Set(flag, value)
+			}
+
+			bounds, err := readerNodeBoundsFromFlags()
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, bounds)
+		})
+	}
+}
+
+type testConsoleReader struct {
+	blocks []*bstream.Block
+}
+
+func (r *testConsoleReader) ReadBlock() (*bstream.Block, error) {
+	if len(r.blocks) == 0 {
+		return nil, io.EOF
+	}
+
+	block := r.blocks[0]
+	r.blocks = r.blocks[1:]
+
+	return block, nil
+}
+
+func (r *testConsoleReader) Done() <-chan interface{} {
+	return nil
+}
@@ -16,14 +16,15 @@ package cli
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/streamingfast/dlauncher/launcher"
 	"github.com/streamingfast/firehose-aptos/codec"
+	"github.com/streamingfast/firehose-aptos/nodemanager"
 	"github.com/streamingfast/logging"
 	nodeManager "github.com/streamingfast/node-manager"
-	nodeReaderStdinApp "github.com/streamingfast/node-manager/app/node_reader_stdin"
 	"github.com/streamingfast/node-manager/metrics"
 	"github.com/streamingfast/node-manager/mindreader"
 )
@@ -34,12 +35,17 @@ func init() {
 	launcher.RegisterApp(rootLog, &launcher.AppDef{
 		ID:            "reader-node-stdin",
 		Title:         "Reader Node (stdin)",
-		Description:   "Blocks reading node, unmanaged, reads Firehose logs from standard input and transform them into Firehose for Aptos blocks",
-		RegisterFlags: func(cmd *cobra.Command) error { return nil },
+		Description:   "Blocks reading node, unmanaged, reads Firehose logs from standard input (or another log source) and transform them into Firehose for Aptos blocks",
+		RegisterFlags: registerReaderNodeStdinFlags,
 		FactoryFunc: func(runtime *launcher.Runtime) (launcher.App, error) {
 			sfDataDir := runtime.AbsDataDir
 			archiveStoreURL := MustReplaceDataDir(sfDataDir, viper.GetString("common-one-block-store-url"))
 
+			source, err := readerNodeStdinSource(sfDataDir)
+			if err != nil {
+				return nil, err
+			}
+
 			consoleReaderFactory := func(lines chan string) (mindreader.ConsolerReader, error) {
 				r, err := codec.NewConsoleReader(appLogger, lines)
 				if err != nil {
@@ -55,7 +61,7 @@ func init() {
 			appReadiness := metrics.NewAppReadiness(metricID)
 			metricsAndReadinessManager := nodeManager.NewMetricsAndReadinessManager(headBlockTimeDrift, headBlockNumber, appReadiness, viper.GetDuration("reader-node-readiness-max-latency"))
 
-			return nodeReaderStdinApp.New(&nodeReaderStdinApp.Config{
+			return nodemanager.NewReaderStdinApp(&nodemanager.ReaderStdinAppConfig{
 				GRPCAddr:                   viper.GetString("reader-node-grpc-listen-addr"),
 				OneBlocksStoreURL:          archiveStoreURL,
 				MindReadBlocksChanCapacity: viper.GetInt("reader-node-blocks-chan-capacity"),
@@ -63,10 +69,36 @@ func init() {
 				StopBlockNum:               viper.GetUint64("reader-node-stop-block-num"),
 				WorkingDir:                 MustReplaceDataDir(sfDataDir, viper.GetString("reader-node-working-dir")),
 				OneBlockSuffix:             viper.GetString("reader-node-one-block-suffix"),
-			}, &nodeReaderStdinApp.Modules{
+				Source:                     source,
+			}, &nodemanager.ReaderStdinAppModules{
 				ConsoleReaderFactory:       consoleReaderFactory,
 				MetricsAndReadinessManager: metricsAndReadinessManager,
 			}, appLogger, appTracer), nil
 		},
 	})
 }
+
+func registerReaderNodeStdinFlags(cmd *cobra.Command) error {
+	cmd.Flags().String("reader-node-stdin-source-url", "stdin://", FlagDescription(`
+		Where the Firehose logs are read from: 'stdin://' for the standard input, 'file://<path>' to replay captured logs (gzip or zstd
+		compressed files being decompressed), 'fifo://<path>' for an existing named pipe (opened again each time its writer closes it),
+		'unix://<path>' or 'tcp://<host>:<port>' to connect to a socket (reconnecting when disconnected, the incomplete last line being
+		dropped). Like with the standard input, the app keeps running once a file has been read entirely
+	`))
+	cmd.Flags().Duration("reader-node-stdin-reconnect-delay", time.Second, "Delay before opening a named pipe or socket log source again, doubled on each consecutive failure up to 'reader-node-stdin-max-reconnect-delay'")
+	cmd.Flags().Duration("reader-node-stdin-max-reconnect-delay", 30*time.Second, "Maximum delay before opening a named pipe or socket log source again")
+
+	return nil
+}
+
+func readerNodeStdinSource(dataDir string) (*nodemanager.LogSource, error) {
+	source, err := nodemanager.ParseLogSource(MustReplaceDataDir(dataDir, viper.GetString("reader-node-stdin-source-url")))
+	if err != nil {
+		return nil, err
+	}
+
+	source.ReconnectDelay = viper.GetDuration("reader-node-stdin-reconnect-delay")
+	source.MaxReconnectDelay = viper.GetDuration("reader-node-stdin-max-reconnect-delay")
+
+	return source, nil
+}
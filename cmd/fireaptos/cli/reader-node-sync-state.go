@@ -0,0 +1,268 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/codec"
+	"github.com/streamingfast/firehose-aptos/internal/fileutil"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+)
+
+const syncStateFileName = "sync_state.json"
+
+type readerNodeSyncState struct {
+	BlockNum uint64 `json:"last_seen_block_num"`
+
+	// LastVersion, BlockTime and ClientVersion are informative, the node restarting from `BlockNum`
+	LastVersion   uint64    `json:"last_seen_transaction_version,omitempty"`
+	BlockTime     time.Time `json:"last_seen_block_time,omitempty"`
+	ClientVersion string    `json:"client_version,omitempty"`
+
+	// Deprecated: There for backward compatibility reading
+	Version uint64 `json:"last_seen_version,omitempty"`
+}
+
+func readNodeSyncState(logger *zap.Logger, path string) (state *readerNodeSyncState, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+
+	return decodeNodeSyncState(logger, path, content)
+}
+
+func decodeNodeSyncState(logger *zap.Logger, path string, content []byte) (state *readerNodeSyncState, err error) {
+	if len(content) == 0 {
+		logger.Warn("reader node sync state file content is empty, this is unexpected, resetting sync state to block num 0", zap.String("path", path))
+		return &readerNodeSyncState{BlockNum: 0}, nil
+	}
+
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("unmarshal file %q: %w", path, err)
+	}
+
+	if state.Version != 0 && state.BlockNum == 0 {
+		// Once we remove the deprecated `Version` struct and it's removed, we should remove that (and the `state.Version = 0` below)
+		logger.Info("converting wrong 'last_seen_version' field in sync state file to 'last_seen_block_num' (which is accurately represents what was actually stored in 'last_seen_version')")
+		state.BlockNum = state.Version
+	}
+
+	state.Version = 0
+
+	return state, nil
+}
+
+// writeNodeSyncState writes the state to a temporary file renamed to `path` once synced to disk, so
+// a crash while writing never leaves a truncated or empty sync state behind.
+func writeNodeSyncState(state *readerNodeSyncState, path string) (err error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal json: %w", err)
+	}
+
+	return fileutil.WriteFileAtomically(path, data)
+}
+
+// loadNodeSyncState reads the sync state at `path`, falling back to the one mirrored to `mirror`
+// (if non-nil) when there is none locally, like on a fresh volume. The returned error wraps
+// `fs.ErrNotExist` when there is no sync state at all.
+func loadNodeSyncState(ctx context.Context, logger *zap.Logger, path string, mirror *syncStateMirror) (*readerNodeSyncState, error) {
+	state, err := readNodeSyncState(logger, path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) || mirror == nil {
+		return state, err
+	}
+
+	logger.Info("no local sync state found, reading mirrored one", zap.String("path", path), zap.String("mirror", mirror.store.ObjectURL(syncStateFileName)))
+	state, err = mirror.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read mirrored sync state: %w", err)
+	}
+
+	return state, nil
+}
+
+// syncStateWriter writes the reader's sync state as blocks are written, to disk at most once per
+// interval as each write is synced, and mirrors it when a mirror is configured. The node restarting
+// from an older block only re-extracts the blocks written since.
+type syncStateWriter struct {
+	path          string
+	interval      time.Duration
+	mirror        *syncStateMirror
+	clientVersion func() string
+
+	lock      sync.Mutex
+	pending   *readerNodeSyncState
+	writtenAt time.Time
+}
+
+func (w *syncStateWriter) write(block *bstream.Block) error {
+	state := &readerNodeSyncState{
+		BlockNum:  block.Num(),
+		BlockTime: block.Time().UTC(),
+	}
+
+	if w.clientVersion != nil {
+		state.ClientVersion = w.clientVersion()
+	}
+
+	// The version is informative, a block that cannot be decoded must not stop the reader
+	state.LastVersion, _ = codec.BlockLastVersion(block)
+
+	if w.mirror != nil {
+		w.mirror.update(state)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.pending = state
+	if time.Since(w.writtenAt) < w.interval {
+		return nil
+	}
+
+	return w.flushLocked()
+}
+
+// flush writes the last sync state to disk if it was not yet.
+func (w *syncStateWriter) flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.flushLocked()
+}
+
+func (w *syncStateWriter) flushLocked() error {
+	if w.pending == nil {
+		return nil
+	}
+
+	// It's much faster to serialized to memory than write to file than trying to be clever and keep the file
+	// open and seek to top of it which was used before. There is a 100x gain and writing the file in one swift.
+	if err := writeNodeSyncState(w.pending, w.path); err != nil {
+		return err
+	}
+
+	w.pending = nil
+	w.writtenAt = time.Now()
+	return nil
+}
+
+// syncStateMirror copies the reader's sync state to a dstore, at most once per interval as the
+// store may be remote, so a reader started on a fresh volume resumes where it left off.
+type syncStateMirror struct {
+	*shutter.Shutter
+
+	store    dstore.Store
+	interval time.Duration
+	logger   *zap.Logger
+
+	lock    sync.Mutex
+	pending *readerNodeSyncState
+	writing sync.Mutex
+}
+
+func newSyncStateMirror(storeURL string, interval time.Duration, logger *zap.Logger) (*syncStateMirror, error) {
+	store, err := dstore.NewSimpleStore(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create sync state store at path %q: %w", storeURL, err)
+	}
+
+	return &syncStateMirror{
+		Shutter:  shutter.New(),
+		store:    store,
+		interval: interval,
+		logger:   logger,
+	}, nil
+}
+
+func (m *syncStateMirror) update(state *readerNodeSyncState) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.pending = state
+}
+
+// Launch writes the last sync state to the store every interval until the mirror is shut down.
+func (m *syncStateMirror) Launch() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.Terminating():
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+		if err := m.flush(ctx); err != nil {
+			m.logger.Warn("unable to mirror sync state, will retry", zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+// flush writes the last sync state to the store if it changed since the last write.
+func (m *syncStateMirror) flush(ctx context.Context) error {
+	m.writing.Lock()
+	defer m.writing.Unlock()
+
+	m.lock.Lock()
+	state := m.pending
+	m.pending = nil
+	m.lock.Unlock()
+
+	if state == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal json: %w", err)
+	}
+
+	if err := m.store.WriteObject(ctx, syncStateFileName, bytes.NewReader(data)); err != nil {
+		// Keep it for the next flush unless a newer state came in meanwhile
+		m.lock.Lock()
+		if m.pending == nil {
+			m.pending = state
+		}
+		m.lock.Unlock()
+
+		return fmt.Errorf("write sync state to store: %w", err)
+	}
+
+	return nil
+}
+
+// read returns the mirrored sync state, the error wrapping `fs.ErrNotExist` when there is none.
+func (m *syncStateMirror) read(ctx context.Context) (*readerNodeSyncState, error) {
+	reader, err := m.store.OpenObject(ctx, syncStateFileName)
+	if err != nil {
+		if errors.Is(err, dstore.ErrNotFound) {
+			return nil, fmt.Errorf("open %q: %w", m.store.ObjectURL(syncStateFileName), fs.ErrNotExist)
+		}
+
+		return nil, fmt.Errorf("open %q: %w", m.store.ObjectURL(syncStateFileName), err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", m.store.ObjectURL(syncStateFileName), err)
+	}
+
+	return decodeNodeSyncState(m.logger, m.store.ObjectURL(syncStateFileName), content)
+}
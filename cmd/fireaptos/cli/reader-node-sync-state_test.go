@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSyncStateWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, syncStateFileName)
+	blockTime := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	mirror, err := newSyncStateMirror("file://"+t.TempDir(), time.Hour, zap.NewNop())
+	require.NoError(t, err)
+
+	writer := &syncStateWriter{path: path, mirror: mirror, clientVersion: func() string { return "1.3.0" }}
+	require.NoError(t, writer.write(newTestSyncStateBlock(t, 10, 1200, blockTime)))
+
+	expected := &readerNodeSyncState{BlockNum: 10, LastVersion: 1200, BlockTime: blockTime, ClientVersion: "1.3.0"}
+
+	state, err := readNodeSyncState(zap.NewNop(), path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, state)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files must not be left behind")
+
+	// The mirror is only written on flush
+	_, err = mirror.read(context.Background())
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.NoError(t, mirror.flush(context.Background()))
+	state, err = mirror.read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, expected, state)
+}
+
+func TestSyncStateWriter_Interval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), syncStateFileName)
+	blockTime := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	writer := &syncStateWriter{path: path, interval: time.Hour}
+	require.NoError(t, writer.write(newTestSyncStateBlock(t, 10, 1200, blockTime)))
+	require.NoError(t, writer.write(newTestSyncStateBlock(t, 11, 1300, blockTime.Add(time.Second))))
+
+	// The first state is written right away, the next ones once the interval elapsed or on flush
+	state, err := readNodeSyncState(zap.NewNop(), path)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), state.BlockNum)
+
+	require.NoError(t, writer.flush())
+	state, err = readNodeSyncState(zap.NewNop(), path)
+	require.NoError(t, err)
+	assert.Equal(t, &readerNodeSyncState{BlockNum: 11, LastVersion: 1300, BlockTime: blockTime.Add(time.Second)}, state)
+
+	// Nothing is written when no block was written since the last write
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, writer.flush())
+	assert.NoFileExists(t, path)
+}
+
+func TestLoadNodeSyncState(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "work", syncStateFileName)
+
+	_, err := loadNodeSyncState(context.Background(), zap.NewNop(), path, nil)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	mirror, err := newSyncStateMirror("file://"+filepath.Join(dir, "mirror"), time.Hour, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = loadNodeSyncState(context.Background(), zap.NewNop(), path, mirror)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	mirror.update(&readerNodeSyncState{BlockNum: 20})
+	require.NoError(t, mirror.flush(context.Background()))
+
+	state, err := loadNodeSyncState(context.Background(), zap.NewNop(), path, mirror)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), state.BlockNum)
+
+	// The local sync state takes precedence over the mirrored one
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, writeNodeSyncState(&readerNodeSyncState{BlockNum: 30}, path))
+
+	state, err = loadNodeSyncState(context.Background(), zap.NewNop(), path, mirror)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(30), state.BlockNum)
+}
+
+func TestReadNodeSyncState_Legacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), syncStateFileName)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"last_seen_version":42}`), 0644))
+	state, err := readNodeSyncState(zap.NewNop(), path)
+	require.NoError(t, err)
+	assert.Equal(t, &readerNodeSyncState{BlockNum: 42}, state)
+
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	state, err = readNodeSyncState(zap.NewNop(), path)
+	require.NoError(t, err)
+	assert.Equal(t, &readerNodeSyncState{BlockNum: 0}, state)
+}
+
+func newTestSyncStateBlock(t *testing.T, height, lastVersion uint64, blockTime time.Time) *bstream.Block {
+	t.Helper()
+
+	payload, err := proto.Marshal(&pbaptos.Block{Height: height, Transactions: []*pbaptos.Transaction{{Version: lastVersion}}})
+	require.NoError(t, err)
+
+	block, err := bstream.MemoryBlockPayloadSetter(&bstream.Block{Number: height, Timestamp: blockTime}, payload)
+	require.NoError(t, err)
+
+	return block
+}
@@ -15,15 +15,14 @@
 package cli
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/bstream/blockstream"
 	"github.com/streamingfast/firehose-aptos/codec"
+	"github.com/streamingfast/firehose-aptos/nodemanager"
 	"github.com/streamingfast/logging"
 	nodeManager "github.com/streamingfast/node-manager"
 	"github.com/streamingfast/node-manager/mindreader"
@@ -40,7 +39,30 @@ func registerReaderNodeFlags(cmd *cobra.Command) error {
 	cmd.Flags().String("reader-node-working-dir", "{data-dir}/reader/work", "Path where reader will stores its files")
 	cmd.Flags().Uint("reader-node-start-block-num", 0, "Blocks that were produced with smaller block number then the given block num are skipped")
 	cmd.Flags().Uint("reader-node-stop-block-num", 0, "Shutdown reader node when we the following 'stop-block-num' has been reached, inclusively.")
+	cmd.Flags().Uint64("reader-node-start-version", 0, "Blocks before the one containing the given transaction version are skipped, 0 meaning no start version")
+	cmd.Flags().Uint64("reader-node-stop-version", 0, "Shutdown reader node when the block containing the given transaction version has been reached, inclusively, blocks after it being discarded. 0 meaning no stop version")
+	cmd.Flags().String("reader-node-start-time", "", FlagDescription(`
+		Blocks before the one containing the given time, in RFC3339 format, are skipped. A block contains the times from its timestamp
+		up to the next block's one, so it's the last block produced at or before the given time. Empty meaning no start time
+	`))
+	cmd.Flags().String("reader-node-stop-time", "", FlagDescription(`
+		Shutdown reader node when the block containing the given time, in RFC3339 format, has been reached, inclusively, blocks after
+		it being discarded. A block contains the times from its timestamp up to the next block's one, so it's the last block produced
+		at or before the given time. Empty meaning no stop time
+	`))
 	cmd.Flags().Int("reader-node-blocks-chan-capacity", 100, "Capacity of the channel holding blocks read by the reader. Process will shutdown superviser/geth if the channel gets over 90% of that capacity to prevent horrible consequences. Raise this number when processing tiny blocks very quickly")
+	cmd.Flags().Duration("reader-node-sync-state-write-interval", time.Second, FlagDescription(`
+		Interval at which the reader's sync state is written to 'sync_state.json' in 'reader-node-working-dir', the last one being
+		written on shutdown. The node restarts from the block of the sync state, a longer interval only re-extracting more blocks
+		after a crash. 0 writes it after each block
+	`))
+	cmd.Flags().String("reader-node-sync-state-store-url", "", FlagDescription(`
+		If non-empty, the reader's sync state (last block written, with its last transaction version, time and the node's client
+		version) is mirrored to 'sync_state.json' in this store every '%s', and read from it on startup when there is no local sync
+		state in 'reader-node-working-dir' (like on a fresh volume), so a stateless reader resumes where it left off. Use a
+		distinct store URL per reader.
+	`, "reader-node-sync-state-mirror-interval"))
+	cmd.Flags().Duration("reader-node-sync-state-mirror-interval", 10*time.Second, "Interval at which the reader's sync state is mirrored to 'reader-node-sync-state-store-url'")
 	cmd.Flags().String("reader-node-one-block-suffix", "default", FlagDescription(`
 		Unique identifier for reader node, so that it can produce 'oneblock files' in the same store as another instance without competing
 		for writes. You should set this flag if you have multiple reader nodes running, each one should get a unique identifier, the
@@ -56,10 +78,12 @@ func getReaderLogPlugin(
 	workingDir string,
 	batchStartBlockNum uint64,
 	batchStopBlockNum uint64,
+	bounds *readerNodeBounds,
 	blocksChanCapacity int,
 	oneBlockFileSuffix string,
 	operatorShutdownFunc func(error),
-	onLastBlockSeen func(uint64),
+	syncState *syncStateWriter,
+	onBlockWritten func(block *bstream.Block),
 	metricsAndReadinessManager *nodeManager.MetricsAndReadinessManager,
 	appLogger *zap.Logger,
 	appTracer logging.Tracer,
@@ -69,7 +93,20 @@ func getReaderLogPlugin(
 	}
 
 	consoleReaderFactory := func(lines chan string) (mindreader.ConsolerReader, error) {
-		return codec.NewConsoleReader(appLogger, lines)
+		// The node process may be restarted (after a crash or through the node manager API), the
+		// reader plugin then receiving the output of its next run
+		consoleReader, err := nodemanager.NewRestartableConsoleReader(lines, func(runLines chan string) (mindreader.ConsolerReader, error) {
+			return codec.NewConsoleReader(appLogger, runLines)
+		}, appLogger)
+		if err != nil {
+			return nil, err
+		}
+
+		if bounds != nil {
+			return newBoundedConsoleReader(consoleReader, bounds, func() { operatorShutdownFunc(nil) }, appLogger), nil
+		}
+
+		return consoleReader, nil
 	}
 
 	plugin, err := mindreader.NewMindReaderPlugin(
@@ -92,61 +129,15 @@ func getReaderLogPlugin(
 		return nil, fmt.Errorf("new reader plugin: %w", err)
 	}
 
-	syncStateFile := filepath.Join(workingDir, "sync_state.json")
-
 	plugin.OnBlockWritten(func(block *bstream.Block) error {
-		// It's much faster to serialized to memory than write to file than trying to be clever and keep the file
-		// open and seek to top of it which was used before. There is a 100x gain and writing the file in one swift.
-		if err := writeNodeSyncState(appLogger, &readerNodeSyncState{BlockNum: block.Num()}, syncStateFile); err != nil {
+		if err := syncState.write(block); err != nil {
 			return fmt.Errorf("write node sync state: %w", err)
 		}
 
-		onLastBlockSeen(block.Num())
+		onBlockWritten(block)
 
 		return nil
 	})
 
 	return plugin, nil
 }
-
-type readerNodeSyncState struct {
-	BlockNum uint64 `json:"last_seen_block_num"`
-
-	// Deprecated: There for backward compatibility reading
-	Version uint64 `json:"last_seen_version,omitempty"`
-}
-
-func readNodeSyncState(logger *zap.Logger, path string) (state *readerNodeSyncState, err error) {
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("open file: %w", err)
-	}
-
-	if len(content) == 0 {
-		logger.Warn("reader node sync state file content is empty, this is unexpected, resetting sync state to block num 0", zap.String("path", path))
-		return &readerNodeSyncState{BlockNum: 0}, nil
-	}
-
-	if err := json.Unmarshal(content, &state); err != nil {
-		return nil, fmt.Errorf("unmarshal file %q: %w", path, err)
-	}
-
-	if state.Version != 0 && state.BlockNum == 0 {
-		// Once we remove the deprecated `Version` struct and it's removed, we should remove that (and the `state.Version = 0` below)
-		logger.Info("converting wrong 'last_seen_version' field in sync state file to 'last_seen_block_num' (which is accurately represents what was actually stored in 'last_seen_version')")
-		state.BlockNum = state.Version
-	}
-
-	state.Version = 0
-
-	return state, nil
-}
-
-func writeNodeSyncState(logger *zap.Logger, state *readerNodeSyncState, path string) (err error) {
-	data, err := json.Marshal(state)
-	if err != nil {
-		return fmt.Errorf("marshal json: %w", err)
-	}
-
-	return os.WriteFile(path, data, os.ModePerm)
-}
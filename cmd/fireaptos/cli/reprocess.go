@@ -0,0 +1,629 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/internal/fileutil"
+	"github.com/streamingfast/firehose-aptos/tools"
+	sftools "github.com/streamingfast/sf-tools"
+	"go.uber.org/zap"
+)
+
+var ReprocessCmd = &cobra.Command{
+	Use:   "reprocess",
+	Short: "Re-extracts a range of blocks with concurrent readers, one per segment of the range, then merges them",
+	Long: string(cli.Description(`
+		Splits the range into segments and launches a 'fireaptos start reader-node' process per segment, up to
+		'--workers' at a time, each supervising its own aptos-node with its own data directory, start and stop
+		block and one-block suffix. Once all segments are done, their one-block files are merged into merged
+		blocks files in 'common-merged-blocks-store-url'.
+
+		Readers use the config file and network preset like 'fireaptos start' does, the flags following '--'
+		being passed to each of them on top (like '--reader-node-restore-backup-name' to start each node from
+		a backup). As all segments restore the same backup, each of them re-executes the chain from the backup's
+		block up to its own start before extracting, so the backup should be taken close to the range start. Listen addresses and node ports of each reader are picked among free ones, its logs are
+		written to 'reader.log' in the segment's directory. The reader's directories must be relative to
+		'{data-dir}' (the default) for each segment to get its own.
+
+		The progress of each segment is tracked in 'state.json' in '--work-dir'. A reader exiting before its
+		segment's stop block is retried, resuming from its sync state, up to '--max-attempts' times. Running
+		the command again with the same range resumes the segments that are not done.
+
+		The range start must be on a bundle boundary (or be the first streamable block) and its stop the last
+		block of a bundle.
+	`)),
+	Args: cobra.ArbitraryArgs,
+	RunE: reprocessE,
+	Example: string(cli.ExamplePrefixed("fireaptos reprocess", `
+		--range 0-999999 --workers 8
+
+		# Each reader's node restoring the same backup of a node synced up to block 1000000, then syncing up to its segment's start
+		--range 1000000-1999999 --workers 4 -- --reader-node-backup-store-url gs://<bucket>/backups --reader-node-restore-backup-name 0001000000-1700000000
+
+		# Synthetic Firehose logs standing in for aptos-node
+		--range 0-9999 --workers 4 -- --reader-node-path fireaptos --reader-node-arguments "tools generate firelogs --rate 0 --start-block-from-env"
+	`)),
+}
+
+func init() {
+	RootCmd.AddCommand(ReprocessCmd)
+
+	ReprocessCmd.Flags().String("range", "", "Block range to re-extract, in the form '<start>-<stop>' (inclusive)")
+	ReprocessCmd.Flags().Int("workers", 4, "Amount of readers running concurrently")
+	ReprocessCmd.Flags().Uint64("segment-size", 0, "Amount of blocks extracted by each reader, a multiple of '--bundle-size', 0 meaning the range divided by '--workers'")
+	ReprocessCmd.Flags().Uint64("bundle-size", 100, "Amount of blocks contained in each merged blocks file")
+	ReprocessCmd.Flags().Int("max-attempts", 3, "Amount of times a segment is attempted before it's considered failed")
+	ReprocessCmd.Flags().String("work-dir", "{data-dir}/reprocess", "Directory holding the state file and the data directory of each segment")
+	ReprocessCmd.Flags().Duration("progress-interval", 15*time.Second, "Interval at which the progress of running segments is read from their sync state and saved to the state file")
+}
+
+func reprocessE(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	bundleSize, _ := cmd.Flags().GetUint64("bundle-size")
+	segmentSize, _ := cmd.Flags().GetUint64("segment-size")
+	workers, _ := cmd.Flags().GetInt("workers")
+	maxAttempts, _ := cmd.Flags().GetInt("max-attempts")
+	progressInterval, _ := cmd.Flags().GetDuration("progress-interval")
+	rangeFlag, _ := cmd.Flags().GetString("range")
+	workDirFlag, _ := cmd.Flags().GetString("work-dir")
+
+	bstream.GetProtocolFirstStreamableBlock = uint64(viper.GetInt("common-first-streamable-block"))
+
+	blockRange, err := parseReprocessRange(rangeFlag, bundleSize)
+	if err != nil {
+		return err
+	}
+
+	if workers <= 0 || maxAttempts <= 0 {
+		return fmt.Errorf("flags --workers and --max-attempts must be greater than 0")
+	}
+
+	dataDir, err := filepath.Abs(viper.GetString("global-data-dir"))
+	if err != nil {
+		return fmt.Errorf("data dir absolute path: %w", err)
+	}
+
+	oneBlockStoreURL := mustReplaceDataDir(dataDir, viper.GetString("common-one-block-store-url"))
+	mergedBlocksStoreURL := mustReplaceDataDir(dataDir, viper.GetString("common-merged-blocks-store-url"))
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("resolve fireaptos executable: %w", err)
+	}
+
+	runner := &readerProcessRunner{
+		executable:       executable,
+		configFile:       viper.GetString("global-config-file"),
+		network:          viper.GetString("global-network"),
+		logFormat:        viper.GetString("global-log-format"),
+		oneBlockStoreURL: oneBlockStoreURL,
+		extraArgs:        args,
+		logger:           rootLog,
+	}
+
+	if runner.configFile != "" {
+		exists, err := fileExists(runner.configFile)
+		if err != nil {
+			return fmt.Errorf("unable to check if config file exists: %w", err)
+		}
+
+		if !exists {
+			runner.configFile = ""
+		} else if runner.configFile, err = filepath.Abs(runner.configFile); err != nil {
+			return fmt.Errorf("config file absolute path: %w", err)
+		}
+	}
+
+	reprocessor := &reprocessor{
+		workDir:          mustReplaceDataDir(dataDir, workDirFlag),
+		oneBlockStoreURL: oneBlockStoreURL,
+		blockRange:       blockRange,
+		segmentSize:      segmentSize,
+		bundleSize:       bundleSize,
+		workers:          workers,
+		maxAttempts:      maxAttempts,
+		progressInterval: progressInterval,
+		runSegment:       runner.run,
+		merge: func(ctx context.Context, blockRange sftools.BlockRange) error {
+			oneBlockStore, err := dstore.NewDBinStore(oneBlockStoreURL)
+			if err != nil {
+				return fmt.Errorf("unable to create one-block store at path %q: %w", oneBlockStoreURL, err)
+			}
+
+			mergedBlocksStore, err := dstore.NewDBinStore(mergedBlocksStoreURL)
+			if err != nil {
+				return fmt.Errorf("unable to create merged blocks store at path %q: %w", mergedBlocksStoreURL, err)
+			}
+			mergedBlocksStore.SetOverwrite(true)
+
+			return tools.MergeOneBlocks(ctx, oneBlockStore, mergedBlocksStore, blockRange, bundleSize, workers, true)
+		},
+		logger: rootLog,
+	}
+
+	return reprocessor.run(ctx)
+}
+
+// parseReprocessRange parses a `<start>-<stop>` range, which must be mergeable into whole bundles.
+func parseReprocessRange(in string, bundleSize uint64) (out sftools.BlockRange, err error) {
+	parts := strings.Split(strings.TrimSpace(in), "-")
+	if len(parts) != 2 {
+		return out, fmt.Errorf("invalid range %q, not matching format `<start>-<stop>`", in)
+	}
+
+	if out.Start, err = strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 64); err != nil {
+		return out, fmt.Errorf("invalid range %q, `<start>` is not a valid integer", in)
+	}
+
+	if out.Stop, err = strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64); err != nil {
+		return out, fmt.Errorf("invalid range %q, `<stop>` is not a valid integer", in)
+	}
+
+	// The segments' one-block files are merged at the end, the range is validated upfront
+	if err := tools.ValidateMergeRange(out, bundleSize); err != nil {
+		return out, err
+	}
+
+	return out, nil
+}
+
+const (
+	segmentStatusPending = "pending"
+	segmentStatusRunning = "running"
+	segmentStatusDone    = "done"
+	segmentStatusFailed  = "failed"
+)
+
+const reprocessStateFileName = "state.json"
+
+type reprocessState struct {
+	Start    uint64              `json:"start"`
+	Stop     uint64              `json:"stop"`
+	Segments []*reprocessSegment `json:"segments"`
+	Merged   bool                `json:"merged"`
+}
+
+type reprocessSegment struct {
+	Start     uint64 `json:"start"`
+	Stop      uint64 `json:"stop"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastBlock uint64 `json:"last_block,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (s *reprocessSegment) String() string {
+	return fmt.Sprintf("%d-%d", s.Start, s.Stop)
+}
+
+// dirName is the segment's directory within the work dir, used as the reader's data dir
+func (s *reprocessSegment) dirName() string {
+	return fmt.Sprintf("%010d-%010d", s.Start, s.Stop)
+}
+
+// reprocessor runs segments of a block range concurrently, retrying failed ones, tracking their
+// progress in a state file so it can be resumed, and merges them once all are done.
+type reprocessor struct {
+	workDir          string
+	oneBlockStoreURL string
+	blockRange       sftools.BlockRange
+	segmentSize      uint64
+	bundleSize       uint64
+	workers          int
+	maxAttempts      int
+	progressInterval time.Duration
+
+	// runSegment extracts the blocks of the segment, using `dataDir` as its data dir, the
+	// reader's sync state being expected in `{dataDir}/reader/work`.
+	runSegment func(ctx context.Context, segment *reprocessSegment, dataDir string) error
+	merge      func(ctx context.Context, blockRange sftools.BlockRange) error
+
+	logger *zap.Logger
+	state  *reprocessState
+}
+
+type segmentResult struct {
+	segment *reprocessSegment
+	err     error
+}
+
+func (r *reprocessor) run(ctx context.Context) error {
+	if err := r.loadState(); err != nil {
+		return err
+	}
+
+	var queue []*reprocessSegment
+	for _, segment := range r.state.Segments {
+		if segment.Status != segmentStatusDone {
+			// A previous run was interrupted or failed, attempts start over
+			segment.Status = segmentStatusPending
+			segment.Attempts = 0
+			queue = append(queue, segment)
+		}
+	}
+
+	r.logger.Info("reprocessing block range",
+		zap.Stringer("range", r.blockRange),
+		zap.Int("segment_count", len(r.state.Segments)),
+		zap.Int("remaining_segment_count", len(queue)),
+		zap.Int("workers", r.workers),
+	)
+
+	ticker := time.NewTicker(r.progressInterval)
+	defer ticker.Stop()
+
+	results := make(chan segmentResult)
+	running := 0
+	for {
+		for running < r.workers && len(queue) > 0 && ctx.Err() == nil {
+			segment := queue[0]
+			queue = queue[1:]
+
+			segment.Status = segmentStatusRunning
+			segment.Attempts++
+			running++
+
+			r.logger.Info("starting segment", zap.Stringer("segment", segment), zap.Int("attempt", segment.Attempts))
+			go func() {
+				results <- segmentResult{segment, r.runSegment(ctx, segment, r.segmentDir(segment))}
+			}()
+		}
+
+		if err := r.saveState(); err != nil {
+			return err
+		}
+
+		if running == 0 {
+			break
+		}
+
+		select {
+		case result := <-results:
+			running--
+			if r.completeSegment(ctx, result.segment, result.err) == segmentStatusPending && ctx.Err() == nil {
+				queue = append(queue, result.segment)
+			}
+
+		case <-ticker.C:
+			for _, segment := range r.state.Segments {
+				if segment.Status == segmentStatusRunning {
+					r.updateProgress(segment)
+				}
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("reprocessing interrupted, run the command again to resume: %w", err)
+	}
+
+	var failed []string
+	for _, segment := range r.state.Segments {
+		if segment.Status != segmentStatusDone {
+			failed = append(failed, segment.String())
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("segments %s failed after %d attempts, see %q", strings.Join(failed, ", "), r.maxAttempts, r.statePath())
+	}
+
+	if r.state.Merged {
+		r.logger.Info("all segments done and already merged, nothing to do")
+		return nil
+	}
+
+	r.logger.Info("all segments done, merging their blocks")
+	if err := r.merge(ctx, r.blockRange); err != nil {
+		return fmt.Errorf("merge one-block files: %w", err)
+	}
+
+	r.state.Merged = true
+	if err := r.saveState(); err != nil {
+		return err
+	}
+
+	r.logger.Info("reprocessing completed", zap.Stringer("range", r.blockRange))
+	return nil
+}
+
+// completeSegment updates the segment once its run ended with `err`, returning its new status. A
+// segment is done once its reader's sync state reached its stop block, whatever the run's outcome.
+func (r *reprocessor) completeSegment(ctx context.Context, segment *reprocessSegment, err error) string {
+	r.updateProgress(segment)
+
+	switch {
+	case segment.LastBlock >= segment.Stop:
+		if err = r.uploadLeftoverOneBlocks(ctx, segment); err == nil {
+			segment.Status = segmentStatusDone
+			segment.LastError = ""
+
+			r.logger.Info("segment done", zap.Stringer("segment", segment), zap.Int("attempts", segment.Attempts))
+			return segment.Status
+		}
+
+	case err == nil:
+		err = fmt.Errorf("reader exited at block #%d before segment's stop block", segment.LastBlock)
+	}
+
+	segment.LastError = err.Error()
+	segment.Status = segmentStatusPending
+	if segment.Attempts >= r.maxAttempts {
+		segment.Status = segmentStatusFailed
+	}
+
+	r.logger.Warn("segment attempt failed", zap.Stringer("segment", segment), zap.Int("attempt", segment.Attempts), zap.String("status", segment.Status), zap.Error(err))
+	return segment.Status
+}
+
+// uploadLeftoverOneBlocks uploads the one-block files the segment's reader wrote locally but did
+// not upload before exiting, which it would otherwise only do on its next start.
+func (r *reprocessor) uploadLeftoverOneBlocks(ctx context.Context, segment *reprocessSegment) error {
+	localStore, err := dstore.NewDBinStore(filepath.Join(r.segmentDir(segment), "reader", "work", "uploadable-oneblock"))
+	if err != nil {
+		return fmt.Errorf("unable to create segment local one-block store: %w", err)
+	}
+
+	// Like the reader, files being already compressed
+	oneBlockStore, err := dstore.NewStore(r.oneBlockStoreURL, "dbin.zst", "", false)
+	if err != nil {
+		return fmt.Errorf("unable to create one-block store at path %q: %w", r.oneBlockStoreURL, err)
+	}
+
+	count := 0
+	err = localStore.Walk(ctx, "", func(filename string) error {
+		err := oneBlockStore.PushLocalFile(ctx, localStore.ObjectPath(filename), filename)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Uploaded by the reader's own uploader meanwhile
+			return nil
+		}
+
+		if err == nil {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("upload leftover one-block files: %w", err)
+	}
+
+	if count > 0 {
+		r.logger.Info("uploaded leftover one-block files of segment", zap.Stringer("segment", segment), zap.Int("count", count))
+	}
+
+	return nil
+}
+
+// updateProgress reads the last block written by the segment's reader from its sync state
+func (r *reprocessor) updateProgress(segment *reprocessSegment) {
+	state, err := readNodeSyncState(r.logger, filepath.Join(r.segmentDir(segment), "reader", "work", syncStateFileName))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			r.logger.Warn("unable to read segment sync state", zap.Stringer("segment", segment), zap.Error(err))
+		}
+		return
+	}
+
+	segment.LastBlock = state.BlockNum
+}
+
+func (r *reprocessor) loadState() error {
+	content, err := os.ReadFile(r.statePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		r.state = &reprocessState{Start: r.blockRange.Start, Stop: r.blockRange.Stop, Segments: r.splitRange()}
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("read state file: %w", err)
+	}
+
+	state := &reprocessState{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return fmt.Errorf("unmarshal state file %q: %w", r.statePath(), err)
+	}
+
+	if state.Start != r.blockRange.Start || state.Stop != r.blockRange.Stop {
+		return fmt.Errorf("state file %q is for range %d-%d, remove it or use another --work-dir to reprocess range %d-%d", r.statePath(), state.Start, state.Stop, r.blockRange.Start, r.blockRange.Stop)
+	}
+
+	r.logger.Info("resuming from state file, its segments are used", zap.String("path", r.statePath()))
+	r.state = state
+	return nil
+}
+
+func (r *reprocessor) saveState() error {
+	data, err := json.MarshalIndent(r.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+
+	if err := os.MkdirAll(r.workDir, 0755); err != nil {
+		return fmt.Errorf("create work dir: %w", err)
+	}
+
+	if err := fileutil.WriteFileAtomically(r.statePath(), data); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+
+	return nil
+}
+
+// splitRange splits the block range in segments of the segment size, or in as many segments as
+// there are workers when not set, segments being aligned on bundle boundaries.
+func (r *reprocessor) splitRange() (out []*reprocessSegment) {
+	blockCount := r.blockRange.Stop - r.blockRange.Start + 1
+
+	size := r.segmentSize
+	if size == 0 {
+		size = blockCount / uint64(r.workers)
+	}
+	if remainder := size % r.bundleSize; remainder != 0 || size == 0 {
+		size += r.bundleSize - remainder
+	}
+
+	// The first segment ends on a bundle boundary even if the range starts on the first streamable block
+	alignedStart := r.blockRange.Start - (r.blockRange.Start % r.bundleSize)
+	for start := r.blockRange.Start; start <= r.blockRange.Stop; {
+		stop := alignedStart + size - 1
+		if stop > r.blockRange.Stop {
+			stop = r.blockRange.Stop
+		}
+
+		out = append(out, &reprocessSegment{Start: start, Stop: stop, Status: segmentStatusPending})
+
+		start, alignedStart = stop+1, stop+1
+	}
+
+	return out
+}
+
+func (r *reprocessor) segmentDir(segment *reprocessSegment) string {
+	return filepath.Join(r.workDir, "segments", segment.dirName())
+}
+
+func (r *reprocessor) statePath() string {
+	return filepath.Join(r.workDir, reprocessStateFileName)
+}
+
+// readerProcessRunner extracts a segment by running `fireaptos start reader-node` as a child process
+type readerProcessRunner struct {
+	executable       string
+	configFile       string
+	network          string
+	logFormat        string
+	oneBlockStoreURL string
+	extraArgs        []string
+	logger           *zap.Logger
+}
+
+func (p *readerProcessRunner) run(ctx context.Context, segment *reprocessSegment, dataDir string) error {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("create segment data dir: %w", err)
+	}
+
+	args, err := p.args(segment, dataDir)
+	if err != nil {
+		return err
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(dataDir, "reader.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open reader log file: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(p.executable, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	p.logger.Debug("launching segment reader", zap.Stringer("segment", segment), zap.String("executable", p.executable), zap.Strings("args", args))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start reader: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("reader exited: %w", err)
+		}
+		return nil
+
+	case <-ctx.Done():
+		// Let the reader stop its node cleanly, killing it only if it takes too long
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			p.logger.Warn("unable to signal segment reader", zap.Stringer("segment", segment), zap.Error(err))
+		}
+
+		select {
+		case <-done:
+		case <-time.After(time.Minute):
+			cmd.Process.Kill()
+			<-done
+		}
+
+		return ctx.Err()
+	}
+}
+
+// args returns the arguments of the segment's reader, listen addresses and node ports being free
+// ones so readers of concurrent segments do not conflict.
+func (p *readerProcessRunner) args(segment *reprocessSegment, dataDir string) ([]string, error) {
+	ports, err := freePorts(4)
+	if err != nil {
+		return nil, fmt.Errorf("find free ports: %w", err)
+	}
+
+	args := []string{"start", "reader-node",
+		"--data-dir=" + dataDir,
+		"--log-format=" + p.logFormat,
+		"--log-level-switcher-listen-addr=",
+		"--metrics-listen-addr=",
+		"--pprof-listen-addr=",
+		"--common-one-block-store-url=" + p.oneBlockStoreURL,
+		"--reader-node-working-dir=" + filepath.Join(dataDir, "reader", "work"),
+		"--reader-node-start-block-num=" + strconv.FormatUint(segment.Start, 10),
+		"--reader-node-stop-block-num=" + strconv.FormatUint(segment.Stop, 10),
+		// One-block filenames being '-' separated, the suffix cannot contain any
+		"--reader-node-one-block-suffix=" + fmt.Sprintf("reprocess%d", segment.Start),
+		"--reader-node-sync-state-store-url=",
+		"--reader-node-grpc-listen-addr=" + fmt.Sprintf("localhost:%d", ports[0]),
+		"--reader-node-manager-api-addr=" + fmt.Sprintf("localhost:%d", ports[1]),
+		"--reader-node-p2p-port=" + strconv.Itoa(ports[2]),
+		"--reader-node-rpc-port=" + strconv.Itoa(ports[3]),
+	}
+
+	// An empty config file is valid, meaning no config file, it must be passed as is
+	args = append(args, "--config-file="+p.configFile)
+	if p.network != "" {
+		args = append(args, "--network="+p.network)
+	}
+
+	return append(args, p.extraArgs...), nil
+}
+
+// freePorts returns `count` distinct TCP ports that are free at the time of the call
+func freePorts(count int) ([]int, error) {
+	var listeners []net.Listener
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	ports := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		listener, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			return nil, err
+		}
+
+		listeners = append(listeners, listener)
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+
+	return ports, nil
+}
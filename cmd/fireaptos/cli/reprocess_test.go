@@ -0,0 +1,373 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/bstream/blockstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/codec/synthetic"
+	"github.com/streamingfast/firehose-aptos/tools"
+	sftools "github.com/streamingfast/sf-tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReprocessRange(t *testing.T) {
+	tests := []struct {
+		in            string
+		expected      sftools.BlockRange
+		expectedError string
+	}{
+		{"0-999", sftools.BlockRange{Start: 0, Stop: 999}, ""},
+		{" 100 - 199 ", sftools.BlockRange{Start: 100, Stop: 199}, ""},
+		{"100", sftools.BlockRange{}, "invalid range \"100\", not matching format `<start>-<stop>`"},
+		{"a-199", sftools.BlockRange{}, "invalid range \"a-199\", `<start>` is not a valid integer"},
+		{"200-99", sftools.BlockRange{}, "range stop 99 must be greater or equal to range start 200"},
+		{"150-199", sftools.BlockRange{}, "range start 150 must be a multiple of the bundle size 100"},
+		{"100-250", sftools.BlockRange{}, "range stop 250 must be the last block of a bundle (a multiple of the bundle size 100 minus 1)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			blockRange, err := parseReprocessRange(test.in, 100)
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, blockRange)
+		})
+	}
+}
+
+func TestReprocessor_SplitRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		blockRange  sftools.BlockRange
+		segmentSize uint64
+		workers     int
+		expected    []string
+	}{
+		{"segment size", sftools.BlockRange{Start: 0, Stop: 399}, 200, 8, []string{"0-199", "200-399"}},
+		{"segment size not dividing range", sftools.BlockRange{Start: 100, Stop: 599}, 200, 1, []string{"100-299", "300-499", "500-599"}},
+		{"segment size rounded to bundle size", sftools.BlockRange{Start: 0, Stop: 399}, 150, 1, []string{"0-199", "200-399"}},
+		{"split by workers", sftools.BlockRange{Start: 0, Stop: 999}, 0, 4, []string{"0-299", "300-599", "600-899", "900-999"}},
+		{"more workers than bundles", sftools.BlockRange{Start: 0, Stop: 199}, 0, 4, []string{"0-99", "100-199"}},
+		{"first streamable block", sftools.BlockRange{Start: 1, Stop: 399}, 200, 1, []string{"1-199", "200-399"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &reprocessor{blockRange: test.blockRange, segmentSize: test.segmentSize, bundleSize: 100, workers: test.workers}
+
+			var segments []string
+			for _, segment := range r.splitRange() {
+				segments = append(segments, segment.String())
+			}
+
+			assert.Equal(t, test.expected, segments)
+		})
+	}
+}
+
+func TestReprocessor_Run(t *testing.T) {
+	r, runner, mergedBlocksStore := newTestReprocessor(t, sftools.BlockRange{Start: 0, Stop: 399})
+
+	// The first attempt of a segment crashes midway, the retry resumes from its sync state
+	runner.crashes[100] = []uint64{150}
+
+	require.NoError(t, r.run(context.Background()))
+
+	state := readTestReprocessState(t, r)
+	assert.True(t, state.Merged)
+	require.Len(t, state.Segments, 4)
+	for _, segment := range state.Segments {
+		assert.Equal(t, segmentStatusDone, segment.Status, segment.String())
+		assert.GreaterOrEqual(t, segment.LastBlock, segment.Stop, segment.String())
+		assert.Empty(t, segment.LastError, segment.String())
+	}
+	assert.Equal(t, 2, state.Segments[1].Attempts)
+	assert.Equal(t, []uint64{100, 150}, runner.startBlocks[100])
+
+	assertTestMergedBundles(t, mergedBlocksStore, "0000000000", "0000000100", "0000000200", "0000000300")
+}
+
+func TestReprocessor_Resume(t *testing.T) {
+	r, runner, mergedBlocksStore := newTestReprocessor(t, sftools.BlockRange{Start: 0, Stop: 399})
+	runner.crashes[200] = []uint64{230, 260}
+
+	err := r.run(context.Background())
+	assert.EqualError(t, err, fmt.Sprintf("segments 200-299 failed after 2 attempts, see %q", r.statePath()))
+
+	state := readTestReprocessState(t, r)
+	assert.False(t, state.Merged)
+	assert.Equal(t, segmentStatusFailed, state.Segments[2].Status)
+	assert.Equal(t, uint64(260), state.Segments[2].LastBlock)
+	assert.Equal(t, "node crashed at block #260", state.Segments[2].LastError)
+	assertTestMergedBundles(t, mergedBlocksStore)
+
+	// Running again only runs the segment that is not done, from where it left off
+	runner.startBlocks = map[uint64][]uint64{}
+	require.NoError(t, r.run(context.Background()))
+
+	assert.Equal(t, map[uint64][]uint64{200: {260}}, runner.startBlocks)
+	assert.True(t, readTestReprocessState(t, r).Merged)
+	assertTestMergedBundles(t, mergedBlocksStore, "0000000000", "0000000100", "0000000200", "0000000300")
+
+	// A different range cannot resume from the state file
+	r.blockRange = sftools.BlockRange{Start: 0, Stop: 499}
+	assert.ErrorContains(t, r.run(context.Background()), "is for range 0-399, remove it or use another --work-dir to reprocess range 0-499")
+}
+
+func TestReaderProcessRunner_Args(t *testing.T) {
+	runner := &readerProcessRunner{
+		configFile:       "/etc/firehose.yaml",
+		network:          "testnet",
+		logFormat:        "text",
+		oneBlockStoreURL: "file:///data/storage/one-blocks",
+		extraArgs:        []string{"--reader-node-restore-backup-name", "latest"},
+	}
+
+	args, err := runner.args(&reprocessSegment{Start: 100, Stop: 199}, "/data/reprocess/segments/0000000100-0000000199")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"start", "reader-node"}, args[0:2])
+	assert.Contains(t, args, "--data-dir=/data/reprocess/segments/0000000100-0000000199")
+	assert.Contains(t, args, "--common-one-block-store-url=file:///data/storage/one-blocks")
+	assert.Contains(t, args, "--reader-node-working-dir=/data/reprocess/segments/0000000100-0000000199/reader/work")
+	assert.Contains(t, args, "--reader-node-start-block-num=100")
+	assert.Contains(t, args, "--reader-node-stop-block-num=199")
+	assert.Contains(t, args, "--reader-node-one-block-suffix=reprocess100")
+	assert.Contains(t, args, "--metrics-listen-addr=")
+	assert.Contains(t, args, "--config-file=/etc/firehose.yaml")
+	assert.Contains(t, args, "--network=testnet")
+	assert.Equal(t, []string{"--reader-node-restore-backup-name", "latest"}, args[len(args)-2:])
+
+	ports := map[string]bool{}
+	for _, arg := range args {
+		for _, flag := range []string{"--reader-node-grpc-listen-addr=localhost:", "--reader-node-manager-api-addr=localhost:", "--reader-node-p2p-port=", "--reader-node-rpc-port="} {
+			if strings.HasPrefix(arg, flag) {
+				ports[strings.TrimPrefix(arg, flag)] = true
+			}
+		}
+	}
+	assert.Len(t, ports, 4, "each listen address and node port must be distinct")
+}
+
+func TestReaderProcessRunner_Run(t *testing.T) {
+	// The test binary stands in for both 'fireaptos' and aptos-node, the latter generating synthetic Firehose logs
+	t.Setenv(testRunCLIEnv, "true")
+
+	nodeConfigFile := filepath.Join(t.TempDir(), "aptos-node.yaml")
+	require.NoError(t, os.WriteFile(nodeConfigFile, []byte("base: {}\n"), 0644))
+
+	r, _, mergedBlocksStore := newTestReprocessor(t, sftools.BlockRange{Start: 0, Stop: 199})
+	runner := &readerProcessRunner{
+		executable:       os.Args[0],
+		logFormat:        "text",
+		oneBlockStoreURL: r.oneBlockStoreURL,
+		extraArgs: []string{
+			"--reader-node-path=" + os.Args[0],
+			"--reader-node-arguments=tools generate firelogs --rate 0 --start-block-from-env",
+			"--reader-node-config-file=" + nodeConfigFile,
+		},
+		logger: rootLog,
+	}
+	r.runSegment = runner.run
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	require.NoError(t, r.run(ctx))
+
+	// Each reader stopped by itself on its segment's stop block
+	state := readTestReprocessState(t, r)
+	require.Len(t, state.Segments, 2)
+	for _, segment := range state.Segments {
+		assert.Equal(t, segmentStatusDone, segment.Status, "segment %s", segment)
+		assert.Equal(t, 1, segment.Attempts, "segment %s", segment)
+		assert.GreaterOrEqual(t, segment.LastBlock, segment.Stop, "segment %s", segment)
+	}
+	assert.True(t, state.Merged)
+
+	oneBlockStore, err := dstore.NewDBinStore(r.oneBlockStoreURL)
+	require.NoError(t, err)
+
+	suffixes := map[string]int{}
+	require.NoError(t, oneBlockStore.Walk(context.Background(), "", func(filename string) error {
+		suffixes[filename[strings.LastIndex(filename, "-")+1:]]++
+		return nil
+	}))
+	assert.GreaterOrEqual(t, suffixes["reprocess0"], 100)
+	assert.GreaterOrEqual(t, suffixes["reprocess100"], 100)
+
+	assertTestMergedBundles(t, mergedBlocksStore, "0000000000", "0000000100")
+}
+
+// syntheticSegmentRunner extracts segments with the reader plugin used by 'fireaptos start reader-node',
+// the synthetic generator standing in for aptos-node.
+type syntheticSegmentRunner struct {
+	oneBlockStoreURL string
+
+	lock sync.Mutex
+	// crashes is the block at which the node crashes for each attempt of a segment, by segment start
+	crashes map[uint64][]uint64
+	// startBlocks is the block the node was started from for each attempt of a segment, by segment start
+	startBlocks map[uint64][]uint64
+}
+
+func (r *syntheticSegmentRunner) run(ctx context.Context, segment *reprocessSegment, dataDir string) error {
+	workingDir := filepath.Join(dataDir, "reader", "work")
+	syncStateFile := filepath.Join(workingDir, syncStateFileName)
+
+	// Like the reader node, the node starts from the last block seen
+	startBlock := segment.Start
+	if state, err := readNodeSyncState(rootLog, syncStateFile); err == nil {
+		startBlock = state.BlockNum
+	}
+
+	r.lock.Lock()
+	r.startBlocks[segment.Start] = append(r.startBlocks[segment.Start], startBlock)
+	var crashAt uint64
+	if crashes := r.crashes[segment.Start]; len(crashes) > 0 {
+		crashAt, r.crashes[segment.Start] = crashes[0], crashes[1:]
+	}
+	r.lock.Unlock()
+
+	metricsAndReadinessManager, _ := buildMetricsAndReadinessManager(fmt.Sprintf("reprocess-test-%d", segment.Start), time.Minute)
+	plugin, err := getReaderLogPlugin(
+		blockstream.NewUnmanagedServer(),
+		r.oneBlockStoreURL,
+		workingDir,
+		segment.Start,
+		segment.Stop,
+		nil,
+		100,
+		fmt.Sprintf("reprocess%d", segment.Start),
+		func(error) {},
+		&syncStateWriter{path: syncStateFile},
+		func(*bstream.Block) {},
+		metricsAndReadinessManager,
+		rootLog,
+		readerTracer,
+	)
+	if err != nil {
+		return err
+	}
+	go metricsAndReadinessManager.Launch()
+	plugin.Launch()
+
+	options := synthetic.DefaultOptions()
+	options.StartBlock = startBlock
+	generator, err := synthetic.NewGenerator(options)
+	if err != nil {
+		return err
+	}
+
+	errStopped := errors.New("reader stopped")
+	blockCount := uint64(0)
+	if crashAt != 0 {
+		blockCount = crashAt - startBlock + 1
+	}
+
+	err = generator.Run(ctx, lineWriter(func(line string) error {
+		if plugin.IsTerminating() {
+			return errStopped
+		}
+
+		plugin.LogLine(line)
+		return nil
+	}), blockCount, 0)
+	plugin.Stop()
+
+	switch {
+	case crashAt != 0:
+		return fmt.Errorf("node crashed at block #%d", crashAt)
+	case errors.Is(err, errStopped):
+		return nil
+	default:
+		return err
+	}
+}
+
+type lineWriter func(line string) error
+
+// Write sends each line of `p` to the function, the generator writing a whole block at once.
+func (w lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		if err := w(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func newTestReprocessor(t *testing.T, blockRange sftools.BlockRange) (*reprocessor, *syntheticSegmentRunner, dstore.Store) {
+	t.Helper()
+
+	oneBlockStoreURL := "file://" + t.TempDir()
+	mergedBlocksStore, err := dstore.NewDBinStore("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	runner := &syntheticSegmentRunner{
+		oneBlockStoreURL: oneBlockStoreURL,
+		crashes:          map[uint64][]uint64{},
+		startBlocks:      map[uint64][]uint64{},
+	}
+
+	return &reprocessor{
+		workDir:          t.TempDir(),
+		oneBlockStoreURL: oneBlockStoreURL,
+		blockRange:       blockRange,
+		segmentSize:      100,
+		bundleSize:       100,
+		workers:          2,
+		maxAttempts:      2,
+		progressInterval: 10 * time.Millisecond,
+		runSegment:       runner.run,
+		merge: func(ctx context.Context, blockRange sftools.BlockRange) error {
+			oneBlockStore, err := dstore.NewDBinStore(oneBlockStoreURL)
+			if err != nil {
+				return err
+			}
+
+			return tools.MergeOneBlocks(ctx, oneBlockStore, mergedBlocksStore, blockRange, 100, 2, true)
+		},
+		logger: rootLog,
+	}, runner, mergedBlocksStore
+}
+
+func readTestReprocessState(t *testing.T, r *reprocessor) *reprocessState {
+	t.Helper()
+
+	content, err := os.ReadFile(r.statePath())
+	require.NoError(t, err)
+
+	state := &reprocessState{}
+	require.NoError(t, json.Unmarshal(content, state))
+
+	return state
+}
+
+func assertTestMergedBundles(t *testing.T, store dstore.Store, expected ...string) {
+	t.Helper()
+
+	var bundles []string
+	require.NoError(t, store.Walk(context.Background(), "", func(filename string) error {
+		bundles = append(bundles, filename)
+		return nil
+	}))
+
+	assert.Equal(t, expected, bundles)
+}
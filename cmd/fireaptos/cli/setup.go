@@ -17,7 +17,7 @@ func setupCmd(cmd *cobra.Command) error {
 	cmds := extractCmd(cmd)
 	subCommand := cmds[len(cmds)-1]
 
-	forceConfigOn := []*cobra.Command{StartCmd}
+	forceConfigOn := []*cobra.Command{StartCmd, ConfigCheckCmd, ReprocessCmd}
 	logToFileOn := []*cobra.Command{StartCmd}
 
 	if configFile := viper.GetString("global-config-file"); configFile != "" {
@@ -26,7 +26,7 @@ func setupCmd(cmd *cobra.Command) error {
 			return fmt.Errorf("unable to check if config file exists: %w", err)
 		}
 
-		if !exists && isMatchingCommand(cmds, forceConfigOn) {
+		if !exists && isMatchingCommand(cmds, forceConfigOn) && viper.GetString("global-network") == "" {
 			return fmt.Errorf("config file %q not found. Did you 'fireaptos init'?", configFile)
 		}
 
@@ -37,6 +37,13 @@ func setupCmd(cmd *cobra.Command) error {
 		}
 	}
 
+	// 'config check' validates the flags 'start' would use and 'reprocess' launches readers with
+	// them, so they load the same config section
+	if isMatchingCommand(cmds, []*cobra.Command{ConfigCheckCmd, ReprocessCmd}) {
+		subCommand = "start"
+	}
+
+	configured := map[string]bool{}
 	subconf := launcher.Config[subCommand]
 	if subconf != nil {
 		for k, v := range subconf.Flags {
@@ -47,6 +54,7 @@ func setupCmd(cmd *cobra.Command) error {
 			}
 			if _, ok := allFlags[k]; ok {
 				viper.SetDefault(k, v)
+				configured[k] = true
 				validFlag = true
 			}
 			if !validFlag {
@@ -55,6 +63,13 @@ func setupCmd(cmd *cobra.Command) error {
 		}
 	}
 
+	// Applied once the config file is loaded, it must be possible to define the network in it
+	if network := viper.GetString("global-network"); network != "" {
+		if err := applyNetworkPreset(network, allFlags, configured); err != nil {
+			return err
+		}
+	}
+
 	launcher.SetupLogger(rootLog, &launcher.LoggingOptions{
 		WorkingDir:    viper.GetString("global-data-dir"),
 		Verbosity:     viper.GetInt("global-log-verbosity"),
@@ -16,11 +16,11 @@ package cli
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/spf13/viper"
 	"github.com/streamingfast/cli"
 	"github.com/streamingfast/logging"
 	"go.uber.org/zap"
@@ -75,25 +75,11 @@ func makeDirs(directories []string) error {
 	return nil
 }
 
-func copyFile(inPath, outPath string) error {
-	inFile, err := os.Open(inPath)
-	if err != nil {
-		return fmt.Errorf("open file: %w", err)
-	}
-	defer inFile.Close()
-
-	outFile, err := os.Create(outPath)
-	if err != nil {
-		return fmt.Errorf("create file: %w", err)
-	}
-	defer outFile.Close()
-
-	_, err = io.Copy(outFile, inFile)
-	if err != nil {
-		return fmt.Errorf("copy file %q to %q: %w", inPath, outPath, err)
-	}
-
-	return nil
+// getStringArray returns the values of the string array flag `key`, each value being kept
+// whole even when it contains commas. Viper has no accessor for string arrays but reads
+// them back from their CSV encoded representation, so `GetStringSlice` doesn't split them.
+func getStringArray(key string) []string {
+	return viper.GetStringSlice(key)
 }
 
 // MustReplaceDataDir is used in sf-ethereum-priv
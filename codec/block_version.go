@@ -0,0 +1,45 @@
+package codec
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/streamingfast/bstream"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+)
+
+// lastVersionPayload is the payload of the blocks read by the console reader, carrying the version
+// of the block's last transaction so the reader's consumers get it without decoding the block.
+type lastVersionPayload struct {
+	bstream.BlockPayload
+
+	lastVersion uint64
+}
+
+// BlockLastVersion returns the version of the last transaction of `block`, 0 when it has none. It's
+// carried by the blocks read by the console reader, other blocks are decoded.
+func BlockLastVersion(block *bstream.Block) (uint64, error) {
+	if payload, ok := block.Payload.(*lastVersionPayload); ok {
+		return payload.lastVersion, nil
+	}
+
+	payload, err := block.Payload.Get()
+	if err != nil {
+		return 0, fmt.Errorf("get block payload: %w", err)
+	}
+
+	aptosBlock := &pbaptos.Block{}
+	if err := proto.Unmarshal(payload, aptosBlock); err != nil {
+		return 0, fmt.Errorf("decode block: %w", err)
+	}
+
+	return lastVersion(aptosBlock), nil
+}
+
+func lastVersion(block *pbaptos.Block) uint64 {
+	if transactions := block.Transactions; len(transactions) > 0 {
+		return transactions[len(transactions)-1].Version
+	}
+
+	return 0
+}
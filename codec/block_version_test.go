@@ -0,0 +1,45 @@
+package codec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/firehose-aptos/types"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	tt "github.com/streamingfast/firehose-aptos/types/testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockLastVersion(t *testing.T) {
+	cr := testStringConsoleReader(t, strings.Join([]string{
+		fireInit(),
+		fireBlockStart(1),
+		fireTrx(tt.Transaction(t, 1, tt.TrxTypeBlockMetadata, tt.Timestamp(t, "2020-01-02T15:04:05Z"))),
+		fireTrx(tt.Transaction(t, 2, tt.TrxTypeUser, tt.Timestamp(t, "2020-01-02T15:04:05Z"))),
+		fireBlockEnd(1),
+	}, "\n"))
+
+	block, err := cr.ReadBlock()
+	require.NoError(t, err)
+
+	// The version is carried by the blocks of the console reader
+	require.IsType(t, &lastVersionPayload{}, block.Payload)
+	version, err := BlockLastVersion(block)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), version)
+
+	block, err = types.BlockFromProto(&pbaptos.Block{Height: 3, Transactions: []*pbaptos.Transaction{{Version: 7}, {Version: 8}}})
+	require.NoError(t, err)
+
+	version, err = BlockLastVersion(block)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), version)
+
+	block, err = types.BlockFromProto(&pbaptos.Block{Height: 4})
+	require.NoError(t, err)
+
+	version, err = BlockLastVersion(block)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), version)
+}
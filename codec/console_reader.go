@@ -29,6 +29,7 @@ type ConsoleReader struct {
 	activeBlock          *pbaptos.Block
 	chainID              uint32
 	initRead             bool
+	lineCount            uint64
 	stats                *consoleReaderStats
 }
 
@@ -51,6 +52,12 @@ func (r *ConsoleReader) Done() <-chan interface{} {
 	return r.done
 }
 
+// LineCount returns the amount of lines consumed so far by the reader, when `ReadBlock` returns
+// an error, it's the 1-based line number of the line that caused the error.
+func (r *ConsoleReader) LineCount() uint64 {
+	return r.lineCount
+}
+
 func (r *ConsoleReader) Close() {
 	r.stats.StopPeriodicLogToZap()
 
@@ -63,7 +70,13 @@ func (r *ConsoleReader) ReadBlock() (out *bstream.Block, err error) {
 		return nil, err
 	}
 
-	return types.BlockFromProto(block)
+	out, err = types.BlockFromProto(block)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Payload = &lastVersionPayload{BlockPayload: out.Payload, lastVersion: lastVersion(block)}
+	return out, nil
 }
 
 const (
@@ -76,6 +89,8 @@ const (
 
 func (r *ConsoleReader) next() (out *pbaptos.Block, err error) {
 	for line := range r.lines {
+		r.lineCount++
+
 		if !strings.HasPrefix(line, LogPrefix) {
 			continue
 		}
@@ -394,3 +394,23 @@ func EqualErrorAssertion(errString string) require.ErrorAssertionFunc {
 		require.EqualError(tt, err, errString, i...)
 	}
 }
+
+func TestConsoleReader_LineCount(t *testing.T) {
+	cr := testStringConsoleReader(t, strings.Join([]string{
+		"some node log",
+		fireInit(),
+		fireBlockStart(1),
+		fireTrx(tt.Transaction(t, 1, tt.TrxTypeGenesis, tt.Timestamp(t, "2020-01-02T15:04:05Z"))),
+		fireBlockEnd(1),
+		"another node log",
+		fireBlockEnd(2),
+	}, "\n"))
+
+	_, err := cr.ReadBlock()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), cr.LineCount())
+
+	_, err = cr.ReadBlock()
+	require.EqualError(t, err, `no active block in progress when reading BLOCK_END (on line "FIRE BLOCK_END 2")`)
+	assert.Equal(t, uint64(7), cr.LineCount())
+}
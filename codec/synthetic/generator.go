@@ -0,0 +1,461 @@
+// Package synthetic generates realistic Firehose instrumentation logs (`FIRE INIT`, `FIRE BLOCK_START`,
+// `FIRE TRX` and `FIRE BLOCK_END` lines) as emitted by an instrumented `aptos-node`, so the reader, the
+// console reader and the bench harness can be exercised without a running node.
+package synthetic
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	pbtimestamp "github.com/streamingfast/firehose-aptos/types/pb/aptos/util/timestamp"
+	"google.golang.org/protobuf/proto"
+)
+
+// Options controls the content of the generated stream.
+type Options struct {
+	ChainID      uint32
+	StartBlock   uint64
+	StartVersion uint64
+	StartTime    time.Time
+
+	// BlockInterval is the time elapsed between two generated blocks' timestamp.
+	BlockInterval time.Duration
+
+	// MinTransactions and MaxTransactions are the bounds of the amount of transactions generated in
+	// each block, on top of the block metadata transaction starting each block.
+	MinTransactions int
+	MaxTransactions int
+
+	// UserWeight, CheckpointWeight and ModulePublishWeight define the relative proportion of
+	// each kind of transaction generated.
+	UserWeight          int
+	CheckpointWeight    int
+	ModulePublishWeight int
+
+	// MinPayloadSize and MaxPayloadSize are the bounds, in bytes, of the random arguments attached
+	// to user transactions and of the bytecode of published modules.
+	MinPayloadSize int
+	MaxPayloadSize int
+
+	// AccountCount is the amount of distinct accounts used as senders and receivers.
+	AccountCount int
+
+	// NoiseLines is the amount of regular (non Firehose) `aptos-node` log lines emitted per block.
+	NoiseLines int
+
+	Faults Faults
+
+	Seed int64
+}
+
+// Faults defines the probability, between 0 and 1, of injecting a given fault in each generated block.
+type Faults struct {
+	// Gap skips one block height.
+	Gap float64
+	// TruncatedLine cuts a `FIRE TRX` line somewhere in its middle.
+	TruncatedLine float64
+	// BadBase64 corrupts the base64 payload of a `FIRE TRX` line.
+	BadBase64 float64
+	// DuplicateInit emits a new `FIRE INIT` line before the block.
+	DuplicateInit float64
+}
+
+func DefaultOptions() Options {
+	return Options{
+		ChainID:             4,
+		StartBlock:          0,
+		StartVersion:        0,
+		StartTime:           time.Date(2022, 10, 12, 0, 0, 0, 0, time.UTC),
+		BlockInterval:       250 * time.Millisecond,
+		MinTransactions:     1,
+		MaxTransactions:     10,
+		UserWeight:          80,
+		CheckpointWeight:    15,
+		ModulePublishWeight: 5,
+		MinPayloadSize:      32,
+		MaxPayloadSize:      256,
+		AccountCount:        100,
+		Seed:                1,
+	}
+}
+
+func (o Options) validate() error {
+	if o.MinTransactions < 0 || o.MaxTransactions < o.MinTransactions {
+		return fmt.Errorf("invalid transactions bounds [%d, %d]", o.MinTransactions, o.MaxTransactions)
+	}
+
+	if o.MinPayloadSize < 0 || o.MaxPayloadSize < o.MinPayloadSize {
+		return fmt.Errorf("invalid payload size bounds [%d, %d]", o.MinPayloadSize, o.MaxPayloadSize)
+	}
+
+	if o.UserWeight < 0 || o.CheckpointWeight < 0 || o.ModulePublishWeight < 0 || o.UserWeight+o.CheckpointWeight+o.ModulePublishWeight == 0 {
+		return fmt.Errorf("invalid transactions mix weights, they must be positive and at least one must be non-zero")
+	}
+
+	if o.AccountCount <= 0 {
+		return fmt.Errorf("invalid account count %d, must be greater than 0", o.AccountCount)
+	}
+
+	return nil
+}
+
+type Generator struct {
+	options  Options
+	random   *rand.Rand
+	accounts []string
+
+	nextBlock   uint64
+	nextVersion uint64
+	nextTime    time.Time
+	initWritten bool
+}
+
+func NewGenerator(options Options) (*Generator, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	g := &Generator{
+		options:     options,
+		random:      rand.New(rand.NewSource(options.Seed)),
+		nextBlock:   options.StartBlock,
+		nextVersion: options.StartVersion,
+		nextTime:    options.StartTime,
+	}
+
+	for i := 0; i < options.AccountCount; i++ {
+		g.accounts = append(g.accounts, fmt.Sprintf("0x%064x", 0x1000+i))
+	}
+
+	return g, nil
+}
+
+// NextBlock returns the height of the next block that will be generated.
+func (g *Generator) NextBlock() uint64 {
+	return g.nextBlock
+}
+
+// Run writes `blockCount` blocks (or indefinitely when 0) to the writer, emitting at most `rate` blocks
+// per second (or as fast as possible when 0). The `FIRE INIT` line is written first if not done already.
+func (g *Generator) Run(ctx context.Context, writer io.Writer, blockCount uint64, rate float64) error {
+	var ticker *time.Ticker
+	if rate > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+	}
+
+	for i := uint64(0); blockCount == 0 || i < blockCount; i++ {
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := g.WriteBlock(writer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteInit writes the `FIRE INIT` line.
+func (g *Generator) WriteInit(writer io.Writer) error {
+	g.initWritten = true
+
+	return writeLine(writer, fmt.Sprintf("FIRE INIT aptos-node 0.0.0-synthetic aptos 0 0 %d", g.options.ChainID))
+}
+
+// WriteBlock writes the next block's lines, injecting faults according to the configured probabilities.
+func (g *Generator) WriteBlock(writer io.Writer) error {
+	if !g.initWritten || g.happens(g.options.Faults.DuplicateInit) {
+		if err := g.WriteInit(writer); err != nil {
+			return err
+		}
+	}
+
+	if g.happens(g.options.Faults.Gap) {
+		g.nextBlock++
+	}
+
+	height := g.nextBlock
+	g.nextBlock++
+
+	timestamp := g.nextTime
+	g.nextTime = g.nextTime.Add(g.options.BlockInterval)
+
+	lines := []string{fmt.Sprintf("FIRE BLOCK_START %d", height)}
+	for _, trx := range g.blockTransactions(height, timestamp) {
+		data, err := proto.Marshal(trx)
+		if err != nil {
+			return fmt.Errorf("marshal transaction: %w", err)
+		}
+
+		lines = append(lines, "FIRE TRX "+base64.StdEncoding.EncodeToString(data))
+	}
+	lines = append(lines, fmt.Sprintf("FIRE BLOCK_END %d", height))
+
+	if len(lines) > 2 && g.happens(g.options.Faults.BadBase64) {
+		index := 1 + g.random.Intn(len(lines)-2)
+		lines[index] = lines[index][:len("FIRE TRX ")] + "!" + lines[index][len("FIRE TRX ")+1:]
+	}
+
+	if len(lines) > 2 && g.happens(g.options.Faults.TruncatedLine) {
+		index := 1 + g.random.Intn(len(lines)-2)
+		payloadLength := len(lines[index]) - len("FIRE TRX ")
+		lines[index] = lines[index][:len("FIRE TRX ")+g.random.Intn(payloadLength)]
+	}
+
+	for i := 0; i < g.options.NoiseLines; i++ {
+		noise := fmt.Sprintf("%s [state-sync] INFO executor.rs:%d committed synthetic chunk for block %d", timestamp.Format("2006-01-02T15:04:05.000000Z"), g.random.Intn(1000), height)
+		position := g.random.Intn(len(lines) + 1)
+		lines = append(lines[:position], append([]string{noise}, lines[position:]...)...)
+	}
+
+	return writeLine(writer, strings.Join(lines, "\n"))
+}
+
+func (g *Generator) blockTransactions(height uint64, timestamp time.Time) (out []*pbaptos.Transaction) {
+	blockMetadata := g.newTransaction(height, timestamp, pbaptos.Transaction_BLOCK_METADATA)
+	blockMetadata.TxnData = &pbaptos.Transaction_BlockMetadata{
+		BlockMetadata: &pbaptos.BlockMetadataTransaction{
+			Id:       hex.EncodeToString(g.randomBytes(32)),
+			Round:    height,
+			Proposer: g.randomAccount(),
+			Events: []*pbaptos.Event{
+				g.newEvent("0x1", 3, "0x1::block::NewBlockEvent", fmt.Sprintf(`{"height":"%d"}`, height)),
+			},
+		},
+	}
+	out = append(out, blockMetadata)
+
+	transactionCount := g.options.MinTransactions
+	if delta := g.options.MaxTransactions - g.options.MinTransactions; delta > 0 {
+		transactionCount += g.random.Intn(delta + 1)
+	}
+
+	totalWeight := g.options.UserWeight + g.options.CheckpointWeight + g.options.ModulePublishWeight
+	for i := 0; i < transactionCount; i++ {
+		pick := g.random.Intn(totalWeight)
+
+		switch {
+		case pick < g.options.UserWeight:
+			out = append(out, g.newUserTransaction(height, timestamp))
+		case pick < g.options.UserWeight+g.options.CheckpointWeight:
+			checkpoint := g.newTransaction(height, timestamp, pbaptos.Transaction_STATE_CHECKPOINT)
+			checkpoint.TxnData = &pbaptos.Transaction_StateCheckpoint{StateCheckpoint: &pbaptos.StateCheckpointTransaction{}}
+			out = append(out, checkpoint)
+		default:
+			out = append(out, g.newModulePublishTransaction(height, timestamp))
+		}
+	}
+
+	return out
+}
+
+func (g *Generator) newTransaction(height uint64, timestamp time.Time, trxType pbaptos.Transaction_TransactionType) *pbaptos.Transaction {
+	trx := &pbaptos.Transaction{
+		Timestamp:   pbtimestamp.New(timestamp),
+		Version:     g.nextVersion,
+		BlockHeight: height,
+		Epoch:       1 + height/10000,
+		Type:        trxType,
+		Info: &pbaptos.TransactionInfo{
+			Hash:                g.randomBytes(32),
+			StateChangeHash:     g.randomBytes(32),
+			EventRootHash:       g.randomBytes(32),
+			AccumulatorRootHash: g.randomBytes(32),
+			Success:             true,
+			VmStatus:            "Executed successfully",
+		},
+	}
+	g.nextVersion++
+
+	return trx
+}
+
+func (g *Generator) newUserTransaction(height uint64, timestamp time.Time) *pbaptos.Transaction {
+	sender := g.randomAccount()
+	receiver := g.randomAccount()
+	amount := g.random.Intn(1_000_000)
+
+	trx := g.newTransaction(height, timestamp, pbaptos.Transaction_USER)
+	trx.TxnData = &pbaptos.Transaction_User{
+		User: &pbaptos.UserTransaction{
+			Request: g.newUserRequest(sender, &pbaptos.TransactionPayload{
+				Type: pbaptos.TransactionPayload_ENTRY_FUNCTION_PAYLOAD,
+				Payload: &pbaptos.TransactionPayload_EntryFunctionPayload{
+					EntryFunctionPayload: &pbaptos.EntryFunctionPayload{
+						Function:  &pbaptos.EntryFunctionId{Module: &pbaptos.MoveModuleId{Address: "0x1", Name: "coin"}, Name: "transfer"},
+						Arguments: []string{fmt.Sprintf("%q", receiver), fmt.Sprintf(`"%d"`, amount), fmt.Sprintf("%q", "0x"+hex.EncodeToString(g.randomPayload()))},
+					},
+				},
+			}),
+			Events: []*pbaptos.Event{
+				g.newEvent(sender, 3, "0x1::coin::WithdrawEvent", fmt.Sprintf(`{"amount":"%d"}`, amount)),
+				g.newEvent(receiver, 2, "0x1::coin::DepositEvent", fmt.Sprintf(`{"amount":"%d"}`, amount)),
+			},
+		},
+	}
+
+	// Roughly 5% of user transactions fail.
+	if g.random.Intn(20) == 0 {
+		trx.Info.Success = false
+		trx.Info.VmStatus = "Move abort in 0x1::coin: EINSUFFICIENT_BALANCE(0x10006): Not enough coins to complete transaction"
+	}
+
+	trx.Info.GasUsed = uint64(500 + g.random.Intn(1000))
+	trx.Info.Changes = []*pbaptos.WriteSetChange{
+		g.newCoinStoreChange(sender),
+		g.newCoinStoreChange(receiver),
+		{
+			Type: pbaptos.WriteSetChange_WRITE_TABLE_ITEM,
+			Change: &pbaptos.WriteSetChange_WriteTableItem{WriteTableItem: &pbaptos.WriteTableItem{
+				StateKeyHash: g.randomBytes(32),
+				Handle:       "0x1b854694ae746cdbd8d44186ca4929b2b337df21d1c74633be19b2710552fdca",
+				Key:          "0x0619dc29a0aac8fa146714058e8dd6d2d0f3bdf5f6331907bf91f3acd81e6935",
+				Data: &pbaptos.WriteTableData{
+					Key:       `"0x619dc29a0aac8fa146714058e8dd6d2d0f3bdf5f6331907bf91f3acd81e6935"`,
+					KeyType:   "address",
+					Value:     fmt.Sprintf(`"%d"`, g.random.Int63()),
+					ValueType: "u128",
+				},
+			}},
+		},
+	}
+
+	return trx
+}
+
+func (g *Generator) newModulePublishTransaction(height uint64, timestamp time.Time) *pbaptos.Transaction {
+	sender := g.randomAccount()
+	module := &pbaptos.MoveModuleBytecode{
+		Bytecode: g.randomPayload(),
+		Abi: &pbaptos.MoveModule{
+			Address: sender,
+			Name:    fmt.Sprintf("synthetic_%d", g.random.Intn(10)),
+			ExposedFunctions: []*pbaptos.MoveFunction{
+				{Name: "run", Visibility: pbaptos.MoveFunction_PUBLIC, IsEntry: true, Params: []*pbaptos.MoveType{{Type: pbaptos.MoveTypes_Signer}}},
+			},
+			Structs: []*pbaptos.MoveStruct{
+				{Name: "Counter", Abilities: []pbaptos.MoveAbility{pbaptos.MoveAbility_KEY}, Fields: []*pbaptos.MoveStructField{{Name: "value", Type: &pbaptos.MoveType{Type: pbaptos.MoveTypes_U64}}}},
+			},
+		},
+	}
+
+	trx := g.newTransaction(height, timestamp, pbaptos.Transaction_USER)
+	trx.TxnData = &pbaptos.Transaction_User{
+		User: &pbaptos.UserTransaction{
+			Request: g.newUserRequest(sender, &pbaptos.TransactionPayload{
+				Type: pbaptos.TransactionPayload_MODULE_BUNDLE_PAYLOAD,
+				Payload: &pbaptos.TransactionPayload_ModuleBundlePayload{
+					ModuleBundlePayload: &pbaptos.ModuleBundlePayload{Modules: []*pbaptos.MoveModuleBytecode{module}},
+				},
+			}),
+		},
+	}
+
+	trx.Info.GasUsed = uint64(5000 + g.random.Intn(10000))
+	trx.Info.Changes = []*pbaptos.WriteSetChange{
+		{
+			Type: pbaptos.WriteSetChange_WRITE_MODULE,
+			Change: &pbaptos.WriteSetChange_WriteModule{WriteModule: &pbaptos.WriteModule{
+				Address:      sender,
+				StateKeyHash: g.randomBytes(32),
+				Data:         module,
+			}},
+		},
+	}
+
+	return trx
+}
+
+func (g *Generator) newUserRequest(sender string, payload *pbaptos.TransactionPayload) *pbaptos.UserTransactionRequest {
+	return &pbaptos.UserTransactionRequest{
+		Sender:                  sender,
+		SequenceNumber:          uint64(g.random.Intn(1000)),
+		MaxGasAmount:            20000,
+		GasUnitPrice:            100,
+		ExpirationTimestampSecs: pbtimestamp.New(g.nextTime.Add(10 * time.Minute)),
+		Payload:                 payload,
+		Signature: &pbaptos.Signature{
+			Type: pbaptos.Signature_ED25519,
+			Signature: &pbaptos.Signature_Ed25519{Ed25519: &pbaptos.Ed25519Signature{
+				PublicKey: g.randomBytes(32),
+				Signature: g.randomBytes(64),
+			}},
+		},
+	}
+}
+
+func (g *Generator) newCoinStoreChange(address string) *pbaptos.WriteSetChange {
+	return &pbaptos.WriteSetChange{
+		Type: pbaptos.WriteSetChange_WRITE_RESOURCE,
+		Change: &pbaptos.WriteSetChange_WriteResource{WriteResource: &pbaptos.WriteResource{
+			Address:      address,
+			StateKeyHash: g.randomBytes(32),
+			Type: &pbaptos.MoveStructTag{
+				Address: "0x1",
+				Module:  "coin",
+				Name:    "CoinStore",
+				GenericTypeParams: []*pbaptos.MoveType{
+					{Type: pbaptos.MoveTypes_Struct, Content: &pbaptos.MoveType_Struct{Struct: &pbaptos.MoveStructTag{Address: "0x1", Module: "aptos_coin", Name: "AptosCoin"}}},
+				},
+			},
+			TypeStr: "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>",
+			Data:    fmt.Sprintf(`{"coin":{"value":"%d"},"frozen":false}`, g.random.Int63n(1_000_000_000)),
+		}},
+	}
+}
+
+func (g *Generator) newEvent(address string, creationNumber uint64, typeStr string, data string) *pbaptos.Event {
+	return &pbaptos.Event{
+		Key:            &pbaptos.EventKey{CreationNumber: creationNumber, AccountAddress: address},
+		SequenceNumber: uint64(g.random.Intn(10000)),
+		TypeStr:        typeStr,
+		Data:           data,
+	}
+}
+
+func (g *Generator) randomAccount() string {
+	return g.accounts[g.random.Intn(len(g.accounts))]
+}
+
+func (g *Generator) randomPayload() []byte {
+	size := g.options.MinPayloadSize
+	if delta := g.options.MaxPayloadSize - g.options.MinPayloadSize; delta > 0 {
+		size += g.random.Intn(delta + 1)
+	}
+
+	return g.randomBytes(size)
+}
+
+func (g *Generator) randomBytes(size int) []byte {
+	out := make([]byte, size)
+	g.random.Read(out)
+
+	return out
+}
+
+func (g *Generator) happens(probability float64) bool {
+	return probability > 0 && g.random.Float64() < probability
+}
+
+func writeLine(writer io.Writer, line string) error {
+	if _, err := io.WriteString(writer, line+"\n"); err != nil {
+		return fmt.Errorf("write line: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package synthetic
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/streamingfast/firehose-aptos/codec"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGenerator_ParsedByConsoleReader(t *testing.T) {
+	options := DefaultOptions()
+	options.StartBlock = 10
+	options.StartVersion = 1000
+	options.NoiseLines = 2
+
+	blocks, errs := generateAndRead(t, options, 20)
+	require.Empty(t, errs)
+	require.Len(t, blocks, 20)
+
+	expectedVersion := uint64(1000)
+	for i, block := range blocks {
+		assert.Equal(t, uint64(10+i), block.Height)
+		assert.Equal(t, uint32(4), block.ChainId)
+		require.NotEmpty(t, block.Transactions)
+		assert.Equal(t, pbaptos.Transaction_BLOCK_METADATA, block.Transactions[0].Type)
+
+		for _, trx := range block.Transactions {
+			assert.Equal(t, expectedVersion, trx.Version)
+			expectedVersion++
+		}
+	}
+}
+
+func TestGenerator_Deterministic(t *testing.T) {
+	generate := func() []byte {
+		generator, err := NewGenerator(DefaultOptions())
+		require.NoError(t, err)
+
+		buffer := bytes.NewBuffer(nil)
+		require.NoError(t, generator.Run(context.Background(), buffer, 5, 0))
+		return buffer.Bytes()
+	}
+
+	assert.Equal(t, generate(), generate())
+}
+
+func TestGenerator_TransactionMix(t *testing.T) {
+	options := DefaultOptions()
+	options.UserWeight = 0
+	options.CheckpointWeight = 0
+	options.ModulePublishWeight = 1
+
+	blocks, errs := generateAndRead(t, options, 5)
+	require.Empty(t, errs)
+
+	for _, block := range blocks {
+		for _, trx := range block.Transactions[1:] {
+			payload := trx.GetUser().GetRequest().GetPayload()
+			require.NotNil(t, payload.GetModuleBundlePayload(), "expected only module publish transactions")
+			require.NotNil(t, trx.Info.Changes[0].GetWriteModule())
+		}
+	}
+}
+
+func TestGenerator_Faults(t *testing.T) {
+	tests := []struct {
+		name          string
+		faults        Faults
+		expectedError string
+		expectGaps    bool
+	}{
+		{"gap", Faults{Gap: 1}, "", true},
+		{"bad base64", Faults{BadBase64: 1}, "invalid base64 value", false},
+		{"truncated line", Faults{TruncatedLine: 1}, "read trx in block", false},
+		{"duplicate init", Faults{DuplicateInit: 1}, "received INIT line while one has already been read", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := DefaultOptions()
+			options.Faults = test.faults
+
+			blocks, errs := generateAndRead(t, options, 5)
+
+			if test.expectedError == "" {
+				require.Empty(t, errs)
+			} else {
+				// A fault can trigger follow-up errors on the same block, only the first one is deterministic
+				require.NotEmpty(t, errs)
+				assert.Contains(t, errs[0].Error(), test.expectedError)
+			}
+
+			if test.expectGaps {
+				require.Len(t, blocks, 5)
+				for i := 1; i < len(blocks); i++ {
+					assert.Equal(t, blocks[i-1].Height+2, blocks[i].Height)
+				}
+			}
+		})
+	}
+}
+
+func TestNewGenerator_InvalidOptions(t *testing.T) {
+	options := DefaultOptions()
+	options.UserWeight, options.CheckpointWeight, options.ModulePublishWeight = 0, 0, 0
+	_, err := NewGenerator(options)
+	assert.EqualError(t, err, "invalid transactions mix weights, they must be positive and at least one must be non-zero")
+
+	options = DefaultOptions()
+	options.MinPayloadSize, options.MaxPayloadSize = 10, 5
+	_, err = NewGenerator(options)
+	assert.EqualError(t, err, "invalid payload size bounds [10, 5]")
+}
+
+func generateAndRead(t *testing.T, options Options, blockCount uint64) (blocks []*pbaptos.Block, errs []error) {
+	t.Helper()
+
+	generator, err := NewGenerator(options)
+	require.NoError(t, err)
+
+	buffer := bytes.NewBuffer(nil)
+	require.NoError(t, generator.Run(context.Background(), buffer, blockCount, 0))
+
+	reader, err := codec.NewConsoleReader(zap.NewNop(), make(chan string, 10000))
+	require.NoError(t, err)
+	defer reader.Close()
+
+	go reader.ProcessData(buffer)
+
+	for {
+		block, err := reader.ReadBlock()
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		blocks = append(blocks, block.ToProtocol().(*pbaptos.Block))
+	}
+}
@@ -0,0 +1,31 @@
+// Package aptosutil holds the Aptos related helpers shared by the tools and the packages built
+// on blocks.
+package aptosutil
+
+import "strings"
+
+// NormalizeAddress returns the canonical form of an account address (or table handle), lower-cased,
+// `0x` prefixed and without leading zeros, so that `0x1`, `0x01` and `0x000...001` are all
+// considered equal.
+func NormalizeAddress(address string) string {
+	trimmed := strings.TrimLeft(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(address)), "0x"), "0")
+	if trimmed == "" {
+		return "0x0"
+	}
+
+	return "0x" + trimmed
+}
+
+// NormalizeMoveID normalizes the leading address of a fully qualified Move identifier like
+// `<address>::<module>::<name>` (a type, a function or a module), leaving the rest untouched.
+// Identifiers without a `0x` prefixed address, like patterns, are only trimmed.
+func NormalizeMoveID(id string) string {
+	id = strings.TrimSpace(id)
+
+	address, rest, found := strings.Cut(id, "::")
+	if !found || !strings.HasPrefix(address, "0x") {
+		return id
+	}
+
+	return NormalizeAddress(address) + "::" + rest
+}
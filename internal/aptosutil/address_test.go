@@ -0,0 +1,47 @@
+package aptosutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"0x1", "0x1"},
+		{"0x01", "0x1"},
+		{"0x0000000000000000000000000000000000000000000000000000000000000001", "0x1"},
+		{" 0xABC ", "0xabc"},
+		{"abc", "0xabc"},
+		{"0x0", "0x0"},
+		{"0x", "0x0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			assert.Equal(t, test.expected, NormalizeAddress(test.in))
+		})
+	}
+}
+
+func TestNormalizeMoveID(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"0x1::coin::transfer", "0x1::coin::transfer"},
+		{" 0x0001::coin::CoinStore<0x01::aptos_coin::AptosCoin> ", "0x1::coin::CoinStore<0x01::aptos_coin::AptosCoin>"},
+		{"0xABC::module", "0xabc::module"},
+		{"*::coin::*", "*::coin::*"},
+		{"transfer", "transfer"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			assert.Equal(t, test.expected, NormalizeMoveID(test.in))
+		})
+	}
+}
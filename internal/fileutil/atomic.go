@@ -0,0 +1,74 @@
+// Package fileutil holds the file helpers shared by the packages persisting state on disk.
+package fileutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomically writes the content to `path` through a temporary file renamed once fully
+// written, see WriteReaderAtomically.
+func WriteFileAtomically(path string, content []byte) error {
+	_, err := WriteReaderAtomically(path, bytes.NewReader(content), nil)
+	return err
+}
+
+// WriteReaderAtomically writes `in` to a temporary file next to `path` and renames it to `path`
+// once `validate` (if non-nil) accepted the amount of bytes written, so `path` is never observed
+// partially written. The temporary file and the directory are synced, a crash leaving either the
+// previous or the new content at `path`.
+func WriteReaderAtomically(path string, in io.Reader, validate func(written int64) error) (int64, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if err := tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		return 0, fmt.Errorf("chmod temporary file: %w", err)
+	}
+
+	written, err := io.Copy(tmpFile, in)
+	if err == nil {
+		// Flushed before the rename so a crash never leaves an empty or partial file at `path`
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return written, fmt.Errorf("write temporary file: %w", err)
+	}
+
+	if validate != nil {
+		if err := validate(written); err != nil {
+			return written, err
+		}
+	}
+
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return written, fmt.Errorf("rename temporary file: %w", err)
+	}
+
+	// The rename itself is only durable once the directory is synced
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return written, fmt.Errorf("sync directory: %w", err)
+	}
+
+	return written, nil
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
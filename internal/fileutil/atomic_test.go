@@ -0,0 +1,53 @@
+package fileutil
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	require.NoError(t, WriteFileAtomically(path, []byte("first")))
+	require.NoError(t, WriteFileAtomically(path, []byte("second")))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	assertNoTemporaryFile(t, filepath.Dir(path))
+}
+
+func TestWriteReaderAtomically_Rejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "download")
+	require.NoError(t, WriteFileAtomically(path, []byte("previous")))
+
+	written, err := WriteReaderAtomically(path, bytes.NewReader([]byte("truncated")), func(written int64) error {
+		return errors.New("rejected")
+	})
+	assert.EqualError(t, err, "rejected")
+	assert.Equal(t, int64(9), written)
+
+	// The previous content is kept
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "previous", string(content))
+	assertNoTemporaryFile(t, filepath.Dir(path))
+}
+
+func assertNoTemporaryFile(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
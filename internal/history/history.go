@@ -0,0 +1,216 @@
+// Package history keeps the versioned history of keys in an embedded bbolt database. Each value is
+// stored under its key followed by a NUL byte and the big-endian version that wrote it, so the
+// history of a key is contiguous, in version order, and its value as of a version is a single seek.
+// Keys must not contain a NUL byte.
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/streamingfast/firehose-aptos/internal/fileutil"
+	"go.etcd.io/bbolt"
+)
+
+const versionSize = 8
+
+// openTimeout bounds the wait for the lock of a database already opened by another process.
+const openTimeout = time.Second
+
+// DB is a bbolt database whose writes are batched in a write transaction kept open until Commit,
+// reads seeing the pending writes.
+type DB struct {
+	db *bbolt.DB
+	tx *bbolt.Tx
+}
+
+// Open opens the database at `path`, creating it if it does not exist unless `readOnly`.
+func Open(path string, readOnly bool) (*DB, error) {
+	db, err := bbolt.Open(path, 0644, &bbolt.Options{Timeout: openTimeout, ReadOnly: readOnly})
+	if err != nil {
+		if errors.Is(err, bbolt.ErrTimeout) {
+			return nil, fmt.Errorf("database %q is opened by another process", path)
+		}
+
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+// Update runs `fn` in the pending write transaction, starting it if needed. An error discards all
+// the writes pending since the last commit.
+func (d *DB) Update(fn func(tx *bbolt.Tx) error) error {
+	if d.tx == nil {
+		tx, err := d.db.Begin(true)
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+
+		d.tx = tx
+	}
+
+	if err := fn(d.tx); err != nil {
+		d.Rollback()
+		return err
+	}
+
+	return nil
+}
+
+// Rollback discards the writes pending since the last commit.
+func (d *DB) Rollback() {
+	if d.tx != nil {
+		d.tx.Rollback()
+		d.tx = nil
+	}
+}
+
+// View runs `fn` in the pending write transaction if any, in a read-only transaction otherwise.
+// Values read are only valid until `fn` returns.
+func (d *DB) View(fn func(tx *bbolt.Tx) error) error {
+	if d.tx != nil {
+		return fn(d.tx)
+	}
+
+	return d.db.View(fn)
+}
+
+// Commit commits the pending write transaction, if any, syncing it to disk.
+func (d *DB) Commit() error {
+	if d.tx == nil {
+		return nil
+	}
+
+	tx := d.tx
+	d.tx = nil
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Checkpoint commits the pending writes and copies the database to `path`, atomically replacing
+// it. The copy can be opened read-only while the database keeps being written.
+func (d *DB) Checkpoint(path string) error {
+	if err := d.Commit(); err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	copied := make(chan error, 1)
+	go func() {
+		err := d.db.View(func(tx *bbolt.Tx) error {
+			_, err := tx.WriteTo(writer)
+			return err
+		})
+
+		writer.CloseWithError(err)
+		copied <- err
+	}()
+
+	_, err := fileutil.WriteReaderAtomically(path, reader, nil)
+
+	// Unblocks the copy if the checkpoint failed before reading it all
+	reader.Close()
+	if copyErr := <-copied; err == nil && copyErr != nil {
+		err = copyErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// Close commits the pending writes and closes the database.
+func (d *DB) Close() error {
+	err := d.Commit()
+	if closeErr := d.db.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close database: %w", closeErr)
+	}
+
+	return err
+}
+
+// Put stores `value` as the value of `key` written at `version`, replacing the value already
+// written at that version.
+func Put(bucket *bbolt.Bucket, key string, version uint64, value []byte) error {
+	return bucket.Put(entryKey(key, version), value)
+}
+
+// Get returns the value of `key` as of `version`, that is the value written at the last version at
+// or before `version`, `found` being false if the key was never written at or before that version.
+func Get(bucket *bbolt.Bucket, key string, version uint64) (value []byte, found bool) {
+	cursor := bucket.Cursor()
+
+	// Positioned on the first entry after the requested version, the previous one being the value as of it
+	entry, value := cursor.Seek(entryKey(key, version))
+	if entry != nil && entryOf(entry, key) && entryVersion(entry) == version {
+		return value, true
+	}
+
+	if entry == nil {
+		entry, value = cursor.Last()
+	} else {
+		entry, value = cursor.Prev()
+	}
+
+	if entry == nil || !entryOf(entry, key) {
+		return nil, false
+	}
+
+	return value, true
+}
+
+// ForEach calls `fn` with each value of `key`, in version order.
+func ForEach(bucket *bbolt.Bucket, key string, fn func(version uint64, value []byte) error) error {
+	prefix := append([]byte(key), 0)
+
+	cursor := bucket.Cursor()
+	for entry, value := cursor.Seek(prefix); entry != nil && entryOf(entry, key); entry, value = cursor.Next() {
+		if err := fn(entryVersion(entry), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ForEachKey calls `fn` with each key starting with `prefix` that has at least one value, in key order.
+func ForEachKey(bucket *bbolt.Bucket, prefix string, fn func(key string) error) error {
+	cursor := bucket.Cursor()
+	for entry, _ := cursor.Seek([]byte(prefix)); entry != nil && bytes.HasPrefix(entry, []byte(prefix)); {
+		key := string(entry[:len(entry)-versionSize-1])
+		if err := fn(key); err != nil {
+			return err
+		}
+
+		// Skips the remaining values of the key, all its entries sorting before `<key>\x01`
+		entry, _ = cursor.Seek(append([]byte(key), 1))
+	}
+
+	return nil
+}
+
+func entryKey(key string, version uint64) []byte {
+	entry := make([]byte, len(key)+1+versionSize)
+	copy(entry, key)
+	binary.BigEndian.PutUint64(entry[len(key)+1:], version)
+
+	return entry
+}
+
+func entryOf(entry []byte, key string) bool {
+	return len(entry) == len(key)+1+versionSize && entry[len(key)] == 0 && string(entry[:len(key)]) == key
+}
+
+func entryVersion(entry []byte) uint64 {
+	return binary.BigEndian.Uint64(entry[len(entry)-versionSize:])
+}
@@ -0,0 +1,144 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+var testBucket = []byte("values")
+
+func TestGet(t *testing.T) {
+	db := newTestDB(t)
+
+	// Keys sorting right before and after `a`, and one of which `a` is a prefix
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(testBucket)
+		for _, put := range []struct {
+			key     string
+			version uint64
+			value   string
+		}{
+			{"`", 5, "before"},
+			{"a", 10, "a10"},
+			{"a", 20, "a20"},
+			{"a", 20, "a20-last"},
+			{"a/b", 15, "a/b15"},
+			{"b", 1, "after"},
+		} {
+			if err := Put(bucket, put.key, put.version, []byte(put.value)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+	require.NoError(t, db.Commit())
+
+	tests := []struct {
+		name          string
+		key           string
+		version       uint64
+		expectedValue string
+		expectedFound bool
+	}{
+		{"before first write", "a", 9, "", false},
+		{"at first write", "a", 10, "a10", true},
+		{"between writes", "a", 19, "a10", true},
+		{"overwritten at same version", "a", 20, "a20-last", true},
+		{"past last write", "a", 1000, "a20-last", true},
+		{"key prefixed by another", "a/b", 1000, "a/b15", true},
+		{"last key of bucket", "b", 1000, "after", true},
+		{"unknown key", "c", 1000, "", false},
+		{"unknown key between others", "a/a", 1000, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+				value, found := Get(tx.Bucket(testBucket), test.key, test.version)
+				assert.Equal(t, test.expectedFound, found)
+				assert.Equal(t, test.expectedValue, string(value))
+				return nil
+			}))
+		})
+	}
+
+	var versions []uint64
+	var keys []string
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		if err := ForEach(tx.Bucket(testBucket), "a", func(version uint64, value []byte) error {
+			versions = append(versions, version)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		return ForEachKey(tx.Bucket(testBucket), "a", func(key string) error {
+			keys = append(keys, key)
+			return nil
+		})
+	}))
+
+	assert.Equal(t, []uint64{10, 20}, versions)
+	assert.Equal(t, []string{"a", "a/b"}, keys)
+}
+
+func TestCheckpoint(t *testing.T) {
+	db := newTestDB(t)
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.db")
+
+	put := func(version uint64, value string) {
+		require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+			return Put(tx.Bucket(testBucket), "a", version, []byte(value))
+		}))
+	}
+
+	// Pending writes are committed by the checkpoint
+	put(10, "a10")
+	require.NoError(t, db.Checkpoint(checkpointPath))
+
+	checkpoint, err := Open(checkpointPath, true)
+	require.NoError(t, err)
+
+	// The database keeps being written and checkpointed while the previous checkpoint is opened
+	put(20, "a20")
+	require.NoError(t, db.Checkpoint(checkpointPath))
+
+	assertValue := func(db *DB, expected string) {
+		t.Helper()
+
+		require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+			value, _ := Get(tx.Bucket(testBucket), "a", 1000)
+			assert.Equal(t, expected, string(value))
+			return nil
+		}))
+	}
+
+	assertValue(checkpoint, "a10")
+	require.NoError(t, checkpoint.Close())
+
+	checkpoint, err = Open(checkpointPath, true)
+	require.NoError(t, err)
+	defer checkpoint.Close()
+
+	assertValue(checkpoint, "a20")
+}
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := Open(filepath.Join(t.TempDir(), "history.db"), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(testBucket)
+		return err
+	}))
+
+	return db
+}
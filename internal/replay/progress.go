@@ -0,0 +1,34 @@
+// Package replay holds what's shared by the stores built by replaying blocks in order.
+package replay
+
+import "fmt"
+
+// Progress tracks the last block applied to a store, embedded by the stores to expose it.
+type Progress struct {
+	hasBlock  bool
+	lastBlock uint64
+}
+
+// LastBlock returns the height of the last applied block, `ok` being false if no block was applied yet.
+func (p *Progress) LastBlock() (height uint64, ok bool) {
+	return p.lastBlock, p.hasBlock
+}
+
+// Check rejects the block at `height` if it's at or below the last applied block.
+func (p *Progress) Check(height uint64) error {
+	if p.hasBlock && height <= p.lastBlock {
+		return fmt.Errorf("block %d is at or below last applied block %d", height, p.lastBlock)
+	}
+
+	return nil
+}
+
+// Applied records the block at `height` as the last applied one.
+func (p *Progress) Applied(height uint64) {
+	p.hasBlock, p.lastBlock = true, height
+}
+
+// Restore sets the last applied block as loaded from a persisted store.
+func (p *Progress) Restore(height uint64, ok bool) {
+	p.hasBlock, p.lastBlock = ok, height
+}
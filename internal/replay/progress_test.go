@@ -0,0 +1,27 @@
+package replay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	var progress Progress
+
+	_, ok := progress.LastBlock()
+	assert.False(t, ok)
+	require.NoError(t, progress.Check(0))
+
+	progress.Applied(10)
+	lastBlock, ok := progress.LastBlock()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(10), lastBlock)
+
+	assert.EqualError(t, progress.Check(10), "block 10 is at or below last applied block 10")
+	require.NoError(t, progress.Check(11))
+
+	progress.Restore(0, false)
+	require.NoError(t, progress.Check(0))
+}
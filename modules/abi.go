@@ -0,0 +1,226 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/streamingfast/firehose-aptos/internal/aptosutil"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+)
+
+// ABI is the interface of a Move module, with all types rendered in their Move source form like
+// `vector<u8>`, `&signer` or `0x1::coin::Coin<T0>`.
+type ABI struct {
+	Friends   []string    `json:"friends,omitempty"`
+	Functions []*Function `json:"functions,omitempty"`
+	Structs   []*Struct   `json:"structs,omitempty"`
+}
+
+type Function struct {
+	Name       string   `json:"name"`
+	Visibility string   `json:"visibility"`
+	IsEntry    bool     `json:"is_entry,omitempty"`
+	TypeParams []string `json:"type_params,omitempty"`
+	Params     []string `json:"params,omitempty"`
+	Return     []string `json:"return,omitempty"`
+}
+
+type Struct struct {
+	Name       string   `json:"name"`
+	IsNative   bool     `json:"is_native,omitempty"`
+	Abilities  []string `json:"abilities,omitempty"`
+	TypeParams []string `json:"type_params,omitempty"`
+	Fields     []*Field `json:"fields,omitempty"`
+}
+
+type Field struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Function returns the exposed function named `name`, nil if there is none.
+func (a *ABI) Function(name string) *Function {
+	for _, function := range a.Functions {
+		if function.Name == name {
+			return function
+		}
+	}
+
+	return nil
+}
+
+// Struct returns the struct named `name`, nil if there is none.
+func (a *ABI) Struct(name string) *Struct {
+	for _, s := range a.Structs {
+		if s.Name == name {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// Signature returns the declaration of the function like `public entry fun transfer<T0>(&signer, address, u64)`.
+func (f *Function) Signature() string {
+	var out strings.Builder
+	switch f.Visibility {
+	case "public":
+		out.WriteString("public ")
+	case "friend":
+		out.WriteString("public(friend) ")
+	}
+	if f.IsEntry {
+		out.WriteString("entry ")
+	}
+
+	out.WriteString("fun " + f.Name)
+	out.WriteString(typeParamsString(f.TypeParams))
+	out.WriteString("(" + strings.Join(f.Params, ", ") + ")")
+
+	switch len(f.Return) {
+	case 0:
+	case 1:
+		out.WriteString(": " + f.Return[0])
+	default:
+		out.WriteString(": (" + strings.Join(f.Return, ", ") + ")")
+	}
+
+	return out.String()
+}
+
+// Signature returns the declaration of the struct like `struct Coin<phantom T0> has store { value: u64 }`.
+func (s *Struct) Signature() string {
+	var out strings.Builder
+	if s.IsNative {
+		out.WriteString("native ")
+	}
+
+	out.WriteString("struct " + s.Name)
+	out.WriteString(typeParamsString(s.TypeParams))
+
+	if len(s.Abilities) > 0 {
+		out.WriteString(" has " + strings.Join(s.Abilities, ", "))
+	}
+
+	fields := make([]string, len(s.Fields))
+	for i, field := range s.Fields {
+		fields[i] = field.Name + ": " + field.Type
+	}
+	out.WriteString(" { " + strings.Join(fields, ", ") + " }")
+
+	return out.String()
+}
+
+func typeParamsString(params []string) string {
+	if len(params) == 0 {
+		return ""
+	}
+
+	return "<" + strings.Join(params, ", ") + ">"
+}
+
+func newABI(module *pbaptos.MoveModule) *ABI {
+	abi := &ABI{}
+
+	for _, friend := range module.Friends {
+		abi.Friends = append(abi.Friends, aptosutil.NormalizeAddress(friend.Address)+"::"+friend.Name)
+	}
+
+	for _, function := range module.ExposedFunctions {
+		out := &Function{
+			Name:       function.Name,
+			Visibility: strings.ToLower(function.Visibility.String()),
+			IsEntry:    function.IsEntry,
+			Params:     formatMoveTypes(function.Params),
+			Return:     formatMoveTypes(function.Return),
+		}
+
+		for i, param := range function.GenericTypeParams {
+			out.TypeParams = append(out.TypeParams, typeParamString(i, false, param.Constraints))
+		}
+
+		abi.Functions = append(abi.Functions, out)
+	}
+
+	for _, s := range module.Structs {
+		out := &Struct{
+			Name:      s.Name,
+			IsNative:  s.IsNative,
+			Abilities: abilitiesString(s.Abilities),
+		}
+
+		for i, param := range s.GenericTypeParams {
+			out.TypeParams = append(out.TypeParams, typeParamString(i, param.IsPhantom, param.Constraints))
+		}
+
+		for _, field := range s.Fields {
+			out.Fields = append(out.Fields, &Field{Name: field.Name, Type: FormatMoveType(field.Type)})
+		}
+
+		abi.Structs = append(abi.Structs, out)
+	}
+
+	return abi
+}
+
+func typeParamString(index int, phantom bool, constraints []pbaptos.MoveAbility) string {
+	out := fmt.Sprintf("T%d", index)
+	if phantom {
+		out = "phantom " + out
+	}
+
+	if len(constraints) > 0 {
+		out += ": " + strings.Join(abilitiesString(constraints), " + ")
+	}
+
+	return out
+}
+
+func abilitiesString(abilities []pbaptos.MoveAbility) (out []string) {
+	for _, ability := range abilities {
+		out = append(out, strings.ToLower(ability.String()))
+	}
+
+	return out
+}
+
+func formatMoveTypes(moveTypes []*pbaptos.MoveType) []string {
+	if len(moveTypes) == 0 {
+		return nil
+	}
+
+	out := make([]string, len(moveTypes))
+	for i, moveType := range moveTypes {
+		out[i] = FormatMoveType(moveType)
+	}
+
+	return out
+}
+
+// FormatMoveType renders the type in its Move source form, generic type parameters being rendered
+// by their index like `T0`.
+func FormatMoveType(moveType *pbaptos.MoveType) string {
+	switch moveType.GetType() {
+	case pbaptos.MoveTypes_Vector:
+		return "vector<" + FormatMoveType(moveType.GetVector()) + ">"
+	case pbaptos.MoveTypes_Struct:
+		tag := moveType.GetStruct()
+		out := aptosutil.NormalizeAddress(tag.Address) + "::" + tag.Module + "::" + tag.Name
+		if len(tag.GenericTypeParams) > 0 {
+			out += "<" + strings.Join(formatMoveTypes(tag.GenericTypeParams), ", ") + ">"
+		}
+		return out
+	case pbaptos.MoveTypes_GenericTypeParam:
+		return fmt.Sprintf("T%d", moveType.GetGenericTypeParamIndex())
+	case pbaptos.MoveTypes_Reference:
+		reference := moveType.GetReference()
+		if reference.GetMutable() {
+			return "&mut " + FormatMoveType(reference.GetTo())
+		}
+		return "&" + FormatMoveType(reference.GetTo())
+	case pbaptos.MoveTypes_Unparsable:
+		return moveType.GetUnparsable()
+	}
+
+	return strings.ToLower(moveType.GetType().String())
+}
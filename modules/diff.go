@@ -0,0 +1,95 @@
+package modules
+
+import (
+	"sort"
+	"strings"
+)
+
+// ChangeKind is the kind of difference between two versions of a module ABI.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// Change is a difference of a single function, struct or friend between two versions of a module.
+type Change struct {
+	Kind   ChangeKind `json:"kind"`
+	Member string     `json:"member"`
+	Before string     `json:"before,omitempty"`
+	After  string     `json:"after,omitempty"`
+}
+
+// Diff returns the changes of the ABI from module `from` to module `to`, friends first, then functions
+// and structs, each sorted by name. A nil module or ABI is considered empty.
+func Diff(from *Module, to *Module) (out []*Change) {
+	before, after := abiMembers(from), abiMembers(to)
+
+	var names []string
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, found := before[name]; !found {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if memberOrder(names[i]) != memberOrder(names[j]) {
+			return memberOrder(names[i]) < memberOrder(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		beforeSignature, inBefore := before[name]
+		afterSignature, inAfter := after[name]
+
+		switch {
+		case !inBefore:
+			out = append(out, &Change{Kind: ChangeAdded, Member: name, After: afterSignature})
+		case !inAfter:
+			out = append(out, &Change{Kind: ChangeRemoved, Member: name, Before: beforeSignature})
+		case beforeSignature != afterSignature:
+			out = append(out, &Change{Kind: ChangeModified, Member: name, Before: beforeSignature, After: afterSignature})
+		}
+	}
+
+	return out
+}
+
+// abiMembers returns the signature of each member of the module ABI keyed by a name like `fun transfer`.
+func abiMembers(module *Module) map[string]string {
+	out := map[string]string{}
+	if module == nil || module.ABI == nil {
+		return out
+	}
+
+	for _, friend := range module.ABI.Friends {
+		out["friend "+friend] = "friend " + friend
+	}
+
+	for _, function := range module.ABI.Functions {
+		out["fun "+function.Name] = function.Signature()
+	}
+
+	for _, s := range module.ABI.Structs {
+		out["struct "+s.Name] = s.Signature()
+	}
+
+	return out
+}
+
+func memberOrder(name string) int {
+	switch {
+	case strings.HasPrefix(name, "friend "):
+		return 0
+	case strings.HasPrefix(name, "fun "):
+		return 1
+	}
+
+	return 2
+}
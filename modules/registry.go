@@ -0,0 +1,350 @@
+// Package modules catalogs every version of every Move module published on chain, extracted from the
+// `WriteModule` changes and `ModuleBundlePayload` of Aptos blocks, so the ABI of a module can be
+// known "as of" any version, for example to decode the arguments of an entry function or the data
+// of a resource.
+package modules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/streamingfast/firehose-aptos/internal/aptosutil"
+	"github.com/streamingfast/firehose-aptos/internal/fileutil"
+	"github.com/streamingfast/firehose-aptos/internal/replay"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/streamingfast/logging"
+	"go.uber.org/zap"
+)
+
+var zlog, _ = logging.PackageLogger("modules", "github.com/streamingfast/firehose-aptos/modules")
+
+const registryFilename = "registry.json"
+
+// ErrNotFound is returned when a module, function or struct did not exist at the requested version.
+var ErrNotFound = errors.New("not found")
+
+// Module is one published version of a Move module.
+type Module struct {
+	Address     string `json:"address"`
+	Name        string `json:"name"`
+	Version     uint64 `json:"version"`
+	BlockHeight uint64 `json:"block_height"`
+
+	// Deleted is set when the module was removed from the account by the transaction at `Version`.
+	Deleted bool `json:"deleted,omitempty"`
+
+	BytecodeHash string `json:"bytecode_hash,omitempty"`
+	BytecodeSize int    `json:"bytecode_size,omitempty"`
+	ABI          *ABI   `json:"abi,omitempty"`
+}
+
+// ID returns the fully qualified module identifier `<address>::<name>`.
+func (m *Module) ID() string {
+	return moduleID(m.Address, m.Name)
+}
+
+// Options configures how the registry is persisted.
+type Options struct {
+	// SaveInterval is the amount of applied blocks after which the registry is automatically saved, 0
+	// meaning only when `Save` or `Close` is called. The registry being rewritten as a whole, it should
+	// not be saved too often.
+	SaveInterval uint64
+}
+
+// Registry holds all the published versions of all the modules found in the applied blocks, it is
+// persisted as a single file in its directory.
+type Registry struct {
+	replay.Progress
+
+	dir          string
+	saveInterval uint64
+
+	modules map[string][]*Module
+
+	blocksSinceSave uint64
+}
+
+type registryFile struct {
+	HasBlock  bool      `json:"has_block"`
+	LastBlock uint64    `json:"last_block"`
+	Modules   []*Module `json:"modules"`
+}
+
+// Open loads the registry persisted in `dir`, an empty registry being returned if none was saved yet.
+func Open(dir string, options Options) (*Registry, error) {
+	r := &Registry{dir: dir, saveInterval: options.SaveInterval, modules: map[string][]*Module{}}
+
+	content, err := os.ReadFile(filepath.Join(dir, registryFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+
+		return nil, fmt.Errorf("read registry: %w", err)
+	}
+
+	var file registryFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("decode registry: %w", err)
+	}
+
+	r.Restore(file.LastBlock, file.HasBlock)
+	for _, module := range file.Modules {
+		r.modules[module.ID()] = append(r.modules[module.ID()], module)
+	}
+
+	return r, nil
+}
+
+// Save persists the registry to its directory, writing to a temporary file then renaming it so a
+// crash leaves either the previous or the new registry.
+func (r *Registry) Save() error {
+	file := registryFile{}
+	file.LastBlock, file.HasBlock = r.LastBlock()
+	for _, id := range r.Modules() {
+		file.Modules = append(file.Modules, r.modules[id]...)
+	}
+
+	content, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("encode registry: %w", err)
+	}
+
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return fmt.Errorf("create registry directory: %w", err)
+	}
+
+	if err := fileutil.WriteFileAtomically(filepath.Join(r.dir, registryFilename), content); err != nil {
+		return fmt.Errorf("write registry: %w", err)
+	}
+
+	zlog.Debug("saved module registry", zap.Uint64("last_block", file.LastBlock), zap.Int("module_count", len(r.modules)))
+
+	r.blocksSinceSave = 0
+	return nil
+}
+
+// Close saves the registry if blocks were applied since it was last saved.
+func (r *Registry) Close() error {
+	if r.blocksSinceSave == 0 {
+		return nil
+	}
+
+	return r.Save()
+}
+
+// ApplyBlock records the modules published or deleted by the successful transactions of the block.
+// Blocks must be applied in order, a block at or below the last applied block is rejected.
+func (r *Registry) ApplyBlock(block *pbaptos.Block) error {
+	if err := r.Check(block.Height); err != nil {
+		return err
+	}
+
+	for _, trx := range block.Transactions {
+		if !trx.GetInfo().GetSuccess() {
+			continue
+		}
+
+		for _, module := range transactionModules(trx) {
+			r.add(module)
+		}
+	}
+
+	r.Applied(block.Height)
+
+	r.blocksSinceSave++
+	if r.saveInterval > 0 && r.blocksSinceSave >= r.saveInterval {
+		return r.Save()
+	}
+
+	return nil
+}
+
+func (r *Registry) add(module *Module) {
+	id := module.ID()
+	versions := r.modules[id]
+	if len(versions) > 0 && versions[len(versions)-1].Version == module.Version {
+		versions[len(versions)-1] = module
+		return
+	}
+
+	r.modules[id] = append(versions, module)
+}
+
+// transactionModules returns the modules written or deleted by the transaction. Written modules come
+// from the `WriteModule` changes, completed by the modules of a `ModuleBundlePayload` which are not
+// found in the changes or to provide the ABI when it's missing from the change.
+func transactionModules(trx *pbaptos.Transaction) (out []*Module) {
+	byID := map[string]*Module{}
+
+	for _, change := range trx.GetInfo().GetChanges() {
+		switch {
+		case change.GetWriteModule() != nil:
+			write := change.GetWriteModule()
+			module := newModule(trx, write.Address, write.Data)
+			if module == nil {
+				zlog.Debug("skipping written module without name", zap.Uint64("version", trx.Version), zap.String("address", write.Address))
+				continue
+			}
+
+			byID[module.ID()] = module
+			out = append(out, module)
+
+		case change.GetDeleteModule() != nil:
+			deleted := change.GetDeleteModule()
+			if deleted.Module == nil {
+				continue
+			}
+
+			out = append(out, &Module{
+				Address:     aptosutil.NormalizeAddress(deleted.Address),
+				Name:        deleted.Module.Name,
+				Version:     trx.Version,
+				BlockHeight: trx.BlockHeight,
+				Deleted:     true,
+			})
+		}
+	}
+
+	for _, bytecode := range trx.GetUser().GetRequest().GetPayload().GetModuleBundlePayload().GetModules() {
+		module := newModule(trx, bytecode.GetAbi().GetAddress(), bytecode)
+		if module == nil {
+			continue
+		}
+
+		if existing, found := byID[module.ID()]; found {
+			if existing.ABI == nil {
+				existing.ABI = module.ABI
+			}
+			continue
+		}
+
+		out = append(out, module)
+	}
+
+	return out
+}
+
+// newModule returns the module of the bytecode, or nil if its name cannot be determined because the
+// bytecode has no ABI.
+func newModule(trx *pbaptos.Transaction, address string, bytecode *pbaptos.MoveModuleBytecode) *Module {
+	abi := bytecode.GetAbi()
+	if abi.GetName() == "" {
+		return nil
+	}
+
+	if address == "" {
+		address = abi.Address
+	}
+
+	hash := sha256.Sum256(bytecode.Bytecode)
+	return &Module{
+		Address:      aptosutil.NormalizeAddress(address),
+		Name:         abi.Name,
+		Version:      trx.Version,
+		BlockHeight:  trx.BlockHeight,
+		BytecodeHash: hex.EncodeToString(hash[:]),
+		BytecodeSize: len(bytecode.Bytecode),
+		ABI:          newABI(abi),
+	}
+}
+
+// Modules returns the identifier of all the modules of the registry, sorted.
+func (r *Registry) Modules() []string {
+	out := make([]string, 0, len(r.modules))
+	for id := range r.modules {
+		out = append(out, id)
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// Versions returns all the published versions of the module, in version order.
+func (r *Registry) Versions(address string, name string) []*Module {
+	return r.modules[moduleID(aptosutil.NormalizeAddress(address), name)]
+}
+
+// AsOf returns the version of the module that was live at `version`, that is the last one published
+// at or before `version`. ErrNotFound is returned if the module was not published yet or was deleted.
+func (r *Registry) AsOf(address string, name string, version uint64) (*Module, error) {
+	versions := r.Versions(address, name)
+
+	// Index of the first publication strictly after the requested version
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Version > version })
+	if i == 0 || versions[i-1].Deleted {
+		return nil, fmt.Errorf("module %s at version %d: %w", moduleID(aptosutil.NormalizeAddress(address), name), version, ErrNotFound)
+	}
+
+	return versions[i-1], nil
+}
+
+// FunctionAsOf returns the ABI of the function identified by `<address>::<module>::<name>` as it was at `version`.
+func (r *Registry) FunctionAsOf(id string, version uint64) (*Function, error) {
+	module, name, err := r.memberAsOf(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if function := module.ABI.Function(name); function != nil {
+		return function, nil
+	}
+
+	return nil, fmt.Errorf("function %s at version %d: %w", id, version, ErrNotFound)
+}
+
+// StructAsOf returns the ABI of the struct identified by `<address>::<module>::<name>` as it was at
+// `version`, generic type parameters of `id` like in `0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>`
+// being ignored.
+func (r *Registry) StructAsOf(id string, version uint64) (*Struct, error) {
+	module, name, err := r.memberAsOf(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if s := module.ABI.Struct(name); s != nil {
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("struct %s at version %d: %w", id, version, ErrNotFound)
+}
+
+func (r *Registry) memberAsOf(id string, version uint64) (*Module, string, error) {
+	base, _, _ := strings.Cut(id, "<")
+	parts := strings.Split(base, "::")
+	if len(parts) != 3 {
+		return nil, "", fmt.Errorf("invalid identifier %q, expecting '<address>::<module>::<name>'", id)
+	}
+
+	module, err := r.AsOf(parts[0], parts[1], version)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if module.ABI == nil {
+		return nil, "", fmt.Errorf("module %s at version %d has no ABI: %w", module.ID(), version, ErrNotFound)
+	}
+
+	return module, parts[2], nil
+}
+
+func moduleID(address string, name string) string {
+	return address + "::" + name
+}
+
+// ParseModuleID splits a `<address>::<name>` module identifier.
+func ParseModuleID(id string) (address string, name string, err error) {
+	address, name, found := strings.Cut(id, "::")
+	if !found || address == "" || name == "" || strings.Contains(name, "::") {
+		return "", "", fmt.Errorf("invalid module identifier %q, expecting '<address>::<name>'", id)
+	}
+
+	return aptosutil.NormalizeAddress(address), name, nil
+}
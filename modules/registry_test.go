@@ -0,0 +1,230 @@
+package modules
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_AsOf(t *testing.T) {
+	registry := newTestRegistry(t, t.TempDir())
+
+	tests := []struct {
+		name            string
+		address         string
+		module          string
+		version         uint64
+		expectedVersion uint64
+		expectedErr     error
+	}{
+		{"before publish", "0xcafe", "counter", 9, 0, ErrNotFound},
+		{"at publish", "0xcafe", "counter", 10, 10, nil},
+		{"before upgrade", "0xcafe", "counter", 19, 10, nil},
+		{"after upgrade", "0x0CAFE", "counter", 1000, 20, nil},
+		{"from bundle payload", "0xbeef", "vault", 1000, 30, nil},
+		{"failed transaction ignored", "0xdead", "broken", 1000, 0, ErrNotFound},
+		{"deleted", "0xbeef", "old", 45, 0, ErrNotFound},
+		{"before delete", "0xbeef", "old", 44, 40, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			module, err := registry.AsOf(test.address, test.module, test.version)
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), "expected %s, got %s", test.expectedErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedVersion, module.Version)
+			assert.NotNil(t, module.ABI)
+		})
+	}
+
+	assert.Equal(t, []string{"0xbeef::old", "0xbeef::vault", "0xcafe::counter"}, registry.Modules())
+	assert.Len(t, registry.Versions("0xcafe", "counter"), 2)
+}
+
+func TestRegistry_FunctionAndStructAsOf(t *testing.T) {
+	registry := newTestRegistry(t, t.TempDir())
+
+	function, err := registry.FunctionAsOf("0xcafe::counter::increment", 15)
+	require.NoError(t, err)
+	assert.Equal(t, "public entry fun increment(&signer)", function.Signature())
+
+	function, err = registry.FunctionAsOf("0xcafe::counter::increment", 25)
+	require.NoError(t, err)
+	assert.Equal(t, "public entry fun increment(&signer, u64)", function.Signature())
+
+	_, err = registry.FunctionAsOf("0xcafe::counter::reset", 15)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	s, err := registry.StructAsOf("0xcafe::counter::Counter<0x1::aptos_coin::AptosCoin>", 25)
+	require.NoError(t, err)
+	assert.Equal(t, "struct Counter<phantom T0: store> has key { value: u64, history: vector<0x1::coin::Coin<T0>> }", s.Signature())
+
+	_, err = registry.StructAsOf("counter::Counter", 25)
+	assert.EqualError(t, err, `invalid identifier "counter::Counter", expecting '<address>::<module>::<name>'`)
+}
+
+func TestRegistry_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	registry := newTestRegistry(t, dir)
+	require.NoError(t, registry.Save())
+
+	reopened, err := Open(dir, Options{})
+	require.NoError(t, err)
+
+	lastBlock, ok := reopened.LastBlock()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), lastBlock)
+	assert.Equal(t, registry.Modules(), reopened.Modules())
+	assert.Equal(t, registry.Versions("0xcafe", "counter"), reopened.Versions("0xcafe", "counter"))
+
+	assert.Error(t, reopened.ApplyBlock(&pbaptos.Block{Height: 5}))
+	assert.NoError(t, reopened.ApplyBlock(&pbaptos.Block{Height: 6}))
+}
+
+func TestRegistry_SaveInterval(t *testing.T) {
+	dir := t.TempDir()
+
+	// Saved after the fourth block, the fifth one being only saved on close
+	registry := newTestRegistryWithOptions(t, dir, Options{SaveInterval: 4})
+
+	saved, err := Open(dir, Options{})
+	require.NoError(t, err)
+	lastBlock, _ := saved.LastBlock()
+	assert.Equal(t, uint64(4), lastBlock)
+
+	require.NoError(t, registry.Close())
+
+	saved, err = Open(dir, Options{})
+	require.NoError(t, err)
+	lastBlock, _ = saved.LastBlock()
+	assert.Equal(t, uint64(5), lastBlock)
+	assert.Equal(t, registry.Modules(), saved.Modules())
+
+	// Nothing to save, closing an unchanged registry does not write it
+	require.NoError(t, os.Remove(filepath.Join(dir, registryFilename)))
+	require.NoError(t, saved.Close())
+	assert.NoFileExists(t, filepath.Join(dir, registryFilename))
+}
+
+func TestDiff(t *testing.T) {
+	registry := newTestRegistry(t, t.TempDir())
+	versions := registry.Versions("0xcafe", "counter")
+
+	assert.Equal(t, []*Change{
+		{Kind: ChangeAdded, Member: "friend 0xcafe::admin", After: "friend 0xcafe::admin"},
+		{Kind: ChangeModified, Member: "fun increment", Before: "public entry fun increment(&signer)", After: "public entry fun increment(&signer, u64)"},
+		{Kind: ChangeAdded, Member: "fun reset", After: "public(friend) fun reset(&mut 0xcafe::counter::Counter<T0>)"},
+		{Kind: ChangeRemoved, Member: "fun value", Before: "public fun value(address): u64"},
+		{Kind: ChangeModified, Member: "struct Counter", Before: "struct Counter has key { value: u64 }", After: "struct Counter<phantom T0: store> has key { value: u64, history: vector<0x1::coin::Coin<T0>> }"},
+	}, Diff(versions[0], versions[1]))
+
+	assert.Empty(t, Diff(versions[1], versions[1]))
+	assert.Len(t, Diff(nil, versions[0]), 3)
+}
+
+// newTestRegistry returns a registry where `0xcafe::counter` is published at version 10 and upgraded at
+// version 20, `0xbeef::vault` is published through a module bundle payload at version 30 and `0xbeef::old`
+// is published at version 40 and deleted at version 45.
+func newTestRegistry(t *testing.T, dir string) *Registry {
+	t.Helper()
+
+	return newTestRegistryWithOptions(t, dir, Options{})
+}
+
+func newTestRegistryWithOptions(t *testing.T, dir string, options Options) *Registry {
+	t.Helper()
+
+	registry, err := Open(dir, options)
+	require.NoError(t, err)
+
+	counterV1 := &pbaptos.MoveModule{
+		Address: "0xcafe",
+		Name:    "counter",
+		ExposedFunctions: []*pbaptos.MoveFunction{
+			{Name: "increment", Visibility: pbaptos.MoveFunction_PUBLIC, IsEntry: true, Params: []*pbaptos.MoveType{signerRef()}},
+			{Name: "value", Visibility: pbaptos.MoveFunction_PUBLIC, Params: []*pbaptos.MoveType{{Type: pbaptos.MoveTypes_Address}}, Return: []*pbaptos.MoveType{{Type: pbaptos.MoveTypes_U64}}},
+		},
+		Structs: []*pbaptos.MoveStruct{
+			{Name: "Counter", Abilities: []pbaptos.MoveAbility{pbaptos.MoveAbility_KEY}, Fields: []*pbaptos.MoveStructField{{Name: "value", Type: &pbaptos.MoveType{Type: pbaptos.MoveTypes_U64}}}},
+		},
+	}
+
+	coin := &pbaptos.MoveType{Type: pbaptos.MoveTypes_Struct, Content: &pbaptos.MoveType_Struct{Struct: &pbaptos.MoveStructTag{
+		Address: "0x01", Module: "coin", Name: "Coin", GenericTypeParams: []*pbaptos.MoveType{{Type: pbaptos.MoveTypes_GenericTypeParam, Content: &pbaptos.MoveType_GenericTypeParamIndex{GenericTypeParamIndex: 0}}},
+	}}}
+	counter := &pbaptos.MoveType{Type: pbaptos.MoveTypes_Struct, Content: &pbaptos.MoveType_Struct{Struct: &pbaptos.MoveStructTag{
+		Address: "0xcafe", Module: "counter", Name: "Counter", GenericTypeParams: []*pbaptos.MoveType{{Type: pbaptos.MoveTypes_GenericTypeParam, Content: &pbaptos.MoveType_GenericTypeParamIndex{GenericTypeParamIndex: 0}}},
+	}}}
+
+	counterV2 := &pbaptos.MoveModule{
+		Address: "0xcafe",
+		Name:    "counter",
+		Friends: []*pbaptos.MoveModuleId{{Address: "0xcafe", Name: "admin"}},
+		ExposedFunctions: []*pbaptos.MoveFunction{
+			{Name: "increment", Visibility: pbaptos.MoveFunction_PUBLIC, IsEntry: true, Params: []*pbaptos.MoveType{signerRef(), {Type: pbaptos.MoveTypes_U64}}},
+			{Name: "reset", Visibility: pbaptos.MoveFunction_FRIEND, Params: []*pbaptos.MoveType{{Type: pbaptos.MoveTypes_Reference, Content: &pbaptos.MoveType_Reference{Reference: &pbaptos.MoveType_ReferenceType{Mutable: true, To: counter}}}}},
+		},
+		Structs: []*pbaptos.MoveStruct{
+			{
+				Name:              "Counter",
+				Abilities:         []pbaptos.MoveAbility{pbaptos.MoveAbility_KEY},
+				GenericTypeParams: []*pbaptos.MoveStructGenericTypeParam{{IsPhantom: true, Constraints: []pbaptos.MoveAbility{pbaptos.MoveAbility_STORE}}},
+				Fields: []*pbaptos.MoveStructField{
+					{Name: "value", Type: &pbaptos.MoveType{Type: pbaptos.MoveTypes_U64}},
+					{Name: "history", Type: &pbaptos.MoveType{Type: pbaptos.MoveTypes_Vector, Content: &pbaptos.MoveType_Vector{Vector: coin}}},
+				},
+			},
+		},
+	}
+
+	vault := &pbaptos.MoveModule{Address: "0xbeef", Name: "vault"}
+	old := &pbaptos.MoveModule{Address: "0xbeef", Name: "old"}
+	broken := &pbaptos.MoveModule{Address: "0xdead", Name: "broken"}
+
+	bundleTrx := newTestTransaction(30, true)
+	bundleTrx.TxnData = &pbaptos.Transaction_User{User: &pbaptos.UserTransaction{Request: &pbaptos.UserTransactionRequest{
+		Payload: &pbaptos.TransactionPayload{Payload: &pbaptos.TransactionPayload_ModuleBundlePayload{ModuleBundlePayload: &pbaptos.ModuleBundlePayload{
+			Modules: []*pbaptos.MoveModuleBytecode{{Bytecode: []byte{0xa1, 0x1c}, Abi: vault}},
+		}}},
+	}}}
+
+	blocks := []*pbaptos.Block{
+		{Height: 1, Transactions: []*pbaptos.Transaction{newTestTransaction(10, true, writeModule(counterV1))}},
+		{Height: 2, Transactions: []*pbaptos.Transaction{newTestTransaction(20, true, writeModule(counterV2))}},
+		{Height: 3, Transactions: []*pbaptos.Transaction{bundleTrx, newTestTransaction(31, false, writeModule(broken))}},
+		{Height: 4, Transactions: []*pbaptos.Transaction{newTestTransaction(40, true, writeModule(old))}},
+		{Height: 5, Transactions: []*pbaptos.Transaction{newTestTransaction(45, true, &pbaptos.WriteSetChange{Change: &pbaptos.WriteSetChange_DeleteModule{DeleteModule: &pbaptos.DeleteModule{
+			Address: "0xbeef", Module: &pbaptos.MoveModuleId{Address: "0xbeef", Name: "old"},
+		}}})}},
+	}
+
+	for _, block := range blocks {
+		require.NoError(t, registry.ApplyBlock(block))
+	}
+
+	return registry
+}
+
+func newTestTransaction(version uint64, success bool, changes ...*pbaptos.WriteSetChange) *pbaptos.Transaction {
+	return &pbaptos.Transaction{Version: version, Info: &pbaptos.TransactionInfo{Success: success, Changes: changes}}
+}
+
+func writeModule(abi *pbaptos.MoveModule) *pbaptos.WriteSetChange {
+	return &pbaptos.WriteSetChange{Change: &pbaptos.WriteSetChange_WriteModule{WriteModule: &pbaptos.WriteModule{
+		Address: abi.Address,
+		Data:    &pbaptos.MoveModuleBytecode{Bytecode: []byte(abi.Name), Abi: abi},
+	}}}
+}
+
+func signerRef() *pbaptos.MoveType {
+	return &pbaptos.MoveType{Type: pbaptos.MoveTypes_Reference, Content: &pbaptos.MoveType_Reference{Reference: &pbaptos.MoveType_ReferenceType{To: &pbaptos.MoveType{Type: pbaptos.MoveTypes_Signer}}}}
+}
@@ -0,0 +1,90 @@
+package nodemanager
+
+import (
+	"fmt"
+
+	dgrpcserver "github.com/streamingfast/dgrpc/server"
+	dgrpcfactory "github.com/streamingfast/dgrpc/server/factory"
+	"github.com/streamingfast/dmetrics"
+	nodeManagerApp "github.com/streamingfast/node-manager/app/node_manager2"
+	"github.com/streamingfast/node-manager/metrics"
+	"github.com/streamingfast/node-manager/operator"
+	"go.uber.org/zap"
+)
+
+// App is the node manager app of `github.com/streamingfast/node-manager/app/node_manager2`, only
+// running differently to launch the operator with `httpOptions`, adding routes to the node manager
+// API which the upstream app launches without any.
+type App struct {
+	*nodeManagerApp.App
+
+	config          *nodeManagerApp.Config
+	modules         *nodeManagerApp.Modules
+	httpOptions     []operator.HTTPOption
+	ledgerReadiness *LedgerReadiness
+	zlogger         *zap.Logger
+}
+
+// NewApp creates the node manager app, `ledgerReadiness`, when non-nil, being launched along the
+// operator to poll the node's ledger info.
+func NewApp(config *nodeManagerApp.Config, modules *nodeManagerApp.Modules, httpOptions []operator.HTTPOption, ledgerReadiness *LedgerReadiness, zlogger *zap.Logger) *App {
+	return &App{
+		App:             nodeManagerApp.New(config, modules, zlogger),
+		config:          config,
+		modules:         modules,
+		httpOptions:     httpOptions,
+		ledgerReadiness: ledgerReadiness,
+		zlogger:         zlogger,
+	}
+}
+
+func (a *App) Run() error {
+	hasMindreader := a.modules.MindreaderPlugin != nil
+	a.zlogger.Info("running node manager app", zap.Reflect("config", a.config), zap.Bool("mindreader", hasMindreader))
+
+	dmetrics.Register(metrics.Metricset)
+
+	a.OnTerminating(func(err error) {
+		a.modules.Operator.Shutdown(err)
+		<-a.modules.Operator.Terminated()
+	})
+
+	a.modules.Operator.OnTerminated(func(err error) {
+		a.zlogger.Info("chain operator terminated shutting down mindreader app")
+		a.Shutdown(err)
+	})
+
+	if hasMindreader {
+		if err := a.startMindreader(); err != nil {
+			return fmt.Errorf("unable to start mindreader: %w", err)
+		}
+	}
+
+	a.zlogger.Info("launching operator")
+	go a.modules.MetricsAndReadinessManager.Launch()
+	if a.ledgerReadiness != nil {
+		a.OnTerminating(a.ledgerReadiness.Shutdown)
+		go a.ledgerReadiness.Launch()
+	}
+	go a.Shutdown(a.modules.Operator.Launch(a.config.HTTPAddr, a.httpOptions...))
+
+	return nil
+}
+
+func (a *App) startMindreader() error {
+	a.zlogger.Info("starting mindreader gRPC server")
+	gs := dgrpcfactory.ServerFromOptions(dgrpcserver.WithLogger(a.zlogger))
+
+	if a.modules.RegisterGRPCService != nil {
+		if err := a.modules.RegisterGRPCService(gs.ServiceRegistrar()); err != nil {
+			return fmt.Errorf("register extra grpc service: %w", err)
+		}
+	}
+
+	gs.OnTerminated(a.Shutdown)
+
+	// Launch is blocking and we don't want to block in this method
+	go gs.Launch(a.config.GRPCAddr)
+
+	return nil
+}
@@ -0,0 +1,387 @@
+package nodemanager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+const (
+	backupDataDirPrefix    = "data/"
+	backupSyncStateEntry   = "sync_state.json"
+	LatestBackupName       = "latest"
+	backupArchiveExtension = "tar.gz"
+)
+
+// DataDirBackupModule archives the node data directory along with the reader sync state
+// file into a gzipped tarball saved to a dstore and restores it back. The node must be
+// stopped for both operations, which is handled by the operator through `RequiresStop`.
+//
+// Backups are named `<block num>-<unix timestamp>`, the block num being zero padded so
+// that the lexicographically greatest name is always the most recent backup.
+type DataDirBackupModule struct {
+	store         dstore.Store
+	dataDir       string
+	syncStateFile string
+	onRestored    func(blockNum uint64) error
+	logger        *zap.Logger
+}
+
+// NewDataDirBackupModule creates a backup module reading and writing its archives to
+// `storeURL`. The `onRestored` callback, when non-nil, is invoked after each successful
+// restore with the block num at which the backup was taken.
+func NewDataDirBackupModule(storeURL, dataDir, syncStateFile string, onRestored func(blockNum uint64) error, logger *zap.Logger) (*DataDirBackupModule, error) {
+	store, err := dstore.NewStore(storeURL, backupArchiveExtension, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create backup store at path %q: %w", storeURL, err)
+	}
+
+	return &DataDirBackupModule{
+		store:         store,
+		dataDir:       dataDir,
+		syncStateFile: syncStateFile,
+		onRestored:    onRestored,
+		logger:        logger,
+	}, nil
+}
+
+func (m *DataDirBackupModule) RequiresStop() bool {
+	return true
+}
+
+func (m *DataDirBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	name := fmt.Sprintf("%010d-%d", lastSeenBlockNum, time.Now().Unix())
+	m.logger.Info("backing up node data directory", zap.String("data_dir", m.dataDir), zap.String("backup_name", name))
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(m.writeArchive(writer))
+	}()
+
+	if err := m.store.WriteObject(context.Background(), name, reader); err != nil {
+		reader.CloseWithError(err)
+		return "", fmt.Errorf("write backup %q: %w", name, err)
+	}
+
+	return name, nil
+}
+
+func (m *DataDirBackupModule) Restore(name string) error {
+	ctx := context.Background()
+
+	if name == "" || name == LatestBackupName {
+		latest, err := m.LatestBackup(ctx)
+		if err != nil {
+			return err
+		}
+
+		name = latest
+	}
+
+	blockNum, err := parseBackupBlockNum(name)
+	if err != nil {
+		return err
+	}
+
+	m.logger.Info("restoring node data directory", zap.String("data_dir", m.dataDir), zap.String("backup_name", name), zap.Uint64("block_num", blockNum))
+
+	reader, err := m.store.OpenObject(ctx, name)
+	if err != nil {
+		return fmt.Errorf("open backup %q: %w", name, err)
+	}
+	defer reader.Close()
+
+	// The backup is extracted next to the data directory and sync state file, replacing them only
+	// once fully extracted so a corrupted backup or a store failure leaves the node's data untouched
+	stagingDataDir, err := os.MkdirTemp(filepath.Dir(m.dataDir), filepath.Base(m.dataDir)+".restore-")
+	if err != nil {
+		return fmt.Errorf("create restore directory: %w", err)
+	}
+	defer os.RemoveAll(stagingDataDir)
+
+	if err := os.Chmod(stagingDataDir, 0755); err != nil {
+		return fmt.Errorf("create restore directory: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.syncStateFile), 0755); err != nil {
+		return fmt.Errorf("create sync state directory: %w", err)
+	}
+
+	stagingSyncStateFile := m.syncStateFile + ".restore"
+	defer os.Remove(stagingSyncStateFile)
+
+	hasSyncState, err := m.extractArchive(reader, stagingDataDir, stagingSyncStateFile)
+	if err != nil {
+		return fmt.Errorf("extract backup %q: %w", name, err)
+	}
+
+	if err := replaceDir(m.dataDir, stagingDataDir); err != nil {
+		return fmt.Errorf("replace data directory: %w", err)
+	}
+
+	if hasSyncState {
+		if err := os.Rename(stagingSyncStateFile, m.syncStateFile); err != nil {
+			return fmt.Errorf("replace sync state: %w", err)
+		}
+	}
+
+	if m.onRestored != nil {
+		if err := m.onRestored(blockNum); err != nil {
+			return fmt.Errorf("restored callback: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// LatestBackup returns the name of the most recent backup in the store.
+func (m *DataDirBackupModule) LatestBackup(ctx context.Context) (string, error) {
+	latest := ""
+	err := m.store.Walk(ctx, "", func(filename string) error {
+		if _, err := parseBackupBlockNum(filename); err == nil && filename > latest {
+			latest = filename
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("list backups: %w", err)
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("no backup found in store %q", m.store.BaseURL())
+	}
+
+	return latest, nil
+}
+
+func (m *DataDirBackupModule) writeArchive(out io.Writer) error {
+	gzipWriter := gzip.NewWriter(out)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err := filepath.WalkDir(m.dataDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(m.dataDir, path)
+		if err != nil {
+			return err
+		}
+
+		if relativePath == "." {
+			return nil
+		}
+
+		return addFileToArchive(tarWriter, path, backupDataDirPrefix+filepath.ToSlash(relativePath))
+	})
+	if err != nil {
+		return fmt.Errorf("archive data directory: %w", err)
+	}
+
+	if err := addFileToArchive(tarWriter, m.syncStateFile, backupSyncStateEntry); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("archive sync state: %w", err)
+		}
+
+		m.logger.Warn("no sync state file found, backup will only contain node data directory", zap.String("sync_state_file", m.syncStateFile))
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+func addFileToArchive(tarWriter *tar.Writer, path string, name string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("write header of %q: %w", name, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(tarWriter, file); err != nil {
+		return fmt.Errorf("write content of %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// extractArchive extracts the data directory entries of the backup to `dataDir` and its sync
+// state to `syncStateFile`, returning whether the backup had one.
+func (m *DataDirBackupModule) extractArchive(in io.Reader, dataDir, syncStateFile string) (hasSyncState bool, err error) {
+	gzipReader, err := gzip.NewReader(in)
+	if err != nil {
+		return false, err
+	}
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return hasSyncState, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		var destination string
+		switch {
+		case header.Name == backupSyncStateEntry:
+			if header.Typeflag != tar.TypeReg {
+				return false, fmt.Errorf("invalid entry %q, expecting a regular file", header.Name)
+			}
+
+			destination = syncStateFile
+			hasSyncState = true
+		case strings.HasPrefix(header.Name, backupDataDirPrefix):
+			relativePath := filepath.FromSlash(strings.TrimPrefix(header.Name, backupDataDirPrefix))
+			if !isWithinDir(dataDir, filepath.Join(dataDir, relativePath)) {
+				return false, fmt.Errorf("invalid entry %q, escapes the data directory", header.Name)
+			}
+
+			if header.Typeflag == tar.TypeSymlink && !m.isSymlinkWithinDataDir(relativePath, header.Linkname) {
+				return false, fmt.Errorf("invalid entry %q, its link %q escapes the data directory", header.Name, header.Linkname)
+			}
+
+			destination = filepath.Join(dataDir, relativePath)
+		default:
+			m.logger.Warn("skipping unknown backup entry", zap.String("name", header.Name))
+			continue
+		}
+
+		if err := extractEntry(tarReader, header, destination); err != nil {
+			return false, fmt.Errorf("extract %q: %w", header.Name, err)
+		}
+	}
+}
+
+// isSymlinkWithinDataDir returns true when the link at `relativePath` in the data directory
+// points inside of it.
+func (m *DataDirBackupModule) isSymlinkWithinDataDir(relativePath, link string) bool {
+	target := filepath.FromSlash(link)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(m.dataDir, filepath.Dir(relativePath), target)
+	}
+
+	return isWithinDir(m.dataDir, target)
+}
+
+// isWithinDir returns true when `path` is `dir` or a path under it.
+func isWithinDir(dir, path string) bool {
+	relativePath, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+
+	return relativePath == "." || (relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator)))
+}
+
+// replaceDir replaces `dir` by `replacement`, which must be on the same filesystem.
+func replaceDir(dir, replacement string) error {
+	previous := dir + ".previous"
+	if err := os.RemoveAll(previous); err != nil {
+		return err
+	}
+
+	if err := os.Rename(dir, previous); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Rename(replacement, dir); err != nil {
+		// Puts the previous directory back, the restore failing as a whole
+		if restoreErr := os.Rename(previous, dir); restoreErr != nil && !errors.Is(restoreErr, fs.ErrNotExist) {
+			return fmt.Errorf("%w (and putting back the previous directory failed: %s)", err, restoreErr)
+		}
+		return err
+	}
+
+	return os.RemoveAll(previous)
+}
+
+func extractEntry(tarReader *tar.Reader, header *tar.Header, destination string) error {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(destination, 0755)
+
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+			return err
+		}
+
+		return os.Symlink(header.Linkname, destination)
+
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(destination, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fs.FileMode(header.Mode).Perm())
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(file, tarReader); err != nil {
+			file.Close()
+			return err
+		}
+
+		return file.Close()
+	}
+
+	return nil
+}
+
+func parseBackupBlockNum(name string) (uint64, error) {
+	blockNum, _, found := strings.Cut(name, "-")
+	if !found {
+		return 0, fmt.Errorf("invalid backup name %q, expected '<block num>-<timestamp>'", name)
+	}
+
+	value, err := strconv.ParseUint(blockNum, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid backup name %q, expected '<block num>-<timestamp>': %w", name, err)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,170 @@
+package nodemanager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataDirBackupModule_BackupRestore(t *testing.T) {
+	storeURL := "file://" + t.TempDir()
+	dataDir := filepath.Join(t.TempDir(), "data")
+	syncStateFile := filepath.Join(t.TempDir(), "reader", "sync_state.json")
+
+	writeTestFile(t, filepath.Join(dataDir, "node.yaml"), "base: {}")
+	writeTestFile(t, filepath.Join(dataDir, "db", "ledger_db", "000001.sst"), "ledger")
+	writeTestFile(t, syncStateFile, `{"last_seen_block_num":150}`)
+
+	var restoredBlockNum uint64
+	module, err := NewDataDirBackupModule(storeURL, dataDir, syncStateFile, func(blockNum uint64) error {
+		restoredBlockNum = blockNum
+		return nil
+	}, zlog)
+	require.NoError(t, err)
+
+	first, err := module.Backup(150)
+	require.NoError(t, err)
+
+	writeTestFile(t, filepath.Join(dataDir, "db", "ledger_db", "000001.sst"), "ledger updated")
+	writeTestFile(t, syncStateFile, `{"last_seen_block_num":1200}`)
+
+	second, err := module.Backup(1200)
+	require.NoError(t, err)
+
+	latest, err := module.LatestBackup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, second, latest)
+
+	// Simulates a lost volume, only the data of the backup must remain after the restore
+	require.NoError(t, os.RemoveAll(filepath.Dir(syncStateFile)))
+	writeTestFile(t, filepath.Join(dataDir, "db", "stale"), "stale")
+
+	require.NoError(t, module.Restore(first))
+	assert.Equal(t, uint64(150), restoredBlockNum)
+	assertTestFile(t, filepath.Join(dataDir, "node.yaml"), "base: {}")
+	assertTestFile(t, filepath.Join(dataDir, "db", "ledger_db", "000001.sst"), "ledger")
+	assertTestFile(t, syncStateFile, `{"last_seen_block_num":150}`)
+	assert.NoFileExists(t, filepath.Join(dataDir, "db", "stale"))
+
+	require.NoError(t, module.Restore(LatestBackupName))
+	assert.Equal(t, uint64(1200), restoredBlockNum)
+	assertTestFile(t, filepath.Join(dataDir, "db", "ledger_db", "000001.sst"), "ledger updated")
+	assertTestFile(t, syncStateFile, `{"last_seen_block_num":1200}`)
+}
+
+func TestDataDirBackupModule_NoBackup(t *testing.T) {
+	module, err := NewDataDirBackupModule("file://"+t.TempDir(), t.TempDir(), filepath.Join(t.TempDir(), "sync_state.json"), nil, zlog)
+	require.NoError(t, err)
+
+	assert.ErrorContains(t, module.Restore(LatestBackupName), "no backup found in store")
+}
+
+func TestDataDirBackupModule_RestoreFailureKeepsData(t *testing.T) {
+	storeDir := t.TempDir()
+	dataDir := filepath.Join(t.TempDir(), "data")
+	syncStateFile := filepath.Join(t.TempDir(), "sync_state.json")
+
+	writeTestFile(t, filepath.Join(dataDir, "db", "ledger_db", "000001.sst"), "ledger")
+	writeTestFile(t, syncStateFile, `{"last_seen_block_num":150}`)
+
+	module, err := NewDataDirBackupModule("file://"+storeDir, dataDir, syncStateFile, func(uint64) error {
+		t.Fatal("restored callback must not be called on failure")
+		return nil
+	}, zlog)
+	require.NoError(t, err)
+
+	name, err := module.Backup(150)
+	require.NoError(t, err)
+
+	archive, err := os.ReadFile(filepath.Join(storeDir, name+".tar.gz"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		archive       []byte
+		expectedError string
+	}{
+		{"truncated", archive[:len(archive)/2], "unexpected EOF"},
+		{"escaping entry", testBackupArchive(t, &tar.Header{Name: "data/../escaped", Typeflag: tar.TypeReg}), `invalid entry "data/../escaped", escapes the data directory`},
+		{"escaping symlink", testBackupArchive(t, &tar.Header{Name: "data/db/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}), `invalid entry "data/db/link", its link "../../etc" escapes the data directory`},
+		{"escaping absolute symlink", testBackupArchive(t, &tar.Header{Name: "data/link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}), `invalid entry "data/link", its link "/etc" escapes the data directory`},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := fmt.Sprintf("%010d-%d", 200+i, 0)
+			require.NoError(t, os.WriteFile(filepath.Join(storeDir, name+".tar.gz"), test.archive, 0644))
+
+			assert.ErrorContains(t, module.Restore(name), test.expectedError)
+			assertTestFile(t, filepath.Join(dataDir, "db", "ledger_db", "000001.sst"), "ledger")
+			assertTestFile(t, syncStateFile, `{"last_seen_block_num":150}`)
+
+			// Nothing is left from the failed restore next to the data directory
+			entries, err := os.ReadDir(filepath.Dir(dataDir))
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "data", entries[0].Name())
+		})
+	}
+}
+
+func TestDataDirBackupModule_RestoreSymlinks(t *testing.T) {
+	storeDir := t.TempDir()
+	dataDir := filepath.Join(t.TempDir(), "data")
+
+	module, err := NewDataDirBackupModule("file://"+storeDir, dataDir, filepath.Join(t.TempDir(), "sync_state.json"), nil, zlog)
+	require.NoError(t, err)
+
+	archive := testBackupArchive(t,
+		&tar.Header{Name: "data/db/current", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "data/db/latest", Typeflag: tar.TypeSymlink, Linkname: "current"},
+		&tar.Header{Name: "data/ledger", Typeflag: tar.TypeSymlink, Linkname: filepath.Join(dataDir, "db")},
+	)
+	require.NoError(t, os.WriteFile(filepath.Join(storeDir, "0000000100-0.tar.gz"), archive, 0644))
+
+	require.NoError(t, module.Restore("0000000100-0"))
+
+	link, err := os.Readlink(filepath.Join(dataDir, "db", "latest"))
+	require.NoError(t, err)
+	assert.Equal(t, "current", link)
+	assert.FileExists(t, filepath.Join(dataDir, "ledger", "current"))
+}
+
+// testBackupArchive returns a backup archive made of the given entries, regular files being empty
+func testBackupArchive(t *testing.T, headers ...*tar.Header) []byte {
+	t.Helper()
+
+	buffer := bytes.NewBuffer(nil)
+	gzipWriter := gzip.NewWriter(buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, header := range headers {
+		require.NoError(t, tarWriter.WriteHeader(header))
+	}
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+
+	return buffer.Bytes()
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func assertTestFile(t *testing.T, path string, expected string) {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(content))
+}
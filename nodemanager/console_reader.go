@@ -0,0 +1,93 @@
+package nodemanager
+
+import (
+	"io"
+	"strings"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/mindreader"
+	"go.uber.org/zap"
+)
+
+// RestartableConsoleReader reads the blocks of the successive runs of the node process, whose
+// output the superviser sends to the same reader plugin. Each run starting with its own
+// `FIRE INIT` line, a new console reader is created for it, the incomplete block the previous
+// run may have left being discarded.
+type RestartableConsoleReader struct {
+	factory mindreader.ConsolerReaderFactory
+	logger  *zap.Logger
+	done    chan interface{}
+
+	current mindreader.ConsolerReader
+	runs    chan chan string
+}
+
+func NewRestartableConsoleReader(lines chan string, factory mindreader.ConsolerReaderFactory, logger *zap.Logger) (*RestartableConsoleReader, error) {
+	runLines := make(chan string, cap(lines))
+	current, err := factory(runLines)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RestartableConsoleReader{
+		factory: factory,
+		logger:  logger,
+		done:    make(chan interface{}),
+		current: current,
+		runs:    make(chan chan string),
+	}
+
+	go r.splitRuns(lines, runLines)
+
+	return r, nil
+}
+
+func (r *RestartableConsoleReader) Done() <-chan interface{} {
+	return r.done
+}
+
+func (r *RestartableConsoleReader) ReadBlock() (*bstream.Block, error) {
+	for {
+		block, err := r.current.ReadBlock()
+		if err != io.EOF {
+			return block, err
+		}
+
+		runLines, ok := <-r.runs
+		if !ok {
+			return nil, io.EOF
+		}
+
+		r.logger.Info("node process restarted, reading its blocks with a new console reader")
+		closeConsoleReader(r.current)
+		if r.current, err = r.factory(runLines); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// splitRuns forwards the lines of each run of the node process to a distinct channel.
+func (r *RestartableConsoleReader) splitRuns(lines chan string, runLines chan string) {
+	initRead := false
+	for line := range lines {
+		if strings.HasPrefix(line, "FIRE INIT ") {
+			if initRead {
+				close(runLines)
+				runLines = make(chan string, cap(lines))
+				r.runs <- runLines
+			}
+			initRead = true
+		}
+
+		runLines <- line
+	}
+
+	close(runLines)
+	close(r.runs)
+}
+
+func closeConsoleReader(reader mindreader.ConsolerReader) {
+	if closer, ok := reader.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
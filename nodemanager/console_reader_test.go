@@ -0,0 +1,92 @@
+package nodemanager
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/node-manager/mindreader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartableConsoleReader(t *testing.T) {
+	var readers []*testLinesConsoleReader
+	factory := func(lines chan string) (mindreader.ConsolerReader, error) {
+		reader := &testLinesConsoleReader{lines: lines}
+		readers = append(readers, reader)
+
+		return reader, nil
+	}
+
+	lines := make(chan string, 10)
+	reader, err := NewRestartableConsoleReader(lines, factory, zlog)
+	require.NoError(t, err)
+
+	go func() {
+		for _, line := range []string{
+			"FIRE INIT aptos-node 1.0.0", "FIRE BLOCK 1", "FIRE BLOCK 2",
+			// The node crashed and was restarted from its last block
+			"FIRE INIT aptos-node 1.0.0", "FIRE BLOCK 2", "FIRE BLOCK 3",
+		} {
+			lines <- line
+		}
+		close(lines)
+	}()
+
+	var blockNums []uint64
+	for {
+		block, err := reader.ReadBlock()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		blockNums = append(blockNums, block.Number)
+	}
+
+	assert.Equal(t, []uint64{1, 2, 2, 3}, blockNums)
+	require.Len(t, readers, 2)
+	assert.True(t, readers[0].closed)
+	assert.False(t, readers[1].closed)
+}
+
+// testLinesConsoleReader reads `FIRE BLOCK <num>` lines, failing like the codec's console reader
+// on a second `FIRE INIT` line
+type testLinesConsoleReader struct {
+	lines    chan string
+	initRead bool
+	closed   bool
+}
+
+func (r *testLinesConsoleReader) ReadBlock() (*bstream.Block, error) {
+	for line := range r.lines {
+		switch {
+		case strings.HasPrefix(line, "FIRE INIT "):
+			if r.initRead {
+				return nil, fmt.Errorf("received INIT line while one has already been read")
+			}
+			r.initRead = true
+		case strings.HasPrefix(line, "FIRE BLOCK "):
+			num, err := strconv.ParseUint(strings.TrimPrefix(line, "FIRE BLOCK "), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			return &bstream.Block{Number: num}, nil
+		}
+	}
+
+	return nil, io.EOF
+}
+
+func (r *testLinesConsoleReader) Done() <-chan interface{} {
+	return nil
+}
+
+func (r *testLinesConsoleReader) Close() {
+	r.closed = true
+}
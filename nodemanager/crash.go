@@ -0,0 +1,298 @@
+package nodemanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/node-manager/superviser"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RestartPolicy defines how the node process is restarted when it crashes (exits with a non-zero
+// status without being asked to).
+type RestartPolicy struct {
+	// InitialBackoff is the delay before restarting the node after a crash, doubled for each other
+	// crash within `CrashLoopWindow` up to `MaxBackoff`.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// CrashLoopRestarts is the amount of restarts allowed within `CrashLoopWindow`, past which the
+	// node is considered crash looping and is not restarted anymore.
+	CrashLoopRestarts int
+	CrashLoopWindow   time.Duration
+
+	// CrashLogLines is the amount of log lines kept before and after a panic in crash reports (the
+	// last lines when the node did not panic).
+	CrashLogLines int
+}
+
+func (p *RestartPolicy) backoff(recentCrashes int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < recentCrashes && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+
+	return backoff
+}
+
+// CrashReport describes the last crash of the node process.
+type CrashReport struct {
+	Time          time.Time `json:"time"`
+	ExitCode      int       `json:"exit_code"`
+	LastBlockSeen uint64    `json:"last_block_seen"`
+
+	// Panic is the line at which the node panicked, if it did, in which case `LogLines` holds the
+	// log lines around it.
+	Panic    string   `json:"panic,omitempty"`
+	LogLines []string `json:"log_lines"`
+
+	// RecentCrashes is the amount of crashes within the policy's crash loop window, this one included.
+	RecentCrashes int        `json:"recent_crashes"`
+	CrashLoop     bool       `json:"crash_loop"`
+	NextRestartAt *time.Time `json:"next_restart_at,omitempty"`
+}
+
+func (r *CrashReport) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddTime("time", r.Time)
+	enc.AddInt("exit_code", r.ExitCode)
+	enc.AddUint64("last_block_seen", r.LastBlockSeen)
+	enc.AddString("panic", r.Panic)
+	enc.AddInt("recent_crashes", r.RecentCrashes)
+	enc.AddBool("crash_loop", r.CrashLoop)
+
+	return nil
+}
+
+// SetRestartPolicy enables restarting the node process when it crashes, it must be called
+// before the superviser is started. Without a policy, the node exiting stops the operator.
+func (s *Superviser) SetRestartPolicy(policy *RestartPolicy) {
+	s.restartPolicy = policy
+	s.crashLogs = newCrashLogPlugin(policy.CrashLogLines)
+	s.RegisterLogPlugin(s.crashLogs)
+}
+
+// OnCrashLoop registers a function called when the node is detected as crash looping.
+func (s *Superviser) OnCrashLoop(f func(report *CrashReport)) {
+	s.crashLock.Lock()
+	defer s.crashLock.Unlock()
+
+	s.onCrashLoop = append(s.onCrashLoop, f)
+}
+
+// LastCrash returns the report of the node's last crash, nil if it never crashed.
+func (s *Superviser) LastCrash() *CrashReport {
+	s.crashLock.Lock()
+	defer s.crashLock.Unlock()
+
+	if s.lastCrash == nil {
+		return nil
+	}
+
+	report := *s.lastCrash
+	return &report
+}
+
+// IsCrashLooping returns true when the node crashed too many times and is not restarted
+// anymore, until explicitly started again.
+func (s *Superviser) IsCrashLooping() bool {
+	s.crashLock.Lock()
+	defer s.crashLock.Unlock()
+
+	return s.crashLooping
+}
+
+func (s *Superviser) lastCrashHandler(w http.ResponseWriter, _ *http.Request) {
+	response := struct {
+		CrashLoop bool         `json:"crash_loop"`
+		LastCrash *CrashReport `json:"last_crash"`
+	}{s.IsCrashLooping(), s.LastCrash()}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.Logger.Warn("unable to write last crash response", zap.Error(err))
+	}
+}
+
+// watchProcess waits for the node process to exit and restarts it if it crashed, according
+// to the restart policy.
+func (s *Superviser) watchProcess(run *superviser.Superviser, runDone <-chan struct{}, output *runOutput) {
+	<-runDone
+	// The process' last lines, like a panic's backtrace, have all been logged once its output is read
+	output.waitRead()
+
+	s.crashLock.Lock()
+	if s.stopRequested || s.IsTerminating() || !s.isCurrentRun(run) {
+		s.crashLock.Unlock()
+		return
+	}
+
+	exitCode := run.LastExitCode()
+	if exitCode == 0 {
+		s.crashLock.Unlock()
+
+		s.Logger.Info("node process exited cleanly without being asked to, not restarting it")
+		s.exitedOnce.Do(func() { close(s.exited) })
+		return
+	}
+
+	now := time.Now()
+	s.crashTimes = append(crashesSince(s.crashTimes, now.Add(-s.restartPolicy.CrashLoopWindow)), now)
+
+	report := &CrashReport{
+		Time:          now,
+		ExitCode:      exitCode,
+		LastBlockSeen: s.LastSeenBlockNum(),
+		RecentCrashes: len(s.crashTimes),
+	}
+	report.Panic, report.LogLines = s.crashLogs.capture()
+	s.lastCrash = report
+
+	if len(s.crashTimes) > s.restartPolicy.CrashLoopRestarts {
+		report.CrashLoop = true
+		s.crashLooping = true
+		hooks := s.onCrashLoop
+		s.crashLock.Unlock()
+
+		s.Logger.Error("node process is crash looping, not restarting it anymore until explicitly resumed", zap.Object("crash", report), zap.Strings("log_lines", report.LogLines))
+		for _, hook := range hooks {
+			hook(report)
+		}
+		return
+	}
+
+	backoff := s.restartPolicy.backoff(len(s.crashTimes))
+	nextRestartAt := now.Add(backoff)
+	report.NextRestartAt = &nextRestartAt
+
+	cancelRestart := make(chan struct{})
+	s.cancelRestart = cancelRestart
+	s.crashLock.Unlock()
+
+	s.Logger.Warn("node process crashed, restarting it after backoff", zap.Object("crash", report), zap.Duration("backoff", backoff), zap.Strings("log_lines", report.LogLines))
+
+	select {
+	case <-time.After(backoff):
+	case <-cancelRestart:
+		s.Logger.Info("node process restart cancelled by a stop request")
+		return
+	case <-s.Terminating():
+		return
+	}
+
+	s.crashLock.Lock()
+	defer s.crashLock.Unlock()
+
+	if s.stopRequested || s.IsTerminating() {
+		return
+	}
+	s.cancelRestart = nil
+
+	if err := s.start(); err != nil {
+		s.Logger.Error("unable to restart node process", zap.Error(err))
+		s.exitedOnce.Do(func() { close(s.exited) })
+	}
+}
+
+// isCurrentRun returns false when the run was superseded by an explicit start, whose own run is
+// watched instead.
+func (s *Superviser) isCurrentRun(run *superviser.Superviser) bool {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	return s.run == run
+}
+
+func crashesSince(crashTimes []time.Time, since time.Time) []time.Time {
+	var recent []time.Time
+	for _, crashTime := range crashTimes {
+		if crashTime.After(since) {
+			recent = append(recent, crashTime)
+		}
+	}
+
+	return recent
+}
+
+var instrumentationLineRegex = regexp.MustCompile("^(DMLOG|FIRE) ")
+
+// crashLogPlugin keeps the last log lines of the node process, freezing them when the node
+// panics so the lines before the panic are kept along with the ones after it (the backtrace).
+type crashLogPlugin struct {
+	*shutter.Shutter
+
+	lock       sync.Mutex
+	maxLines   int
+	lines      []string
+	panicLine  string
+	afterPanic []string
+}
+
+func newCrashLogPlugin(maxLines int) *crashLogPlugin {
+	return &crashLogPlugin{
+		Shutter:  shutter.New(),
+		maxLines: maxLines,
+	}
+}
+
+func (p *crashLogPlugin) Name() string { return "CrashLogPlugin" }
+func (p *crashLogPlugin) Launch()      {}
+func (p *crashLogPlugin) Stop()        {}
+
+// reset is called each time the node process starts, lines of the previous run are dropped.
+func (p *crashLogPlugin) reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.lines = nil
+	p.panicLine = ""
+	p.afterPanic = nil
+}
+
+func (p *crashLogPlugin) LogLine(in string) {
+	if instrumentationLineRegex.MatchString(in) {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.panicLine != "" {
+		if len(p.afterPanic) < p.maxLines {
+			p.afterPanic = append(p.afterPanic, in)
+		}
+		return
+	}
+
+	if panicLineRegex.MatchString(in) {
+		p.panicLine = in
+		return
+	}
+
+	p.lines = append(p.lines, in)
+	if len(p.lines) > p.maxLines {
+		p.lines = p.lines[len(p.lines)-p.maxLines:]
+	}
+}
+
+// capture returns the panic line if the node panicked and the log lines kept.
+func (p *crashLogPlugin) capture() (panicLine string, lines []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	lines = append(lines, p.lines...)
+	if p.panicLine != "" {
+		lines = append(lines, p.panicLine)
+		lines = append(lines, p.afterPanic...)
+	}
+
+	return p.panicLine, lines
+}
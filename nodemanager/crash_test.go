@@ -0,0 +1,253 @@
+package nodemanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/streamingfast/shutter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSuperviser(t *testing.T, script string, policy *RestartPolicy) *Superviser {
+	t.Helper()
+
+	superviser := NewSuperviser("sh", []string{"-c", script}, t.TempDir(), false, true, 10, zlog, zlog)
+	if policy != nil {
+		superviser.SetRestartPolicy(policy)
+	}
+	t.Cleanup(func() { superviser.Shutdown(nil) })
+
+	return superviser
+}
+
+func TestSuperviser_CrashLoop(t *testing.T) {
+	superviser := newTestSuperviser(t, `echo "starting"; echo "FIRE BLOCK 1"; echo "thread 'main' panicked at 'boom', src/main.rs:1:1"; echo "stack backtrace:"; sleep 0.2; exit 101`, &RestartPolicy{
+		InitialBackoff:    10 * time.Millisecond,
+		MaxBackoff:        20 * time.Millisecond,
+		CrashLoopRestarts: 2,
+		CrashLoopWindow:   time.Minute,
+		CrashLogLines:     10,
+	})
+
+	crashLoops := make(chan *CrashReport, 1)
+	superviser.OnCrashLoop(func(report *CrashReport) { crashLoops <- report })
+
+	require.NoError(t, superviser.Start())
+
+	select {
+	case report := <-crashLoops:
+		assert.True(t, report.CrashLoop)
+		assert.Equal(t, 3, report.RecentCrashes)
+		assert.Equal(t, 101, report.ExitCode)
+		assert.Equal(t, uint64(10), report.LastBlockSeen)
+		assert.Equal(t, "thread 'main' panicked at 'boom', src/main.rs:1:1", report.Panic)
+		assert.Equal(t, []string{"starting", "thread 'main' panicked at 'boom', src/main.rs:1:1", "stack backtrace:"}, report.LogLines)
+		assert.Nil(t, report.NextRestartAt)
+	case <-time.After(10 * time.Second):
+		t.Fatal("crash loop not detected")
+	}
+
+	assert.True(t, superviser.IsCrashLooping())
+	assert.False(t, superviser.IsRunning())
+
+	select {
+	case <-superviser.Stopped():
+		t.Fatal("a crash loop must not stop the operator")
+	default:
+	}
+
+	// The crash report is exposed by the node manager API
+	router := mux.NewRouter()
+	superviser.RegisterHTTPRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v1/last_crash", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var response struct {
+		CrashLoop bool         `json:"crash_loop"`
+		LastCrash *CrashReport `json:"last_crash"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.True(t, response.CrashLoop)
+	require.NotNil(t, response.LastCrash)
+	assert.Equal(t, 101, response.LastCrash.ExitCode)
+
+	// An explicit start gives the node a fresh start, the full amount of restarts being allowed again
+	require.NoError(t, superviser.Start())
+	assert.False(t, superviser.IsCrashLooping())
+
+	select {
+	case report := <-crashLoops:
+		assert.Equal(t, 3, report.RecentCrashes)
+	case <-time.After(10 * time.Second):
+		t.Fatal("crash loop not detected again")
+	}
+}
+
+func TestSuperviser_StopCancelsRestart(t *testing.T) {
+	superviser := newTestSuperviser(t, "exit 1", &RestartPolicy{
+		InitialBackoff:    time.Hour,
+		MaxBackoff:        time.Hour,
+		CrashLoopRestarts: 5,
+		CrashLoopWindow:   time.Minute,
+		CrashLogLines:     10,
+	})
+
+	require.NoError(t, superviser.Start())
+	require.Eventually(t, func() bool { return superviser.LastCrash() != nil }, 10*time.Second, 10*time.Millisecond)
+
+	report := superviser.LastCrash()
+	assert.False(t, report.CrashLoop)
+	require.NotNil(t, report.NextRestartAt)
+
+	require.NoError(t, superviser.Stop())
+	assert.Nil(t, superviser.cancelRestart)
+	assert.False(t, superviser.IsRunning())
+}
+
+func TestSuperviser_CleanExitStopsOperator(t *testing.T) {
+	superviser := newTestSuperviser(t, "exit 0", &RestartPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, CrashLoopRestarts: 5, CrashLoopWindow: time.Minute})
+
+	require.NoError(t, superviser.Start())
+
+	select {
+	case <-superviser.Stopped():
+	case <-time.After(10 * time.Second):
+		t.Fatal("clean exit not reported")
+	}
+	assert.Nil(t, superviser.LastCrash())
+}
+
+func TestSuperviser_RestartKeepsLogPlugins(t *testing.T) {
+	superviser := newTestSuperviser(t, `echo "run"; exit 1`, &RestartPolicy{
+		InitialBackoff:    time.Millisecond,
+		MaxBackoff:        time.Millisecond,
+		CrashLoopRestarts: 2,
+		CrashLoopWindow:   time.Minute,
+	})
+
+	plugin := &testLogPlugin{Shutter: shutter.New()}
+	superviser.RegisterLogPlugin(plugin)
+
+	crashLoops := make(chan *CrashReport, 1)
+	superviser.OnCrashLoop(func(report *CrashReport) { crashLoops <- report })
+
+	require.NoError(t, superviser.Start())
+
+	select {
+	case <-crashLoops:
+	case <-time.After(10 * time.Second):
+		t.Fatal("crash loop not detected")
+	}
+
+	// The same plugins receive the output of every run of the node process
+	launches, lines := plugin.get()
+	assert.Equal(t, 1, launches)
+	assert.Equal(t, []string{"run", "run", "run"}, lines)
+}
+
+func TestSuperviser_LastBlockSeenWhileRestarting(t *testing.T) {
+	superviser := newTestSuperviser(t, "exit 1", &RestartPolicy{
+		InitialBackoff:    time.Millisecond,
+		MaxBackoff:        time.Millisecond,
+		CrashLoopRestarts: 2,
+		CrashLoopWindow:   time.Minute,
+	})
+
+	crashLoops := make(chan *CrashReport, 1)
+	superviser.OnCrashLoop(func(report *CrashReport) { crashLoops <- report })
+
+	// The reader sets the last block seen while the node is restarted and its crashes reported
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for blockNum := uint64(11); blockNum <= 1000; blockNum++ {
+			superviser.SetLastBlockSeen(blockNum)
+		}
+	}()
+
+	require.NoError(t, superviser.Start())
+
+	select {
+	case report := <-crashLoops:
+		assert.GreaterOrEqual(t, report.LastBlockSeen, uint64(10))
+	case <-time.After(10 * time.Second):
+		t.Fatal("crash loop not detected")
+	}
+
+	<-written
+	assert.Equal(t, uint64(1000), superviser.LastSeenBlockNum())
+}
+
+func TestRestartPolicy_Backoff(t *testing.T) {
+	policy := &RestartPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	assert.Equal(t, time.Second, policy.backoff(1))
+	assert.Equal(t, 2*time.Second, policy.backoff(2))
+	assert.Equal(t, 4*time.Second, policy.backoff(3))
+	assert.Equal(t, 5*time.Second, policy.backoff(4))
+	assert.Equal(t, 5*time.Second, policy.backoff(100))
+}
+
+func TestCrashLogPlugin(t *testing.T) {
+	plugin := newCrashLogPlugin(2)
+	for _, line := range []string{"a", "FIRE BLOCK 1", "b", "c"} {
+		plugin.LogLine(line)
+	}
+
+	panicLine, lines := plugin.capture()
+	assert.Equal(t, "", panicLine)
+	assert.Equal(t, []string{"b", "c"}, lines)
+
+	for _, line := range []string{"thread 'main' panicked at 'boom'", "d", "e", "f"} {
+		plugin.LogLine(line)
+	}
+
+	panicLine, lines = plugin.capture()
+	assert.Equal(t, "thread 'main' panicked at 'boom'", panicLine)
+	assert.Equal(t, []string{"b", "c", "thread 'main' panicked at 'boom'", "d", "e"}, lines)
+
+	plugin.reset()
+	panicLine, lines = plugin.capture()
+	assert.Equal(t, "", panicLine)
+	assert.Empty(t, lines)
+}
+
+type testLogPlugin struct {
+	*shutter.Shutter
+
+	lock     sync.Mutex
+	launches int
+	lines    []string
+}
+
+func (p *testLogPlugin) Name() string { return "TestLogPlugin" }
+func (p *testLogPlugin) Stop()        {}
+
+func (p *testLogPlugin) Launch() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.launches++
+}
+
+func (p *testLogPlugin) LogLine(in string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.lines = append(p.lines, in)
+}
+
+func (p *testLogPlugin) get() (launches int, lines []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.launches, append([]string(nil), p.lines...)
+}
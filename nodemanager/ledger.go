@@ -0,0 +1,300 @@
+package nodemanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/firehose-aptos/codec"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+)
+
+// ledgerInfoStaleAfter is the amount of poll intervals after which the last ledger info fetched
+// is considered stale, the node not being ready anymore.
+const ledgerInfoStaleAfter = 3
+
+// LedgerInfo is the ledger info returned by aptos-node's REST API at `/v1`.
+type LedgerInfo struct {
+	ChainID       uint32 `json:"chain_id"`
+	Epoch         uint64 `json:"epoch,string"`
+	LedgerVersion uint64 `json:"ledger_version,string"`
+	BlockHeight   uint64 `json:"block_height,string"`
+	NodeRole      string `json:"node_role"`
+
+	// LedgerTimestamp is the timestamp of the ledger's last version in microseconds
+	LedgerTimestamp uint64 `json:"ledger_timestamp,string"`
+}
+
+func (i *LedgerInfo) Time() time.Time {
+	return time.UnixMicro(int64(i.LedgerTimestamp)).UTC()
+}
+
+// LedgerReadinessConfig configures the checks made against the node's ledger info.
+type LedgerReadinessConfig struct {
+	// URL is the node's ledger info endpoint, like `http://localhost:8080/v1`
+	URL          string
+	PollInterval time.Duration
+
+	// MaxLag is the maximum time allowed between the node's ledger and the wall clock, as well
+	// as between the last extracted block and the node's ledger.
+	MaxLag time.Duration
+
+	// MaxVersionLag is the maximum amount of versions the last extracted block is allowed to be
+	// behind the node's ledger, 0 to disable the check.
+	MaxVersionLag uint64
+}
+
+// LedgerReadiness polls the node's ledger info and compares it with the last extracted block,
+// the node being ready when the head block readiness is and neither the node nor the reader
+// lag behind. It fixes the head block readiness staying ready when the node gets stuck (no
+// more blocks means no more readiness update).
+type LedgerReadiness struct {
+	*shutter.Shutter
+
+	config         *LedgerReadinessConfig
+	blockReadiness nodeManager.Readiness
+	client         *http.Client
+	logger         *zap.Logger
+
+	lock           sync.Mutex
+	ledger         *LedgerInfo
+	ledgerPolledAt time.Time
+	ledgerErr      error
+	lastBlock      *bstream.Block
+	lastVersion    uint64
+}
+
+func NewLedgerReadiness(config *LedgerReadinessConfig, blockReadiness nodeManager.Readiness, logger *zap.Logger) *LedgerReadiness {
+	return &LedgerReadiness{
+		Shutter:        shutter.New(),
+		config:         config,
+		blockReadiness: blockReadiness,
+		client:         &http.Client{},
+		logger:         logger,
+	}
+}
+
+// Launch polls the node's ledger info until the readiness is shut down.
+func (r *LedgerReadiness) Launch() {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.poll()
+
+		select {
+		case <-ticker.C:
+		case <-r.Terminating():
+			return
+		}
+	}
+}
+
+func (r *LedgerReadiness) poll() {
+	ledger, err := r.fetchLedgerInfo()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err != nil {
+		if r.ledgerErr == nil {
+			r.logger.Warn("unable to fetch node ledger info", zap.String("url", r.config.URL), zap.Error(err))
+		}
+		r.ledgerErr = err
+		return
+	}
+
+	r.ledger = ledger
+	r.ledgerPolledAt = time.Now()
+	r.ledgerErr = nil
+}
+
+func (r *LedgerReadiness) fetchLedgerInfo() (*LedgerInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.PollInterval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", r.config.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get ledger info: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get ledger info: unexpected status %s", res.Status)
+	}
+
+	ledger := &LedgerInfo{}
+	if err := json.NewDecoder(res.Body).Decode(ledger); err != nil {
+		return nil, fmt.Errorf("decode ledger info: %w", err)
+	}
+
+	return ledger, nil
+}
+
+// SetLastBlock records the last block extracted by the reader along with its last version, 0 if
+// it cannot be known.
+func (r *LedgerReadiness) SetLastBlock(block *bstream.Block) {
+	version, err := codec.BlockLastVersion(block)
+	if err != nil {
+		r.logger.Debug("unable to get last block version", zap.Stringer("block", block), zap.Error(err))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastBlock = block
+	r.lastVersion = version
+}
+
+func (r *LedgerReadiness) IsReady() bool {
+	return r.Status().Ready
+}
+
+// LedgerStatus is the combined readiness of the reader along with the lag of the last
+// extracted block behind the node's ledger.
+type LedgerStatus struct {
+	Ready       bool   `json:"ready"`
+	BlockReady  bool   `json:"block_ready"`
+	LedgerReady bool   `json:"ledger_ready"`
+	Reason      string `json:"reason,omitempty"`
+
+	Ledger         *LedgerInfo `json:"ledger,omitempty"`
+	LedgerPolledAt *time.Time  `json:"ledger_polled_at,omitempty"`
+	LedgerError    string      `json:"ledger_error,omitempty"`
+
+	LastBlock *LastBlockInfo `json:"last_block,omitempty"`
+	Lag       *LedgerLag     `json:"lag,omitempty"`
+}
+
+type LastBlockInfo struct {
+	Num     uint64    `json:"num"`
+	Version uint64    `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// LedgerLag is how far the last extracted block is behind the node's ledger, 0 when it's ahead
+// (the ledger info being polled less often than blocks are extracted).
+type LedgerLag struct {
+	Blocks      uint64  `json:"blocks"`
+	Versions    uint64  `json:"versions"`
+	TimeSeconds float64 `json:"time_seconds"`
+}
+
+func (r *LedgerReadiness) Status() *LedgerStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	status := &LedgerStatus{BlockReady: r.blockReadiness.IsReady()}
+	if r.ledgerErr != nil {
+		status.LedgerError = r.ledgerErr.Error()
+	}
+
+	if r.lastBlock != nil {
+		status.LastBlock = &LastBlockInfo{Num: r.lastBlock.Num(), Version: r.lastVersion, Time: r.lastBlock.Time()}
+	}
+
+	if r.ledger != nil {
+		polledAt := r.ledgerPolledAt
+		status.Ledger = r.ledger
+		status.LedgerPolledAt = &polledAt
+
+		if status.LastBlock != nil {
+			status.Lag = &LedgerLag{
+				Blocks:      lagOf(r.ledger.BlockHeight, status.LastBlock.Num),
+				Versions:    lagOf(r.ledger.LedgerVersion, status.LastBlock.Version),
+				TimeSeconds: r.ledger.Time().Sub(status.LastBlock.Time).Seconds(),
+			}
+			if status.Lag.TimeSeconds < 0 {
+				status.Lag.TimeSeconds = 0
+			}
+		}
+	}
+
+	status.Reason = r.notReadyReason(status, time.Now())
+	status.LedgerReady = status.Reason == ""
+	status.Ready = status.BlockReady && status.LedgerReady
+	if status.LedgerReady && !status.BlockReady {
+		status.Reason = "head block is not ready"
+	}
+
+	return status
+}
+
+func (r *LedgerReadiness) notReadyReason(status *LedgerStatus, now time.Time) string {
+	if status.Ledger == nil {
+		return "ledger info not fetched yet"
+	}
+
+	if stale := time.Duration(ledgerInfoStaleAfter) * r.config.PollInterval; now.Sub(*status.LedgerPolledAt) > stale {
+		return fmt.Sprintf("ledger info not fetched for more than %s", stale)
+	}
+
+	if behind := now.Sub(status.Ledger.Time()); behind > r.config.MaxLag {
+		return fmt.Sprintf("node ledger is %s behind wall clock", behind.Round(time.Second))
+	}
+
+	if status.LastBlock == nil {
+		return "no block extracted yet"
+	}
+
+	if r.config.MaxVersionLag > 0 && status.Lag.Versions > r.config.MaxVersionLag {
+		return fmt.Sprintf("last extracted block is %d versions behind node ledger", status.Lag.Versions)
+	}
+
+	if behind := time.Duration(status.Lag.TimeSeconds * float64(time.Second)); behind > r.config.MaxLag {
+		return fmt.Sprintf("last extracted block is %s behind node ledger", behind.Round(time.Second))
+	}
+
+	return ""
+}
+
+func lagOf(head, current uint64) uint64 {
+	if current >= head {
+		return 0
+	}
+
+	return head - current
+}
+
+// RegisterHTTPRoutes adds the readiness routes to the node manager API.
+func (r *LedgerReadiness) RegisterHTTPRoutes(router *mux.Router) {
+	router.HandleFunc("/v1/readiness", r.readinessHandler).Methods("GET")
+}
+
+func (r *LedgerReadiness) readinessHandler(w http.ResponseWriter, _ *http.Request) {
+	status := r.Status()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		r.logger.Warn("unable to write readiness response", zap.Error(err))
+	}
+}
+
+// LedgerInfoURL returns the ledger info endpoint of the REST API at `apiURL`, which may already
+// point to it.
+func LedgerInfoURL(apiURL string) string {
+	apiURL = strings.TrimSuffix(apiURL, "/")
+	if strings.HasSuffix(apiURL, "/v1") {
+		return apiURL
+	}
+
+	return apiURL + "/v1"
+}
@@ -0,0 +1,199 @@
+package nodemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/streamingfast/bstream"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeReadiness bool
+
+func (r fakeReadiness) IsReady() bool { return bool(r) }
+
+// fakeLedgerNode serves aptos-node's REST API ledger info endpoint
+type fakeLedgerNode struct {
+	*httptest.Server
+
+	lock    sync.Mutex
+	version uint64
+	height  uint64
+	time    time.Time
+	status  int
+}
+
+func newFakeLedgerNode(t *testing.T) *fakeLedgerNode {
+	node := &fakeLedgerNode{status: http.StatusOK}
+	node.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		node.lock.Lock()
+		defer node.lock.Unlock()
+
+		if r.URL.Path != "/v1" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if node.status != http.StatusOK {
+			w.WriteHeader(node.status)
+			return
+		}
+
+		fmt.Fprintf(w, `{"chain_id":2,"epoch":"12","ledger_version":"%d","oldest_ledger_version":"0","ledger_timestamp":"%d","node_role":"full_node","oldest_block_height":"0","block_height":"%d","git_hash":"abc"}`,
+			node.version, node.time.UnixMicro(), node.height)
+	}))
+	t.Cleanup(node.Close)
+
+	return node
+}
+
+func (n *fakeLedgerNode) set(height, version uint64, time time.Time, status int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.height, n.version, n.time, n.status = height, version, time, status
+}
+
+func newTestLedgerBlock(t *testing.T, height, lastVersion uint64, blockTime time.Time) *bstream.Block {
+	t.Helper()
+
+	payload, err := proto.Marshal(&pbaptos.Block{
+		Height: height,
+		Transactions: []*pbaptos.Transaction{
+			{Version: lastVersion - 1},
+			{Version: lastVersion},
+		},
+	})
+	require.NoError(t, err)
+
+	block, err := bstream.MemoryBlockPayloadSetter(&bstream.Block{Number: height, Timestamp: blockTime}, payload)
+	require.NoError(t, err)
+
+	return block
+}
+
+func TestLedgerReadiness(t *testing.T) {
+	node := newFakeLedgerNode(t)
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name           string
+		blockReady     bool
+		nodeHeight     uint64
+		nodeVersion    uint64
+		nodeTime       time.Time
+		nodeStatus     int
+		block          *bstream.Block
+		expectedReady  bool
+		expectedReason string
+		expectedLag    *LedgerLag
+	}{
+		{"ready", true, 100, 1000, now, http.StatusOK, newTestLedgerBlock(t, 99, 990, now.Add(-time.Second)), true, "", &LedgerLag{Blocks: 1, Versions: 10, TimeSeconds: 1}},
+		{"reader ahead of polled ledger", true, 100, 1000, now, http.StatusOK, newTestLedgerBlock(t, 101, 1010, now.Add(time.Second)), true, "", &LedgerLag{}},
+		{"head block not ready", false, 100, 1000, now, http.StatusOK, newTestLedgerBlock(t, 99, 990, now), false, "head block is not ready", &LedgerLag{Blocks: 1, Versions: 10}},
+		{"no block extracted", true, 100, 1000, now, http.StatusOK, nil, false, "no block extracted yet", nil},
+		{"node stuck", true, 100, 1000, now.Add(-time.Hour), http.StatusOK, newTestLedgerBlock(t, 100, 1000, now.Add(-time.Hour)), false, "node ledger is 1h0m0s behind wall clock", &LedgerLag{}},
+		{"reader catching up versions", true, 100, 1000, now, http.StatusOK, newTestLedgerBlock(t, 90, 400, now), false, "last extracted block is 600 versions behind node ledger", &LedgerLag{Blocks: 10, Versions: 600}},
+		{"reader catching up time", true, 100, 1000, now, http.StatusOK, newTestLedgerBlock(t, 99, 999, now.Add(-2*time.Minute)), false, "last extracted block is 2m0s behind node ledger", &LedgerLag{Blocks: 1, Versions: 1, TimeSeconds: 120}},
+		{"ledger unavailable", true, 100, 1000, now, http.StatusInternalServerError, newTestLedgerBlock(t, 99, 990, now), false, "ledger info not fetched yet", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node.set(test.nodeHeight, test.nodeVersion, test.nodeTime, test.nodeStatus)
+
+			readiness := NewLedgerReadiness(&LedgerReadinessConfig{
+				URL:           LedgerInfoURL(node.URL),
+				PollInterval:  time.Minute,
+				MaxLag:        time.Minute,
+				MaxVersionLag: 500,
+			}, fakeReadiness(test.blockReady), zlog)
+
+			readiness.poll()
+			if test.block != nil {
+				readiness.SetLastBlock(test.block)
+			}
+
+			status := readiness.Status()
+			assert.Equal(t, test.expectedReady, status.Ready)
+			assert.Equal(t, test.expectedReady, readiness.IsReady())
+			assert.Equal(t, test.expectedReason, status.Reason)
+			if test.expectedLag != nil {
+				require.NotNil(t, status.Lag)
+				assert.Equal(t, test.expectedLag.Blocks, status.Lag.Blocks)
+				assert.Equal(t, test.expectedLag.Versions, status.Lag.Versions)
+				assert.InDelta(t, test.expectedLag.TimeSeconds, status.Lag.TimeSeconds, 0.001)
+			} else {
+				assert.Nil(t, status.Lag)
+			}
+
+			if test.nodeStatus != http.StatusOK {
+				assert.Equal(t, "get ledger info: unexpected status 500 Internal Server Error", status.LedgerError)
+			}
+		})
+	}
+}
+
+func TestLedgerReadiness_StaleLedgerInfo(t *testing.T) {
+	node := newFakeLedgerNode(t)
+	node.set(100, 1000, time.Now(), http.StatusOK)
+
+	readiness := NewLedgerReadiness(&LedgerReadinessConfig{URL: LedgerInfoURL(node.URL), PollInterval: 10 * time.Millisecond, MaxLag: time.Minute}, fakeReadiness(true), zlog)
+	readiness.poll()
+	readiness.SetLastBlock(newTestLedgerBlock(t, 100, 1000, time.Now()))
+	require.True(t, readiness.IsReady())
+
+	node.set(100, 1000, time.Now(), http.StatusServiceUnavailable)
+	time.Sleep(40 * time.Millisecond)
+	readiness.poll()
+
+	status := readiness.Status()
+	assert.False(t, status.Ready)
+	assert.Equal(t, "ledger info not fetched for more than 30ms", status.Reason)
+	assert.Equal(t, uint64(1000), status.Ledger.LedgerVersion)
+}
+
+func TestLedgerReadiness_HTTPRoute(t *testing.T) {
+	node := newFakeLedgerNode(t)
+	now := time.Now()
+	node.set(100, 1000, now, http.StatusOK)
+
+	readiness := NewLedgerReadiness(&LedgerReadinessConfig{URL: LedgerInfoURL(node.URL + "/"), PollInterval: time.Minute, MaxLag: time.Minute}, fakeReadiness(true), zlog)
+	readiness.poll()
+
+	router := mux.NewRouter()
+	readiness.RegisterHTTPRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v1/readiness", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	readiness.SetLastBlock(newTestLedgerBlock(t, 98, 980, now))
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v1/readiness", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	status := &LedgerStatus{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), status))
+	assert.True(t, status.Ready)
+	assert.Equal(t, &LedgerInfo{ChainID: 2, Epoch: 12, LedgerVersion: 1000, BlockHeight: 100, NodeRole: "full_node", LedgerTimestamp: uint64(now.UnixMicro())}, status.Ledger)
+	assert.Equal(t, uint64(980), status.LastBlock.Version)
+	assert.Equal(t, uint64(2), status.Lag.Blocks)
+	assert.Equal(t, uint64(20), status.Lag.Versions)
+}
+
+func TestLedgerInfoURL(t *testing.T) {
+	assert.Equal(t, "http://localhost:8080/v1", LedgerInfoURL("http://localhost:8080"))
+	assert.Equal(t, "http://localhost:8080/v1", LedgerInfoURL("http://localhost:8080/"))
+	assert.Equal(t, "http://localhost:8080/v1", LedgerInfoURL("http://localhost:8080/v1/"))
+}
@@ -0,0 +1,259 @@
+package nodemanager
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/streamingfast/dmetrics"
+	"github.com/streamingfast/shutter"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	LogMetricTypeGauge   = "gauge"
+	LogMetricTypeCounter = "counter"
+
+	// logMetricNamePrefix is prepended to the name of every log metric
+	logMetricNamePrefix = "aptos_node_"
+
+	// logMetricValueGroup is the regex named group holding a log metric's value, every other
+	// named group being a label of the metric.
+	logMetricValueGroup = "value"
+)
+
+// LogMetric extracts a Prometheus metric from the node's log lines matching `Pattern`. The
+// named group `value` of the pattern is the value set on a gauge or added to a counter (a
+// counter without it is incremented on each match), other named groups are the metric's labels.
+//
+// JSON log lines are matched against their message followed by their data fields as
+// `<key>=<value>`, like `Synced transactions synced_version=12 synced_epoch=2`.
+type LogMetric struct {
+	Name    string `yaml:"name"`
+	Type    string `yaml:"type"`
+	Pattern string `yaml:"pattern"`
+	Help    string `yaml:"help"`
+}
+
+// defaultLogMetricsConfig defines the log metrics extracted from known aptos-node log messages
+//
+//go:embed log_metrics.yaml
+var defaultLogMetricsConfig []byte
+
+// LogMetricsConfig is the format of the log metrics config file, its metrics being added to the
+// default ones, replacing those with the same name, unless `DisableDefaults` is set.
+type LogMetricsConfig struct {
+	DisableDefaults bool         `yaml:"disable_defaults"`
+	Metrics         []*LogMetric `yaml:"metrics"`
+}
+
+// DefaultLogMetrics returns the log metrics extracted from known aptos-node log messages.
+func DefaultLogMetrics() []*LogMetric {
+	config := &LogMetricsConfig{}
+	if err := yaml.Unmarshal(defaultLogMetricsConfig, config); err != nil {
+		panic(fmt.Errorf("invalid embedded log metrics config: %w", err))
+	}
+
+	return config.Metrics
+}
+
+// LoadLogMetrics returns the log metrics defined by the config file at `path` along with the
+// default ones, only the default log metrics when `path` is empty.
+func LoadLogMetrics(path string) ([]*LogMetric, error) {
+	if path == "" {
+		return DefaultLogMetrics(), nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read log metrics config: %w", err)
+	}
+
+	config := &LogMetricsConfig{}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("invalid log metrics config %q: %w", path, err)
+	}
+
+	if config.DisableDefaults {
+		return config.Metrics, nil
+	}
+
+	overridden := map[string]bool{}
+	for _, metric := range config.Metrics {
+		overridden[metric.Name] = true
+	}
+
+	var logMetrics []*LogMetric
+	for _, metric := range DefaultLogMetrics() {
+		if !overridden[metric.Name] {
+			logMetrics = append(logMetrics, metric)
+		}
+	}
+
+	return append(logMetrics, config.Metrics...), nil
+}
+
+// LogMetricsPlugin updates the log metrics from the node's log lines.
+type LogMetricsPlugin struct {
+	*shutter.Shutter
+
+	extractors []*logMetricExtractor
+}
+
+type logMetricExtractor struct {
+	regex      *regexp.Regexp
+	valueIndex int
+	labelIndex []int
+
+	gauge   *dmetrics.GaugeVec
+	counter *dmetrics.CounterVec
+}
+
+// NewLogMetricsPlugin creates the Prometheus metrics of `logMetrics` in `set`, their name being
+// prefixed with `aptos_node_`.
+func NewLogMetricsPlugin(set *dmetrics.Set, logMetrics []*LogMetric) (*LogMetricsPlugin, error) {
+	plugin := &LogMetricsPlugin{
+		Shutter: shutter.New(),
+	}
+
+	seen := map[string]bool{}
+	for _, metric := range logMetrics {
+		if metric.Name == "" {
+			return nil, fmt.Errorf("log metric with pattern %q has no name", metric.Pattern)
+		}
+		if seen[metric.Name] {
+			return nil, fmt.Errorf("log metric %q defined more than once", metric.Name)
+		}
+		seen[metric.Name] = true
+
+		extractor, err := newLogMetricExtractor(set, metric)
+		if err != nil {
+			return nil, fmt.Errorf("log metric %q: %w", metric.Name, err)
+		}
+
+		plugin.extractors = append(plugin.extractors, extractor)
+	}
+
+	return plugin, nil
+}
+
+func newLogMetricExtractor(set *dmetrics.Set, metric *LogMetric) (*logMetricExtractor, error) {
+	regex, err := regexp.Compile(metric.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	extractor := &logMetricExtractor{regex: regex, valueIndex: -1}
+
+	var labels []string
+	for i, group := range regex.SubexpNames() {
+		switch {
+		case group == "":
+		case group == logMetricValueGroup:
+			extractor.valueIndex = i
+		default:
+			labels = append(labels, group)
+			extractor.labelIndex = append(extractor.labelIndex, i)
+		}
+	}
+
+	name := logMetricNamePrefix + metric.Name
+	help := metric.Help
+	if help == "" {
+		help = fmt.Sprintf("Extracted from node log lines matching %q", metric.Pattern)
+	}
+
+	switch metric.Type {
+	case LogMetricTypeGauge:
+		if extractor.valueIndex == -1 {
+			return nil, fmt.Errorf("gauge pattern must have a %q named group", logMetricValueGroup)
+		}
+		extractor.gauge = set.NewGaugeVec(name, labels, help)
+	case LogMetricTypeCounter:
+		extractor.counter = set.NewCounterVec(name, labels, help)
+	default:
+		return nil, fmt.Errorf("invalid type %q, expecting %q or %q", metric.Type, LogMetricTypeGauge, LogMetricTypeCounter)
+	}
+
+	return extractor, nil
+}
+
+func (p *LogMetricsPlugin) Name() string { return "LogMetricsPlugin" }
+func (p *LogMetricsPlugin) Launch()      {}
+func (p *LogMetricsPlugin) Stop()        {}
+
+func (p *LogMetricsPlugin) LogLine(in string) {
+	if instrumentationLineRegex.MatchString(in) {
+		return
+	}
+
+	if entry := parseJSONLogLine(in); entry != nil {
+		in = entry.text()
+	}
+
+	for _, extractor := range p.extractors {
+		extractor.extract(in)
+	}
+}
+
+func (e *logMetricExtractor) extract(in string) {
+	groups := e.regex.FindStringSubmatch(in)
+	if groups == nil {
+		return
+	}
+
+	labels := make([]string, len(e.labelIndex))
+	for i, index := range e.labelIndex {
+		labels[i] = groups[index]
+	}
+
+	if e.valueIndex == -1 {
+		e.counter.Inc(labels...)
+		return
+	}
+
+	value, err := strconv.ParseFloat(groups[e.valueIndex], 64)
+	if err != nil {
+		return
+	}
+
+	if e.gauge != nil {
+		e.gauge.SetFloat64(value, labels...)
+		return
+	}
+
+	// Counters can only go up, Prometheus panics otherwise
+	if value >= 0 {
+		e.counter.AddFloat64(value, labels...)
+	}
+}
+
+// text returns the line's message followed by its data fields as `<key>=<value>`, string
+// values being unquoted.
+func (l *jsonLogLine) text() string {
+	keys := make([]string, 0, len(l.Data))
+	for key := range l.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteString(l.Message)
+	for _, key := range keys {
+		value := string(l.Data[key])
+
+		var unquoted string
+		if err := json.Unmarshal(l.Data[key], &unquoted); err == nil {
+			value = unquoted
+		}
+
+		builder.WriteString(" " + key + "=" + value)
+	}
+
+	return builder.String()
+}
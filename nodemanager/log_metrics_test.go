@@ -0,0 +1,164 @@
+package nodemanager
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streamingfast/dmetrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogMetricsPlugin_LogLine(t *testing.T) {
+	logMetrics := []*LogMetric{
+		{Name: "synced_version", Type: LogMetricTypeGauge, Pattern: `synced_version=(?P<value>\d+)`},
+		{Name: "connected_peers", Type: LogMetricTypeGauge, Pattern: `connected peers: (?P<value>\d+)`},
+		{Name: "peer_disconnections_total", Type: LogMetricTypeCounter, Pattern: `Peer disconnected`},
+	}
+
+	plugin, err := NewLogMetricsPlugin(dmetrics.NewSet(), logMetrics)
+	require.NoError(t, err)
+
+	for _, line := range []string{
+		`{"level":"INFO","message":"Synced transactions","data":{"synced_version":1200,"synced_epoch":"3"}}`,
+		"2022-09-13T18:51:10.000000Z [network] INFO Current connected peers: 12",
+		"2022-09-13T18:51:12.000000Z [network] INFO Peer disconnected",
+		"2022-09-13T18:51:13.000000Z [network] INFO Peer disconnected",
+		"FIRE BLOCK 1 synced_version=99999 connected peers: 99",
+	} {
+		plugin.LogLine(line)
+	}
+
+	assert.Equal(t, map[string]float64{
+		"synced_version":            1200,
+		"connected_peers":           12,
+		"peer_disconnections_total": 2,
+	}, logMetricValues(plugin, logMetrics))
+}
+
+func TestLogMetricsPlugin_DefaultLogMetrics(t *testing.T) {
+	logMetrics := DefaultLogMetrics()
+	plugin, err := NewLogMetricsPlugin(dmetrics.NewSet(), logMetrics)
+	require.NoError(t, err)
+
+	file, err := os.Open("testdata/aptos-node.log")
+	require.NoError(t, err)
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		plugin.LogLine(scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+
+	assert.Equal(t, map[string]float64{
+		"state_sync_synced_version": 1200,
+		"state_sync_synced_epoch":   3,
+		"committed_version":         1150,
+		"epoch":                     4,
+		"connected_peers":           5,
+	}, logMetricValues(plugin, logMetrics))
+}
+
+func TestLogMetricsPlugin_Labels(t *testing.T) {
+	plugin, err := NewLogMetricsPlugin(dmetrics.NewSet(), []*LogMetric{
+		{Name: "peers", Type: LogMetricTypeGauge, Pattern: `(?P<network>\w+) peers: (?P<value>\d+)`},
+		{Name: "bytes_total", Type: LogMetricTypeCounter, Pattern: `received (?P<value>-?\d+) bytes`},
+	})
+	require.NoError(t, err)
+
+	plugin.LogLine("vfn peers: 2")
+	plugin.LogLine("public peers: 8")
+	plugin.LogLine("received 10 bytes")
+	plugin.LogLine("received 5 bytes")
+	plugin.LogLine("received -5 bytes")
+
+	peers := plugin.extractors[0].gauge.Native()
+	assert.Equal(t, float64(2), testutil.ToFloat64(peers.WithLabelValues("vfn")))
+	assert.Equal(t, float64(8), testutil.ToFloat64(peers.WithLabelValues("public")))
+	assert.Equal(t, float64(15), testutil.ToFloat64(plugin.extractors[1].counter.Native()))
+}
+
+func TestNewLogMetricsPlugin_Invalid(t *testing.T) {
+	tests := []struct {
+		name          string
+		logMetrics    []*LogMetric
+		expectedError string
+	}{
+		{"no name", []*LogMetric{{Type: LogMetricTypeCounter, Pattern: "a"}}, `log metric with pattern "a" has no name`},
+		{"duplicate", []*LogMetric{{Name: "a", Type: LogMetricTypeCounter, Pattern: "a"}, {Name: "a", Type: LogMetricTypeCounter, Pattern: "b"}}, `log metric "a" defined more than once`},
+		{"invalid pattern", []*LogMetric{{Name: "a", Type: LogMetricTypeCounter, Pattern: "("}}, "log metric \"a\": invalid pattern: error parsing regexp: missing closing ): `(`"},
+		{"gauge without value", []*LogMetric{{Name: "a", Type: LogMetricTypeGauge, Pattern: "a"}}, `log metric "a": gauge pattern must have a "value" named group`},
+		{"invalid type", []*LogMetric{{Name: "a", Type: "histogram", Pattern: "a"}}, `log metric "a": invalid type "histogram", expecting "gauge" or "counter"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewLogMetricsPlugin(dmetrics.NewSet(), test.logMetrics)
+			assert.EqualError(t, err, test.expectedError)
+		})
+	}
+}
+
+func TestLoadLogMetrics(t *testing.T) {
+	logMetrics, err := LoadLogMetrics("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLogMetrics(), logMetrics)
+
+	configFile := filepath.Join(t.TempDir(), "log-metrics.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+metrics:
+  - name: epoch
+    type: gauge
+    pattern: 'epoch (?P<value>\d+)'
+    help: Current epoch
+  - name: mempool_size
+    type: gauge
+    pattern: 'mempool size: (?P<value>\d+)'
+`), 0644))
+
+	logMetrics, err = LoadLogMetrics(configFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"state_sync_synced_version", "state_sync_synced_epoch", "committed_version", "connected_peers", "epoch", "mempool_size"}, logMetricNames(logMetrics))
+	assert.Equal(t, &LogMetric{Name: "epoch", Type: LogMetricTypeGauge, Pattern: `epoch (?P<value>\d+)`, Help: "Current epoch"}, logMetrics[4])
+
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+disable_defaults: true
+metrics:
+  - name: mempool_size
+    type: gauge
+    pattern: 'mempool size: (?P<value>\d+)'
+`), 0644))
+
+	logMetrics, err = LoadLogMetrics(configFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mempool_size"}, logMetricNames(logMetrics))
+
+	_, err = LoadLogMetrics(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "read log metrics config:")
+}
+
+func logMetricNames(logMetrics []*LogMetric) (out []string) {
+	for _, metric := range logMetrics {
+		out = append(out, metric.Name)
+	}
+
+	return out
+}
+
+func logMetricValues(plugin *LogMetricsPlugin, logMetrics []*LogMetric) map[string]float64 {
+	values := map[string]float64{}
+	for i, extractor := range plugin.extractors {
+		name := logMetrics[i].Name
+		if extractor.gauge != nil {
+			values[name] = testutil.ToFloat64(extractor.gauge.Native().WithLabelValues())
+		} else {
+			values[name] = testutil.ToFloat64(extractor.counter.Native().WithLabelValues())
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,250 @@
+package nodemanager
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// LogSource is where the reader reads the node's log lines from:
+//
+//   - `stdin://` reads the process's standard input
+//   - `file://<path>` replays captured logs, gzip or zstd compressed files being decompressed
+//   - `fifo://<path>` reads an existing named pipe, opened again each time its writer closes it
+//   - `unix://<path>` and `tcp://<host>:<port>` connect to a socket, reconnecting when disconnected
+//
+// Sources that are opened again (named pipes and sockets) are retried with an exponential backoff,
+// from `ReconnectDelay` up to `MaxReconnectDelay`.
+type LogSource struct {
+	Scheme  string
+	Address string
+
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+}
+
+func ParseLogSource(in string) (*LogSource, error) {
+	scheme, address, found := strings.Cut(in, "://")
+	if !found {
+		return nil, fmt.Errorf("invalid log source %q, expecting '<scheme>://<address>'", in)
+	}
+
+	source := &LogSource{Scheme: scheme, Address: address, ReconnectDelay: time.Second, MaxReconnectDelay: 30 * time.Second}
+	switch scheme {
+	case "stdin":
+		if address != "" {
+			return nil, fmt.Errorf("invalid log source %q, 'stdin://' has no address", in)
+		}
+	case "file", "fifo", "unix":
+		if address == "" {
+			return nil, fmt.Errorf("invalid log source %q, a path is required", in)
+		}
+	case "tcp":
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid log source %q, expecting 'tcp://<host>:<port>': %w", in, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid log source %q, scheme must be one of 'stdin', 'file', 'fifo', 'unix' or 'tcp'", in)
+	}
+
+	return source, nil
+}
+
+func (s *LogSource) String() string {
+	return s.Scheme + "://" + s.Address
+}
+
+// Reopens returns true when the source is opened again once it ends or fails
+func (s *LogSource) Reopens() bool {
+	return s.Scheme == "fifo" || s.Scheme == "unix" || s.Scheme == "tcp"
+}
+
+// ReadLines sends each line of the source to `onLine`, without its line terminator, until
+// the source ends or the context is canceled. Lines longer than `maxLineLength` bytes are
+// an error. Sources that reopen never end, the incomplete last line of each of their
+// connections being dropped.
+func (s *LogSource) ReadLines(ctx context.Context, maxLineLength int, onLine func(line string), logger *zap.Logger) error {
+	delay := s.ReconnectDelay
+	for {
+		lineCount, err := s.readOnce(ctx, maxLineLength, onLine, logger)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !s.Reopens() {
+			return err
+		}
+
+		if lineCount > 0 {
+			delay = s.ReconnectDelay
+		}
+
+		if err != nil {
+			logger.Warn("log source failed, opening it again", zap.Stringer("source", s), zap.Error(err), zap.Duration("delay", delay))
+		} else {
+			logger.Info("log source ended, opening it again", zap.Stringer("source", s), zap.Duration("delay", delay))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > s.MaxReconnectDelay {
+			delay = s.MaxReconnectDelay
+		}
+	}
+}
+
+func (s *LogSource) readOnce(ctx context.Context, maxLineLength int, onLine func(line string), logger *zap.Logger) (lineCount int, err error) {
+	source, err := s.open(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Unblocks the read when the context is canceled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			source.Close()
+		case <-done:
+			source.Close()
+		}
+	}()
+
+	logger.Info("reading log source", zap.Stringer("source", s))
+
+	lines := bufio.NewReaderSize(source, maxLineLength)
+	if s.Scheme == "file" {
+		decompressed, err := decompressor(lines)
+		if err != nil {
+			return 0, fmt.Errorf("decompress %q: %w", s.Address, err)
+		}
+
+		if decompressed != nil {
+			defer decompressed.Close()
+			lines = bufio.NewReaderSize(decompressed, maxLineLength)
+		}
+	}
+
+	for {
+		line, err := lines.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return lineCount, fmt.Errorf("line longer than %d bytes", maxLineLength)
+		}
+
+		if err != nil && len(line) > 0 && s.Reopens() {
+			logger.Warn("dropping incomplete last line of log source", zap.Stringer("source", s), zap.Int("length", len(line)))
+			line = nil
+		}
+
+		if len(line) > 0 {
+			lineCount++
+			onLine(string(bytes.TrimRight(line, "\r\n")))
+		}
+
+		if err == io.EOF {
+			return lineCount, nil
+		}
+
+		if err != nil {
+			return lineCount, err
+		}
+	}
+}
+
+func (s *LogSource) open(ctx context.Context) (io.ReadCloser, error) {
+	switch s.Scheme {
+	case "stdin":
+		return os.Stdin, nil
+	case "file":
+		return os.Open(s.Address)
+	case "fifo":
+		return openFifo(ctx, s.Address)
+	default:
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, s.Scheme, s.Address)
+	}
+}
+
+// openFifo opens the named pipe for reading, which blocks until a writer opens it
+func openFifo(ctx context.Context, path string) (io.ReadCloser, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%q is not a named pipe", path)
+	}
+
+	type result struct {
+		file *os.File
+		err  error
+	}
+
+	opened := make(chan result, 1)
+	go func() {
+		file, err := os.Open(path)
+		opened <- result{file, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// The pending open completes when a writer shows up, close it then
+		go func() {
+			if result := <-opened; result.file != nil {
+				result.file.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	case result := <-opened:
+		return result.file, result.err
+	}
+}
+
+// decompressor returns a reader decompressing the content of `in` when it's gzip or zstd compressed,
+// detected by their magic bytes, nil otherwise. The returned reader must be closed to release the
+// decoder's resources, which doesn't close `in`.
+func decompressor(in *bufio.Reader) (io.ReadCloser, error) {
+	magic, err := in.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		reader, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, err
+		}
+		return reader, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(in)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, nil
+	}
+}
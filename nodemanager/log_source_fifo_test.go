@@ -0,0 +1,47 @@
+//go:build !windows
+
+package nodemanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSource_Fifo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aptos.fifo")
+	require.NoError(t, syscall.Mkfifo(path, 0600))
+
+	source := newTestLogSource(t, "fifo://"+path)
+	lines, stop := readTestLogSource(t, source)
+
+	// Each writer closing the pipe is followed by the next one
+	for _, content := range []string{"line 1\nline 2\n", "line 3\n"} {
+		writer, err := os.OpenFile(path, os.O_WRONLY, 0)
+		require.NoError(t, err)
+
+		_, err = writer.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+	}
+
+	assert.Eventually(t, func() bool { return len(lines.get()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"line 1", "line 2", "line 3"}, lines.get())
+
+	// Canceled while waiting for the next writer
+	assert.ErrorIs(t, stop(), context.Canceled)
+}
+
+func TestOpenFifo_NotNamedPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aptos.log")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+
+	_, err := openFifo(context.Background(), path)
+	assert.EqualError(t, err, `"`+path+`" is not a named pipe`)
+}
@@ -0,0 +1,226 @@
+package nodemanager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseLogSource(t *testing.T) {
+	tests := []struct {
+		in              string
+		expectedScheme  string
+		expectedAddress string
+		expectedError   string
+	}{
+		{"stdin://", "stdin", "", ""},
+		{"file:///var/log/aptos.log.gz", "file", "/var/log/aptos.log.gz", ""},
+		{"fifo://./aptos.fifo", "fifo", "./aptos.fifo", ""},
+		{"unix:///run/aptos.sock", "unix", "/run/aptos.sock", ""},
+		{"tcp://localhost:9000", "tcp", "localhost:9000", ""},
+		{"/var/log/aptos.log", "", "", `invalid log source "/var/log/aptos.log", expecting '<scheme>://<address>'`},
+		{"stdin://0", "", "", `invalid log source "stdin://0", 'stdin://' has no address`},
+		{"file://", "", "", `invalid log source "file://", a path is required`},
+		{"tcp://localhost", "", "", `invalid log source "tcp://localhost", expecting 'tcp://<host>:<port>': address localhost: missing port in address`},
+		{"http://localhost:9000", "", "", `invalid log source "http://localhost:9000", scheme must be one of 'stdin', 'file', 'fifo', 'unix' or 'tcp'`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			source, err := ParseLogSource(test.in)
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedScheme, source.Scheme)
+			assert.Equal(t, test.expectedAddress, source.Address)
+			assert.Equal(t, test.in, source.String())
+		})
+	}
+}
+
+func TestLogSource_File(t *testing.T) {
+	content := "FIRE INIT\r\nFIRE BLOCK_START 1\n\nFIRE BLOCK_END 1"
+	expected := []string{"FIRE INIT", "FIRE BLOCK_START 1", "", "FIRE BLOCK_END 1"}
+
+	gzipped := bytes.NewBuffer(nil)
+	gzipWriter := gzip.NewWriter(gzipped)
+	_, err := gzipWriter.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	zstdEncoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"plain", []byte(content)},
+		{"gzip", gzipped.Bytes()},
+		{"zstd", zstdEncoder.EncodeAll([]byte(content), nil)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "aptos.log")
+			require.NoError(t, os.WriteFile(path, test.content, 0644))
+
+			source := newTestLogSource(t, "file://"+path)
+			lines := &testLines{}
+			require.NoError(t, source.ReadLines(context.Background(), 1024, lines.add, zap.NewNop()))
+			assert.Equal(t, expected, lines.get())
+		})
+	}
+}
+
+func TestLogSource_FileErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aptos.log")
+
+	source := newTestLogSource(t, "file://"+path)
+	assert.ErrorIs(t, source.ReadLines(context.Background(), 1024, func(string) {}, zap.NewNop()), os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(path, []byte("short\n"+strings.Repeat("long", 10)+"\n"), 0644))
+	lines := &testLines{}
+	assert.EqualError(t, source.ReadLines(context.Background(), 16, lines.add, zap.NewNop()), "line longer than 16 bytes")
+	assert.Equal(t, []string{"short"}, lines.get())
+}
+
+func TestLogSource_Sockets(t *testing.T) {
+	tests := []struct {
+		network string
+		address func(t *testing.T) string
+	}{
+		{"unix", func(t *testing.T) string { return filepath.Join(t.TempDir(), "aptos.sock") }},
+		{"tcp", func(t *testing.T) string { return "127.0.0.1:0" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.network, func(t *testing.T) {
+			listener, err := net.Listen(test.network, test.address(t))
+			require.NoError(t, err)
+			defer listener.Close()
+
+			// The first connection is closed in the middle of a line, the second one is kept open
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Write([]byte("line 1\nline 2\nline"))
+				conn.Close()
+
+				conn, err = listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.Write([]byte("line 3\n"))
+				<-time.After(5 * time.Second)
+			}()
+
+			source := newTestLogSource(t, test.network+"://"+listener.Addr().String())
+			lines, stop := readTestLogSource(t, source)
+
+			assert.Eventually(t, func() bool { return len(lines.get()) == 3 }, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, []string{"line 1", "line 2", "line 3"}, lines.get())
+			assert.ErrorIs(t, stop(), context.Canceled)
+		})
+	}
+}
+
+func TestLogSource_SocketNotListeningYet(t *testing.T) {
+	address := filepath.Join(t.TempDir(), "aptos.sock")
+
+	source := newTestLogSource(t, "unix://"+address)
+	lines, stop := readTestLogSource(t, source)
+
+	// Connection attempts keep failing until the node listens
+	time.Sleep(50 * time.Millisecond)
+	listener, err := net.Listen("unix", address)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("FIRE INIT\n"))
+		<-time.After(5 * time.Second)
+	}()
+
+	assert.Eventually(t, func() bool { return len(lines.get()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"FIRE INIT"}, lines.get())
+	assert.ErrorIs(t, stop(), context.Canceled)
+}
+
+func newTestLogSource(t *testing.T, in string) *LogSource {
+	t.Helper()
+
+	source, err := ParseLogSource(in)
+	require.NoError(t, err)
+
+	source.ReconnectDelay = 10 * time.Millisecond
+	source.MaxReconnectDelay = 20 * time.Millisecond
+
+	return source
+}
+
+// readTestLogSource reads the source in the background, until the returned function is called
+func readTestLogSource(t *testing.T, source *LogSource) (*testLines, func() error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := &testLines{}
+	done := make(chan error, 1)
+	go func() {
+		done <- source.ReadLines(ctx, 1024, lines.add, zap.NewNop())
+	}()
+
+	return lines, func() error {
+		cancel()
+
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("reading log source did not stop")
+			return nil
+		}
+	}
+}
+
+type testLines struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (l *testLines) add(line string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.lines = append(l.lines, line)
+}
+
+func (l *testLines) get() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return append([]string(nil), l.lines...)
+}
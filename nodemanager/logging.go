@@ -1,7 +1,11 @@
 package nodemanager
 
 import (
+	"encoding/json"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
 	logplugin "github.com/streamingfast/node-manager/log_plugin"
 	"go.uber.org/zap"
@@ -15,8 +19,103 @@ import (
 var logLineRegex = regexp.MustCompile("^[0123][0-9]{3}-[0-9]{1,2}-[0-9]{1,2}T[0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}\\.[0-9]+Z\\s*(\\[.*\\])?\\s*(ERROR|WARN|DEBUG|INFO)\\s*(.*)")
 var panicLineRegex = regexp.MustCompile("^thread '.*' panicked")
 
-func newToZapLogPlugin(debugFirehoseLogs bool, logger *zap.Logger) *logplugin.ToZapLogPlugin {
-	return logplugin.NewToZapLogPlugin(debugFirehoseLogs, logger, logplugin.ToZapLogPluginLogLevel(logLevelReader), logplugin.ToZapLogPluginTransformer(stripPrefix))
+// toZapLogPlugin logs JSON lines (aptos-node configured with JSON logging) with their level,
+// message and structured fields, other lines going through the regex based level extraction.
+type toZapLogPlugin struct {
+	*logplugin.ToZapLogPlugin
+
+	logger *zap.Logger
+}
+
+func newToZapLogPlugin(debugFirehoseLogs bool, logger *zap.Logger) *toZapLogPlugin {
+	return &toZapLogPlugin{
+		ToZapLogPlugin: logplugin.NewToZapLogPlugin(debugFirehoseLogs, logger, logplugin.ToZapLogPluginLogLevel(logLevelReader), logplugin.ToZapLogPluginTransformer(stripPrefix)),
+		logger:         logger,
+	}
+}
+
+func (p *toZapLogPlugin) LogLine(in string) {
+	if entry := parseJSONLogLine(in); entry != nil {
+		p.logger.Check(parseLogLevel(entry.Level), entry.Message).Write(entry.fields()...)
+		return
+	}
+
+	p.ToZapLogPlugin.LogLine(in)
+}
+
+// jsonLogLine is a line logged by aptos-node's logger in JSON format, like:
+//
+//	{"level":"INFO","source_path":"state-sync/src/driver.rs:97","module_path":"state_sync::driver","message":"...","data":{...}}
+type jsonLogLine struct {
+	Level      string                     `json:"level"`
+	Message    string                     `json:"message"`
+	ModulePath string                     `json:"module_path"`
+	Target     string                     `json:"target"`
+	SourcePath string                     `json:"source_path"`
+	ThreadName string                     `json:"thread_name"`
+	Data       map[string]json.RawMessage `json:"data"`
+}
+
+// parseJSONLogLine returns nil when the line is not a JSON log line.
+func parseJSONLogLine(in string) *jsonLogLine {
+	if !strings.HasPrefix(in, "{") {
+		return nil
+	}
+
+	entry := &jsonLogLine{}
+	if err := json.Unmarshal([]byte(in), entry); err != nil || entry.Level == "" {
+		return nil
+	}
+
+	return entry
+}
+
+func (l *jsonLogLine) fields() (fields []zap.Field) {
+	module := l.ModulePath
+	if module == "" {
+		module = l.Target
+	}
+	if module != "" {
+		fields = append(fields, zap.String("module", module))
+	}
+
+	if l.SourcePath != "" {
+		file, line, found := cutLast(l.SourcePath, ":")
+		if lineNum, err := strconv.Atoi(line); found && err == nil {
+			fields = append(fields, zap.String("file", file), zap.Int("line", lineNum))
+		} else {
+			fields = append(fields, zap.String("file", l.SourcePath))
+		}
+	}
+
+	if l.ThreadName != "" {
+		fields = append(fields, zap.String("thread", l.ThreadName))
+	}
+
+	keys := make([]string, 0, len(l.Data))
+	for key := range l.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var value interface{}
+		if err := json.Unmarshal(l.Data[key], &value); err != nil {
+			value = string(l.Data[key])
+		}
+
+		fields = append(fields, zap.Any(key, value))
+	}
+
+	return fields
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
 }
 
 func logLevelReader(in string) zapcore.Level {
@@ -30,8 +129,12 @@ func logLevelReader(in string) zapcore.Level {
 		return zap.InfoLevel
 	}
 
-	switch groups[2] {
-	case "debug", "DEBUG":
+	return parseLogLevel(groups[2])
+}
+
+func parseLogLevel(level string) zapcore.Level {
+	switch level {
+	case "debug", "DEBUG", "trace", "TRACE":
 		return zap.DebugLevel
 	case "info", "INFO":
 		return zap.InfoLevel
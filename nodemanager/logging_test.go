@@ -47,6 +47,46 @@ func Test_newToZapLogPlugin(t *testing.T) {
 			`2022-08-13T17:33:13.498748Z [api] INFO file.rs:1 [api] message`,
 			`{"level":"info","msg":"[api] file.rs:1 [api] message"}`,
 		},
+		{
+			"panic line",
+			`thread 'main' panicked at 'boom', src/main.rs:1:1`,
+			`{"level":"error","msg":"thread 'main' panicked at 'boom', src/main.rs:1:1"}`,
+		},
+		{
+			"unknown format",
+			`some line`,
+			`{"level":"info","msg":"some line"}`,
+		},
+		{
+			"json info level with fields",
+			`{"level":"INFO","source_path":"state-sync/state-sync-v2/state-sync-driver/src/driver.rs:97","module_path":"state_sync_driver::driver","target":"state_sync_driver::driver","hostname":"host","timestamp":"2022-08-13T17:33:13.498748Z","thread_name":"sync-driver","message":"Synced to version","data":{"version":1500,"epoch":3,"peer":{"id":"0xa"}}}`,
+			`{"level":"info","msg":"Synced to version","module":"state_sync_driver::driver","file":"state-sync/state-sync-v2/state-sync-driver/src/driver.rs","line":97,"thread":"sync-driver","epoch":3,"peer":{"id":"0xa"},"version":1500}`,
+		},
+		{
+			"json warning level without message",
+			`{"level":"WARN","target":"network","source_path":"network/src/peer.rs","data":{"error":"connection reset"}}`,
+			`{"level":"warn","msg":"","module":"network","file":"network/src/peer.rs","error":"connection reset"}`,
+		},
+		{
+			"json error level",
+			`{"level":"ERROR","message":"failed"}`,
+			`{"level":"error","msg":"failed"}`,
+		},
+		{
+			"json debug level",
+			`{"level":"DEBUG","message":"details"}`,
+			`{"level":"debug","msg":"details"}`,
+		},
+		{
+			"json without level falls back to regex path",
+			`{"message":"no level"}`,
+			`{"level":"info","msg":"{\"message\":\"no level\"}"}`,
+		},
+		{
+			"invalid json falls back to regex path",
+			`{"level":"INFO",`,
+			`{"level":"info","msg":"{\"level\":\"INFO\","}`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
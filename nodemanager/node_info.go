@@ -0,0 +1,174 @@
+package nodemanager
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/curve25519"
+	"gopkg.in/yaml.v3"
+)
+
+const PeerIDSourceIdentityFile = "identity_file"
+
+// NodeInfo identifies the node managed by the superviser, the client fields and the chain ID
+// being filled from the node's `FIRE INIT` line.
+type NodeInfo struct {
+	PeerID        string `json:"peer_id"`
+	PeerIDSource  string `json:"peer_id_source,omitempty"`
+	ClientName    string `json:"client_name,omitempty"`
+	ClientVersion string `json:"client_version,omitempty"`
+	Fork          string `json:"fork,omitempty"`
+	ChainID       uint32 `json:"chain_id,omitempty"`
+	Network       string `json:"network,omitempty"`
+}
+
+// identityBlob is the content of an aptos-node identity file, like `vfn-identity.yaml`
+type identityBlob struct {
+	AccountAddress    string `yaml:"account_address"`
+	NetworkPrivateKey string `yaml:"network_private_key"`
+}
+
+// PeerIDFromIdentityFile returns the peer ID aptos-node uses for the identity file at `path`, its
+// account address when defined, otherwise the x25519 public key of its network private key.
+func PeerIDFromIdentityFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read identity file: %w", err)
+	}
+
+	identity := &identityBlob{}
+	if err := yaml.Unmarshal(content, identity); err != nil {
+		return "", fmt.Errorf("invalid identity file %q: %w", path, err)
+	}
+
+	if identity.AccountAddress != "" {
+		address, err := decodeHex32(identity.AccountAddress)
+		if err != nil {
+			return "", fmt.Errorf("invalid account address in identity file %q: %w", path, err)
+		}
+
+		return "0x" + hex.EncodeToString(address), nil
+	}
+
+	if identity.NetworkPrivateKey == "" {
+		return "", fmt.Errorf("identity file %q defines neither 'account_address' nor 'network_private_key'", path)
+	}
+
+	privateKey, err := decodeHex32(identity.NetworkPrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid network private key in identity file %q: %w", path, err)
+	}
+
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return "", fmt.Errorf("derive network public key: %w", err)
+	}
+
+	return "0x" + hex.EncodeToString(publicKey), nil
+}
+
+// decodeHex32 decodes a 32 bytes hex value, account addresses being left padded with zeros when
+// shorter (like `0x1`).
+func decodeHex32(in string) ([]byte, error) {
+	in = strings.TrimPrefix(strings.TrimPrefix(in, "0x"), "0X")
+	if len(in) > 64 {
+		return nil, fmt.Errorf("expecting at most 32 bytes, got %d hex characters", len(in))
+	}
+
+	return hex.DecodeString(strings.Repeat("0", 64-len(in)) + in)
+}
+
+// SetPeerID sets the peer ID the node runs as, `source` being where it comes from.
+func (s *Superviser) SetPeerID(peerID, source string) {
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+
+	s.nodeInfo.PeerID = peerID
+	s.nodeInfo.PeerIDSource = source
+}
+
+// SetNetwork sets the network the node runs on along with the chain ID configured for it, the
+// chain ID reported by the node in its `FIRE INIT` line taking precedence.
+func (s *Superviser) SetNetwork(network string, chainID uint32) {
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+
+	s.nodeInfo.Network = network
+	if s.nodeInfo.ChainID == 0 {
+		s.nodeInfo.ChainID = chainID
+	}
+}
+
+// NodeInfo returns what is known about the node so far.
+func (s *Superviser) NodeInfo() *NodeInfo {
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+
+	info := s.nodeInfo
+	return &info
+}
+
+func (s *Superviser) setClientInfo(clientName, clientVersion, fork string, chainID uint32) {
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+
+	s.nodeInfo.ClientName = clientName
+	s.nodeInfo.ClientVersion = clientVersion
+	s.nodeInfo.Fork = fork
+	s.nodeInfo.ChainID = chainID
+}
+
+func (s *Superviser) nodeInfoHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.NodeInfo()); err != nil {
+		s.Logger.Warn("unable to write node info response", zap.Error(err))
+	}
+}
+
+// nodeInfoLogPlugin fills the superviser's node info from the `FIRE INIT` line. The peer ID is
+// not read from the logs, the ones of remote peers being logged too, it is only known from the
+// node's identity file.
+type nodeInfoLogPlugin struct {
+	*shutter.Shutter
+
+	superviser *Superviser
+}
+
+func newNodeInfoLogPlugin(superviser *Superviser) *nodeInfoLogPlugin {
+	return &nodeInfoLogPlugin{
+		Shutter:    shutter.New(),
+		superviser: superviser,
+	}
+}
+
+func (p *nodeInfoLogPlugin) Name() string { return "NodeInfoLogPlugin" }
+func (p *nodeInfoLogPlugin) Launch()      {}
+func (p *nodeInfoLogPlugin) Stop()        {}
+
+func (p *nodeInfoLogPlugin) LogLine(in string) {
+	if strings.HasPrefix(in, "FIRE INIT ") {
+		p.readInit(strings.Split(in[len("FIRE INIT "):], " "))
+	}
+}
+
+// readInit reads `FIRE INIT <client name> <client version> <fork> <firehose major> <firehose minor> [<extra>] <chain id>`,
+// the console reader being the one validating it.
+func (p *nodeInfoLogPlugin) readInit(params []string) {
+	if len(params) != 6 && len(params) != 7 {
+		return
+	}
+
+	chainID, err := strconv.ParseUint(params[len(params)-1], 10, 32)
+	if err != nil {
+		return
+	}
+
+	p.superviser.setClientInfo(params[0], params[1], params[2], uint32(chainID))
+}
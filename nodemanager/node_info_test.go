@@ -0,0 +1,121 @@
+package nodemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerIDFromIdentityFile(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		expectedPeerID string
+		expectedError  string
+	}{
+		{
+			"account address",
+			"account_address: 0xA1B2C3D4E5F60718293A4B5C6D7E8F90A1B2C3D4E5F60718293A4B5C6D7E8F90\nnetwork_private_key: \"0x77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a\"\n",
+			"0xa1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90",
+			"",
+		},
+		{
+			"short account address",
+			"account_address: \"0x1\"\n",
+			"0x0000000000000000000000000000000000000000000000000000000000000001",
+			"",
+		},
+		{
+			// RFC 7748 section 6.1 test vector
+			"network private key",
+			"account_private_key: \"0x01\"\nnetwork_private_key: \"0x77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a\"\n",
+			"0x8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a",
+			"",
+		},
+		{
+			"network private key without prefix",
+			"network_private_key: 77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a\n",
+			"0x8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a",
+			"",
+		},
+		{
+			"no keys",
+			"consensus_private_key: \"0x01\"\n",
+			"",
+			"identity file %q defines neither 'account_address' nor 'network_private_key'",
+		},
+		{
+			"invalid network private key",
+			"network_private_key: \"0xzz\"\n",
+			"",
+			"invalid network private key in identity file %q: encoding/hex: invalid byte: U+007A 'z'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identityFile := filepath.Join(t.TempDir(), "vfn-identity.yaml")
+			require.NoError(t, os.WriteFile(identityFile, []byte(test.content), 0644))
+
+			peerID, err := PeerIDFromIdentityFile(identityFile)
+			if test.expectedError != "" {
+				assert.EqualError(t, err, fmt.Sprintf(test.expectedError, identityFile))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedPeerID, peerID)
+		})
+	}
+}
+
+func TestNodeInfoLogPlugin(t *testing.T) {
+	superviser := NewSuperviser("aptos-node", nil, t.TempDir(), false, true, 0, zlog, zlog)
+	superviser.SetNetwork("testnet", 0)
+
+	plugin := newNodeInfoLogPlugin(superviser)
+	plugin.LogLine("FIRE INIT aptos-node 1.3.0 aptos 0 1 2")
+	// Peers mentioned in the logs may be remote ones, they are never taken as the node's peer ID
+	plugin.LogLine("2022-09-13T18:51:09.366353Z [network] INFO Starting network with peer_id: ABCDEF0000000000000000000000000000000000000000000000000000000001")
+	plugin.LogLine(`{"level":"INFO","message":"Other peer","data":{"peer_id":"0000000000000000000000000000000000000000000000000000000000000002"}}`)
+
+	assert.Equal(t, &NodeInfo{
+		ClientName:    "aptos-node",
+		ClientVersion: "1.3.0",
+		Fork:          "aptos",
+		ChainID:       2,
+		Network:       "testnet",
+	}, superviser.NodeInfo())
+
+	serverID, err := superviser.ServerID()
+	require.NoError(t, err)
+	assert.Equal(t, "", serverID)
+}
+
+func TestNodeInfoLogPlugin_IdentityFilePeerID(t *testing.T) {
+	superviser := NewSuperviser("aptos-node", nil, t.TempDir(), false, true, 0, zlog, zlog)
+	superviser.SetNetwork("mainnet", 1)
+	superviser.SetPeerID("0x01", PeerIDSourceIdentityFile)
+
+	plugin := newNodeInfoLogPlugin(superviser)
+	plugin.LogLine(`{"level":"INFO","message":"Starting network","data":{"peer_id":"0000000000000000000000000000000000000000000000000000000000000002"}}`)
+
+	router := mux.NewRouter()
+	superviser.RegisterHTTPRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v1/node-info", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	info := &NodeInfo{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), info))
+	assert.Equal(t, &NodeInfo{PeerID: "0x01", PeerIDSource: PeerIDSourceIdentityFile, ChainID: 1, Network: "mainnet"}, info)
+}
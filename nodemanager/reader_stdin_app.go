@@ -0,0 +1,132 @@
+package nodemanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streamingfast/bstream/blockstream"
+	dgrpcserver "github.com/streamingfast/dgrpc/server"
+	dgrpcfactory "github.com/streamingfast/dgrpc/server/factory"
+	"github.com/streamingfast/logging"
+	nodeManager "github.com/streamingfast/node-manager"
+	"github.com/streamingfast/node-manager/mindreader"
+	pbbstream "github.com/streamingfast/pbgo/sf/bstream/v1"
+	pbheadinfo "github.com/streamingfast/pbgo/sf/headinfo/v1"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// ReaderStdinApp is the reader app of `github.com/streamingfast/node-manager/app/node_reader_stdin`
+// reading the node's logs from a `LogSource` instead of only the process's standard input.
+type ReaderStdinApp struct {
+	*shutter.Shutter
+	config  *ReaderStdinAppConfig
+	modules *ReaderStdinAppModules
+	zlogger *zap.Logger
+	tracer  logging.Tracer
+}
+
+type ReaderStdinAppConfig struct {
+	GRPCAddr                   string
+	OneBlocksStoreURL          string
+	OneBlockSuffix             string
+	MindReadBlocksChanCapacity int
+	StartBlockNum              uint64
+	StopBlockNum               uint64
+	WorkingDir                 string
+	Source                     *LogSource
+
+	// MaxLineLengthInBytes configures the maximum bytes a single line consumed can be
+	// without any error. If left unspecified or 0, the default is 50 MiB (50 * 1024 * 1024).
+	MaxLineLengthInBytes int
+}
+
+type ReaderStdinAppModules struct {
+	ConsoleReaderFactory       mindreader.ConsolerReaderFactory
+	MetricsAndReadinessManager *nodeManager.MetricsAndReadinessManager
+	RegisterGRPCService        func(server grpc.ServiceRegistrar) error
+}
+
+func NewReaderStdinApp(config *ReaderStdinAppConfig, modules *ReaderStdinAppModules, zlogger *zap.Logger, tracer logging.Tracer) *ReaderStdinApp {
+	return &ReaderStdinApp{
+		Shutter: shutter.New(),
+		config:  config,
+		modules: modules,
+		zlogger: zlogger,
+		tracer:  tracer,
+	}
+}
+
+func (a *ReaderStdinApp) Run() error {
+	a.zlogger.Info("launching reader-node app (reading from log source)", zap.Reflect("config", a.config), zap.Stringer("source", a.config.Source))
+
+	gs := dgrpcfactory.ServerFromOptions(dgrpcserver.WithLogger(a.zlogger))
+
+	blockStreamServer := blockstream.NewUnmanagedServer(blockstream.ServerOptionWithLogger(a.zlogger))
+
+	a.zlogger.Info("launching reader log plugin")
+	mindreaderLogPlugin, err := mindreader.NewMindReaderPlugin(
+		a.config.OneBlocksStoreURL,
+		a.config.WorkingDir,
+		a.modules.ConsoleReaderFactory,
+		a.config.StartBlockNum,
+		a.config.StopBlockNum,
+		a.config.MindReadBlocksChanCapacity,
+		a.modules.MetricsAndReadinessManager.UpdateHeadBlock,
+		func(_ error) {},
+		a.config.OneBlockSuffix,
+		blockStreamServer,
+		a.zlogger,
+		a.tracer,
+	)
+	if err != nil {
+		return err
+	}
+
+	mindreaderLogPlugin.OnTerminated(a.Shutdown)
+	a.OnTerminating(mindreaderLogPlugin.Shutdown)
+
+	serviceRegistrar := gs.ServiceRegistrar()
+	pbheadinfo.RegisterHeadInfoServer(serviceRegistrar, blockStreamServer)
+	pbbstream.RegisterBlockStreamServer(serviceRegistrar, blockStreamServer)
+
+	if a.modules.RegisterGRPCService != nil {
+		if err := a.modules.RegisterGRPCService(serviceRegistrar); err != nil {
+			return fmt.Errorf("register extra grpc service: %w", err)
+		}
+	}
+	gs.OnTerminated(a.Shutdown)
+	go gs.Launch(a.config.GRPCAddr)
+
+	a.zlogger.Debug("running reader log plugin")
+	mindreaderLogPlugin.Launch()
+	go a.modules.MetricsAndReadinessManager.Launch()
+
+	maxLineLength := a.config.MaxLineLengthInBytes
+	if maxLineLength == 0 {
+		maxLineLength = 50 * 1024 * 1024
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.OnTerminating(func(_ error) { cancel() })
+
+	go func() {
+		err := a.config.Source.ReadLines(ctx, maxLineLength, mindreaderLogPlugin.LogLine, a.zlogger)
+		switch {
+		case ctx.Err() != nil:
+		case err != nil:
+			a.zlogger.Error("got an error while reading log source", zap.Stringer("source", a.config.Source), zap.Error(err))
+			mindreaderLogPlugin.Shutdown(err)
+		default:
+			// Like when reading from standard input, the app keeps serving the blocks read
+			a.zlogger.Info("done reading from log source", zap.Stringer("source", a.config.Source))
+		}
+	}()
+
+	return nil
+}
+
+func (a *ReaderStdinApp) IsReady() bool {
+	return true
+}
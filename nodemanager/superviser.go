@@ -5,24 +5,60 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ShinyTrinkets/overseer"
+	"github.com/gorilla/mux"
 	nodeManager "github.com/streamingfast/node-manager"
 	logplugin "github.com/streamingfast/node-manager/log_plugin"
 	"github.com/streamingfast/node-manager/superviser"
+	"github.com/streamingfast/shutter"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// outputReadQuietPeriod is how long the output of an exited run must stay quiet to be considered
+// fully read, see `runOutput.waitRead`.
+const outputReadQuietPeriod = 100 * time.Millisecond
+
+// Superviser runs the node process through `github.com/streamingfast/node-manager/superviser`.
+// The upstream superviser is not meant to start its process again once it exited by itself, so
+// each run of the node process gets its own, started once. The log plugins are registered here
+// instead, launched once with the first run and receiving the output of all the runs, each run
+// being fully read before the next one starts.
 type Superviser struct {
-	*superviser.Superviser
+	*shutter.Shutter
+	Logger *zap.Logger
 
 	infoMutex     sync.Mutex
 	binary        string
 	arguments     []string
 	dataDir       string
 	lastBlockSeen uint64
-	serverId      string
+	nodeInfo      NodeInfo
+
+	// run is the upstream superviser of the node process' last run, nil until first started,
+	// `runDone` being closed once its process exited
+	run       *superviser.Superviser
+	runDone   <-chan struct{}
+	runOutput *runOutput
+	runLock   sync.Mutex
+
+	logPlugins         []logplugin.LogPlugin
+	logPluginsLock     sync.RWMutex
+	logPluginsLaunched bool
+
+	restartPolicy *RestartPolicy
+	crashLogs     *crashLogPlugin
+	crashLock     sync.Mutex
+	crashTimes    []time.Time
+	crashLooping  bool
+	lastCrash     *CrashReport
+	onCrashLoop   []func(report *CrashReport)
+	stopRequested bool
+	cancelRestart chan struct{}
+	exited        chan struct{}
+	exitedOnce    sync.Once
 }
 
 func (s *Superviser) GetName() string {
@@ -43,13 +79,28 @@ func NewSuperviser(
 	overseer.DEFAULT_LINE_BUFFER_SIZE = 100 * 1024 * 1024
 
 	supervisor := &Superviser{
-		Superviser:    superviser.New(appLogger, binary, arguments),
+		Shutter:       shutter.New(),
+		Logger:        appLogger,
 		binary:        binary,
 		arguments:     arguments,
 		dataDir:       dataDir,
 		lastBlockSeen: lastSeenBlockNum,
+		exited:        make(chan struct{}),
 	}
 
+	supervisor.OnTerminating(func(_ error) {
+		supervisor.Logger.Info("superviser is terminating")
+
+		if err := supervisor.Stop(); err != nil {
+			supervisor.Logger.Error("failed to stop node process", zap.Error(err))
+		}
+
+		supervisor.Logger.Info("shutting down plugins", zap.Int("last_exit_code", supervisor.LastExitCode()))
+		supervisor.endLogPlugins()
+	})
+
+	supervisor.RegisterLogPlugin(newNodeInfoLogPlugin(supervisor))
+
 	if logToZap {
 		supervisor.RegisterLogPlugin(newToZapLogPlugin(debugFirehoseLogs, nodelogger))
 	} else {
@@ -67,34 +118,329 @@ func (s *Superviser) GetCommand() string {
 	return s.binary + " " + strings.Join(s.arguments, " ")
 }
 
+// RegisterLogPlugin adds a plugin receiving the node's log lines, a plugin shutting down shuts
+// down the superviser.
+func (s *Superviser) RegisterLogPlugin(plugin logplugin.LogPlugin) {
+	s.logPluginsLock.Lock()
+	defer s.logPluginsLock.Unlock()
+
+	s.logPlugins = append(s.logPlugins, plugin)
+	if shut, ok := plugin.(logplugin.Shutter); ok {
+		shut.OnTerminating(func(err error) {
+			if !s.IsTerminating() {
+				s.Logger.Info("superviser shutting down because of a plugin", zap.String("plugin_name", plugin.Name()))
+				go s.Shutdown(err)
+			}
+		})
+	}
+
+	s.Logger.Info("registered log plugin", zap.String("plugin_name", plugin.Name()), zap.Int("plugin_count", len(s.logPlugins)))
+}
+
+// Start starts the node process, an explicit start (the operator's start, resume or reload
+// commands) giving a crash looping node a fresh start.
 func (s *Superviser) Start(options ...nodeManager.StartOption) error {
-	s.Logger.Info("re-configuring environment variable to start syncing at correct location", zap.Uint64("starting_block_num", s.lastBlockSeen))
+	s.crashLock.Lock()
+	defer s.crashLock.Unlock()
+
+	s.stopRequested = false
+	if s.crashLooping {
+		s.Logger.Info("starting crash looping node process again, resetting crash history")
+		s.crashLooping = false
+		s.crashTimes = nil
+	}
+
+	return s.start(options...)
+}
+
+// start starts the node process and watches it for crashes when a restart policy is set, it
+// must be called with `crashLock` held.
+func (s *Superviser) start(options ...nodeManager.StartOption) error {
+	for _, option := range options {
+		if option == nodeManager.EnableDebugDeepmindOption {
+			s.setDeepMindDebug(true)
+		}
+		if option == nodeManager.DisableDebugDeepmindOption {
+			s.setDeepMindDebug(false)
+		}
+	}
+
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	if s.run != nil {
+		if s.isRunning() {
+			s.Logger.Info("underlying process already running, nothing to do")
+			return nil
+		}
+
+		// The previous run's output must be fully read before the next run's one is sent to the
+		// log plugins
+		s.runOutput.waitRead()
+	}
+
+	s.launchLogPlugins()
+	if s.crashLogs != nil {
+		s.crashLogs.reset()
+	}
+
+	lastBlockSeen := s.LastSeenBlockNum()
+	s.Logger.Info("re-configuring environment variable to start syncing at correct location", zap.Uint64("starting_block_num", lastBlockSeen))
+	run := superviser.New(s.Logger, s.binary, s.arguments)
 	// We inherit from parent process env (via `os.Environ()`) and add
 	// STARTING_BLOCK which will be picked by `apots-node` to determine
 	// at which "block num" to start.
-	s.Env = append(os.Environ(), fmt.Sprintf("STARTING_BLOCK=%d", s.lastBlockSeen))
+	run.Env = append(os.Environ(), fmt.Sprintf("STARTING_BLOCK=%d", lastBlockSeen))
+
+	output := &runOutput{superviser: s}
+	run.RegisterLogPlugin(output)
 
-	return s.Superviser.Start(options...)
+	if err := run.Start(); err != nil {
+		return err
+	}
+
+	s.run = run
+	s.runDone = run.Stopped()
+	s.runOutput = output
+
+	if s.restartPolicy != nil {
+		go s.watchProcess(run, s.runDone, output)
+	}
+
+	return nil
+}
+
+// Stop stops the node process, cancelling any pending restart after a crash. It returns once the
+// process' output has been fully read.
+func (s *Superviser) Stop() error {
+	s.crashLock.Lock()
+	s.stopRequested = true
+	if s.cancelRestart != nil {
+		close(s.cancelRestart)
+		s.cancelRestart = nil
+	}
+	s.crashLock.Unlock()
+
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	s.Logger.Info("supervisor received a stop request, terminating node process")
+	if s.run == nil {
+		s.Logger.Info("underlying process is not running, nothing to do")
+		return nil
+	}
+
+	if s.isRunning() {
+		if err := s.run.Stop(); err != nil {
+			return err
+		}
+	}
+
+	s.runOutput.waitRead()
+	s.Logger.Info("node process has been terminated and its output read")
+
+	return nil
 }
 
+// Stopped returns a channel closed when the node process stopped without being asked to, which
+// shuts down the operator. With a restart policy, crashes are handled by restarting the node
+// instead so only clean exits and failed restarts close it.
+func (s *Superviser) Stopped() <-chan struct{} {
+	if s.restartPolicy != nil {
+		return s.exited
+	}
+
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	return s.runDone
+}
+
+func (s *Superviser) IsRunning() bool {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	return s.isRunning()
+}
+
+// isRunning must be called with `runLock` held.
+func (s *Superviser) isRunning() bool {
+	if s.run == nil {
+		return false
+	}
+
+	select {
+	case <-s.runDone:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *Superviser) LastExitCode() int {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	if s.run == nil {
+		return 0
+	}
+
+	return s.run.LastExitCode()
+}
+
+func (s *Superviser) LastLogLines() []string {
+	s.logPluginsLock.RLock()
+	defer s.logPluginsLock.RUnlock()
+
+	for _, plugin := range s.logPlugins {
+		if _, ok := plugin.(*logplugin.ToConsoleLogPlugin); ok {
+			// There is no point in showing the last log lines when the user already saw it through the to console log plugin
+			return nil
+		}
+	}
+
+	for _, plugin := range s.logPlugins {
+		if v, ok := plugin.(*logplugin.KeepLastLinesLogPlugin); ok {
+			return v.LastLines()
+		}
+	}
+
+	return nil
+}
+
+func (s *Superviser) processLogLine(line string) {
+	s.logPluginsLock.RLock()
+	defer s.logPluginsLock.RUnlock()
+
+	for _, plugin := range s.logPlugins {
+		plugin.LogLine(line)
+	}
+}
+
+// launchLogPlugins launches the log plugins the first time the node is started, it must be called
+// with `cmdLock` held.
+func (s *Superviser) launchLogPlugins() {
+	if s.logPluginsLaunched {
+		return
+	}
+	s.logPluginsLaunched = true
+
+	s.logPluginsLock.RLock()
+	defer s.logPluginsLock.RUnlock()
+
+	for _, plugin := range s.logPlugins {
+		plugin.Launch()
+	}
+}
+
+func (s *Superviser) endLogPlugins() {
+	s.logPluginsLock.Lock()
+	defer s.logPluginsLock.Unlock()
+
+	for _, plugin := range s.logPlugins {
+		s.Logger.Info("stopping plugin", zap.String("plugin_name", plugin.Name()))
+		plugin.Stop()
+	}
+	s.Logger.Info("all plugins closed")
+}
+
+func (s *Superviser) setDeepMindDebug(enabled bool) {
+	s.logPluginsLock.RLock()
+	defer s.logPluginsLock.RUnlock()
+
+	s.Logger.Info("setting deep mind debug mode", zap.Bool("enabled", enabled))
+	for _, logPlugin := range s.logPlugins {
+		if v, ok := logPlugin.(nodeManager.DeepMindDebuggable); ok {
+			v.DebugDeepMind(enabled)
+		}
+	}
+}
+
+// RegisterHTTPRoutes adds the superviser's routes to the node manager API.
+func (s *Superviser) RegisterHTTPRoutes(router *mux.Router) {
+	router.HandleFunc("/v1/last_crash", s.lastCrashHandler).Methods("GET")
+	router.HandleFunc("/v1/node-info", s.nodeInfoHandler).Methods("GET")
+}
+
+// LastSeenBlockNum returns the last block written by the reader, set from the reader's block
+// written callback while the node runs.
 func (s *Superviser) LastSeenBlockNum() uint64 {
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+
 	return s.lastBlockSeen
 }
 
+// ServerID returns the node's peer ID, empty until known.
 func (s *Superviser) ServerID() (string, error) {
-	return s.serverId, nil
+	return s.NodeInfo().PeerID, nil
 }
 
 func (s *Superviser) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("binary", s.binary)
 	enc.AddArray("arguments", stringArray(s.arguments))
 	enc.AddString("data_dir", s.dataDir)
-	enc.AddUint64("last_block_seen", s.lastBlockSeen)
-	enc.AddString("server_id", s.serverId)
+	enc.AddUint64("last_block_seen", s.LastSeenBlockNum())
+	enc.AddString("server_id", s.NodeInfo().PeerID)
 
 	return nil
 }
 
 func (s *Superviser) SetLastBlockSeen(blockNum uint64) {
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+
 	s.lastBlockSeen = blockNum
 }
+
+// runOutput is the only log plugin of a run's upstream superviser, sending the run's output to
+// the superviser's log plugins.
+type runOutput struct {
+	superviser *Superviser
+
+	lock       sync.Mutex
+	lastLineAt time.Time
+	read       bool
+}
+
+func (o *runOutput) Name() string        { return "RunOutput" }
+func (o *runOutput) Launch()             {}
+func (o *runOutput) Stop()               {}
+func (o *runOutput) Shutdown(_ error)    {}
+func (o *runOutput) IsTerminating() bool { return false }
+
+func (o *runOutput) LogLine(in string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.superviser.processLogLine(in)
+	o.lastLineAt = time.Now()
+}
+
+// waitRead waits for the output of the run, whose process exited, to be fully read. The upstream
+// superviser keeps sending the lines buffered when the process exited for a moment and has no way
+// to tell when it's done, the output is considered fully read once it stayed quiet for
+// `outputReadQuietPeriod`.
+func (o *runOutput) waitRead() {
+	quietSince := time.Now()
+	for {
+		o.lock.Lock()
+		if o.read {
+			o.lock.Unlock()
+			return
+		}
+
+		if o.lastLineAt.After(quietSince) {
+			quietSince = o.lastLineAt
+		}
+
+		if time.Since(quietSince) >= outputReadQuietPeriod {
+			o.read = true
+			o.lock.Unlock()
+			return
+		}
+		o.lock.Unlock()
+
+		time.Sleep(outputReadQuietPeriod / 10)
+	}
+}
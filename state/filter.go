@@ -0,0 +1,80 @@
+package state
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/streamingfast/firehose-aptos/internal/aptosutil"
+)
+
+// Filter selects the resources tracked by a Store. Resources are matched when their address is one
+// of `Addresses` and their type matches one of `TypePatterns`, an empty list matching everything.
+//
+// Type patterns are fully qualified Move types where `*` matches any sequence of characters, like
+// `0x1::coin::CoinStore<*>` or `0x1::*`.
+type Filter struct {
+	Addresses    []string `json:"addresses,omitempty"`
+	TypePatterns []string `json:"type_patterns,omitempty"`
+}
+
+// Validate normalizes the filter addresses and type patterns and ensures patterns are well-formed.
+func (f *Filter) Validate() error {
+	for i, address := range f.Addresses {
+		f.Addresses[i] = aptosutil.NormalizeAddress(address)
+	}
+
+	for i, pattern := range f.TypePatterns {
+		pattern = aptosutil.NormalizeMoveID(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid type pattern %q: %w", pattern, err)
+		}
+
+		f.TypePatterns[i] = pattern
+	}
+
+	return nil
+}
+
+// Match returns whether the resource of type `typeStr` at `address` is selected by the filter, both
+// values are expected to be normalized.
+func (f *Filter) Match(address string, typeStr string) bool {
+	if len(f.Addresses) > 0 && !contains(f.Addresses, address) {
+		return false
+	}
+
+	if len(f.TypePatterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range f.TypePatterns {
+		if matched, _ := path.Match(pattern, typeStr); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *Filter) String() string {
+	if len(f.Addresses) == 0 && len(f.TypePatterns) == 0 {
+		return "all resources"
+	}
+
+	return fmt.Sprintf("addresses [%s] types [%s]", strings.Join(f.Addresses, ", "), strings.Join(f.TypePatterns, ", "))
+}
+
+func (f *Filter) equal(other *Filter) bool {
+	return strings.Join(f.Addresses, ",") == strings.Join(other.Addresses, ",") &&
+		strings.Join(f.TypePatterns, ",") == strings.Join(other.TypePatterns, ",")
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,444 @@
+// Package state reconstructs the on-chain state of Move resources by replaying the write sets
+// (`TransactionInfo.Changes`) of Aptos blocks in version order, giving the value of any tracked
+// resource at any version without requiring an archive node.
+//
+// Replayed changes are kept in an embedded bbolt database living in a single directory, keyed by
+// address, type and version so the value of a resource at a version is a single seek. Each applied
+// block is committed, so a replay interrupted at any point resumes from the last applied block, and
+// the database is periodically checkpointed to a snapshot that can be opened read-only while the
+// replay goes on.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/streamingfast/firehose-aptos/internal/aptosutil"
+	"github.com/streamingfast/firehose-aptos/internal/history"
+	"github.com/streamingfast/firehose-aptos/internal/replay"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/streamingfast/logging"
+	"go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+var zlog, _ = logging.PackageLogger("state", "github.com/streamingfast/firehose-aptos/state")
+
+const (
+	databaseFilename = "state.db"
+	snapshotFilename = "snapshot.db"
+)
+
+var (
+	metaBucket      = []byte("meta")
+	metaKey         = []byte("meta")
+	resourcesBucket = []byte("resources")
+)
+
+// ErrNotFound is returned when a resource did not exist at the requested version.
+var ErrNotFound = errors.New("resource not found")
+
+// ErrReadOnly is returned when modifying a store opened read-only.
+var ErrReadOnly = errors.New("state store is opened read-only")
+
+// ResourceValue is the value of a resource as written (or deleted) by the transaction at `Version`.
+type ResourceValue struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Version uint64 `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+
+	// Data is the JSON encoded content of the resource, empty when `Deleted` is true.
+	Data string `json:"data,omitempty"`
+}
+
+// Options controls how a Store is opened.
+type Options struct {
+	// Filter selects the resources tracked by the store, it must be the same every time an existing
+	// store is re-opened as previously ignored resources cannot be recovered. When nil, the filter
+	// of the existing store is used, a new store tracking all resources.
+	Filter *Filter
+
+	// SnapshotInterval is the amount of applied blocks after which the store automatically writes
+	// a snapshot, 0 meaning only when `Snapshot` or `Close` is called.
+	SnapshotInterval uint64
+
+	// ReadOnly opens the last snapshot of an existing store without ever writing to it, so it can be
+	// queried while another process replays blocks into the store. The store reflects the blocks
+	// applied when the snapshot was written.
+	ReadOnly bool
+}
+
+// Store is an embedded key-value store holding the full history of each tracked resource.
+type Store struct {
+	replay.Progress
+
+	dir              string
+	filter           *Filter
+	snapshotInterval uint64
+	readOnly         bool
+
+	db *history.DB
+
+	lastVersion uint64
+
+	blocksSinceSnapshot uint64
+}
+
+type meta struct {
+	Filter      Filter `json:"filter"`
+	HasBlock    bool   `json:"has_block"`
+	LastBlock   uint64 `json:"last_block"`
+	LastVersion uint64 `json:"last_version"`
+}
+
+// Open opens the store living in `dir`, creating it if it does not exist yet.
+func Open(dir string, options Options) (*Store, error) {
+	if options.Filter != nil {
+		if err := options.Filter.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	if options.ReadOnly {
+		return openReadOnly(dir, options)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create state directory: %w", err)
+	}
+
+	db, err := history.Open(filepath.Join(dir, databaseFilename), false)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:              dir,
+		filter:           options.Filter,
+		snapshotInterval: options.SnapshotInterval,
+		db:               db,
+	}
+
+	if err := s.init(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.logOpened()
+	return s, nil
+}
+
+func (s *Store) init() error {
+	found, err := s.loadMeta()
+	if err != nil {
+		return err
+	}
+
+	if s.filter == nil {
+		s.filter = &Filter{}
+	}
+
+	if !found {
+		// Recording the filter right away rejects a later re-open with a different filter
+		err := s.db.Update(func(tx *bbolt.Tx) error {
+			if _, err := tx.CreateBucketIfNotExists(resourcesBucket); err != nil {
+				return fmt.Errorf("create resources bucket: %w", err)
+			}
+
+			return s.putMeta(tx, meta{Filter: *s.filter})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Written right away so the store can be opened read-only before its first snapshot
+	if _, err := os.Stat(filepath.Join(s.dir, snapshotFilename)); os.IsNotExist(err) {
+		return s.Snapshot()
+	}
+
+	return nil
+}
+
+// openReadOnly opens the last snapshot of the store. The writer of the store replaces the snapshot
+// by renaming a new one over it, the opened one staying consistent.
+func openReadOnly(dir string, options Options) (*Store, error) {
+	snapshotPath := filepath.Join(dir, snapshotFilename)
+	if _, err := os.Stat(snapshotPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no state store found in %q", dir)
+		}
+
+		return nil, fmt.Errorf("stat snapshot: %w", err)
+	}
+
+	db, err := history.Open(snapshotPath, true)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:      dir,
+		filter:   options.Filter,
+		readOnly: true,
+		db:       db,
+	}
+
+	if _, err := s.loadMeta(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.logOpened()
+	return s, nil
+}
+
+func (s *Store) logOpened() {
+	lastBlock, hasBlock := s.LastBlock()
+	zlog.Info("opened state store",
+		zap.String("dir", s.dir),
+		zap.Bool("read_only", s.readOnly),
+		zap.Stringer("filter", s.filter),
+		zap.Bool("has_block", hasBlock),
+		zap.Uint64("last_block", lastBlock),
+	)
+}
+
+func (s *Store) loadMeta() (found bool, err error) {
+	var content []byte
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		if bucket := tx.Bucket(metaBucket); bucket != nil {
+			content = append(content, bucket.Get(metaKey)...)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if content == nil {
+		return false, nil
+	}
+
+	var m meta
+	if err := json.Unmarshal(content, &m); err != nil {
+		return false, fmt.Errorf("decode state metadata: %w", err)
+	}
+
+	if s.filter == nil {
+		s.filter = &m.Filter
+	} else if !m.Filter.equal(s.filter) {
+		return false, fmt.Errorf("state was replayed with filter %s, cannot be re-opened with filter %s", &m.Filter, s.filter)
+	}
+
+	s.Restore(m.LastBlock, m.HasBlock)
+	s.lastVersion = m.LastVersion
+	return true, nil
+}
+
+func (s *Store) putMeta(tx *bbolt.Tx, m meta) error {
+	content, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("encode state metadata: %w", err)
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return fmt.Errorf("create metadata bucket: %w", err)
+	}
+
+	return bucket.Put(metaKey, content)
+}
+
+// LastVersion returns the version of the last transaction of the last applied block.
+func (s *Store) LastVersion() uint64 {
+	return s.lastVersion
+}
+
+// Filter returns the filter selecting the resources tracked by the store.
+func (s *Store) Filter() Filter {
+	return *s.filter
+}
+
+// ApplyBlock applies the resource changes of all the transactions of the block that are selected by
+// the store's filter. Blocks must be applied in order, a block at or below the last applied block
+// is rejected. The changes are committed to disk before returning.
+func (s *Store) ApplyBlock(block *pbaptos.Block) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := s.Check(block.Height); err != nil {
+		return err
+	}
+
+	lastVersion := s.lastVersion
+	var changes []*ResourceValue
+	for _, trx := range block.Transactions {
+		lastVersion = trx.Version
+
+		for _, change := range trx.GetInfo().GetChanges() {
+			var value *ResourceValue
+			switch {
+			case change.GetWriteResource() != nil:
+				resource := change.GetWriteResource()
+				value = &ResourceValue{Address: resource.Address, Type: resource.TypeStr, Version: trx.Version, Data: resource.Data}
+			case change.GetDeleteResource() != nil:
+				resource := change.GetDeleteResource()
+				value = &ResourceValue{Address: resource.Address, Type: resource.TypeStr, Version: trx.Version, Deleted: true}
+			default:
+				continue
+			}
+
+			value.Address = aptosutil.NormalizeAddress(value.Address)
+			value.Type = aptosutil.NormalizeMoveID(value.Type)
+			if s.filter.Match(value.Address, value.Type) {
+				changes = append(changes, value)
+			}
+		}
+	}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		resources := tx.Bucket(resourcesBucket)
+		for _, value := range changes {
+			content, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("encode resource value: %w", err)
+			}
+
+			// Only the last change of a transaction to a given resource is its value at that version
+			if err := history.Put(resources, resourceKey(value.Address, value.Type), value.Version, content); err != nil {
+				return fmt.Errorf("write resource value: %w", err)
+			}
+		}
+
+		return s.putMeta(tx, meta{Filter: *s.filter, HasBlock: true, LastBlock: block.Height, LastVersion: lastVersion})
+	})
+
+	// Blocks without changes are committed along with the next block having some, re-applying them on resume being harmless
+	if err == nil && len(changes) > 0 {
+		err = s.db.Commit()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.Applied(block.Height)
+	s.lastVersion = lastVersion
+	s.blocksSinceSnapshot++
+	if s.snapshotInterval > 0 && s.blocksSinceSnapshot >= s.snapshotInterval {
+		return s.Snapshot()
+	}
+
+	return nil
+}
+
+// Get returns the value of the resource of type `typeStr` at `address` as of `version`, that is
+// the value written by the last change at or before `version`. ErrNotFound is returned if the
+// resource was never written at or before that version, a deleted resource is returned with
+// `Deleted` set.
+func (s *Store) Get(address string, typeStr string, version uint64) (*ResourceValue, error) {
+	key := resourceKey(aptosutil.NormalizeAddress(address), aptosutil.NormalizeMoveID(typeStr))
+
+	var value *ResourceValue
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		content, found := history.Get(tx.Bucket(resourcesBucket), key, version)
+		if !found {
+			return ErrNotFound
+		}
+
+		value = &ResourceValue{}
+		if err := json.Unmarshal(content, value); err != nil {
+			return fmt.Errorf("decode resource value: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// History returns all the changes of the resource of type `typeStr` at `address`, in version order.
+func (s *Store) History(address string, typeStr string) (out []*ResourceValue, err error) {
+	key := resourceKey(aptosutil.NormalizeAddress(address), aptosutil.NormalizeMoveID(typeStr))
+
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		return history.ForEach(tx.Bucket(resourcesBucket), key, func(_ uint64, content []byte) error {
+			value := &ResourceValue{}
+			if err := json.Unmarshal(content, value); err != nil {
+				return fmt.Errorf("decode resource value: %w", err)
+			}
+
+			out = append(out, value)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Resources returns the type of all the resources of `address` for which at least one change was applied.
+func (s *Store) Resources(address string) (out []string, err error) {
+	prefix := resourceKey(aptosutil.NormalizeAddress(address), "")
+
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		return history.ForEachKey(tx.Bucket(resourcesBucket), prefix, func(key string) error {
+			out = append(out, key[len(prefix):])
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Snapshot commits the applied blocks and writes a copy of the store to the snapshot file opened by
+// read-only stores. The copy is written to a temporary file then renamed so a crash leaves either
+// the previous or the new snapshot.
+func (s *Store) Snapshot() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := s.db.Checkpoint(filepath.Join(s.dir, snapshotFilename)); err != nil {
+		return fmt.Errorf("snapshot state: %w", err)
+	}
+
+	lastBlock, _ := s.LastBlock()
+	zlog.Info("wrote state snapshot", zap.Uint64("last_block", lastBlock), zap.Uint64("last_version", s.lastVersion))
+
+	s.blocksSinceSnapshot = 0
+	return nil
+}
+
+// Close snapshots the store if blocks were applied since the last snapshot and releases its resources.
+func (s *Store) Close() error {
+	var err error
+	if !s.readOnly && s.blocksSinceSnapshot > 0 {
+		err = s.Snapshot()
+	}
+
+	if closeErr := s.db.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func resourceKey(address string, typeStr string) string {
+	return address + "/" + typeStr
+}
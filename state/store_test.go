@@ -0,0 +1,236 @@
+package state
+
+import (
+	"testing"
+
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const coinStore = "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>"
+
+func TestStore_Get(t *testing.T) {
+	store, err := Open(t.TempDir(), Options{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	applyTestBlocks(t, store)
+
+	tests := []struct {
+		name            string
+		address         string
+		typeStr         string
+		version         uint64
+		expectedVersion uint64
+		expectedData    string
+		expectedDeleted bool
+		expectedErr     error
+	}{
+		{"before first write", "0xa1", coinStore, 9, 0, "", false, ErrNotFound},
+		{"at first write", "0xa1", coinStore, 10, 10, `{"coin":{"value":"100"}}`, false, nil},
+		{"between writes", "0xa1", coinStore, 19, 10, `{"coin":{"value":"100"}}`, false, nil},
+		{"last write of block", "0xa1", coinStore, 21, 21, `{"coin":{"value":"50"}}`, false, nil},
+		{"deleted", "0xa1", coinStore, 30, 30, "", true, nil},
+		{"written again after delete", "0xa1", coinStore, 1000, 40, `{"coin":{"value":"1"}}`, false, nil},
+		{"non normalized address", "0x00A1", coinStore, 10, 10, `{"coin":{"value":"100"}}`, false, nil},
+		{"other address", "0xb2", coinStore, 1000, 20, `{"coin":{"value":"7"}}`, false, nil},
+		{"unknown resource", "0xa1", "0x1::account::Account", 1000, 0, "", false, ErrNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := store.Get(test.address, test.typeStr, test.version)
+			if test.expectedErr != nil {
+				assert.Equal(t, test.expectedErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedVersion, value.Version)
+			assert.Equal(t, test.expectedData, value.Data)
+			assert.Equal(t, test.expectedDeleted, value.Deleted)
+		})
+	}
+
+	lastBlock, ok := store.LastBlock()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(4), lastBlock)
+	assert.Equal(t, uint64(40), store.LastVersion())
+	assertHistoryLen(t, store, "0xa1", 4)
+
+	resources, err := store.Resources("0xb2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{coinStore}, resources)
+}
+
+func TestStore_Filter(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected map[string]int
+	}{
+		{"everything", Filter{}, map[string]int{"0xa1": 4, "0xb2": 1}},
+		{"address", Filter{Addresses: []string{"0x0B2"}}, map[string]int{"0xa1": 0, "0xb2": 1}},
+		{"type pattern", Filter{TypePatterns: []string{"0x01::coin::CoinStore<*>"}}, map[string]int{"0xa1": 4, "0xb2": 1}},
+		{"non matching type pattern", Filter{TypePatterns: []string{"0x1::account::*"}}, map[string]int{"0xa1": 0, "0xb2": 0}},
+		{"address and type pattern", Filter{Addresses: []string{"0xa1"}, TypePatterns: []string{"*CoinStore*"}}, map[string]int{"0xa1": 4, "0xb2": 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := Open(t.TempDir(), Options{Filter: &test.filter})
+			require.NoError(t, err)
+			defer store.Close()
+
+			applyTestBlocks(t, store)
+
+			for address, expectedCount := range test.expected {
+				assertHistoryLen(t, store, address, expectedCount)
+			}
+		})
+	}
+}
+
+func TestStore_Resume(t *testing.T) {
+	dir := t.TempDir()
+	blocks := testBlocks()
+
+	store, err := Open(dir, Options{SnapshotInterval: 2})
+	require.NoError(t, err)
+
+	// Two blocks are snapshotted, the third one is only committed
+	for _, block := range blocks[:3] {
+		require.NoError(t, store.ApplyBlock(block))
+	}
+
+	// Simulates a crash, the database being released without snapshotting the store
+	require.NoError(t, store.db.Close())
+
+	snapshot, err := Open(dir, Options{ReadOnly: true})
+	require.NoError(t, err)
+	lastBlock, _ := snapshot.LastBlock()
+	assert.Equal(t, uint64(2), lastBlock)
+	require.NoError(t, snapshot.Close())
+
+	reopened, err := Open(dir, Options{SnapshotInterval: 2})
+	require.NoError(t, err)
+
+	lastBlock, _ = reopened.LastBlock()
+	assert.Equal(t, uint64(3), lastBlock)
+	assert.Error(t, reopened.ApplyBlock(blocks[2]))
+
+	require.NoError(t, reopened.ApplyBlock(blocks[3]))
+	require.NoError(t, reopened.Close())
+
+	reopened, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	value, err := reopened.Get("0xa1", coinStore, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(40), value.Version)
+	assertHistoryLen(t, reopened, "0xa1", 4)
+}
+
+func TestStore_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	blocks := testBlocks()
+
+	_, err := Open(dir, Options{ReadOnly: true})
+	assert.ErrorContains(t, err, "no state store found")
+
+	store, err := Open(dir, Options{SnapshotInterval: 2})
+	require.NoError(t, err)
+	defer store.Close()
+
+	for _, block := range blocks[:3] {
+		require.NoError(t, store.ApplyBlock(block))
+	}
+
+	// The last snapshot is opened while the store is being written, the third block is not part of it
+	readOnly, err := Open(dir, Options{ReadOnly: true})
+	require.NoError(t, err)
+
+	lastBlock, _ := readOnly.LastBlock()
+	assert.Equal(t, uint64(2), lastBlock)
+	assertHistoryLen(t, readOnly, "0xa1", 2)
+
+	assert.Equal(t, ErrReadOnly, readOnly.ApplyBlock(blocks[3]))
+	assert.Equal(t, ErrReadOnly, readOnly.Snapshot())
+
+	// A new snapshot replaces the opened one, which stays unchanged
+	require.NoError(t, store.ApplyBlock(blocks[3]))
+	assertHistoryLen(t, readOnly, "0xa1", 2)
+	require.NoError(t, readOnly.Close())
+
+	readOnly, err = Open(dir, Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer readOnly.Close()
+
+	lastBlock, _ = readOnly.LastBlock()
+	assert.Equal(t, uint64(4), lastBlock)
+	assertHistoryLen(t, readOnly, "0xa1", 4)
+}
+
+func TestStore_FilterMismatch(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := Open(dir, Options{Filter: &Filter{Addresses: []string{"0xa1"}}})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	_, err = Open(dir, Options{Filter: &Filter{Addresses: []string{"0xb2"}}})
+	assert.EqualError(t, err, "state was replayed with filter addresses [0xa1] types [], cannot be re-opened with filter addresses [0xb2] types []")
+
+	// Without a filter, the one of the existing store is used
+	store, err = Open(dir, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0xa1"}, store.Filter().Addresses)
+	require.NoError(t, store.Close())
+}
+
+func assertHistoryLen(t *testing.T, store *Store, address string, expected int) {
+	t.Helper()
+
+	history, err := store.History(address, coinStore)
+	require.NoError(t, err)
+	assert.Len(t, history, expected, address)
+}
+
+func applyTestBlocks(t *testing.T, store *Store) {
+	t.Helper()
+
+	for _, block := range testBlocks() {
+		require.NoError(t, store.ApplyBlock(block))
+	}
+}
+
+// testBlocks returns blocks writing the coin store of `0xa1` at versions 10, 21 (twice in the same block),
+// deleting it at version 30 and writing it again at version 40. The coin store of `0xb2` is written at version 20.
+func testBlocks() []*pbaptos.Block {
+	return []*pbaptos.Block{
+		{Height: 1, Transactions: []*pbaptos.Transaction{
+			newTestTransaction(10, writeResource("0xa1", coinStore, `{"coin":{"value":"100"}}`)),
+		}},
+		{Height: 2, Transactions: []*pbaptos.Transaction{
+			newTestTransaction(20, writeResource("0xb2", coinStore, `{"coin":{"value":"7"}}`)),
+			newTestTransaction(21, writeResource("0xa1", coinStore, `{"coin":{"value":"60"}}`), writeResource("0xa1", coinStore, `{"coin":{"value":"50"}}`)),
+		}},
+		{Height: 3, Transactions: []*pbaptos.Transaction{
+			newTestTransaction(30, &pbaptos.WriteSetChange{Change: &pbaptos.WriteSetChange_DeleteResource{DeleteResource: &pbaptos.DeleteResource{Address: "0xa1", TypeStr: coinStore}}}),
+		}},
+		{Height: 4, Transactions: []*pbaptos.Transaction{
+			newTestTransaction(39),
+			newTestTransaction(40, writeResource("0x00a1", coinStore, `{"coin":{"value":"1"}}`)),
+		}},
+	}
+}
+
+func newTestTransaction(version uint64, changes ...*pbaptos.WriteSetChange) *pbaptos.Transaction {
+	return &pbaptos.Transaction{Version: version, Info: &pbaptos.TransactionInfo{Changes: changes}}
+}
+
+func writeResource(address, typeStr, data string) *pbaptos.WriteSetChange {
+	return &pbaptos.WriteSetChange{Change: &pbaptos.WriteSetChange_WriteResource{WriteResource: &pbaptos.WriteResource{Address: address, TypeStr: typeStr, Data: data}}}
+}
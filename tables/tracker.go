@@ -0,0 +1,582 @@
+// Package tables tracks the content of Move tables by replaying the `WriteTableItem` and
+// `DeleteTableItem` changes of Aptos blocks, and attributes each opaque table handle to the
+// resource and field holding it, found by scanning the data of written resources.
+//
+// The tracker is an embedded bbolt database living in a single directory, the items being keyed by
+// handle, raw key and version so the value of an item at a version is a single seek.
+package tables
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/streamingfast/firehose-aptos/internal/aptosutil"
+	"github.com/streamingfast/firehose-aptos/internal/history"
+	"github.com/streamingfast/firehose-aptos/internal/replay"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/streamingfast/logging"
+	"go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+var zlog, _ = logging.PackageLogger("tables", "github.com/streamingfast/firehose-aptos/tables")
+
+const trackerFilename = "tables.db"
+
+var (
+	metaBucket        = []byte("meta")
+	metaKey           = []byte("meta")
+	ownersBucket      = []byte("owners")
+	tablesBucket      = []byte("tables")
+	itemsBucket       = []byte("items")
+	decodedKeysBucket = []byte("decoded_keys")
+)
+
+// ErrNotFound is returned when a table item did not exist at the requested version.
+var ErrNotFound = errors.New("table item not found")
+
+// Owner is the resource field holding a table handle, as first seen in a written resource.
+type Owner struct {
+	Address      string `json:"address"`
+	ResourceType string `json:"resource_type"`
+
+	// Field is the dot separated path of the field holding the handle in the resource data, like
+	// `collection_data` or `tokens.inner` for a table wrapped in a `TableWithLength`.
+	Field string `json:"field"`
+
+	// Version is the version of the transaction in which the handle was first seen.
+	Version uint64 `json:"version"`
+}
+
+func (o *Owner) String() string {
+	return fmt.Sprintf("%s %s.%s", o.Address, o.ResourceType, o.Field)
+}
+
+// ItemValue is the value of a table item as written (or deleted) by the transaction at `Version`.
+type ItemValue struct {
+	Version uint64 `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+
+	// Key is the hex encoded raw key of the item and DecodedKey its JSON decoded form when known.
+	Key        string `json:"key"`
+	DecodedKey string `json:"decoded_key,omitempty"`
+	KeyType    string `json:"key_type,omitempty"`
+
+	// Value is the JSON decoded value of the item, empty when `Deleted` is true.
+	Value     string `json:"value,omitempty"`
+	ValueType string `json:"value_type,omitempty"`
+}
+
+// Table is the summary of a tracked table.
+type Table struct {
+	Handle    string `json:"handle"`
+	Owner     *Owner `json:"owner,omitempty"`
+	KeyType   string `json:"key_type,omitempty"`
+	ValueType string `json:"value_type,omitempty"`
+
+	// ItemCount is the amount of distinct keys ever written in the table, including deleted ones.
+	ItemCount int `json:"item_count"`
+}
+
+// Options controls how a Tracker is opened.
+type Options struct {
+	// Handles restricts the tables whose items are tracked, an empty list tracking all of them. The
+	// owner of every handle is always tracked.
+	Handles []string
+
+	// SaveInterval is the amount of applied blocks after which the applied changes are committed to
+	// disk, 0 meaning only when `Save` or `Close` is called.
+	SaveInterval uint64
+}
+
+// Tracker holds the owner of each seen table handle and the full history of the items of each tracked
+// table. It's opened by a single process at a time.
+type Tracker struct {
+	replay.Progress
+
+	dir          string
+	handles      map[string]bool
+	saveInterval uint64
+
+	db *history.DB
+
+	blocksSinceSave uint64
+}
+
+type meta struct {
+	Handles   []string `json:"handles,omitempty"`
+	HasBlock  bool     `json:"has_block"`
+	LastBlock uint64   `json:"last_block"`
+}
+
+// Open opens the tracker living in `dir`, creating an empty one if it does not exist yet. The handles
+// restriction of an existing tracker cannot be changed.
+func Open(dir string, options Options) (*Tracker, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create tracker directory: %w", err)
+	}
+
+	db, err := history.Open(filepath.Join(dir, trackerFilename), false)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tracker{
+		dir:          dir,
+		handles:      map[string]bool{},
+		saveInterval: options.SaveInterval,
+		db:           db,
+	}
+
+	for _, handle := range options.Handles {
+		t.handles[aptosutil.NormalizeAddress(handle)] = true
+	}
+
+	if err := t.init(len(options.Handles) > 0); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *Tracker) init(checkHandles bool) error {
+	var content []byte
+	err := t.db.View(func(tx *bbolt.Tx) error {
+		if bucket := tx.Bucket(metaBucket); bucket != nil {
+			content = append(content, bucket.Get(metaKey)...)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if content == nil {
+		return t.db.Update(func(tx *bbolt.Tx) error {
+			for _, name := range [][]byte{ownersBucket, tablesBucket, itemsBucket, decodedKeysBucket} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return fmt.Errorf("create %s bucket: %w", name, err)
+				}
+			}
+
+			return t.putMeta(tx, 0, false)
+		})
+	}
+
+	var m meta
+	if err := json.Unmarshal(content, &m); err != nil {
+		return fmt.Errorf("decode tracker metadata: %w", err)
+	}
+
+	if checkHandles && strings.Join(m.Handles, ",") != strings.Join(t.handleList(), ",") {
+		return fmt.Errorf("tables were tracked for handles [%s], cannot be re-opened for handles [%s]", strings.Join(m.Handles, ", "), strings.Join(t.handleList(), ", "))
+	}
+
+	t.handles = map[string]bool{}
+	for _, handle := range m.Handles {
+		t.handles[handle] = true
+	}
+
+	t.Restore(m.LastBlock, m.HasBlock)
+	return nil
+}
+
+func (t *Tracker) putMeta(tx *bbolt.Tx, lastBlock uint64, hasBlock bool) error {
+	content, err := json.Marshal(meta{Handles: t.handleList(), HasBlock: hasBlock, LastBlock: lastBlock})
+	if err != nil {
+		return fmt.Errorf("encode tracker metadata: %w", err)
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return fmt.Errorf("create metadata bucket: %w", err)
+	}
+
+	return bucket.Put(metaKey, content)
+}
+
+func (t *Tracker) handleList() (out []string) {
+	for handle := range t.handles {
+		out = append(out, handle)
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// Save commits the changes applied since the last save, syncing them to disk. A crash loses the
+// changes applied after the last save.
+func (t *Tracker) Save() error {
+	if err := t.db.Commit(); err != nil {
+		return fmt.Errorf("save tracker: %w", err)
+	}
+
+	lastBlock, _ := t.LastBlock()
+	zlog.Debug("saved table tracker", zap.Uint64("last_block", lastBlock))
+
+	t.blocksSinceSave = 0
+	return nil
+}
+
+// Close saves the tracker if blocks were applied since it was last saved and releases its resources.
+func (t *Tracker) Close() error {
+	var err error
+	if t.blocksSinceSave > 0 {
+		err = t.Save()
+	}
+
+	if closeErr := t.db.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// ApplyBlock records the owner of the table handles found in written resources and applies the
+// table item changes of all the transactions of the block. Blocks must be applied in order, a block
+// at or below the last applied block is rejected.
+func (t *Tracker) ApplyBlock(block *pbaptos.Block) error {
+	if err := t.Check(block.Height); err != nil {
+		return err
+	}
+
+	err := t.db.Update(func(tx *bbolt.Tx) error {
+		for _, trx := range block.Transactions {
+			for _, change := range trx.GetInfo().GetChanges() {
+				var err error
+				switch {
+				case change.GetWriteResource() != nil:
+					err = t.discoverOwners(tx, trx.Version, change.GetWriteResource())
+
+				case change.GetWriteTableItem() != nil:
+					item := change.GetWriteTableItem()
+					err = t.applyItem(tx, item.Handle, &ItemValue{
+						Version:    trx.Version,
+						Key:        item.Key,
+						DecodedKey: item.GetData().GetKey(),
+						KeyType:    item.GetData().GetKeyType(),
+						Value:      item.GetData().GetValue(),
+						ValueType:  item.GetData().GetValueType(),
+					})
+
+				case change.GetDeleteTableItem() != nil:
+					item := change.GetDeleteTableItem()
+					err = t.applyItem(tx, item.Handle, &ItemValue{
+						Version:    trx.Version,
+						Deleted:    true,
+						Key:        item.Key,
+						DecodedKey: item.GetData().GetKey(),
+						KeyType:    item.GetData().GetKeyType(),
+					})
+				}
+
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return t.putMeta(tx, block.Height, true)
+	})
+	if err != nil {
+		return err
+	}
+
+	t.Applied(block.Height)
+	t.blocksSinceSave++
+	if t.saveInterval > 0 && t.blocksSinceSave >= t.saveInterval {
+		return t.Save()
+	}
+
+	return nil
+}
+
+// discoverOwners records the resource field holding each table handle found in the resource data,
+// tables being serialized as an object with a single `handle` field.
+func (t *Tracker) discoverOwners(tx *bbolt.Tx, version uint64, resource *pbaptos.WriteResource) error {
+	if resource.Data == "" {
+		return nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal([]byte(resource.Data), &data); err != nil {
+		zlog.Debug("skipping resource with invalid data", zap.Uint64("version", version), zap.String("type", resource.TypeStr), zap.Error(err))
+		return nil
+	}
+
+	owners := tx.Bucket(ownersBucket)
+
+	var err error
+	walkHandles(data, "", func(handle string, field string) {
+		handle = aptosutil.NormalizeAddress(handle)
+		if err != nil || owners.Get([]byte(handle)) != nil {
+			return
+		}
+
+		var content []byte
+		content, err = json.Marshal(&Owner{
+			Address:      aptosutil.NormalizeAddress(resource.Address),
+			ResourceType: resource.TypeStr,
+			Field:        field,
+			Version:      version,
+		})
+		if err == nil {
+			err = owners.Put([]byte(handle), content)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("write table owner: %w", err)
+	}
+
+	return nil
+}
+
+func walkHandles(value interface{}, path string, onHandle func(handle string, field string)) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if handle, ok := v["handle"].(string); ok && len(v) == 1 {
+			onHandle(handle, path)
+			return
+		}
+
+		for key, child := range v {
+			walkHandles(child, joinPath(path, key), onHandle)
+		}
+
+	case []interface{}:
+		for i, child := range v {
+			walkHandles(child, joinPath(path, fmt.Sprint(i)), onHandle)
+		}
+	}
+}
+
+func joinPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+
+	return path + "." + field
+}
+
+func (t *Tracker) applyItem(tx *bbolt.Tx, handle string, value *ItemValue) error {
+	handle = aptosutil.NormalizeAddress(handle)
+	if len(t.handles) > 0 && !t.handles[handle] {
+		return nil
+	}
+
+	items := tx.Bucket(itemsBucket)
+	key := itemKey(handle, value.Key)
+	_, existed := history.Get(items, key, math.MaxUint64)
+
+	content, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encode item value: %w", err)
+	}
+
+	// Only the last change of a transaction to a given item is its value at that version
+	if err := history.Put(items, key, value.Version, content); err != nil {
+		return fmt.Errorf("write item value: %w", err)
+	}
+
+	if value.DecodedKey != "" {
+		if err := tx.Bucket(decodedKeysBucket).Put([]byte(itemKey(handle, value.DecodedKey)), []byte(value.Key)); err != nil {
+			return fmt.Errorf("write decoded key: %w", err)
+		}
+	}
+
+	table, err := getTable(tx, handle)
+	if err != nil {
+		return err
+	}
+
+	if table == nil {
+		table = &Table{Handle: handle}
+	}
+
+	if !existed {
+		table.ItemCount++
+	}
+	if value.KeyType != "" {
+		table.KeyType = value.KeyType
+	}
+	if value.ValueType != "" {
+		table.ValueType = value.ValueType
+	}
+
+	if content, err = json.Marshal(table); err != nil {
+		return fmt.Errorf("encode table: %w", err)
+	}
+
+	return tx.Bucket(tablesBucket).Put([]byte(handle), content)
+}
+
+// Owner returns the resource field holding the table handle, nil if the handle was never seen in a
+// written resource.
+func (t *Tracker) Owner(handle string) (owner *Owner, err error) {
+	err = t.db.View(func(tx *bbolt.Tx) error {
+		owner, err = getOwner(tx, aptosutil.NormalizeAddress(handle))
+		return err
+	})
+
+	return owner, err
+}
+
+// Tables returns the summary of all the tables with tracked items, sorted by handle.
+func (t *Tracker) Tables() (out []*Table, err error) {
+	err = t.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(tablesBucket).ForEach(func(handle, content []byte) error {
+			table := &Table{}
+			if err := json.Unmarshal(content, table); err != nil {
+				return fmt.Errorf("decode table %s: %w", handle, err)
+			}
+
+			owner, err := getOwner(tx, table.Handle)
+			if err != nil {
+				return err
+			}
+
+			table.Owner = owner
+			out = append(out, table)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// History returns all the changes of the item of the table, in version order. The key is either the
+// hex encoded raw key or its JSON decoded form.
+func (t *Tracker) History(handle string, key string) (out []*ItemValue, err error) {
+	handle = aptosutil.NormalizeAddress(handle)
+
+	err = t.db.View(func(tx *bbolt.Tx) error {
+		return history.ForEach(tx.Bucket(itemsBucket), itemKey(handle, rawKey(tx, handle, key)), func(_ uint64, content []byte) error {
+			value, err := decodeItemValue(content)
+			if err != nil {
+				return err
+			}
+
+			out = append(out, value)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Get returns the value of the item of the table as of `version`, that is the value written by the
+// last change at or before `version`. ErrNotFound is returned if the item was never written at or
+// before that version, a deleted item is returned with `Deleted` set. The key is either the hex
+// encoded raw key or its JSON decoded form.
+func (t *Tracker) Get(handle string, key string, version uint64) (value *ItemValue, err error) {
+	handle = aptosutil.NormalizeAddress(handle)
+
+	err = t.db.View(func(tx *bbolt.Tx) error {
+		content, found := history.Get(tx.Bucket(itemsBucket), itemKey(handle, rawKey(tx, handle, key)), version)
+		if !found {
+			return ErrNotFound
+		}
+
+		value, err = decodeItemValue(content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Content returns the items live in the table at `version`, sorted by raw key.
+func (t *Tracker) Content(handle string, version uint64) (out []*ItemValue, err error) {
+	handle = aptosutil.NormalizeAddress(handle)
+
+	err = t.db.View(func(tx *bbolt.Tx) error {
+		items := tx.Bucket(itemsBucket)
+		return history.ForEachKey(items, itemKey(handle, ""), func(key string) error {
+			content, found := history.Get(items, key, version)
+			if !found {
+				return nil
+			}
+
+			value, err := decodeItemValue(content)
+			if err != nil {
+				return err
+			}
+
+			if !value.Deleted {
+				out = append(out, value)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func getOwner(tx *bbolt.Tx, handle string) (*Owner, error) {
+	content := tx.Bucket(ownersBucket).Get([]byte(handle))
+	if content == nil {
+		return nil, nil
+	}
+
+	owner := &Owner{}
+	if err := json.Unmarshal(content, owner); err != nil {
+		return nil, fmt.Errorf("decode owner of table %s: %w", handle, err)
+	}
+
+	return owner, nil
+}
+
+func getTable(tx *bbolt.Tx, handle string) (*Table, error) {
+	content := tx.Bucket(tablesBucket).Get([]byte(handle))
+	if content == nil {
+		return nil, nil
+	}
+
+	table := &Table{}
+	if err := json.Unmarshal(content, table); err != nil {
+		return nil, fmt.Errorf("decode table %s: %w", handle, err)
+	}
+
+	return table, nil
+}
+
+func decodeItemValue(content []byte) (*ItemValue, error) {
+	value := &ItemValue{}
+	if err := json.Unmarshal(content, value); err != nil {
+		return nil, fmt.Errorf("decode item value: %w", err)
+	}
+
+	return value, nil
+}
+
+func rawKey(tx *bbolt.Tx, handle string, key string) string {
+	if raw := tx.Bucket(decodedKeysBucket).Get([]byte(itemKey(handle, key))); raw != nil {
+		return string(raw)
+	}
+
+	return key
+}
+
+func itemKey(handle string, key string) string {
+	return handle + "/" + key
+}
@@ -0,0 +1,219 @@
+package tables
+
+import (
+	"testing"
+
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	collectionsType = "0x3::token::Collections"
+	tokenStoreType  = "0x3::token::TokenStore"
+)
+
+func TestTracker_Owner(t *testing.T) {
+	tracker := newTestTracker(t, t.TempDir(), Options{})
+
+	owner, err := tracker.Owner("0x00ABC")
+	require.NoError(t, err)
+	assert.Equal(t, &Owner{Address: "0xa1", ResourceType: collectionsType, Field: "collection_data", Version: 10}, owner)
+
+	owner, err = tracker.Owner("0xdef")
+	require.NoError(t, err)
+	assert.Equal(t, &Owner{Address: "0xa1", ResourceType: tokenStoreType, Field: "tokens.inner", Version: 11}, owner)
+
+	owner, err = tracker.Owner("0x123")
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+}
+
+func TestTracker_Get(t *testing.T) {
+	tracker := newTestTracker(t, t.TempDir(), Options{})
+
+	tests := []struct {
+		name            string
+		key             string
+		version         uint64
+		expectedVersion uint64
+		expectedValue   string
+		expectedDeleted bool
+		expectedErr     error
+	}{
+		{"before first write", "0x01", 19, 0, "", false, ErrNotFound},
+		{"at first write", "0x01", 20, 20, `{"supply":"1"}`, false, nil},
+		{"by decoded key", `"Punks"`, 25, 20, `{"supply":"1"}`, false, nil},
+		{"updated", "0x01", 30, 30, `{"supply":"2"}`, false, nil},
+		{"deleted", `"Punks"`, 40, 40, "", true, nil},
+		{"unknown key", "0x99", 40, 0, "", false, ErrNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := tracker.Get("0xabc", test.key, test.version)
+			if test.expectedErr != nil {
+				assert.Equal(t, test.expectedErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedVersion, value.Version)
+			assert.Equal(t, test.expectedValue, value.Value)
+			assert.Equal(t, test.expectedDeleted, value.Deleted)
+		})
+	}
+}
+
+func TestTracker_Content(t *testing.T) {
+	tracker := newTestTracker(t, t.TempDir(), Options{})
+
+	assert.Empty(t, contentKeys(t, tracker, "0xabc", 19))
+	assert.Equal(t, []string{"0x01", "0x02"}, contentKeys(t, tracker, "0xabc", 30))
+	assert.Equal(t, []string{"0x02"}, contentKeys(t, tracker, "0xabc", 40))
+
+	assert.Equal(t, []*Table{
+		{Handle: "0xabc", Owner: &Owner{Address: "0xa1", ResourceType: collectionsType, Field: "collection_data", Version: 10}, KeyType: "0x1::string::String", ValueType: "0x3::token::CollectionData", ItemCount: 2},
+		{Handle: "0xdef", Owner: &Owner{Address: "0xa1", ResourceType: tokenStoreType, Field: "tokens.inner", Version: 11}, KeyType: "0x3::token::TokenId", ValueType: "0x3::token::Token", ItemCount: 1},
+	}, trackerTables(t, tracker))
+}
+
+func TestTracker_Handles(t *testing.T) {
+	dir := t.TempDir()
+	tracker := newTestTracker(t, dir, Options{Handles: []string{"0xDEF"}})
+
+	tables := trackerTables(t, tracker)
+	assert.Len(t, tables, 1)
+	assert.Empty(t, contentKeys(t, tracker, "0xabc", 40))
+
+	// Owners of all handles are tracked regardless of the restriction
+	owner, err := tracker.Owner("0xabc")
+	require.NoError(t, err)
+	assert.NotNil(t, owner)
+	require.NoError(t, tracker.Close())
+
+	_, err = Open(dir, Options{Handles: []string{"0xabc"}})
+	assert.EqualError(t, err, "tables were tracked for handles [0xdef], cannot be re-opened for handles [0xabc]")
+
+	reopened, err := Open(dir, Options{})
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	lastBlock, ok := reopened.LastBlock()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(4), lastBlock)
+	assert.Equal(t, tables, trackerTables(t, reopened))
+
+	history, err := reopened.History("0xdef", `{"name":"Punk #1"}`)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Error(t, reopened.ApplyBlock(&pbaptos.Block{Height: 4}))
+}
+
+func TestTracker_SaveInterval(t *testing.T) {
+	dir := t.TempDir()
+
+	// Saved after the third block, the fourth one is only applied
+	tracker := newTestTracker(t, dir, Options{SaveInterval: 3})
+
+	// Simulates a crash, the changes applied since the last save being lost
+	tracker.db.Rollback()
+	require.NoError(t, tracker.db.Close())
+
+	saved, err := Open(dir, Options{})
+	require.NoError(t, err)
+	lastBlock, _ := saved.LastBlock()
+	assert.Equal(t, uint64(3), lastBlock)
+	assert.Equal(t, []string{"0x01", "0x02"}, contentKeys(t, saved, "0xabc", 40))
+	require.NoError(t, saved.Close())
+
+	// The fourth block is saved on close
+	dir = t.TempDir()
+	tracker = newTestTracker(t, dir, Options{SaveInterval: 3})
+	tables := trackerTables(t, tracker)
+	require.NoError(t, tracker.Close())
+
+	saved, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer saved.Close()
+
+	lastBlock, _ = saved.LastBlock()
+	assert.Equal(t, uint64(4), lastBlock)
+	assert.Equal(t, tables, trackerTables(t, saved))
+}
+
+// newTestTracker returns a tracker where account `0xa1` holds the collections table `0xabc` and the
+// tokens table `0xdef`. In table `0xabc`, key `0x01` ("Punks") is written at versions 20 and 30 then
+// deleted at version 40, key `0x02` ("Apes") is written at version 21.
+func newTestTracker(t *testing.T, dir string, options Options) *Tracker {
+	t.Helper()
+
+	tracker, err := Open(dir, options)
+	require.NoError(t, err)
+
+	blocks := []*pbaptos.Block{
+		{Height: 1, Transactions: []*pbaptos.Transaction{
+			newTestTransaction(10, writeResource("0xa1", collectionsType, `{"collection_data":{"handle":"0xabc"},"create_collection_events":{"counter":"0","guid":{"id":{"addr":"0xa1","creation_num":"3"}}}}`)),
+			newTestTransaction(11, writeResource("0x00a1", tokenStoreType, `{"direct_transfer":false,"tokens":{"inner":{"handle":"0xdef"},"length":"0"}}`)),
+		}},
+		{Height: 2, Transactions: []*pbaptos.Transaction{
+			newTestTransaction(20, writeTableItem("0xabc", "0x01", `"Punks"`, "0x1::string::String", `{"supply":"1"}`, "0x3::token::CollectionData")),
+			newTestTransaction(21, writeTableItem("0xabc", "0x02", `"Apes"`, "0x1::string::String", `{"supply":"5"}`, "0x3::token::CollectionData")),
+		}},
+		{Height: 3, Transactions: []*pbaptos.Transaction{
+			newTestTransaction(30,
+				writeTableItem("0xabc", "0x01", `"Punks"`, "0x1::string::String", `{"supply":"2"}`, "0x3::token::CollectionData"),
+				writeTableItem("0xdef", "0x03", `{"name":"Punk #1"}`, "0x3::token::TokenId", `{"amount":"1"}`, "0x3::token::Token"),
+			),
+		}},
+		{Height: 4, Transactions: []*pbaptos.Transaction{
+			newTestTransaction(40, &pbaptos.WriteSetChange{Change: &pbaptos.WriteSetChange_DeleteTableItem{DeleteTableItem: &pbaptos.DeleteTableItem{
+				Handle: "0xabc", Key: "0x01", Data: &pbaptos.DeleteTableData{Key: `"Punks"`, KeyType: "0x1::string::String"},
+			}}}),
+		}},
+	}
+
+	for _, block := range blocks {
+		require.NoError(t, tracker.ApplyBlock(block))
+	}
+
+	return tracker
+}
+
+func newTestTransaction(version uint64, changes ...*pbaptos.WriteSetChange) *pbaptos.Transaction {
+	return &pbaptos.Transaction{Version: version, Info: &pbaptos.TransactionInfo{Success: true, Changes: changes}}
+}
+
+func writeResource(address, typeStr, data string) *pbaptos.WriteSetChange {
+	return &pbaptos.WriteSetChange{Change: &pbaptos.WriteSetChange_WriteResource{WriteResource: &pbaptos.WriteResource{Address: address, TypeStr: typeStr, Data: data}}}
+}
+
+func writeTableItem(handle, key, decodedKey, keyType, value, valueType string) *pbaptos.WriteSetChange {
+	return &pbaptos.WriteSetChange{Change: &pbaptos.WriteSetChange_WriteTableItem{WriteTableItem: &pbaptos.WriteTableItem{
+		Handle: handle,
+		Key:    key,
+		Data:   &pbaptos.WriteTableData{Key: decodedKey, KeyType: keyType, Value: value, ValueType: valueType},
+	}}}
+}
+
+func trackerTables(t *testing.T, tracker *Tracker) []*Table {
+	t.Helper()
+
+	tables, err := tracker.Tables()
+	require.NoError(t, err)
+
+	return tables
+}
+
+func contentKeys(t *testing.T, tracker *Tracker, handle string, version uint64) (out []string) {
+	t.Helper()
+
+	items, err := tracker.Content(handle, version)
+	require.NoError(t, err)
+
+	for _, item := range items {
+		out = append(out, item.Key)
+	}
+
+	return out
+}
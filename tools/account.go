@@ -0,0 +1,453 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/bstream/transform"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/internal/aptosutil"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	sftools "github.com/streamingfast/sf-tools"
+	"go.uber.org/zap"
+)
+
+// accountIndexShortname is the short name of the block index files mapping each account address
+// to the blocks it's involved in.
+const accountIndexShortname = "accounts"
+
+const defaultAccountIndexSize uint64 = 10000
+
+var accountCmd = &cobra.Command{Use: "account", Short: "Various utilities to inspect the activity of an account"}
+
+var accountHistoryCmd = &cobra.Command{
+	Use:   "history <address>",
+	Short: "Lists every transaction involving an account over a range of merged blocks",
+	Long: string(cli.Description(`
+		Lists every transaction involving the account over a range of merged blocks. An account is involved in a
+		transaction when it's the sender or a secondary signer, when one of the emitted events belongs to it (by
+		event key account address) or when one of its resources is written or deleted.
+
+		When '--index-store' is provided, the account index files produced by 'fireaptos tools account index' are
+		used to only read the blocks involving the account, merged blocks files not covered by an index are
+		scanned completely.
+	`)),
+	Args: cobra.ExactArgs(1),
+	RunE: accountHistoryE,
+	Example: ExamplePrefixed("fireaptos tools account history", `
+		0x1 --store ./firehose-data/storage/merged-blocks --range 1000:2000
+		0xa550c18 --store gs://<bucket>/merged-blocks --index-store gs://<bucket>/index --range 0:10000000 --output json
+	`),
+}
+
+var accountIndexCmd = &cobra.Command{
+	Use:   "index {merged-blocks-store-url} {index-store-url}",
+	Short: "Builds the account index files used by 'account history' to skip blocks not involving the account",
+	Args:  cobra.ExactArgs(2),
+	RunE:  accountIndexE,
+	Example: ExamplePrefixed("fireaptos tools account index", `
+		./firehose-data/storage/merged-blocks ./firehose-data/storage/index --range 0:99999
+	`),
+}
+
+func init() {
+	Cmd.AddCommand(accountCmd)
+	accountCmd.AddCommand(accountHistoryCmd)
+	accountCmd.AddCommand(accountIndexCmd)
+
+	for _, cmd := range []*cobra.Command{accountHistoryCmd, accountIndexCmd} {
+		cmd.Flags().StringP("range", "r", "", "Block range to process, in the form '<start>:<stop>' (inclusive, '<stop>' is optional)")
+		cmd.Flags().Int("workers", 8, "Amount of merged blocks files processed concurrently")
+		cmd.Flags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks contained in each merged blocks file")
+		cmd.Flags().Uint64("index-size", defaultAccountIndexSize, "Amount of blocks covered by each index file, must be a multiple of the bundle size")
+	}
+
+	accountHistoryCmd.Flags().StringP("store", "s", "", "Merged blocks store URL to read blocks from")
+	accountHistoryCmd.Flags().String("index-store", "", "Index store URL containing account index files, all blocks are scanned when empty")
+	accountHistoryCmd.Flags().StringP("output", "o", "table", "Output format, either 'table' or 'json'")
+}
+
+// accountHistoryEntry is the normalized view of a transaction involving an account.
+type accountHistoryEntry struct {
+	Version     uint64    `json:"version"`
+	BlockHeight uint64    `json:"block_height"`
+	Timestamp   time.Time `json:"timestamp"`
+	Type        string    `json:"type"`
+	Sender      string    `json:"sender,omitempty"`
+	Function    string    `json:"function,omitempty"`
+	Success     bool      `json:"success"`
+	VmStatus    string    `json:"vm_status,omitempty"`
+	GasUsed     uint64    `json:"gas_used"`
+	Roles       []string  `json:"roles"`
+	Events      []string  `json:"events,omitempty"`
+	Changes     []string  `json:"changes,omitempty"`
+}
+
+func accountHistoryE(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	address := aptosutil.NormalizeAddress(args[0])
+
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	bundleSize := mustGetUint64(cmd, "bundle-size")
+	if bundleSize == 0 {
+		return fmt.Errorf("invalid bundle size 0, must be greater than 0")
+	}
+
+	output := mustGetString(cmd, "output")
+	if output != "table" && output != "json" {
+		return fmt.Errorf("invalid output %q, accepting only 'table' or 'json'", output)
+	}
+
+	storeURL := mustGetString(cmd, "store")
+	if storeURL == "" {
+		return fmt.Errorf("the --store flag is required")
+	}
+
+	store, err := dstore.NewDBinStore(storeURL)
+	if err != nil {
+		return fmt.Errorf("unable to create store at path %q: %w", storeURL, err)
+	}
+
+	var indexStore dstore.Store
+	if indexStoreURL := mustGetString(cmd, "index-store"); indexStoreURL != "" {
+		indexStore, err = dstore.NewStore(indexStoreURL, "", "", false)
+		if err != nil {
+			return fmt.Errorf("unable to create index store at path %q: %w", indexStoreURL, err)
+		}
+	}
+
+	entries, err := accountHistory(ctx, store, indexStore, address, blockRange, bundleSize, mustGetUint64(cmd, "index-size"), mustGetInt(cmd, "workers"))
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	printAccountHistory(entries)
+	return nil
+}
+
+// accountHistory returns the entries of all the transactions involving the address in the block range, sorted
+// by version. When `indexStore` is non-nil, account index files are used to skip blocks not involving the
+// address, merged blocks files not covered by an index file are scanned completely.
+func accountHistory(
+	ctx context.Context,
+	store dstore.Store,
+	indexStore dstore.Store,
+	address string,
+	blockRange sftools.BlockRange,
+	bundleSize uint64,
+	indexSize uint64,
+	workerCount int,
+) ([]*accountHistoryEntry, error) {
+	address = aptosutil.NormalizeAddress(address)
+
+	bundles, err := listMergedBundles(ctx, store, blockRange, bundleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// A nil entry for a bundle means all its blocks must be scanned
+	candidateBlocks := make(map[uint64]map[uint64]bool, len(bundles))
+	if indexStore != nil {
+		provider := transform.NewGenericBlockIndexProvider(indexStore, accountIndexShortname, []uint64{indexSize}, func(index transform.BitmapGetter) []uint64 {
+			if bitmap := index.Get(address); bitmap != nil {
+				return bitmap.ToArray()
+			}
+
+			return nil
+		})
+
+		var bundlesToRead []uint64
+		for _, baseBlockNum := range bundles {
+			blockNums, err := provider.BlocksInRange(baseBlockNum, bundleSize)
+			if err != nil {
+				zlog.Debug("no index covering bundle, scanning it", zap.String("bundle", mergedBundleFilename(baseBlockNum)), zap.Error(err))
+				bundlesToRead = append(bundlesToRead, baseBlockNum)
+				continue
+			}
+
+			if len(blockNums) == 0 {
+				continue
+			}
+
+			candidateBlocks[baseBlockNum] = map[uint64]bool{}
+			for _, blockNum := range blockNums {
+				candidateBlocks[baseBlockNum][blockNum] = true
+			}
+			bundlesToRead = append(bundlesToRead, baseBlockNum)
+		}
+
+		zlog.Info("account index lookup completed", zap.Int("bundle_count", len(bundles)), zap.Int("bundle_to_read_count", len(bundlesToRead)))
+		bundles = bundlesToRead
+	}
+
+	var entries []*accountHistoryEntry
+	var lock sync.Mutex
+
+	err = processInParallel(ctx, bundles, workerCount, func(ctx context.Context, baseBlockNum uint64) error {
+		candidates := candidateBlocks[baseBlockNum]
+
+		return readMergedBundle(ctx, store, baseBlockNum, blockRange, func(block *pbaptos.Block) error {
+			if candidates != nil && !candidates[block.Height] {
+				return nil
+			}
+
+			for _, trx := range block.Transactions {
+				if entry := newAccountHistoryEntry(trx, address); entry != nil {
+					lock.Lock()
+					entries = append(entries, entry)
+					lock.Unlock()
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Version < entries[j].Version })
+	return entries, nil
+}
+
+// newAccountHistoryEntry returns the normalized entry of the transaction if the address is involved in it, nil otherwise.
+func newAccountHistoryEntry(trx *pbaptos.Transaction, address string) *accountHistoryEntry {
+	entry := &accountHistoryEntry{}
+
+	if aptosutil.NormalizeAddress(transactionSender(trx)) == address {
+		entry.Roles = append(entry.Roles, "sender")
+	}
+
+	for _, signer := range transactionSecondarySigners(trx) {
+		if aptosutil.NormalizeAddress(signer) == address {
+			entry.Roles = append(entry.Roles, "secondary-signer")
+			break
+		}
+	}
+
+	for _, event := range transactionEvents(trx) {
+		if aptosutil.NormalizeAddress(event.GetKey().GetAccountAddress()) == address {
+			entry.Events = append(entry.Events, event.TypeStr)
+		}
+	}
+	if len(entry.Events) > 0 {
+		entry.Roles = append(entry.Roles, "event")
+	}
+
+	for _, change := range trx.GetInfo().GetChanges() {
+		switch {
+		case change.GetWriteResource() != nil && aptosutil.NormalizeAddress(change.GetWriteResource().Address) == address:
+			entry.Changes = append(entry.Changes, "write_resource "+change.GetWriteResource().TypeStr)
+		case change.GetDeleteResource() != nil && aptosutil.NormalizeAddress(change.GetDeleteResource().Address) == address:
+			entry.Changes = append(entry.Changes, "delete_resource "+change.GetDeleteResource().TypeStr)
+		}
+	}
+	if len(entry.Changes) > 0 {
+		entry.Roles = append(entry.Roles, "resource-change")
+	}
+
+	if len(entry.Roles) == 0 {
+		return nil
+	}
+
+	entry.Version = trx.Version
+	entry.BlockHeight = trx.BlockHeight
+	entry.Timestamp = trx.Time()
+	entry.Type = trx.Type.String()
+	entry.Sender = transactionSender(trx)
+	entry.Function = entryFunctionID(transactionEntryFunction(trx))
+	entry.Success = trx.GetInfo().GetSuccess()
+	entry.VmStatus = trx.GetInfo().GetVmStatus()
+	entry.GasUsed = trx.GetInfo().GetGasUsed()
+
+	return entry
+}
+
+// transactionAccounts returns the normalized address of every account involved in the transaction, in the
+// sense of `newAccountHistoryEntry`.
+func transactionAccounts(trx *pbaptos.Transaction) (out []string) {
+	seen := map[string]bool{}
+	add := func(address string) {
+		if address == "" {
+			return
+		}
+
+		normalized := aptosutil.NormalizeAddress(address)
+		if !seen[normalized] {
+			seen[normalized] = true
+			out = append(out, normalized)
+		}
+	}
+
+	add(transactionSender(trx))
+	for _, signer := range transactionSecondarySigners(trx) {
+		add(signer)
+	}
+
+	for _, event := range transactionEvents(trx) {
+		add(event.GetKey().GetAccountAddress())
+	}
+
+	for _, change := range trx.GetInfo().GetChanges() {
+		add(change.GetWriteResource().GetAddress())
+		add(change.GetDeleteResource().GetAddress())
+	}
+
+	return out
+}
+
+func printAccountHistory(entries []*accountHistoryEntry) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "VERSION\tHEIGHT\tTIME\tTYPE\tFUNCTION\tSUCCESS\tGAS\tROLES\tCHANGES")
+
+	for _, entry := range entries {
+		function := entry.Function
+		if function == "" {
+			function = "-"
+		}
+
+		changes := "-"
+		if len(entry.Changes) > 0 {
+			changes = strings.Join(entry.Changes, ", ")
+		}
+
+		fmt.Fprintf(writer, "%d\t%d\t%s\t%s\t%s\t%t\t%d\t%s\t%s\n",
+			entry.Version,
+			entry.BlockHeight,
+			entry.Timestamp.Format(time.RFC3339),
+			strings.ToLower(entry.Type),
+			function,
+			entry.Success,
+			entry.GasUsed,
+			strings.Join(entry.Roles, ","),
+			changes,
+		)
+	}
+
+	writer.Flush()
+	fmt.Printf("\n%d transaction(s)\n", len(entries))
+}
+
+func accountIndexE(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	store, err := dstore.NewDBinStore(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to create store at path %q: %w", args[0], err)
+	}
+
+	indexStore, err := dstore.NewStore(args[1], "", "", false)
+	if err != nil {
+		return fmt.Errorf("unable to create index store at path %q: %w", args[1], err)
+	}
+
+	return buildAccountIndexes(ctx, store, indexStore, blockRange, mustGetUint64(cmd, "bundle-size"), mustGetUint64(cmd, "index-size"), mustGetInt(cmd, "workers"))
+}
+
+// buildAccountIndexes writes one account index file per `indexSize` blocks of the range, the range
+// must start on an index boundary and only index files for which all merged blocks files are
+// present are written.
+func buildAccountIndexes(ctx context.Context, store dstore.Store, indexStore dstore.Store, blockRange sftools.BlockRange, bundleSize uint64, indexSize uint64, workerCount int) error {
+	if bundleSize == 0 || indexSize == 0 || indexSize%bundleSize != 0 {
+		return fmt.Errorf("invalid index size %d, must be a non-zero multiple of the bundle size %d", indexSize, bundleSize)
+	}
+
+	if blockRange.Start%indexSize != 0 {
+		return fmt.Errorf("range start %d must be a multiple of the index size %d", blockRange.Start, indexSize)
+	}
+
+	bundles, err := listMergedBundles(ctx, store, blockRange, bundleSize)
+	if err != nil {
+		return err
+	}
+
+	bundlesPerIndex := map[uint64][]uint64{}
+	for _, baseBlockNum := range bundles {
+		indexBase := baseBlockNum - baseBlockNum%indexSize
+		bundlesPerIndex[indexBase] = append(bundlesPerIndex[indexBase], baseBlockNum)
+	}
+
+	var indexes []uint64
+	for indexBase, indexBundles := range bundlesPerIndex {
+		if uint64(len(indexBundles)) != indexSize/bundleSize {
+			zlog.Info("skipping incomplete index range", zap.Uint64("index_base", indexBase), zap.Int("bundle_count", len(indexBundles)))
+			continue
+		}
+
+		if !blockRange.Unbounded() && indexBase+indexSize-1 > blockRange.Stop {
+			zlog.Info("skipping index range not fully covered by block range", zap.Uint64("index_base", indexBase))
+			continue
+		}
+
+		indexes = append(indexes, indexBase)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	zlog.Info("building account indexes", zap.Int("index_count", len(indexes)), zap.Uint64("index_size", indexSize))
+
+	return processInParallel(ctx, indexes, workerCount, func(ctx context.Context, indexBase uint64) error {
+		recordingStore := &writeErrorRecordingStore{Store: indexStore}
+		indexer := transform.NewBlockIndexer(recordingStore, indexSize, accountIndexShortname, transform.WithDefinedStartBlock(indexBase))
+
+		for _, baseBlockNum := range bundlesPerIndex[indexBase] {
+			err := readMergedBundle(ctx, store, baseBlockNum, sftools.BlockRange{}, func(block *pbaptos.Block) error {
+				var keys []string
+				for _, trx := range block.Transactions {
+					keys = append(keys, transactionAccounts(trx)...)
+				}
+
+				indexer.Add(keys, block.Height)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// The indexer writes the current index file when it sees a block of the next range
+		indexer.Add(nil, indexBase+indexSize)
+		if recordingStore.err != nil {
+			return fmt.Errorf("write account index %d: %w", indexBase, recordingStore.err)
+		}
+
+		zlog.Info("wrote account index", zap.Uint64("index_base", indexBase))
+		return nil
+	})
+}
+
+// writeErrorRecordingStore records the error of the last object written to the wrapped store,
+// the block indexer only logging the errors of the index files it fails to write.
+type writeErrorRecordingStore struct {
+	dstore.Store
+	err error
+}
+
+func (s *writeErrorRecordingStore) WriteObject(ctx context.Context, base string, f io.Reader) error {
+	s.err = s.Store.WriteObject(ctx, base, f)
+	return s.err
+}
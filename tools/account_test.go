@@ -0,0 +1,136 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	sftools "github.com/streamingfast/sf-tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountHistory(t *testing.T) {
+	store := newTestAccountStore(t, 0, 400)
+
+	entries, err := accountHistory(context.Background(), store, nil, "0x00a1", sftools.BlockRange{Start: 0, Stop: 399}, 100, 200, 2)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint64{10, 150, 250, 370}, entryVersions(entries))
+	assert.Equal(t, []string{"sender"}, entries[0].Roles)
+	assert.Equal(t, "0x1::coin::transfer", entries[0].Function)
+	assert.Equal(t, []string{"secondary-signer"}, entries[1].Roles)
+	assert.Equal(t, []string{"event"}, entries[2].Roles)
+	assert.Equal(t, []string{"0x1::coin::DepositEvent"}, entries[2].Events)
+	assert.Equal(t, []string{"resource-change"}, entries[3].Roles)
+	assert.Equal(t, []string{"delete_resource 0x1::coin::CoinStore"}, entries[3].Changes)
+
+	entries, err = accountHistory(context.Background(), store, nil, "0xa1", sftools.BlockRange{Start: 100, Stop: 299}, 100, 200, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{150, 250}, entryVersions(entries))
+}
+
+func TestAccountHistory_WithIndex(t *testing.T) {
+	store := newTestAccountStore(t, 0, 400)
+
+	indexStore, err := dstore.NewStore("file://"+t.TempDir(), "", "", false)
+	require.NoError(t, err)
+
+	// Only the first half is indexed, the rest must be found by scanning
+	require.NoError(t, buildAccountIndexes(context.Background(), store, indexStore, sftools.BlockRange{Start: 0, Stop: 199}, 100, 200, 2))
+
+	exists, err := indexStore.FileExists(context.Background(), "0000000000.200.accounts.idx")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	for _, address := range []string{"0xa1", "0xb2", "0xc3", "0x1"} {
+		scanned, err := accountHistory(context.Background(), store, nil, address, sftools.BlockRange{Start: 0, Stop: 399}, 100, 200, 2)
+		require.NoError(t, err)
+
+		indexed, err := accountHistory(context.Background(), store, indexStore, address, sftools.BlockRange{Start: 0, Stop: 399}, 100, 200, 2)
+		require.NoError(t, err)
+
+		assert.Equal(t, scanned, indexed, "address %s", address)
+	}
+}
+
+func TestBuildAccountIndexes_InvalidRange(t *testing.T) {
+	store := newTestAccountStore(t, 0, 100)
+
+	err := buildAccountIndexes(context.Background(), store, store, sftools.BlockRange{Start: 100, Stop: 399}, 100, 300, 1)
+	assert.EqualError(t, err, "range start 100 must be a multiple of the index size 300")
+
+	err = buildAccountIndexes(context.Background(), store, store, sftools.BlockRange{Start: 0, Stop: 399}, 100, 150, 1)
+	assert.EqualError(t, err, "invalid index size 150, must be a non-zero multiple of the bundle size 100")
+}
+
+func TestBuildAccountIndexes_WriteError(t *testing.T) {
+	store := newTestAccountStore(t, 0, 200)
+
+	indexStore, err := dstore.NewStore("file://"+t.TempDir(), "", "", false)
+	require.NoError(t, err)
+
+	err = buildAccountIndexes(context.Background(), store, &failingWriteStore{indexStore}, sftools.BlockRange{Start: 0, Stop: 199}, 100, 200, 1)
+	assert.EqualError(t, err, "write account index 0: write failed")
+}
+
+type failingWriteStore struct {
+	dstore.Store
+}
+
+func (s *failingWriteStore) WriteObject(ctx context.Context, base string, f io.Reader) error {
+	return errors.New("write failed")
+}
+
+func TestTransactionAccounts(t *testing.T) {
+	trx := newTestUserTransaction(1, "0x00A1", []string{"0xb2", "0xa1"})
+	trx.GetUser().Events = []*pbaptos.Event{{Key: &pbaptos.EventKey{AccountAddress: "0x0c3"}, TypeStr: "0x1::coin::DepositEvent"}}
+	trx.Info.Changes = []*pbaptos.WriteSetChange{
+		{Change: &pbaptos.WriteSetChange_WriteResource{WriteResource: &pbaptos.WriteResource{Address: "0xd4", TypeStr: "0x1::coin::CoinStore"}}},
+		{Change: &pbaptos.WriteSetChange_DeleteResource{DeleteResource: &pbaptos.DeleteResource{Address: "0xa1", TypeStr: "0x1::coin::CoinStore"}}},
+	}
+
+	assert.Equal(t, []string{"0xa1", "0xb2", "0xc3", "0xd4"}, transactionAccounts(trx))
+}
+
+// newTestAccountStore writes blocks `[start, stop[` in a temporary store, where account `0xa1` is the
+// sender of version 10, a secondary signer of version 150, receives an event in version 250 and has a
+// resource deleted in version 370. Account `0xb2` sends all those transactions.
+func newTestAccountStore(t *testing.T, start, stop uint64) dstore.Store {
+	t.Helper()
+
+	userTransactions := map[uint64]*pbaptos.Transaction{
+		10:  newTestUserTransaction(10, "0xa1", nil),
+		150: newTestUserTransaction(150, "0xb2", []string{"0xa1"}),
+		250: newTestUserTransaction(250, "0xb2", nil),
+		370: newTestUserTransaction(370, "0xb2", nil),
+		380: newTestUserTransaction(380, "0xb2", nil),
+	}
+	userTransactions[250].GetUser().Events = []*pbaptos.Event{{Key: &pbaptos.EventKey{AccountAddress: "0xa1"}, TypeStr: "0x1::coin::DepositEvent"}}
+	userTransactions[370].Info.Changes = []*pbaptos.WriteSetChange{
+		{Change: &pbaptos.WriteSetChange_DeleteResource{DeleteResource: &pbaptos.DeleteResource{Address: "0xa1", TypeStr: "0x1::coin::CoinStore"}}},
+	}
+
+	store := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, store, start, stop, func(height uint64) *pbaptos.Block {
+		block := newTestBlock(height)
+		if trx, found := userTransactions[height]; found {
+			block.Transactions = []*pbaptos.Transaction{trx}
+		}
+
+		return block
+	})
+
+	return store
+}
+
+func entryVersions(entries []*accountHistoryEntry) (out []uint64) {
+	for _, entry := range entries {
+		out = append(out, entry.Version)
+	}
+
+	return out
+}
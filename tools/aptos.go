@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"strings"
+
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+)
+
+// transactionEvents returns the events emitted by the transaction, regardless of its type.
+func transactionEvents(trx *pbaptos.Transaction) []*pbaptos.Event {
+	switch {
+	case trx.GetUser() != nil:
+		return trx.GetUser().Events
+	case trx.GetBlockMetadata() != nil:
+		return trx.GetBlockMetadata().Events
+	case trx.GetGenesis() != nil:
+		return trx.GetGenesis().Events
+	}
+
+	return nil
+}
+
+// transactionSender returns the sender of the transaction, or an empty string if the transaction is
+// not a user transaction.
+func transactionSender(trx *pbaptos.Transaction) string {
+	return trx.GetUser().GetRequest().GetSender()
+}
+
+// transactionSecondarySigners returns the secondary signers of a multi-agent user transaction.
+func transactionSecondarySigners(trx *pbaptos.Transaction) []string {
+	return trx.GetUser().GetRequest().GetSignature().GetMultiAgent().GetSecondarySignerAddresses()
+}
+
+func transactionEntryFunction(trx *pbaptos.Transaction) *pbaptos.EntryFunctionId {
+	return trx.GetUser().GetRequest().GetPayload().GetEntryFunctionPayload().GetFunction()
+}
+
+func entryFunctionID(function *pbaptos.EntryFunctionId) string {
+	if function == nil {
+		return ""
+	}
+
+	if function.Module == nil {
+		return function.Name
+	}
+
+	return strings.Join([]string{function.Module.Address, function.Module.Name, function.Name}, "::")
+}
@@ -59,6 +59,7 @@ func init() {
 	Cmd.AddCommand(printCmd)
 
 	printCmd.PersistentFlags().String("store", "", "block store")
+	printCmd.PersistentFlags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks contained in each merged blocks file of the store")
 
 	printCmd.AddCommand(oneBlockCmd)
 	printCmd.AddCommand(blocksCmd)
@@ -136,7 +137,12 @@ func printBlockE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("unable to create store at path %q: %w", store, err)
 	}
 
-	mergedBlockNum := blockNum - (blockNum % 100)
+	bundleSize := mustGetUint64(cmd, "bundle-size")
+	if bundleSize == 0 {
+		return fmt.Errorf("invalid bundle size 0, must be greater than 0")
+	}
+
+	mergedBlockNum := blockNum - (blockNum % bundleSize)
 	zlog.Info("finding merged block file",
 		zap.Uint64("merged_block_num", mergedBlockNum),
 		zap.Uint64("block_num", blockNum),
@@ -48,6 +48,7 @@ func init() {
 	CheckCmd.AddCommand(checkMergedBlocksCmd)
 
 	CheckCmd.PersistentFlags().StringP("range", "r", "", "Block range to use for the check")
+	CheckCmd.PersistentFlags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks contained in each merged blocks file of the store")
 
 	checkMergedBlocksCmd.Flags().BoolP("print-stats", "s", false, "Natively decode each block in the segment and print statistics about it, ensuring it contains the required blocks")
 	checkMergedBlocksCmd.Flags().BoolP("print-full", "f", false, "Natively decode each block and print the full JSON representation of the block, should be used with a small range only if you don't want to be overwhelmed")
@@ -55,7 +56,10 @@ func init() {
 
 func checkMergedBlocksE(cmd *cobra.Command, args []string) error {
 	storeURL := args[0]
-	fileBlockSize := uint32(100)
+	fileBlockSize := uint32(mustGetUint64(cmd, "bundle-size"))
+	if fileBlockSize == 0 {
+		return fmt.Errorf("invalid bundle size 0, must be greater than 0")
+	}
 
 	blockRange, err := sftools.Flags.GetBlockRange("range")
 	if err != nil {
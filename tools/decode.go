@@ -1,46 +1,320 @@
 package tools
 
 import (
+	"bufio"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/spf13/cobra"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/firehose-aptos/codec"
 	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 var decodeCmd = &cobra.Command{Use: "decode", Short: "Various utilities around decoding like decoding output types"}
 
 var decodeTrxCmd = &cobra.Command{
-	Use:   "trx <input> [<input>...]",
-	Short: "Receives a base64 standard with padding string expecting to contain an sf.aptos.type.v1.Transaction object and decodes it",
-	Args:  cobra.MinimumNArgs(1),
+	Use:   "trx [<input>...]",
+	Short: "Receives a base64 (standard with padding) string, or a hex one with --encoding hex, expecting to contain an aptos.extractor.v1.Transaction object and decodes it",
 	RunE:  decodeTrxE,
-	Example: ExamplePrefixed("sfeth tools decode trx", `
+	Example: ExamplePrefixed("fireaptos tools decode trx", `
 		"CgoIqe2LlwYQj8cjEBMaoQEKILhgUcdnChYZzDe+VbmXy5kAJoiN7SEZrKDvIZs6OK1HEiA2G1IsMLUxQZBTzPRwScN5LxpQuOP7atP4VcK5KJkSwBogQUNDVU1VTEFUT1JfUExBQ0VIT0xERVJfSEFTSAAAAAAoATIVRXhlY3V0ZWQgc3VjY2Vzc2Z1bGx5OiBp+0BzUC0AF95n4YzdKFku3axn0P2COQP3UR1dTzJeeyACKAowAkoA"
 	`),
 }
 
+var decodeMessageCmd = &cobra.Command{
+	Use:   "message <type> [<input>...]",
+	Short: "Decodes base64 (standard with padding) or hex inputs as the Protobuf message of the given type, inputs are read from standard input when none are given",
+	Long: string(cli.Description(`
+		Decodes base64 (standard with padding) or hex inputs as the Protobuf message of the given type. The type
+		can be one of the short names 'block', 'transaction' (or 'trx'), 'event' and 'write-set-change' or any
+		message name of the 'aptos.extractor.v1' package, fully qualified or not (e.g. 'WriteResource' or
+		'aptos.extractor.v1.MoveModule').
+
+		When no input is given on the command line, inputs are read from standard input, one per line.
+	`)),
+	Args: cobra.MinimumNArgs(1),
+	RunE: decodeMessageE,
+	Example: ExamplePrefixed("fireaptos tools decode message", `
+		event 0x0a0e0a013112...
+		write-set-change < changes.txt
+		aptos.extractor.v1.Block "CgwIu..."
+	`),
+}
+
+var decodeFirelogsCmd = &cobra.Command{
+	Use:   "firelogs [<file>...]",
+	Short: "Decodes full 'FIRE' logs as emitted by the instrumented aptos-node, printing reconstructed blocks and flagging protocol errors, reads standard input when no file (or '-') is given",
+	RunE:  decodeFirelogsE,
+	Example: ExamplePrefixed("fireaptos tools decode firelogs", `
+		captured-stdout.log
+		--summary captured-stdout-1.log captured-stdout-2.log
+		- < captured-stdout.log
+	`),
+}
+
 func init() {
 	Cmd.AddCommand(decodeCmd)
 	decodeCmd.AddCommand(decodeTrxCmd)
+	decodeCmd.AddCommand(decodeMessageCmd)
+	decodeCmd.AddCommand(decodeFirelogsCmd)
+
+	// Inputs of 'decode trx' have always been base64, 'auto' would reject those made only of hex characters
+	decodeTrxCmd.Flags().String("encoding", "base64", "Encoding of the inputs, one of 'base64', 'hex' or 'auto', see 'decode message' for 'auto'")
+	decodeMessageCmd.Flags().String("encoding", "auto", "Encoding of the inputs, one of 'auto', 'base64' or 'hex', 'auto' assumes hex if the input is prefixed with '0x' or contains only hexadecimal characters, base64 otherwise, and fails if the input is both valid hex and valid base64")
+
+	decodeFirelogsCmd.Flags().Bool("summary", false, "Print a single summary line per block instead of the full block JSON")
+	decodeFirelogsCmd.Flags().Bool("stop-on-error", false, "Stop at first protocol error instead of flagging it and continuing with the next lines and files")
+}
+
+var messageTypeAliases = map[string]protoreflect.FullName{
+	"block":            "aptos.extractor.v1.Block",
+	"transaction":      "aptos.extractor.v1.Transaction",
+	"trx":              "aptos.extractor.v1.Transaction",
+	"event":            "aptos.extractor.v1.Event",
+	"write-set-change": "aptos.extractor.v1.WriteSetChange",
 }
 
 func decodeTrxE(cmd *cobra.Command, args []string) error {
-	for _, input := range args {
-		data, err := base64.StdEncoding.DecodeString(input)
+	return decodeMessages(cmd, "transaction", args)
+}
+
+func decodeMessageE(cmd *cobra.Command, args []string) error {
+	return decodeMessages(cmd, args[0], args[1:])
+}
+
+func decodeMessages(cmd *cobra.Command, typeName string, inputs []string) error {
+	messageType, err := resolveMessageType(typeName)
+	if err != nil {
+		return err
+	}
+
+	encoding := mustGetString(cmd, "encoding")
+
+	return forEachInput(inputs, func(input string) error {
+		data, err := decodeInput(input, encoding)
 		if err != nil {
-			return fmt.Errorf("invalid base64 standard with padding transaction's input: %w", err)
+			return fmt.Errorf("invalid %s input: %w", messageType.Descriptor().Name(), err)
+		}
+
+		message := messageType.New().Interface()
+		if err := proto.Unmarshal(data, message); err != nil {
+			return fmt.Errorf("invalid %s bytes: %w", messageType.Descriptor().Name(), err)
+		}
+
+		fmt.Println(protojson.Format(message))
+		return nil
+	})
+}
+
+func resolveMessageType(typeName string) (protoreflect.MessageType, error) {
+	fullName, found := messageTypeAliases[strings.ToLower(typeName)]
+	if !found {
+		fullName = protoreflect.FullName(typeName)
+		if !strings.Contains(typeName, ".") {
+			fullName = (&pbaptos.Block{}).ProtoReflect().Descriptor().ParentFile().Package().Append(protoreflect.Name(typeName))
+		}
+	}
+
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(fullName)
+	if err != nil {
+		return nil, fmt.Errorf("unknown message type %q: %w", typeName, err)
+	}
+
+	return messageType, nil
+}
+
+// forEachInput calls `onInput` for each received input or for each non-empty line of
+// standard input if there is none.
+func forEachInput(inputs []string, onInput func(input string) error) error {
+	if len(inputs) > 0 {
+		for _, input := range inputs {
+			if err := onInput(input); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 1024*1024), 50*1024*1024)
+	for scanner.Scan() {
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" {
+			continue
+		}
+
+		if err := onInput(input); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func decodeInput(input string, encoding string) ([]byte, error) {
+	input = strings.TrimSpace(input)
+
+	switch encoding {
+	case "hex":
+		return hex.DecodeString(strings.TrimPrefix(input, "0x"))
+	case "base64":
+		return base64.StdEncoding.DecodeString(input)
+	case "auto":
+		unprefixed := strings.TrimPrefix(input, "0x")
+		if !isHexString(unprefixed) {
+			return base64.StdEncoding.DecodeString(input)
+		}
+
+		// Hexadecimal characters being part of the base64 alphabet, an unprefixed hex input of a multiple of 4 characters is also valid base64
+		if unprefixed == input && len(input)%4 == 0 {
+			return nil, fmt.Errorf("input is both valid hex and valid base64, use --encoding to specify which one it is")
 		}
 
-		transaction := &pbaptos.Transaction{}
-		if err := proto.Unmarshal(data, transaction); err != nil {
-			return fmt.Errorf("invalid transaction's bytes: %w", err)
+		return hex.DecodeString(unprefixed)
+	}
+
+	return nil, fmt.Errorf("unknown encoding %q, accepting only 'auto', 'base64' or 'hex'", encoding)
+}
+
+func isHexString(in string) bool {
+	if len(in) == 0 || len(in)%2 != 0 {
+		return false
+	}
+
+	for _, char := range in {
+		if !(char >= '0' && char <= '9') && !(char >= 'a' && char <= 'f') && !(char >= 'A' && char <= 'F') {
+			return false
 		}
+	}
+
+	return true
+}
+
+func decodeFirelogsE(cmd *cobra.Command, args []string) error {
+	summary := mustGetBool(cmd, "summary")
+	stopOnError := mustGetBool(cmd, "stop-on-error")
+
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
 
-		fmt.Println(protojson.Format(transaction))
+	errorCount, err := decodeFirelogs(args, summary, stopOnError)
+	if err != nil {
+		return err
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("found %d protocol error(s)", errorCount)
 	}
 
 	return nil
 }
+
+// decodeFirelogs decodes the files in order, returning the amount of protocol errors found. With
+// `stopOnError`, the first protocol error stops the decoding, the remaining files being skipped.
+func decodeFirelogs(files []string, summary bool, stopOnError bool) (errorCount int, err error) {
+	for _, file := range files {
+		fileErrorCount, err := decodeFirelogsFile(file, summary, stopOnError)
+		if err != nil {
+			return errorCount, err
+		}
+
+		errorCount += fileErrorCount
+		if stopOnError && fileErrorCount > 0 {
+			break
+		}
+	}
+
+	return errorCount, nil
+}
+
+func decodeFirelogsFile(file string, summary bool, stopOnError bool) (errorCount int, err error) {
+	name := file
+	var input io.Reader = os.Stdin
+	if file == "-" {
+		name = "<stdin>"
+	} else {
+		reader, err := os.Open(file)
+		if err != nil {
+			return 0, fmt.Errorf("open file: %w", err)
+		}
+		defer reader.Close()
+
+		input = reader
+	}
+
+	lines := make(chan string, 1000)
+	consoleReader, err := codec.NewConsoleReader(zlog, lines)
+	if err != nil {
+		return 0, fmt.Errorf("new console reader: %w", err)
+	}
+	defer consoleReader.Close()
+
+	// Closed when returning, so the line producer does not stay blocked sending lines nobody reads
+	done := make(chan struct{})
+	defer close(done)
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(input)
+		scanner.Buffer(make([]byte, 1024*1024), 50*1024*1024)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+
+		readErr <- scanner.Err()
+	}()
+
+	for {
+		block, err := consoleReader.ReadBlock()
+		if err != nil {
+			if err == io.EOF {
+				if err := <-readErr; err != nil {
+					return errorCount, fmt.Errorf("read %s: %w", name, err)
+				}
+
+				return errorCount, nil
+			}
+
+			errorCount++
+			fmt.Printf("❌ %s:%d: %s\n", name, consoleReader.LineCount(), err)
+			if stopOnError {
+				return errorCount, nil
+			}
+
+			continue
+		}
+
+		aptosBlock := block.ToProtocol().(*pbaptos.Block)
+		if summary {
+			fmt.Printf("Block #%d (%s) (prev: %s) at %s with %d transactions (%s:%d)\n",
+				aptosBlock.Height,
+				aptosBlock.ID(),
+				aptosBlock.PreviousID(),
+				aptosBlock.Time(),
+				len(aptosBlock.Transactions),
+				name,
+				consoleReader.LineCount(),
+			)
+			continue
+		}
+
+		fmt.Println(protojson.Format(aptosBlock))
+	}
+}
@@ -0,0 +1,65 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeInput(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		encoding      string
+		expected      []byte
+		expectedError string
+	}{
+		{"auto prefixed hex", "0x0a0b", "auto", []byte{0x0a, 0x0b}, ""},
+		{"auto unprefixed hex", "0a0b0c", "auto", []byte{0x0a, 0x0b, 0x0c}, ""},
+		{"auto base64", "CgsM", "auto", []byte{0x0a, 0x0b, 0x0c}, ""},
+		{"auto ambiguous", "0a0b", "auto", nil, "input is both valid hex and valid base64, use --encoding to specify which one it is"},
+		{"hex", "0a0b", "hex", []byte{0x0a, 0x0b}, ""},
+		{"base64", "0a0b", "base64", []byte{0xd1, 0xad, 0x1b}, ""},
+		{"unknown encoding", "0a0b", "base58", nil, "unknown encoding \"base58\", accepting only 'auto', 'base64' or 'hex'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := decodeInput(test.input, test.encoding)
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, data)
+		})
+	}
+}
+
+func TestDecodeFirelogs_StopOnError(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, lines ...string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644))
+		return path
+	}
+
+	files := []string{
+		writeFile("first.log", "FIRE INIT aptos-node 1.3.0 aptos 0 1 2", "FIRE BLOCK_START abc", "FIRE BLOCK_START def"),
+		writeFile("second.log", "FIRE INIT aptos-node 1.3.0 aptos 0 1 2", "FIRE BLOCK_START ghi"),
+	}
+
+	errorCount, err := decodeFirelogs(files, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, errorCount)
+
+	// The first error stops the decoding of all the files
+	errorCount, err = decodeFirelogs(files, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, errorCount)
+}
@@ -0,0 +1,244 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/types"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/streamingfast/firehose/client"
+	pbfirehose "github.com/streamingfast/pbgo/sf/firehose/v2"
+	sftools "github.com/streamingfast/sf-tools"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+var downloadFromFirehoseCmd = &cobra.Command{
+	Use:   "download-from-firehose",
+	Short: "Downloads blocks from an existing Firehose endpoint and writes them as merged blocks files in a store",
+	Long: string(cli.Description(`
+		Downloads blocks from an existing Firehose endpoint and writes them as merged blocks files in the destination
+		store, effectively bootstrapping a new environment without syncing 'aptos-node' from genesis.
+
+		The range is split in bundles that are downloaded concurrently by '--workers' workers, each bundle being
+		requested as its own stream of final blocks. A bundle is written to the store only once all its blocks were
+		received, so bundles already present in the destination store are complete and are skipped, which makes
+		it possible to resume an interrupted download by running the same command again.
+
+		The range must be bounded, start on a bundle boundary (or on the first streamable block) and stop on the
+		last block of a bundle.
+	`)),
+	Args: cobra.NoArgs,
+	RunE: downloadFromFirehoseE,
+	Example: ExamplePrefixed("fireaptos tools download-from-firehose", `
+		--endpoint testnet.aptos.streamingfast.io:443 --range 0:99999 --dest-store ./firehose-data/storage/merged-blocks
+		--endpoint localhost:18015 --plaintext --range 100000:199999 --dest-store gs://<bucket>/merged-blocks --workers 16
+	`),
+}
+
+func init() {
+	Cmd.AddCommand(downloadFromFirehoseCmd)
+
+	downloadFromFirehoseCmd.Flags().String("endpoint", "", "Firehose endpoint to download blocks from")
+	downloadFromFirehoseCmd.Flags().StringP("range", "r", "", "Block range to download, in the form '<start>:<stop>' (inclusive)")
+	downloadFromFirehoseCmd.Flags().String("dest-store", "", "Store URL where merged blocks files are written")
+	downloadFromFirehoseCmd.Flags().StringP("api-token-env-var", "a", "FIREHOSE_API_TOKEN", "Look for a JWT in this environment variable to authenticate against endpoint")
+	downloadFromFirehoseCmd.Flags().BoolP("plaintext", "p", false, "Use plaintext connection to firehose")
+	downloadFromFirehoseCmd.Flags().BoolP("insecure", "k", false, "Skip SSL certificate validation when connecting to firehose")
+	downloadFromFirehoseCmd.Flags().Int("workers", 4, "Amount of bundles downloaded concurrently")
+	downloadFromFirehoseCmd.Flags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks to put in each merged blocks file")
+	downloadFromFirehoseCmd.Flags().Int("max-retries", 5, "Amount of times the download of a bundle is retried when the stream fails before giving up")
+	downloadFromFirehoseCmd.Flags().Duration("retry-delay", 4*time.Second, "Delay before retrying the download of a bundle after a stream failure")
+	downloadFromFirehoseCmd.Flags().Bool("verify", true, "Read back each written file and ensure it contains exactly the blocks that were received")
+}
+
+func downloadFromFirehoseE(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	endpoint := mustGetString(cmd, "endpoint")
+	if endpoint == "" {
+		return fmt.Errorf("the --endpoint flag is required")
+	}
+
+	destStoreURL := mustGetString(cmd, "dest-store")
+	if destStoreURL == "" {
+		return fmt.Errorf("the --dest-store flag is required")
+	}
+
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	store, err := dstore.NewDBinStore(destStoreURL)
+	if err != nil {
+		return fmt.Errorf("unable to create destination store at path %q: %w", destStoreURL, err)
+	}
+
+	firehoseClient, connClose, grpcCallOpts, err := client.NewFirehoseClient(endpoint, os.Getenv(mustGetString(cmd, "api-token-env-var")), mustGetBool(cmd, "insecure"), mustGetBool(cmd, "plaintext"))
+	if err != nil {
+		return err
+	}
+	defer connClose()
+
+	downloader := &firehoseDownloader{
+		client:     firehoseClient,
+		callOpts:   grpcCallOpts,
+		store:      store,
+		bundleSize: mustGetUint64(cmd, "bundle-size"),
+		maxRetries: mustGetInt(cmd, "max-retries"),
+		retryDelay: mustGetDuration(cmd, "retry-delay"),
+		verify:     mustGetBool(cmd, "verify"),
+	}
+
+	return downloader.Download(ctx, blockRange, mustGetInt(cmd, "workers"))
+}
+
+type firehoseDownloader struct {
+	client     pbfirehose.StreamClient
+	callOpts   []grpc.CallOption
+	store      dstore.Store
+	bundleSize uint64
+	maxRetries int
+	retryDelay time.Duration
+	verify     bool
+}
+
+// Download writes all the bundles of the block range that are not already present in the store.
+func (d *firehoseDownloader) Download(ctx context.Context, blockRange sftools.BlockRange, workerCount int) error {
+	if d.bundleSize == 0 {
+		return fmt.Errorf("invalid bundle size 0, must be greater than 0")
+	}
+
+	if blockRange.Unbounded() {
+		return fmt.Errorf("range must be bounded, got start %d without stop", blockRange.Start)
+	}
+
+	if blockRange.Start%d.bundleSize != 0 && blockRange.Start != bstream.GetProtocolFirstStreamableBlock {
+		return fmt.Errorf("range start %d must be a multiple of the bundle size %d", blockRange.Start, d.bundleSize)
+	}
+
+	if (blockRange.Stop+1)%d.bundleSize != 0 {
+		return fmt.Errorf("range stop %d must be the last block of a bundle (a multiple of the bundle size %d minus 1)", blockRange.Stop, d.bundleSize)
+	}
+
+	existingBundles, err := listMergedBundles(ctx, d.store, blockRange, d.bundleSize)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[uint64]bool, len(existingBundles))
+	for _, baseBlockNum := range existingBundles {
+		existing[baseBlockNum] = true
+	}
+
+	var bundles []uint64
+	for baseBlockNum := blockRange.Start - (blockRange.Start % d.bundleSize); baseBlockNum <= blockRange.Stop; baseBlockNum += d.bundleSize {
+		if !existing[baseBlockNum] {
+			bundles = append(bundles, baseBlockNum)
+		}
+	}
+
+	zlog.Info("downloading merged blocks files from firehose",
+		zap.Stringer("range", blockRange),
+		zap.Uint64("bundle_size", d.bundleSize),
+		zap.Int("bundle_count", len(bundles)),
+		zap.Int("already_present_bundle_count", len(existing)),
+		zap.Int("worker_count", workerCount),
+	)
+
+	return processInParallel(ctx, bundles, workerCount, func(ctx context.Context, baseBlockNum uint64) error {
+		bundleRange := sftools.BlockRange{Start: baseBlockNum, Stop: baseBlockNum + d.bundleSize - 1}
+		if bundleRange.Start < blockRange.Start {
+			bundleRange.Start = blockRange.Start
+		}
+
+		for attempt := 0; ; attempt++ {
+			err := d.downloadBundle(ctx, baseBlockNum, bundleRange)
+			if err == nil {
+				return nil
+			}
+
+			if ctx.Err() != nil || attempt >= d.maxRetries {
+				return fmt.Errorf("bundle %s: %w", mergedBundleFilename(baseBlockNum), err)
+			}
+
+			zlog.Warn("bundle download failed, retrying",
+				zap.String("bundle", mergedBundleFilename(baseBlockNum)),
+				zap.Int("attempt", attempt+1),
+				zap.Duration("retry_delay", d.retryDelay),
+				zap.Error(err),
+			)
+
+			select {
+			case <-time.After(d.retryDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+}
+
+func (d *firehoseDownloader) downloadBundle(ctx context.Context, baseBlockNum uint64, bundleRange sftools.BlockRange) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := d.client.Blocks(ctx, &pbfirehose.Request{
+		StartBlockNum:   int64(bundleRange.Start),
+		StopBlockNum:    bundleRange.Stop,
+		FinalBlocksOnly: true,
+	}, d.callOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to start blocks stream: %w", err)
+	}
+
+	var blocks []*bstream.Block
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return fmt.Errorf("stream error while receiving: %w", err)
+		}
+
+		aptosBlock := &pbaptos.Block{}
+		if err := response.Block.UnmarshalTo(aptosBlock); err != nil {
+			return fmt.Errorf("decoding block: %w", err)
+		}
+
+		block, err := types.BlockFromProto(aptosBlock)
+		if err != nil {
+			return fmt.Errorf("converting block %d: %w", aptosBlock.Height, err)
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	if len(blocks) == 0 {
+		return fmt.Errorf("no blocks received")
+	}
+
+	if first, last := blocks[0].Number, blocks[len(blocks)-1].Number; first != bundleRange.Start || last != bundleRange.Stop {
+		return fmt.Errorf("received blocks #%d to #%d, expected #%d to #%d", first, last, bundleRange.Start, bundleRange.Stop)
+	}
+
+	if err := verifyBlocksContinuity(blocks); err != nil {
+		return err
+	}
+
+	if err := writeBlocksFile(ctx, d.store, mergedBundleFilename(baseBlockNum), blocks, d.verify); err != nil {
+		return err
+	}
+
+	zlog.Info("wrote merged blocks file", zap.String("bundle", mergedBundleFilename(baseBlockNum)), zap.Int("block_count", len(blocks)))
+	return nil
+}
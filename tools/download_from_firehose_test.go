@@ -0,0 +1,187 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream/transform"
+	dauth "github.com/streamingfast/dauth/authenticator"
+	_ "github.com/streamingfast/dauth/authenticator/null"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/streamingfast/firehose/client"
+	"github.com/streamingfast/firehose/server"
+	sftools "github.com/streamingfast/sf-tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFirehoseDownloader_Download(t *testing.T) {
+	sourceStore := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, sourceStore, 0, 500, newTestBlock)
+	downloader, destinationStore := newTestFirehoseDownloader(t, sourceStore, 100)
+
+	require.NoError(t, downloader.Download(context.Background(), sftools.BlockRange{Start: 100, Stop: 399}, 2))
+
+	assertStoreBundles(t, destinationStore, []uint64{100, 200, 300})
+	assertBundleBlocks(t, destinationStore, 300, 300, 399)
+}
+
+func TestFirehoseDownloader_DownloadDifferentBundleSize(t *testing.T) {
+	sourceStore := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, sourceStore, 0, 300, newTestBlock)
+	downloader, destinationStore := newTestFirehoseDownloader(t, sourceStore, 50)
+
+	require.NoError(t, downloader.Download(context.Background(), sftools.BlockRange{Start: 0, Stop: 149}, 4))
+
+	assertStoreBundles(t, destinationStore, []uint64{0, 50, 100})
+	assertBundleBlocks(t, destinationStore, 50, 50, 99)
+}
+
+func TestFirehoseDownloader_Resume(t *testing.T) {
+	sourceStore := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, sourceStore, 0, 400, newTestBlock)
+	downloader, destinationStore := newTestFirehoseDownloader(t, sourceStore, 100)
+
+	// A bundle already present is considered complete and must be left untouched
+	require.NoError(t, destinationStore.WriteObject(context.Background(), mergedBundleFilename(100), bytes.NewBufferString("already there")))
+
+	require.NoError(t, downloader.Download(context.Background(), sftools.BlockRange{Start: 0, Stop: 299}, 2))
+
+	assertStoreBundles(t, destinationStore, []uint64{0, 100, 200})
+
+	reader, err := destinationStore.OpenObject(context.Background(), mergedBundleFilename(100))
+	require.NoError(t, err)
+	defer reader.Close()
+
+	content := bytes.NewBuffer(nil)
+	_, err = content.ReadFrom(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "already there", content.String())
+}
+
+func TestFirehoseDownloader_MissingBlocks(t *testing.T) {
+	sourceStore := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, sourceStore, 0, 200, newTestBlock)
+	downloader, _ := newTestFirehoseDownloader(t, sourceStore, 100)
+	downloader.maxRetries = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// Requesting a range the server does not have never completes, it must be reported as an error and not written
+	err := downloader.Download(ctx, sftools.BlockRange{Start: 100, Stop: 299}, 1)
+	require.Error(t, err)
+}
+
+func TestFirehoseDownloader_InvalidRange(t *testing.T) {
+	downloader := &firehoseDownloader{bundleSize: 100}
+
+	tests := []struct {
+		name          string
+		blockRange    sftools.BlockRange
+		expectedError string
+	}{
+		{"unbounded", sftools.BlockRange{Start: 100}, "range must be bounded, got start 100 without stop"},
+		{"unaligned start", sftools.BlockRange{Start: 150, Stop: 199}, "range start 150 must be a multiple of the bundle size 100"},
+		{"unaligned stop", sftools.BlockRange{Start: 100, Stop: 250}, "range stop 250 must be the last block of a bundle (a multiple of the bundle size 100 minus 1)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.EqualError(t, downloader.Download(context.Background(), test.blockRange, 1), test.expectedError)
+		})
+	}
+}
+
+func newTestFirehoseDownloader(t *testing.T, sourceStore dstore.Store, bundleSize uint64) (*firehoseDownloader, dstore.Store) {
+	t.Helper()
+
+	endpoint := newTestFirehoseServer(t, sourceStore)
+
+	firehoseClient, connClose, callOpts, err := client.NewFirehoseClient(endpoint, "", false, true)
+	require.NoError(t, err)
+	t.Cleanup(func() { connClose() })
+
+	destinationStore, err := dstore.NewDBinStore("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	return &firehoseDownloader{
+		client:     firehoseClient,
+		callOpts:   callOpts,
+		store:      destinationStore,
+		bundleSize: bundleSize,
+		maxRetries: 1,
+		retryDelay: 10 * time.Millisecond,
+		verify:     true,
+	}, destinationStore
+}
+
+// newTestFirehoseServer launches an in-process Firehose server serving blocks from the merged blocks
+// store and returns its plaintext endpoint.
+func newTestFirehoseServer(t *testing.T, mergedBlocksStore dstore.Store) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	endpoint := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	authenticator, err := dauth.New("null://")
+	require.NoError(t, err)
+
+	transformRegistry := transform.NewRegistry()
+	firehoseServer := server.New(
+		transformRegistry,
+		firehose.NewStreamFactory(mergedBlocksStore, nil, nil, transformRegistry),
+		nil,
+		zap.NewNop(),
+		authenticator,
+		func(context.Context) bool { return true },
+		endpoint,
+		nil,
+	)
+
+	go firehoseServer.Launch()
+	t.Cleanup(func() { firehoseServer.Shutdown(time.Second) })
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", endpoint)
+		if err != nil {
+			return false
+		}
+
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return endpoint
+}
+
+func assertStoreBundles(t *testing.T, store dstore.Store, expected []uint64) {
+	t.Helper()
+
+	bundles, err := listMergedBundles(context.Background(), store, sftools.BlockRange{}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, expected, bundles)
+}
+
+func assertBundleBlocks(t *testing.T, store dstore.Store, baseBlockNum uint64, expectedFirst, expectedLast uint64) {
+	t.Helper()
+
+	var heights []uint64
+	require.NoError(t, readMergedBundle(context.Background(), store, baseBlockNum, sftools.BlockRange{}, func(block *pbaptos.Block) error {
+		heights = append(heights, block.Height)
+		return nil
+	}))
+
+	require.NotEmpty(t, heights)
+	assert.Equal(t, expectedFirst, heights[0])
+	assert.Equal(t, expectedLast, heights[len(heights)-1])
+	assert.Len(t, heights, int(expectedLast-expectedFirst+1))
+}
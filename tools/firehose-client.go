@@ -1,10 +1,386 @@
 package tools
 
 import (
-	sftools "github.com/streamingfast/sf-tools"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mostynb/go-grpc-compression/zstd"
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/firehose-aptos/internal/aptosutil"
+	"github.com/streamingfast/firehose-aptos/internal/fileutil"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/streamingfast/firehose/client"
+	pbfirehose "github.com/streamingfast/pbgo/sf/firehose/v2"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
+var firehoseClientCmd = &cobra.Command{
+	Use:   "firehose-client {endpoint} {start} {stop}",
+	Short: "Streams Aptos blocks from a Firehose endpoint, printing block summaries, transactions or full blocks",
+	Long: string(cli.Description(`
+		Streams Aptos blocks from a Firehose endpoint, printing a summary line per block (default), each transaction
+		as a JSON line or each full block as a JSON line, see '--output'.
+
+		Transactions can be filtered client side by sender, event type and entry function. Filters of different
+		kinds must all match while multiple values of the same kind match if any of them matches. When at least
+		one filter is defined, blocks without any matching transaction are not printed.
+
+		When '--cursor-file' is provided, the cursor of the last received block is saved to it and the stream
+		resumes from it on the next invocation if the file exists.
+
+		Throughput and latency (time elapsed between the block's timestamp and its reception) are reported
+		periodically through the logger and once more when the stream completes.
+	`)),
+	Args: cobra.ExactArgs(3),
+	RunE: firehoseClientE,
+	Example: ExamplePrefixed("fireaptos tools firehose-client", `
+		localhost:18015 1000 2000 --plaintext
+		localhost:18015 -1 0 --plaintext --output transactions --sender 0x1 --cursor-file ./cursor.txt
+		localhost:18015 0 0 --plaintext --event-type 0x1::coin::DepositEvent --entry-function 0x1::coin::transfer
+	`),
+}
+
 func init() {
-	firehoseClientCmd := sftools.GetFirehoseClientCmd(zlog, tracer, nil)
 	Cmd.AddCommand(firehoseClientCmd)
+
+	firehoseClientCmd.Flags().StringP("api-token-env-var", "a", "FIREHOSE_API_TOKEN", "Look for a JWT in this environment variable to authenticate against endpoint")
+	firehoseClientCmd.Flags().String("compression", "none", "http compression: use either 'none', 'gzip' or 'zstd'")
+	firehoseClientCmd.Flags().String("cursor", "", "Send this cursor with the request, ignored if '--cursor-file' exists")
+	firehoseClientCmd.Flags().String("cursor-file", "", "Save the cursor of each received block to this file and resume from it if it exists")
+	firehoseClientCmd.Flags().BoolP("plaintext", "p", false, "Use plaintext connection to firehose")
+	firehoseClientCmd.Flags().BoolP("insecure", "k", false, "Skip SSL certificate validation when connecting to firehose")
+	firehoseClientCmd.Flags().Bool("final-blocks-only", false, "Only ask for final blocks")
+	firehoseClientCmd.Flags().StringP("output", "o", "summary", "Output format, one of 'summary' (one line per block), 'transactions' (one JSON line per transaction), 'json' (one JSON line per block) or 'none'")
+	firehoseClientCmd.Flags().StringSlice("sender", nil, "Only keep user transactions sent by one of these addresses")
+	firehoseClientCmd.Flags().StringSlice("event-type", nil, "Only keep transactions emitting an event of one of these types, generic type parameters are ignored if not specified (e.g. '0x1::coin::CoinStore')")
+	firehoseClientCmd.Flags().StringSlice("entry-function", nil, "Only keep user transactions calling one of these entry functions (e.g. '0x1::coin::transfer')")
+	firehoseClientCmd.Flags().Duration("stats-interval", 10*time.Second, "Interval at which throughput and latency statistics are reported, 0 to only report them at the end")
+}
+
+func firehoseClientE(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	endpoint := args[0]
+	start, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing start block num: %w", err)
+	}
+	stop, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing stop block num: %w", err)
+	}
+
+	output := mustGetString(cmd, "output")
+	switch output {
+	case "summary", "transactions", "json", "none":
+	default:
+		return fmt.Errorf("invalid value for output: only 'summary', 'transactions', 'json' or 'none' are accepted")
+	}
+
+	filter := newTransactionFilter(mustGetStringSlice(cmd, "sender"), mustGetStringSlice(cmd, "event-type"), mustGetStringSlice(cmd, "entry-function"))
+
+	cursorFile := mustGetString(cmd, "cursor-file")
+	cursor := mustGetString(cmd, "cursor")
+	if cursorFile != "" {
+		savedCursor, err := readCursorFile(cursorFile)
+		if err != nil {
+			return err
+		}
+
+		if savedCursor != "" {
+			zlog.Info("resuming from cursor file", zap.String("cursor_file", cursorFile))
+			cursor = savedCursor
+		}
+	}
+
+	firehoseClient, connClose, grpcCallOpts, err := client.NewFirehoseClient(endpoint, os.Getenv(mustGetString(cmd, "api-token-env-var")), mustGetBool(cmd, "insecure"), mustGetBool(cmd, "plaintext"))
+	if err != nil {
+		return err
+	}
+	defer connClose()
+
+	switch mustGetString(cmd, "compression") {
+	case "gzip":
+		grpcCallOpts = append(grpcCallOpts, grpc.UseCompressor(gzip.Name))
+	case "zstd":
+		grpcCallOpts = append(grpcCallOpts, grpc.UseCompressor(zstd.Name))
+	case "none":
+	default:
+		return fmt.Errorf("invalid value for compression: only 'gzip', 'zstd' or 'none' are accepted")
+	}
+
+	stream, err := firehoseClient.Blocks(ctx, &pbfirehose.Request{
+		StartBlockNum:   start,
+		StopBlockNum:    stop,
+		FinalBlocksOnly: mustGetBool(cmd, "final-blocks-only"),
+		Cursor:          cursor,
+	}, grpcCallOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to start blocks stream: %w", err)
+	}
+
+	logger := zlog
+	if meta, err := stream.Header(); err != nil {
+		logger.Warn("cannot read header")
+	} else if hosts := meta.Get("hostname"); len(hosts) != 0 {
+		logger = logger.With(zap.String("remote_hostname", hosts[0]))
+	}
+	logger.Info("connected")
+
+	stats := newStreamStats()
+	defer func() { logger.Info("stream statistics", stats.Fields()...) }()
+
+	statsInterval := mustGetDuration(cmd, "stats-interval")
+	lastReport := time.Now()
+
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return fmt.Errorf("stream error while receiving: %w", err)
+		}
+
+		block := &pbaptos.Block{}
+		if err := response.Block.UnmarshalTo(block); err != nil {
+			return fmt.Errorf("decoding block: %w", err)
+		}
+
+		stats.RecordBlock(block)
+
+		if err := printFirehoseBlock(block, response.Step, filter, output); err != nil {
+			return err
+		}
+
+		if cursorFile != "" {
+			if err := writeCursorFile(cursorFile, response.Cursor); err != nil {
+				return err
+			}
+		}
+
+		if statsInterval > 0 && time.Since(lastReport) >= statsInterval {
+			logger.Info("stream statistics", stats.Fields()...)
+			lastReport = time.Now()
+		}
+	}
+}
+
+func printFirehoseBlock(block *pbaptos.Block, step pbfirehose.ForkStep, filter *transactionFilter, output string) error {
+	transactions := block.Transactions
+	if !filter.IsEmpty() {
+		transactions = nil
+		for _, trx := range block.Transactions {
+			if filter.Matches(trx) {
+				transactions = append(transactions, trx)
+			}
+		}
+
+		if len(transactions) == 0 {
+			return nil
+		}
+	}
+
+	switch output {
+	case "summary":
+		matching := ""
+		if !filter.IsEmpty() {
+			matching = fmt.Sprintf(" (%d matching)", len(transactions))
+		}
+
+		fmt.Printf("%s Block #%d (%s) at %s with %d transactions%s, latency %s\n",
+			strings.TrimPrefix(step.String(), "STEP_"),
+			block.Height,
+			block.ID(),
+			block.Time(),
+			len(block.Transactions),
+			matching,
+			time.Since(block.Time()).Round(time.Millisecond),
+		)
+
+	case "transactions":
+		for _, trx := range transactions {
+			line, err := protojson.Marshal(trx)
+			if err != nil {
+				return fmt.Errorf("marshal transaction %d: %w", trx.Version, err)
+			}
+
+			fmt.Println(string(line))
+		}
+
+	case "json":
+		line, err := protojson.Marshal(block)
+		if err != nil {
+			return fmt.Errorf("marshal block %d: %w", block.Height, err)
+		}
+
+		fmt.Println(string(line))
+	}
+
+	return nil
+}
+
+// transactionFilter keeps transactions matching any of the configured values for each configured
+// kind of filter. An empty filter matches every transaction.
+type transactionFilter struct {
+	senders        map[string]bool
+	eventTypes     map[string]bool
+	entryFunctions map[string]bool
+}
+
+func newTransactionFilter(senders, eventTypes, entryFunctions []string) *transactionFilter {
+	filter := &transactionFilter{}
+
+	if len(senders) > 0 {
+		filter.senders = map[string]bool{}
+		for _, sender := range senders {
+			filter.senders[aptosutil.NormalizeAddress(sender)] = true
+		}
+	}
+
+	if len(eventTypes) > 0 {
+		filter.eventTypes = map[string]bool{}
+		for _, eventType := range eventTypes {
+			filter.eventTypes[aptosutil.NormalizeMoveID(eventType)] = true
+		}
+	}
+
+	if len(entryFunctions) > 0 {
+		filter.entryFunctions = map[string]bool{}
+		for _, entryFunction := range entryFunctions {
+			filter.entryFunctions[aptosutil.NormalizeMoveID(entryFunction)] = true
+		}
+	}
+
+	return filter
+}
+
+func (f *transactionFilter) IsEmpty() bool {
+	return f.senders == nil && f.eventTypes == nil && f.entryFunctions == nil
+}
+
+func (f *transactionFilter) Matches(trx *pbaptos.Transaction) bool {
+	if f.senders != nil {
+		// Non-user transactions have no sender, which must not match the `0x0` address
+		sender := transactionSender(trx)
+		if sender == "" || !f.senders[aptosutil.NormalizeAddress(sender)] {
+			return false
+		}
+	}
+
+	if f.entryFunctions != nil {
+		function := transactionEntryFunction(trx)
+		if function == nil || !f.entryFunctions[aptosutil.NormalizeMoveID(entryFunctionID(function))] {
+			return false
+		}
+	}
+
+	if f.eventTypes != nil && !f.matchesEventType(trx) {
+		return false
+	}
+
+	return true
+}
+
+func (f *transactionFilter) matchesEventType(trx *pbaptos.Transaction) bool {
+	for _, event := range transactionEvents(trx) {
+		eventType := aptosutil.NormalizeMoveID(event.TypeStr)
+		if f.eventTypes[eventType] {
+			return true
+		}
+
+		if genericStart := strings.Index(eventType, "<"); genericStart != -1 && f.eventTypes[eventType[:genericStart]] {
+			return true
+		}
+	}
+
+	return false
+}
+
+type streamStats struct {
+	startedAt        time.Time
+	blockCount       uint64
+	transactionCount uint64
+	lastBlock        uint64
+	totalLatency     time.Duration
+	minLatency       time.Duration
+	maxLatency       time.Duration
+	lastLatency      time.Duration
+}
+
+func newStreamStats() *streamStats {
+	return &streamStats{startedAt: time.Now()}
+}
+
+func (s *streamStats) RecordBlock(block *pbaptos.Block) {
+	latency := time.Since(block.Time())
+
+	if s.blockCount == 0 || latency < s.minLatency {
+		s.minLatency = latency
+	}
+	if latency > s.maxLatency {
+		s.maxLatency = latency
+	}
+
+	s.blockCount++
+	s.transactionCount += uint64(len(block.Transactions))
+	s.lastBlock = block.Height
+	s.totalLatency += latency
+	s.lastLatency = latency
+}
+
+func (s *streamStats) Fields() []zap.Field {
+	elapsed := time.Since(s.startedAt)
+
+	fields := []zap.Field{
+		zap.Uint64("block_count", s.blockCount),
+		zap.Uint64("transaction_count", s.transactionCount),
+		zap.Duration("elapsed", elapsed),
+		zap.Float64("blocks_per_second", float64(s.blockCount)/elapsed.Seconds()),
+		zap.Float64("transactions_per_second", float64(s.transactionCount)/elapsed.Seconds()),
+	}
+
+	if s.blockCount > 0 {
+		fields = append(fields,
+			zap.Uint64("last_block", s.lastBlock),
+			zap.Duration("last_latency", s.lastLatency),
+			zap.Duration("min_latency", s.minLatency),
+			zap.Duration("avg_latency", s.totalLatency/time.Duration(s.blockCount)),
+			zap.Duration("max_latency", s.maxLatency),
+		)
+	}
+
+	return fields
+}
+
+func readCursorFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", fmt.Errorf("read cursor file: %w", err)
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// writeCursorFile atomically replaces the cursor file content so that an interruption never leaves
+// a partially written cursor behind.
+func writeCursorFile(path string, cursor string) error {
+	if err := fileutil.WriteFileAtomically(path, []byte(cursor+"\n")); err != nil {
+		return fmt.Errorf("write cursor file: %w", err)
+	}
+
+	return nil
 }
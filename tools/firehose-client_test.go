@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"testing"
+
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionFilter_Matches(t *testing.T) {
+	user := newTestUserTransaction(2, "0x00A1", nil)
+	user.GetUser().Events = []*pbaptos.Event{
+		{TypeStr: "0x1::coin::WithdrawEvent"},
+		{TypeStr: "0x01::coin::CoinStore<0x1::aptos_coin::AptosCoin>"},
+	}
+
+	metadata := newTestBlock(1).Transactions[0]
+	metadata.GetBlockMetadata().Events = []*pbaptos.Event{{TypeStr: "0x1::block::NewBlockEvent"}}
+
+	tests := []struct {
+		name             string
+		senders          []string
+		eventTypes       []string
+		entryFunctions   []string
+		expectedUser     bool
+		expectedMetadata bool
+	}{
+		{"empty filter", nil, nil, nil, true, true},
+
+		{"sender", []string{"0xa1"}, nil, nil, true, false},
+		{"sender not normalized", []string{" 0x000A1"}, nil, nil, true, false},
+		{"sender any of", []string{"0xb2", "0xa1"}, nil, nil, true, false},
+		{"sender no match", []string{"0xb2"}, nil, nil, false, false},
+		{"sender zero address", []string{"0x0"}, nil, nil, false, false},
+
+		{"event type", nil, []string{"0x1::coin::WithdrawEvent"}, nil, true, false},
+		{"event type not normalized", nil, []string{"0x0001::coin::WithdrawEvent"}, nil, true, false},
+		{"event type any of", nil, []string{"0x1::coin::DepositEvent", "0x1::block::NewBlockEvent"}, nil, false, true},
+		{"event type without type parameters", nil, []string{"0x1::coin::CoinStore"}, nil, true, false},
+		{"event type with type parameters", nil, []string{"0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>"}, nil, true, false},
+		{"event type with other type parameters", nil, []string{"0x1::coin::CoinStore<0x2::other::Coin>"}, nil, false, false},
+		{"event type no match", nil, []string{"0x1::coin::DepositEvent"}, nil, false, false},
+
+		{"entry function", nil, nil, []string{"0x1::coin::transfer"}, true, false},
+		{"entry function not normalized", nil, nil, []string{"0x01::coin::transfer"}, true, false},
+		{"entry function no match", nil, nil, []string{"0x1::coin::mint"}, false, false},
+
+		{"all kinds matching", []string{"0xa1"}, []string{"0x1::coin::WithdrawEvent"}, []string{"0x1::coin::transfer"}, true, false},
+		{"one kind not matching", []string{"0xa1"}, []string{"0x1::block::NewBlockEvent"}, []string{"0x1::coin::transfer"}, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := newTransactionFilter(test.senders, test.eventTypes, test.entryFunctions)
+
+			assert.Equal(t, test.senders == nil && test.eventTypes == nil && test.entryFunctions == nil, filter.IsEmpty())
+			assert.Equal(t, test.expectedUser, filter.Matches(user), "user transaction")
+			assert.Equal(t, test.expectedMetadata, filter.Matches(metadata), "block metadata transaction")
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/types"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	pbtimestamp "github.com/streamingfast/firehose-aptos/types/pb/aptos/util/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEmptyStore(t *testing.T) dstore.Store {
+	t.Helper()
+
+	store, err := dstore.NewDBinStore("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	return store
+}
+
+// writeTestMergedBlocks writes the 100 blocks merged blocks files containing blocks `[start, stop[`,
+// each block being created by `newBlock`.
+func writeTestMergedBlocks(t *testing.T, store dstore.Store, start, stop uint64, newBlock func(height uint64) *pbaptos.Block) {
+	t.Helper()
+
+	for baseBlockNum := start; baseBlockNum < stop; baseBlockNum += 100 {
+		bundleStop := baseBlockNum + 100
+		if bundleStop > stop {
+			bundleStop = stop
+		}
+
+		require.NoError(t, writeBlocksFile(context.Background(), store, mergedBundleFilename(baseBlockNum), newTestBstreamBlocks(t, baseBlockNum, bundleStop, newBlock), false))
+	}
+}
+
+// newTestBstreamBlocks returns the blocks `[start, stop[`, each block being created by `newBlock`
+func newTestBstreamBlocks(t *testing.T, start, stop uint64, newBlock func(height uint64) *pbaptos.Block) (out []*bstream.Block) {
+	t.Helper()
+
+	for height := start; height < stop; height++ {
+		block, err := types.BlockFromProto(newBlock(height))
+		require.NoError(t, err)
+
+		out = append(out, block)
+	}
+
+	return out
+}
+
+func newTestBlock(height uint64) *pbaptos.Block {
+	return &pbaptos.Block{
+		Height:    height,
+		Timestamp: pbtimestamp.New(time.Date(2022, 10, 12, 0, 0, 0, 0, time.UTC).Add(time.Duration(height) * time.Second)),
+		ChainId:   4,
+		Transactions: []*pbaptos.Transaction{
+			{
+				Version:     height,
+				BlockHeight: height,
+				Type:        pbaptos.Transaction_BLOCK_METADATA,
+				TxnData:     &pbaptos.Transaction_BlockMetadata{BlockMetadata: &pbaptos.BlockMetadataTransaction{Round: height}},
+			},
+		},
+	}
+}
+
+func newTestUserTransaction(version uint64, sender string, secondarySigners []string) *pbaptos.Transaction {
+	request := &pbaptos.UserTransactionRequest{
+		Sender: sender,
+		Payload: &pbaptos.TransactionPayload{
+			Type: pbaptos.TransactionPayload_ENTRY_FUNCTION_PAYLOAD,
+			Payload: &pbaptos.TransactionPayload_EntryFunctionPayload{EntryFunctionPayload: &pbaptos.EntryFunctionPayload{
+				Function: &pbaptos.EntryFunctionId{Module: &pbaptos.MoveModuleId{Address: "0x1", Name: "coin"}, Name: "transfer"},
+			}},
+		},
+	}
+
+	if len(secondarySigners) > 0 {
+		request.Signature = &pbaptos.Signature{
+			Type: pbaptos.Signature_MULTI_AGENT,
+			Signature: &pbaptos.Signature_MultiAgent{MultiAgent: &pbaptos.MultiAgentSignature{
+				SecondarySignerAddresses: secondarySigners,
+			}},
+		}
+	}
+
+	return &pbaptos.Transaction{
+		Version:     version,
+		BlockHeight: version,
+		Type:        pbaptos.Transaction_USER,
+		Info:        &pbaptos.TransactionInfo{Success: true, GasUsed: 10},
+		TxnData:     &pbaptos.Transaction_User{User: &pbaptos.UserTransaction{Request: request}},
+	}
+}
@@ -0,0 +1,133 @@
+package tools
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	sftools "github.com/streamingfast/sf-tools"
+)
+
+// Flags of the `tools` sub-commands are read straight from the command instead of through `viper`.
+// The `viper` auto-binding is keyed by the flag's name only, so two sub-commands defining the same
+// flag name (like `--range` or `--store`) would otherwise end up reading each other's value.
+
+func mustGetString(cmd *cobra.Command, flagName string) string {
+	val, err := cmd.Flags().GetString(flagName)
+	if err != nil {
+		panic(fmt.Sprintf("flags: couldn't find flag %q", flagName))
+	}
+	return val
+}
+
+func mustGetStringSlice(cmd *cobra.Command, flagName string) []string {
+	val, err := cmd.Flags().GetStringSlice(flagName)
+	if err != nil {
+		panic(fmt.Sprintf("flags: couldn't find flag %q", flagName))
+	}
+	return val
+}
+
+func mustGetBool(cmd *cobra.Command, flagName string) bool {
+	val, err := cmd.Flags().GetBool(flagName)
+	if err != nil {
+		panic(fmt.Sprintf("flags: couldn't find flag %q", flagName))
+	}
+	return val
+}
+
+func mustGetInt(cmd *cobra.Command, flagName string) int {
+	val, err := cmd.Flags().GetInt(flagName)
+	if err != nil {
+		panic(fmt.Sprintf("flags: couldn't find flag %q", flagName))
+	}
+	return val
+}
+
+func mustGetUint64(cmd *cobra.Command, flagName string) uint64 {
+	val, err := cmd.Flags().GetUint64(flagName)
+	if err != nil {
+		panic(fmt.Sprintf("flags: couldn't find flag %q", flagName))
+	}
+	return val
+}
+
+func mustGetUint32(cmd *cobra.Command, flagName string) uint32 {
+	val, err := cmd.Flags().GetUint32(flagName)
+	if err != nil {
+		panic(fmt.Sprintf("flags: couldn't find flag %q", flagName))
+	}
+	return val
+}
+
+func mustGetFloat64(cmd *cobra.Command, flagName string) float64 {
+	val, err := cmd.Flags().GetFloat64(flagName)
+	if err != nil {
+		panic(fmt.Sprintf("flags: couldn't find flag %q", flagName))
+	}
+	return val
+}
+
+func mustGetDuration(cmd *cobra.Command, flagName string) time.Duration {
+	val, err := cmd.Flags().GetDuration(flagName)
+	if err != nil {
+		panic(fmt.Sprintf("flags: couldn't find flag %q", flagName))
+	}
+	return val
+}
+
+// getBlockRange reads a `<start>:<stop>` block range from the flag, both boundaries
+// are optional, a missing `<stop>` meaning an unbounded range.
+func getBlockRange(cmd *cobra.Command, flagName string) (out sftools.BlockRange, err error) {
+	return parseBlockRange(mustGetString(cmd, flagName))
+}
+
+func parseBlockRange(in string) (out sftools.BlockRange, err error) {
+	in = strings.TrimSpace(in)
+	if in == "" {
+		return
+	}
+
+	parts := strings.SplitN(in, ":", 2)
+	if len(parts) != 2 {
+		return out, fmt.Errorf("invalid range %q, not matching format `<start>:<stop>`", in)
+	}
+
+	if out.Start, err = parseBlockNum("start", parts[0]); err != nil {
+		return
+	}
+
+	if out.Stop, err = parseBlockNum("stop", parts[1]); err != nil {
+		return
+	}
+
+	if !out.Unbounded() && out.Stop < out.Start {
+		return out, fmt.Errorf("invalid range %q, `<stop>` must be greater or equal to `<start>`", in)
+	}
+
+	return
+}
+
+func parseBlockNum(tag string, in string) (uint64, error) {
+	trimmed := strings.ReplaceAll(strings.TrimSpace(in), " ", "")
+	if trimmed == "" {
+		return 0, nil
+	}
+
+	out, err := strconv.ParseUint(trimmed, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("`<%s>` value %q is not a valid integer", tag, in)
+	}
+
+	return out, nil
+}
+
+func inBlockRange(blockRange sftools.BlockRange, blockNum uint64) bool {
+	if blockNum < blockRange.Start {
+		return false
+	}
+
+	return blockRange.Unbounded() || blockNum <= blockRange.Stop
+}
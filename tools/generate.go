@@ -0,0 +1,238 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/firehose-aptos/codec/synthetic"
+	"go.uber.org/zap"
+)
+
+var generateCmd = &cobra.Command{Use: "generate", Short: "Various utilities to generate synthetic data for testing purposes"}
+
+var generateFirelogsCmd = &cobra.Command{
+	Use:   "firelogs",
+	Short: "Generates a synthetic stream of 'FIRE' logs as emitted by the instrumented aptos-node",
+	Long: string(cli.Description(`
+		Generates a synthetic stream of 'FIRE INIT', 'FIRE BLOCK_START', 'FIRE TRX' and 'FIRE BLOCK_END' lines
+		as emitted by the instrumented aptos-node, at a configurable rate. Piped into 'fireaptos start reader-node-stdin'
+		or the codec bench harness, it allows soak testing the whole reading pipeline without any running node.
+
+		Generated blocks always start with a block metadata transaction followed by a random amount of user,
+		state checkpoint and module publish transactions, in the proportion defined by '--mix'. Faults can be
+		injected in each block with the '--fault-*' flags, each defining the probability (between 0 and 1)
+		of the fault happening in a given block.
+
+		The output is deterministic for a given '--seed' and set of flags.
+	`)),
+	Args: cobra.NoArgs,
+	RunE: generateFirelogsE,
+	Example: ExamplePrefixed("fireaptos tools generate firelogs", `
+		--block-count 1000 --rate 0 -o /tmp/firelogs.log
+		--rate 4 --mix user=60,checkpoint=20,module-publish=20 | fireaptos start reader-node-stdin
+		--fault-gap 0.01 --fault-bad-base64 0.01 --fault-truncated-line 0.01 --fault-duplicate-init 0.001
+	`),
+}
+
+func init() {
+	Cmd.AddCommand(generateCmd)
+	generateCmd.AddCommand(generateFirelogsCmd)
+
+	defaults := synthetic.DefaultOptions()
+
+	generateFirelogsCmd.Flags().StringP("output", "o", "-", "File to write the generated logs to, '-' meaning standard output")
+	generateFirelogsCmd.Flags().Uint64("block-count", 0, "Amount of blocks to generate, 0 meaning generate until interrupted")
+	generateFirelogsCmd.Flags().Float64("rate", 4, "Amount of blocks generated per second, up to 1000000000, 0 meaning as fast as possible")
+	generateFirelogsCmd.Flags().Uint64("start-block", defaults.StartBlock, "Height of the first generated block")
+	generateFirelogsCmd.Flags().Bool("start-block-from-env", false, "Read the height of the first generated block from the 'STARTING_BLOCK' environment variable set by the reader node when defined, so the generator can stand in for aptos-node as 'reader-node-path'")
+	generateFirelogsCmd.Flags().Uint64("start-version", defaults.StartVersion, "Version of the first generated transaction")
+	generateFirelogsCmd.Flags().String("start-time", defaults.StartTime.Format(time.RFC3339), "Timestamp of the first generated block, in RFC3339 format")
+	generateFirelogsCmd.Flags().Duration("block-interval", defaults.BlockInterval, "Time elapsed between the timestamp of two generated blocks")
+	generateFirelogsCmd.Flags().Uint32("chain-id", defaults.ChainID, "Chain ID emitted in the 'FIRE INIT' line")
+	generateFirelogsCmd.Flags().Int64("seed", defaults.Seed, "Seed of the random generator")
+	generateFirelogsCmd.Flags().String("transactions", fmt.Sprintf("%d:%d", defaults.MinTransactions, defaults.MaxTransactions), "Amount of transactions per block (on top of the block metadata transaction), in the form '<min>:<max>'")
+	generateFirelogsCmd.Flags().String("payload-size", fmt.Sprintf("%d:%d", defaults.MinPayloadSize, defaults.MaxPayloadSize), "Size in bytes of the random user transaction arguments and published module bytecode, in the form '<min>:<max>'")
+	generateFirelogsCmd.Flags().String("mix", fmt.Sprintf("user=%d,checkpoint=%d,module-publish=%d", defaults.UserWeight, defaults.CheckpointWeight, defaults.ModulePublishWeight), "Relative weight of each kind of transaction, kinds not listed have a weight of 0")
+	generateFirelogsCmd.Flags().Int("accounts", defaults.AccountCount, "Amount of distinct accounts used as transaction senders and receivers")
+	generateFirelogsCmd.Flags().Int("noise-lines", 0, "Amount of regular aptos-node log lines interleaved within each block")
+	generateFirelogsCmd.Flags().Float64("fault-gap", 0, "Probability of skipping a block height")
+	generateFirelogsCmd.Flags().Float64("fault-truncated-line", 0, "Probability of truncating a 'FIRE TRX' line of the block")
+	generateFirelogsCmd.Flags().Float64("fault-bad-base64", 0, "Probability of corrupting the base64 payload of a 'FIRE TRX' line of the block")
+	generateFirelogsCmd.Flags().Float64("fault-duplicate-init", 0, "Probability of emitting a 'FIRE INIT' line again before the block")
+}
+
+func generateFirelogsE(cmd *cobra.Command, args []string) error {
+	options, err := generatorOptionsFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	rate := mustGetFloat64(cmd, "rate")
+	if err := validateGenerateRate(rate); err != nil {
+		return err
+	}
+
+	generator, err := synthetic.NewGenerator(options)
+	if err != nil {
+		return fmt.Errorf("invalid generator options: %w", err)
+	}
+
+	var output io.Writer = os.Stdout
+	if file := mustGetString(cmd, "output"); file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return fmt.Errorf("create output file: %w", err)
+		}
+		defer f.Close()
+
+		output = f
+	}
+
+	writer := bufio.NewWriter(output)
+
+	// When throttled, flush after each block so consumers see them as soon as they are generated
+	var blockWriter io.Writer = writer
+	if rate > 0 {
+		blockWriter = flushingWriter{writer}
+	}
+
+	zlog.Info("generating synthetic firelogs",
+		zap.Uint64("start_block", options.StartBlock),
+		zap.Uint64("block_count", mustGetUint64(cmd, "block-count")),
+		zap.Float64("rate", rate),
+	)
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = generator.Run(ctx, blockWriter, mustGetUint64(cmd, "block-count"), rate)
+	if errors.Is(err, context.Canceled) {
+		zlog.Info("generation interrupted", zap.Uint64("next_block", generator.NextBlock()))
+		err = nil
+	}
+
+	if flushErr := writer.Flush(); err == nil && flushErr != nil {
+		err = fmt.Errorf("flush output: %w", flushErr)
+	}
+
+	return err
+}
+
+func generatorOptionsFromFlags(cmd *cobra.Command) (options synthetic.Options, err error) {
+	options = synthetic.DefaultOptions()
+	options.StartBlock = mustGetUint64(cmd, "start-block")
+	if startingBlock := os.Getenv("STARTING_BLOCK"); startingBlock != "" && mustGetBool(cmd, "start-block-from-env") {
+		if options.StartBlock, err = strconv.ParseUint(startingBlock, 10, 64); err != nil {
+			return options, fmt.Errorf("invalid STARTING_BLOCK environment variable %q: %w", startingBlock, err)
+		}
+	}
+
+	options.StartVersion = mustGetUint64(cmd, "start-version")
+	options.BlockInterval = mustGetDuration(cmd, "block-interval")
+	options.ChainID = mustGetUint32(cmd, "chain-id")
+	options.AccountCount = mustGetInt(cmd, "accounts")
+	options.NoiseLines = mustGetInt(cmd, "noise-lines")
+
+	if options.Seed, err = cmd.Flags().GetInt64("seed"); err != nil {
+		return options, fmt.Errorf("invalid seed: %w", err)
+	}
+
+	if options.StartTime, err = time.Parse(time.RFC3339, mustGetString(cmd, "start-time")); err != nil {
+		return options, fmt.Errorf("invalid start time: %w", err)
+	}
+
+	if options.MinTransactions, options.MaxTransactions, err = parseIntBounds(mustGetString(cmd, "transactions")); err != nil {
+		return options, fmt.Errorf("invalid transactions: %w", err)
+	}
+
+	if options.MinPayloadSize, options.MaxPayloadSize, err = parseIntBounds(mustGetString(cmd, "payload-size")); err != nil {
+		return options, fmt.Errorf("invalid payload size: %w", err)
+	}
+
+	options.UserWeight, options.CheckpointWeight, options.ModulePublishWeight = 0, 0, 0
+	for _, entry := range strings.Split(mustGetString(cmd, "mix"), ",") {
+		kind, weightString, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			return options, fmt.Errorf("invalid mix entry %q, expecting '<kind>=<weight>'", entry)
+		}
+
+		weight, err := strconv.Atoi(weightString)
+		if err != nil {
+			return options, fmt.Errorf("invalid mix entry %q weight: %w", entry, err)
+		}
+
+		switch kind {
+		case "user":
+			options.UserWeight = weight
+		case "checkpoint":
+			options.CheckpointWeight = weight
+		case "module-publish":
+			options.ModulePublishWeight = weight
+		default:
+			return options, fmt.Errorf("invalid mix entry %q, kind must be one of 'user', 'checkpoint' or 'module-publish'", entry)
+		}
+	}
+
+	options.Faults = synthetic.Faults{
+		Gap:           mustGetFloat64(cmd, "fault-gap"),
+		TruncatedLine: mustGetFloat64(cmd, "fault-truncated-line"),
+		BadBase64:     mustGetFloat64(cmd, "fault-bad-base64"),
+		DuplicateInit: mustGetFloat64(cmd, "fault-duplicate-init"),
+	}
+
+	return options, nil
+}
+
+// maxGenerateRate is the highest rate the generator can be throttled to, one block per nanosecond
+const maxGenerateRate = float64(time.Second)
+
+func validateGenerateRate(rate float64) error {
+	if math.IsNaN(rate) || rate < 0 || rate > maxGenerateRate {
+		return fmt.Errorf("invalid rate %v, must be between 0 and %.0f blocks per second", rate, maxGenerateRate)
+	}
+
+	return nil
+}
+
+// parseIntBounds parses a `<min>:<max>` pair, a single value meaning both bounds are equal.
+func parseIntBounds(in string) (min int, max int, err error) {
+	minString, maxString, found := strings.Cut(in, ":")
+	if !found {
+		maxString = minString
+	}
+
+	if min, err = strconv.Atoi(strings.TrimSpace(minString)); err != nil {
+		return 0, 0, fmt.Errorf("invalid min value %q: %w", minString, err)
+	}
+
+	if max, err = strconv.Atoi(strings.TrimSpace(maxString)); err != nil {
+		return 0, 0, fmt.Errorf("invalid max value %q: %w", maxString, err)
+	}
+
+	return min, max, nil
+}
+
+type flushingWriter struct {
+	*bufio.Writer
+}
+
+func (w flushingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, w.Writer.Flush()
+}
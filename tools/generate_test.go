@@ -0,0 +1,33 @@
+package tools
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateGenerateRate(t *testing.T) {
+	tests := []struct {
+		rate        float64
+		expectedErr bool
+	}{
+		{0, false},
+		{0.5, false},
+		{4, false},
+		{1e9, false},
+		{1e9 + 1, true},
+		{-1, true},
+		{math.Inf(1), true},
+		{math.NaN(), true},
+	}
+
+	for _, test := range tests {
+		err := validateGenerateRate(test.rate)
+		if test.expectedErr {
+			assert.ErrorContains(t, err, "invalid rate", "rate %v", test.rate)
+		} else {
+			assert.NoError(t, err, "rate %v", test.rate)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/dstore"
+	sftools "github.com/streamingfast/sf-tools"
+	"go.uber.org/zap"
+)
+
+var mergeOneBlocksCmd = &cobra.Command{
+	Use:   "merge-one-blocks {one-block-store-url} {merged-blocks-store-url}",
+	Short: "Merges the one-block files of a bounded range into merged blocks files, like the merger app does",
+	Long: string(cli.Description(`
+		Merges the one-block files of the range into merged blocks files, for example those produced by readers
+		re-processing a range of history with distinct one-block suffixes. Every block of the range must have
+		a one-block file, the blocks of each bundle must follow each other and one-block files of the same block
+		written by different readers must agree on the block's ID.
+
+		The range must be bounded, start on a bundle boundary (or on the first streamable block) and stop on the
+		last block of a bundle. The one-block files are left untouched.
+	`)),
+	Args: cobra.ExactArgs(2),
+	RunE: mergeOneBlocksE,
+	Example: ExamplePrefixed("fireaptos tools merge-one-blocks", `
+		"./firehose-data/storage/one-blocks" "./firehose-data/storage/merged-blocks" --range 0:99999
+	`),
+}
+
+func init() {
+	Cmd.AddCommand(mergeOneBlocksCmd)
+
+	mergeOneBlocksCmd.Flags().StringP("range", "r", "", "Block range to merge, in the form '<start>:<stop>' (inclusive)")
+	mergeOneBlocksCmd.Flags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks to put in each merged blocks file")
+	mergeOneBlocksCmd.Flags().Int("workers", 4, "Amount of merged blocks files written concurrently")
+	mergeOneBlocksCmd.Flags().Bool("verify", true, "Read back each written file and ensure it contains exactly the blocks that were written")
+	mergeOneBlocksCmd.Flags().Bool("overwrite", false, "Overwrite merged blocks files already present in the destination store")
+}
+
+func mergeOneBlocksE(cmd *cobra.Command, args []string) error {
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	oneBlockStore, err := dstore.NewDBinStore(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to create one-block store at path %q: %w", args[0], err)
+	}
+
+	mergedBlocksStore, err := dstore.NewDBinStore(args[1])
+	if err != nil {
+		return fmt.Errorf("unable to create merged blocks store at path %q: %w", args[1], err)
+	}
+	mergedBlocksStore.SetOverwrite(mustGetBool(cmd, "overwrite"))
+
+	return MergeOneBlocks(cmd.Context(), oneBlockStore, mergedBlocksStore, blockRange, mustGetUint64(cmd, "bundle-size"), mustGetInt(cmd, "workers"), mustGetBool(cmd, "verify"))
+}
+
+// MergeOneBlocks merges the one-block files of `oneBlockStore` into merged blocks files of `bundleSize`
+// blocks written to `mergedBlocksStore`, for each bundle of the block range, using up to `workerCount`
+// concurrent workers.
+func MergeOneBlocks(ctx context.Context, oneBlockStore, mergedBlocksStore dstore.Store, blockRange sftools.BlockRange, bundleSize uint64, workerCount int, verify bool) error {
+	if err := ValidateMergeRange(blockRange, bundleSize); err != nil {
+		return err
+	}
+
+	var bundles []uint64
+	for baseBlockNum := blockRange.Start - (blockRange.Start % bundleSize); baseBlockNum <= blockRange.Stop; baseBlockNum += bundleSize {
+		bundles = append(bundles, baseBlockNum)
+	}
+
+	zlog.Info("merging one-block files",
+		zap.Stringer("range", blockRange),
+		zap.Uint64("bundle_size", bundleSize),
+		zap.Int("bundle_count", len(bundles)),
+	)
+
+	return processInParallel(ctx, bundles, workerCount, func(ctx context.Context, baseBlockNum uint64) error {
+		bundleRange := sftools.BlockRange{Start: baseBlockNum, Stop: baseBlockNum + bundleSize - 1}
+		if bundleRange.Start < blockRange.Start {
+			bundleRange.Start = blockRange.Start
+		}
+
+		blocks, err := readOneBlocks(ctx, oneBlockStore, bundleRange)
+		if err != nil {
+			return fmt.Errorf("bundle %s: %w", mergedBundleFilename(baseBlockNum), err)
+		}
+
+		if err := verifyBlocksContinuity(blocks); err != nil {
+			return fmt.Errorf("bundle %s: %w", mergedBundleFilename(baseBlockNum), err)
+		}
+
+		if err := writeBlocksFile(ctx, mergedBlocksStore, mergedBundleFilename(baseBlockNum), blocks, verify); err != nil {
+			return err
+		}
+
+		zlog.Info("wrote merged blocks file", zap.String("bundle", mergedBundleFilename(baseBlockNum)), zap.Int("block_count", len(blocks)))
+		return nil
+	})
+}
+
+// ValidateMergeRange checks that the block range can be merged into bundles of `bundleSize` blocks:
+// it must be bounded, start on a bundle boundary (or on the first streamable block) and stop on the
+// last block of a bundle.
+func ValidateMergeRange(blockRange sftools.BlockRange, bundleSize uint64) error {
+	if bundleSize == 0 {
+		return fmt.Errorf("invalid bundle size 0, must be greater than 0")
+	}
+
+	if blockRange.Unbounded() {
+		return fmt.Errorf("range must be bounded, got start %d without stop", blockRange.Start)
+	}
+
+	if blockRange.Stop < blockRange.Start {
+		return fmt.Errorf("range stop %d must be greater or equal to range start %d", blockRange.Stop, blockRange.Start)
+	}
+
+	if blockRange.Start%bundleSize != 0 && blockRange.Start != bstream.GetProtocolFirstStreamableBlock {
+		return fmt.Errorf("range start %d must be a multiple of the bundle size %d", blockRange.Start, bundleSize)
+	}
+
+	if (blockRange.Stop+1)%bundleSize != 0 {
+		return fmt.Errorf("range stop %d must be the last block of a bundle (a multiple of the bundle size %d minus 1)", blockRange.Stop, bundleSize)
+	}
+
+	return nil
+}
+
+// readOneBlocks reads the blocks of the bounded block range from their one-block files, failing
+// if any is missing or if one-block files of the same block disagree on its ID.
+func readOneBlocks(ctx context.Context, store dstore.Store, blockRange sftools.BlockRange) ([]*bstream.Block, error) {
+	filenames := map[uint64][]string{}
+	err := store.Walk(ctx, oneBlocksWalkPrefix(blockRange), func(filename string) error {
+		blockNum, _, _, _, _, err := bstream.ParseFilename(filename)
+		if err != nil {
+			zlog.Debug("skipping file not looking like a one-block file", zap.String("filename", filename))
+			return nil
+		}
+
+		if inBlockRange(blockRange, blockNum) {
+			filenames[blockNum] = append(filenames[blockNum], filename)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking one-block store: %w", err)
+	}
+
+	blocks := make([]*bstream.Block, 0, blockRange.Stop-blockRange.Start+1)
+	for blockNum := blockRange.Start; blockNum <= blockRange.Stop; blockNum++ {
+		candidates := filenames[blockNum]
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("missing one-block file for block #%d", blockNum)
+		}
+
+		sort.Strings(candidates)
+		for _, candidate := range candidates[1:] {
+			if !sameOneBlock(candidates[0], candidate) {
+				return nil, fmt.Errorf("one-block files %q and %q of block #%d do not agree on the block's ID", candidates[0], candidate, blockNum)
+			}
+		}
+
+		block, err := readOneBlockFile(ctx, store, candidates[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if block.Number != blockNum {
+			return nil, fmt.Errorf("one-block file %q contains block %s, expected block #%d", candidates[0], block.AsRef(), blockNum)
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+func readOneBlockFile(ctx context.Context, store dstore.Store, filename string) (*bstream.Block, error) {
+	reader, err := store.OpenObject(ctx, filename)
+	if err != nil {
+		return nil, fmt.Errorf("open one-block file %q: %w", filename, err)
+	}
+	defer reader.Close()
+
+	blockReader, err := bstream.GetBlockReaderFactory.New(reader)
+	if err != nil {
+		return nil, fmt.Errorf("new block reader for one-block file %q: %w", filename, err)
+	}
+
+	block, err := blockReader.Read()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read one-block file %q: %w", filename, err)
+	}
+
+	if block == nil {
+		return nil, fmt.Errorf("one-block file %q contains no block", filename)
+	}
+
+	return block, nil
+}
+
+// sameOneBlock returns true when both one-block filenames are of the same block, only their
+// suffix being different.
+func sameOneBlock(left, right string) bool {
+	_, _, _, _, leftName, leftErr := bstream.ParseFilename(left)
+	_, _, _, _, rightName, rightErr := bstream.ParseFilename(right)
+
+	return leftErr == nil && rightErr == nil && leftName == rightName
+}
+
+// oneBlocksWalkPrefix returns the longest filename prefix shared by the one-block files of all
+// blocks of the bounded block range.
+func oneBlocksWalkPrefix(blockRange sftools.BlockRange) string {
+	start, stop := fmt.Sprintf("%010d", blockRange.Start), fmt.Sprintf("%010d", blockRange.Stop)
+
+	i := 0
+	for i < len(start) && i < len(stop) && start[i] == stop[i] {
+		i++
+	}
+
+	return start[:i]
+}
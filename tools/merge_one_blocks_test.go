@@ -0,0 +1,85 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/types"
+	sftools "github.com/streamingfast/sf-tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeOneBlocks(t *testing.T) {
+	oneBlockStore := newTestEmptyStore(t)
+	writeTestOneBlocks(t, oneBlockStore, 0, 300, "reader0", nil)
+	// Readers with overlapping segments write the same blocks with their own suffix
+	writeTestOneBlocks(t, oneBlockStore, 180, 220, "reader1", nil)
+
+	mergedBlocksStore := newTestEmptyStore(t)
+	require.NoError(t, MergeOneBlocks(context.Background(), oneBlockStore, mergedBlocksStore, sftools.BlockRange{Start: 0, Stop: 199}, 100, 2, true))
+
+	assertStoreBundles(t, mergedBlocksStore, []uint64{0, 100})
+	assertBundleBlocks(t, mergedBlocksStore, 0, 0, 99)
+	assertBundleBlocks(t, mergedBlocksStore, 100, 100, 199)
+}
+
+func TestMergeOneBlocks_MissingBlock(t *testing.T) {
+	oneBlockStore := newTestEmptyStore(t)
+	writeTestOneBlocks(t, oneBlockStore, 0, 150, "reader0", nil)
+	writeTestOneBlocks(t, oneBlockStore, 151, 200, "reader0", nil)
+
+	err := MergeOneBlocks(context.Background(), oneBlockStore, newTestEmptyStore(t), sftools.BlockRange{Start: 0, Stop: 199}, 100, 1, false)
+	assert.EqualError(t, err, "bundle 0000000100: missing one-block file for block #150")
+}
+
+func TestMergeOneBlocks_ConflictingBlocks(t *testing.T) {
+	oneBlockStore := newTestEmptyStore(t)
+	writeTestOneBlocks(t, oneBlockStore, 0, 100, "reader0", nil)
+	writeTestOneBlocks(t, oneBlockStore, 42, 43, "reader1", func(block *bstream.Block) {
+		block.Id = "00000000000000000000000000000000000000000000000000000000deadbeef"
+	})
+
+	err := MergeOneBlocks(context.Background(), oneBlockStore, newTestEmptyStore(t), sftools.BlockRange{Start: 0, Stop: 99}, 100, 1, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "of block #42 do not agree on the block's ID")
+}
+
+func TestMergeOneBlocks_InvalidRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		blockRange    sftools.BlockRange
+		expectedError string
+	}{
+		{"unbounded", sftools.BlockRange{Start: 0}, "range must be bounded, got start 0 without stop"},
+		{"stop before start", sftools.BlockRange{Start: 200, Stop: 99}, "range stop 99 must be greater or equal to range start 200"},
+		{"unaligned start", sftools.BlockRange{Start: 50, Stop: 199}, "range start 50 must be a multiple of the bundle size 100"},
+		{"unaligned stop", sftools.BlockRange{Start: 0, Stop: 150}, "range stop 150 must be the last block of a bundle (a multiple of the bundle size 100 minus 1)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := MergeOneBlocks(context.Background(), newTestEmptyStore(t), newTestEmptyStore(t), test.blockRange, 100, 1, false)
+			assert.EqualError(t, err, test.expectedError)
+		})
+	}
+}
+
+// writeTestOneBlocks writes the one-block files of blocks `[start, stop[` with the given suffix,
+// `alter` (when non-nil) being applied to each block before it's written.
+func writeTestOneBlocks(t *testing.T, store dstore.Store, start, stop uint64, suffix string, alter func(block *bstream.Block)) {
+	t.Helper()
+
+	for height := start; height < stop; height++ {
+		block, err := types.BlockFromProto(newTestBlock(height))
+		require.NoError(t, err)
+
+		if alter != nil {
+			alter(block)
+		}
+
+		require.NoError(t, writeBlocksFile(context.Background(), store, bstream.BlockFileNameWithSuffix(block, suffix), []*bstream.Block{block}, false))
+	}
+}
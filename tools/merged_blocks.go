@@ -0,0 +1,207 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	sftools "github.com/streamingfast/sf-tools"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultBundleSize is the amount of blocks contained in a single merged blocks file as produced
+// by the merger app.
+const defaultBundleSize uint64 = 100
+
+func mergedBundleFilename(baseBlockNum uint64) string {
+	return fmt.Sprintf("%010d", baseBlockNum)
+}
+
+// listMergedBundles returns the base block number of all merged blocks files found in the store
+// that overlaps with the received block range, sorted in ascending order.
+func listMergedBundles(ctx context.Context, store dstore.Store, blockRange sftools.BlockRange, bundleSize uint64) (out []uint64, err error) {
+	lowBundle := blockRange.Start - (blockRange.Start % bundleSize)
+
+	err = store.Walk(ctx, "", func(filename string) error {
+		baseBlockNum, err := strconv.ParseUint(filename, 10, 64)
+		if err != nil {
+			zlog.Debug("skipping file not looking like a merged blocks file", zap.String("filename", filename))
+			return nil
+		}
+
+		if baseBlockNum < lowBundle {
+			return nil
+		}
+
+		if !blockRange.Unbounded() && baseBlockNum > blockRange.Stop {
+			return dstore.StopIteration
+		}
+
+		out = append(out, baseBlockNum)
+		return nil
+	})
+	if err != nil && err != dstore.StopIteration {
+		return nil, fmt.Errorf("walking merged blocks store: %w", err)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, nil
+}
+
+// readMergedBundle reads the merged blocks file starting at `baseBlockNum` and calls `onBlock` for
+// each block it contains that is part of the received block range.
+func readMergedBundle(ctx context.Context, store dstore.Store, baseBlockNum uint64, blockRange sftools.BlockRange, onBlock func(block *pbaptos.Block) error) error {
+	return readMergedBundleBlocks(ctx, store, baseBlockNum, blockRange, func(block *bstream.Block) error {
+		return onBlock(block.ToProtocol().(*pbaptos.Block))
+	})
+}
+
+// readMergedBundleBlocks is like `readMergedBundle` but gives back the undecoded `bstream.Block`.
+func readMergedBundleBlocks(ctx context.Context, store dstore.Store, baseBlockNum uint64, blockRange sftools.BlockRange, onBlock func(block *bstream.Block) error) error {
+	filename := mergedBundleFilename(baseBlockNum)
+	reader, err := store.OpenObject(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("open merged blocks file %q: %w", filename, err)
+	}
+	defer reader.Close()
+
+	blockReader, err := bstream.GetBlockReaderFactory.New(reader)
+	if err != nil {
+		return fmt.Errorf("new block reader for %q: %w", filename, err)
+	}
+
+	for {
+		block, err := blockReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return fmt.Errorf("read block from %q: %w", filename, err)
+		}
+
+		if !inBlockRange(blockRange, block.Number) {
+			continue
+		}
+
+		if err := onBlock(block); err != nil {
+			return err
+		}
+	}
+}
+
+// processMergedBundles calls `processBundle` for each merged blocks file overlapping the block range,
+// using up to `workerCount` concurrent workers. The first error returned by `processBundle` stops the
+// processing and is returned.
+func processMergedBundles(
+	ctx context.Context,
+	store dstore.Store,
+	blockRange sftools.BlockRange,
+	bundleSize uint64,
+	workerCount int,
+	processBundle func(ctx context.Context, baseBlockNum uint64) error,
+) error {
+	bundles, err := listMergedBundles(ctx, store, blockRange, bundleSize)
+	if err != nil {
+		return err
+	}
+
+	zlog.Info("processing merged blocks files",
+		zap.Stringer("range", blockRange),
+		zap.Int("bundle_count", len(bundles)),
+		zap.Int("worker_count", workerCount),
+	)
+
+	return processInParallel(ctx, bundles, workerCount, func(ctx context.Context, baseBlockNum uint64) error {
+		if err := processBundle(ctx, baseBlockNum); err != nil {
+			return fmt.Errorf("processing bundle %s: %w", mergedBundleFilename(baseBlockNum), err)
+		}
+
+		return nil
+	})
+}
+
+// blockApplier is implemented by the stores built by applying blocks in order, like the resource
+// state, the module registry and the table tracker.
+type blockApplier interface {
+	LastBlock() (height uint64, ok bool)
+	ApplyBlock(block *pbaptos.Block) error
+}
+
+// applyMergedBlocks applies the blocks of the range to the applier, in order, starting after the last
+// block it applied if any.
+func applyMergedBlocks(ctx context.Context, store dstore.Store, applier blockApplier, blockRange sftools.BlockRange, bundleSize uint64) error {
+	if lastBlock, ok := applier.LastBlock(); ok {
+		if !blockRange.Unbounded() && lastBlock >= blockRange.Stop {
+			zlog.Info("blocks already applied up to range stop", zap.Uint64("last_block", lastBlock))
+			return nil
+		}
+
+		zlog.Info("resuming after last applied block", zap.Uint64("last_block", lastBlock))
+		blockRange.Start = lastBlock + 1
+	}
+
+	bundles, err := listMergedBundles(ctx, store, blockRange, bundleSize)
+	if err != nil {
+		return err
+	}
+
+	for _, baseBlockNum := range bundles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := readMergedBundle(ctx, store, baseBlockNum, blockRange, applier.ApplyBlock); err != nil {
+			return fmt.Errorf("applying bundle %s: %w", mergedBundleFilename(baseBlockNum), err)
+		}
+
+		zlog.Debug("applied bundle", zap.String("bundle", mergedBundleFilename(baseBlockNum)))
+	}
+
+	return nil
+}
+
+// processInParallel calls `process` for each item using up to `workerCount` concurrent workers, the
+// first error returned by `process` cancels the context and is returned.
+func processInParallel[T any](ctx context.Context, items []T, workerCount int, process func(ctx context.Context, item T) error) error {
+	if workerCount <= 0 {
+		workerCount = 1
+	}
+
+	group, ctx := errgroup.WithContext(ctx)
+	work := make(chan T)
+
+	for i := 0; i < workerCount; i++ {
+		group.Go(func() error {
+			for item := range work {
+				if err := process(ctx, item); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	group.Go(func() error {
+		defer close(work)
+
+		for _, item := range items {
+			select {
+			case work <- item:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return group.Wait()
+}
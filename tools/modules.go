@@ -0,0 +1,324 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/internal/aptosutil"
+	"github.com/streamingfast/firehose-aptos/modules"
+)
+
+var modulesCmd = &cobra.Command{Use: "modules", Short: "Catalogs and inspects the Move modules published on chain"}
+
+var modulesBuildCmd = &cobra.Command{
+	Use:   "build {merged-blocks-store-url}",
+	Short: "Builds or updates the module registry from merged blocks",
+	Long: string(cli.Description(`
+		Records every version of every Move module published (or deleted) by the successful transactions of the
+		merged blocks in the registry directory, along with its publish version and ABI.
+
+		The registry is saved every '--save-interval' blocks and when the command exits, even interrupted, running
+		the command again resumes from the block following the last saved one.
+	`)),
+	Args: cobra.ExactArgs(1),
+	RunE: modulesBuildE,
+	Example: ExamplePrefixed("fireaptos tools modules build", `
+		./firehose-data/storage/merged-blocks --registry-dir ./modules --range 0:99999
+	`),
+}
+
+var modulesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the modules of the registry with their amount of published versions",
+	Args:  cobra.NoArgs,
+	RunE:  modulesListE,
+	Example: ExamplePrefixed("fireaptos tools modules list", `
+		--registry-dir ./modules
+		--registry-dir ./modules --address 0x1
+	`),
+}
+
+var modulesShowCmd = &cobra.Command{
+	Use:   "show <address>::<module>",
+	Short: "Prints the ABI of a module as of a given version",
+	Args:  cobra.ExactArgs(1),
+	RunE:  modulesShowE,
+	Example: ExamplePrefixed("fireaptos tools modules show", `
+		0x1::coin --registry-dir ./modules
+		0x1::coin --registry-dir ./modules --version 1200000 --output json
+	`),
+}
+
+var modulesDiffCmd = &cobra.Command{
+	Use:   "diff <address>::<module>",
+	Short: "Prints the ABI changes of a module between two versions",
+	Long: string(cli.Description(`
+		Prints the ABI changes of a module between the versions live at '--from' and '--to'. Without flags,
+		the last two published versions of the module are compared.
+	`)),
+	Args: cobra.ExactArgs(1),
+	RunE: modulesDiffE,
+	Example: ExamplePrefixed("fireaptos tools modules diff", `
+		0x1::coin --registry-dir ./modules
+		0x1::coin --registry-dir ./modules --from 1000 --to 1200000
+	`),
+}
+
+func init() {
+	Cmd.AddCommand(modulesCmd)
+	modulesCmd.AddCommand(modulesBuildCmd)
+	modulesCmd.AddCommand(modulesListCmd)
+	modulesCmd.AddCommand(modulesShowCmd)
+	modulesCmd.AddCommand(modulesDiffCmd)
+
+	modulesCmd.PersistentFlags().String("registry-dir", "", "Directory holding the module registry")
+
+	modulesBuildCmd.Flags().StringP("range", "r", "", "Block range to process, in the form '<start>:<stop>' (inclusive, '<stop>' is optional), the start being ignored when resuming")
+	modulesBuildCmd.Flags().Uint64("save-interval", 10000, "Amount of blocks applied between two saves of the registry")
+	modulesBuildCmd.Flags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks contained in each merged blocks file")
+
+	modulesListCmd.Flags().String("address", "", "Only list the modules of this account")
+
+	modulesShowCmd.Flags().Uint64("version", 0, "Version at which to show the module, 0 meaning its last published version")
+	modulesShowCmd.Flags().StringP("output", "o", "text", "Output format, either 'text' or 'json'")
+
+	modulesDiffCmd.Flags().Uint64("from", 0, "Version of the module to diff from, defaults to the version preceding the last published one")
+	modulesDiffCmd.Flags().Uint64("to", 0, "Version of the module to diff to, defaults to the last published version")
+}
+
+func modulesBuildE(cmd *cobra.Command, args []string) error {
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	mergedBlocksStore, err := dstore.NewDBinStore(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to create store at path %q: %w", args[0], err)
+	}
+
+	registry, err := openModuleRegistry(cmd, modules.Options{SaveInterval: mustGetUint64(cmd, "save-interval")})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = applyMergedBlocks(ctx, mergedBlocksStore, registry, blockRange, mustGetUint64(cmd, "bundle-size"))
+	if errors.Is(err, context.Canceled) {
+		zlog.Info("build interrupted")
+		err = nil
+	}
+
+	// Closing the registry saves it, so even an interrupted build resumes from its last applied block
+	if closeErr := registry.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("save registry: %w", closeErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lastBlock, _ := registry.LastBlock()
+	fmt.Printf("Module registry built up to block #%d, %d modules\n", lastBlock, len(registry.Modules()))
+	return nil
+}
+
+func modulesListE(cmd *cobra.Command, args []string) error {
+	registry, err := openExistingModuleRegistry(cmd)
+	if err != nil {
+		return err
+	}
+
+	address := mustGetString(cmd, "address")
+	if address != "" {
+		address = aptosutil.NormalizeAddress(address)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "MODULE\tVERSIONS\tFIRST PUBLISHED\tLAST PUBLISHED\tFUNCTIONS\tSTRUCTS")
+
+	count := 0
+	for _, id := range registry.Modules() {
+		if address != "" && !strings.HasPrefix(id, address+"::") {
+			continue
+		}
+
+		moduleAddress, name, _ := modules.ParseModuleID(id)
+		versions := registry.Versions(moduleAddress, name)
+		first, last := versions[0], versions[len(versions)-1]
+
+		functions, structs := "-", "-"
+		if last.Deleted {
+			functions, structs = "deleted", "deleted"
+		} else if last.ABI != nil {
+			functions, structs = fmt.Sprint(len(last.ABI.Functions)), fmt.Sprint(len(last.ABI.Structs))
+		}
+
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%s\t%s\n", id, len(versions), first.Version, last.Version, functions, structs)
+		count++
+	}
+
+	writer.Flush()
+	fmt.Printf("\n%d module(s)\n", count)
+	return nil
+}
+
+func modulesShowE(cmd *cobra.Command, args []string) error {
+	registry, err := openExistingModuleRegistry(cmd)
+	if err != nil {
+		return err
+	}
+
+	output := mustGetString(cmd, "output")
+	if output != "text" && output != "json" {
+		return fmt.Errorf("invalid output %q, accepting only 'text' or 'json'", output)
+	}
+
+	module, err := moduleAsOf(registry, args[0], mustGetUint64(cmd, "version"))
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(module)
+	}
+
+	fmt.Printf("module %s (published at version %d, block #%d, bytecode %d bytes, sha256 %s)\n", module.ID(), module.Version, module.BlockHeight, module.BytecodeSize, module.BytecodeHash)
+	if module.ABI == nil {
+		fmt.Println("  no ABI available")
+		return nil
+	}
+
+	for _, friend := range module.ABI.Friends {
+		fmt.Printf("  friend %s\n", friend)
+	}
+
+	for _, s := range module.ABI.Structs {
+		fmt.Printf("  %s\n", s.Signature())
+	}
+
+	for _, function := range module.ABI.Functions {
+		fmt.Printf("  %s\n", function.Signature())
+	}
+
+	return nil
+}
+
+func modulesDiffE(cmd *cobra.Command, args []string) error {
+	registry, err := openExistingModuleRegistry(cmd)
+	if err != nil {
+		return err
+	}
+
+	address, name, err := modules.ParseModuleID(args[0])
+	if err != nil {
+		return err
+	}
+
+	versions := registry.Versions(address, name)
+	if len(versions) == 0 {
+		return fmt.Errorf("module %s not found in registry", args[0])
+	}
+
+	var from, to *modules.Module
+	if toVersion := mustGetUint64(cmd, "to"); toVersion != 0 {
+		if to, err = registry.AsOf(address, name, toVersion); err != nil {
+			return err
+		}
+	} else {
+		to = versions[len(versions)-1]
+	}
+
+	if fromVersion := mustGetUint64(cmd, "from"); fromVersion != 0 {
+		if from, err = registry.AsOf(address, name, fromVersion); err != nil {
+			return err
+		}
+	} else if to.Version > 0 {
+		// The version live just before `to` was published, nil if `to` is the first one
+		from, _ = registry.AsOf(address, name, to.Version-1)
+	}
+
+	fromDescription := "nothing"
+	if from != nil {
+		fromDescription = fmt.Sprintf("version %d", from.Version)
+	}
+	fmt.Printf("module %s from %s to version %d\n", to.ID(), fromDescription, to.Version)
+
+	changes := modules.Diff(from, to)
+	if len(changes) == 0 {
+		fmt.Println("  no ABI changes")
+		return nil
+	}
+
+	for _, change := range changes {
+		switch change.Kind {
+		case modules.ChangeAdded:
+			fmt.Printf("  + %s\n", change.After)
+		case modules.ChangeRemoved:
+			fmt.Printf("  - %s\n", change.Before)
+		case modules.ChangeModified:
+			fmt.Printf("  - %s\n  + %s\n", change.Before, change.After)
+		}
+	}
+
+	return nil
+}
+
+func moduleAsOf(registry *modules.Registry, id string, version uint64) (*modules.Module, error) {
+	address, name, err := modules.ParseModuleID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0 {
+		versions := registry.Versions(address, name)
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("module %s not found in registry", id)
+		}
+
+		version = versions[len(versions)-1].Version
+	}
+
+	return registry.AsOf(address, name, version)
+}
+
+func openModuleRegistry(cmd *cobra.Command, options modules.Options) (*modules.Registry, error) {
+	registryDir := mustGetString(cmd, "registry-dir")
+	if registryDir == "" {
+		return nil, fmt.Errorf("the --registry-dir flag is required")
+	}
+
+	registry, err := modules.Open(registryDir, options)
+	if err != nil {
+		return nil, fmt.Errorf("open module registry: %w", err)
+	}
+
+	return registry, nil
+}
+
+func openExistingModuleRegistry(cmd *cobra.Command) (*modules.Registry, error) {
+	registry, err := openModuleRegistry(cmd, modules.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := registry.LastBlock(); !ok {
+		return nil, fmt.Errorf("module registry in %q is empty, build it first with 'fireaptos tools modules build'", mustGetString(cmd, "registry-dir"))
+	}
+
+	return registry, nil
+}
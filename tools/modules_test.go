@@ -0,0 +1,72 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/modules"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	sftools "github.com/streamingfast/sf-tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildModuleRegistry_Resume(t *testing.T) {
+	mergedBlocksStore := newTestModulesStore(t, map[uint64]string{50: "counter", 150: "counter", 250: "vault"})
+	registryDir := t.TempDir()
+
+	registry, err := modules.Open(registryDir, modules.Options{SaveInterval: 1000})
+	require.NoError(t, err)
+	require.NoError(t, applyMergedBlocks(context.Background(), mergedBlocksStore, registry, sftools.BlockRange{Start: 0, Stop: 199}, 100))
+	require.NoError(t, registry.Close())
+
+	registry, err = modules.Open(registryDir, modules.Options{})
+	require.NoError(t, err)
+
+	lastBlock, _ := registry.LastBlock()
+	assert.Equal(t, uint64(199), lastBlock)
+	assert.Equal(t, []string{"0xcafe::counter"}, registry.Modules())
+
+	require.NoError(t, applyMergedBlocks(context.Background(), mergedBlocksStore, registry, sftools.BlockRange{Start: 0}, 100))
+	assert.Equal(t, []string{"0xcafe::counter", "0xcafe::vault"}, registry.Modules())
+
+	module, err := moduleAsOf(registry, "0x0cafe::counter", 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(150), module.Version)
+	assert.Equal(t, "public entry fun run(&signer)", module.ABI.Functions[0].Signature())
+}
+
+// newTestModulesStore writes blocks `[0, 300[` in a temporary store, the block at each height of
+// `published` containing a transaction publishing the module of that name at address `0xcafe`.
+func newTestModulesStore(t *testing.T, published map[uint64]string) dstore.Store {
+	t.Helper()
+
+	store := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, store, 0, 300, func(height uint64) *pbaptos.Block {
+		block := newTestBlock(height)
+		if name, found := published[height]; found {
+			block.Transactions[0].Info = &pbaptos.TransactionInfo{Success: true, Changes: []*pbaptos.WriteSetChange{
+				{Change: &pbaptos.WriteSetChange_WriteModule{WriteModule: &pbaptos.WriteModule{
+					Address: "0xcafe",
+					Data: &pbaptos.MoveModuleBytecode{Bytecode: []byte(name), Abi: &pbaptos.MoveModule{
+						Address: "0xcafe",
+						Name:    name,
+						ExposedFunctions: []*pbaptos.MoveFunction{{
+							Name:       "run",
+							Visibility: pbaptos.MoveFunction_PUBLIC,
+							IsEntry:    true,
+							Params: []*pbaptos.MoveType{{Type: pbaptos.MoveTypes_Reference, Content: &pbaptos.MoveType_Reference{Reference: &pbaptos.MoveType_ReferenceType{
+								To: &pbaptos.MoveType{Type: pbaptos.MoveTypes_Signer},
+							}}}},
+						}},
+					}},
+				}}},
+			}}
+		}
+
+		return block
+	})
+
+	return store
+}
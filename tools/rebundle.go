@@ -0,0 +1,323 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	sftools "github.com/streamingfast/sf-tools"
+	"go.uber.org/zap"
+)
+
+var unmergeCmd = &cobra.Command{
+	Use:   "unmerge {merged-blocks-store-url} {one-block-store-url}",
+	Short: "Splits merged blocks files back into one-block files, for example to feed a merger again",
+	Args:  cobra.ExactArgs(2),
+	RunE:  unmergeE,
+	Example: ExamplePrefixed("fireaptos tools unmerge", `
+		"./firehose-data/storage/merged-blocks" "./firehose-data/storage/one-blocks" --range 1000:1999
+	`),
+}
+
+var remergeCmd = &cobra.Command{
+	Use:   "remerge {source-merged-blocks-store-url} {destination-merged-blocks-store-url}",
+	Short: "Re-bundles merged blocks files of a store into merged blocks files of a different size in another store",
+	Args:  cobra.ExactArgs(2),
+	RunE:  remergeE,
+	Example: ExamplePrefixed("fireaptos tools remerge", `
+		"./firehose-data/storage/merged-blocks" "./firehose-data/storage/merged-blocks-1000" --range 0:99999 --bundle-size 1000
+	`),
+}
+
+func init() {
+	Cmd.AddCommand(unmergeCmd)
+	Cmd.AddCommand(remergeCmd)
+
+	for _, cmd := range []*cobra.Command{unmergeCmd, remergeCmd} {
+		cmd.Flags().StringP("range", "r", "", "Block range to process, in the form '<start>:<stop>' (inclusive, '<stop>' is optional)")
+		cmd.Flags().Int("workers", 4, "Amount of files processed concurrently")
+		cmd.Flags().Bool("verify", true, "Read back each written file and ensure it contains exactly the blocks that were written")
+		cmd.Flags().Bool("overwrite", false, "Overwrite files already present in the destination store")
+	}
+
+	unmergeCmd.Flags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks contained in each merged blocks file of the source store")
+	unmergeCmd.Flags().String("suffix", "unmerged", "Suffix to use for the one-block files written")
+
+	remergeCmd.Flags().Uint64("source-bundle-size", defaultBundleSize, "Amount of blocks contained in each merged blocks file of the source store")
+	remergeCmd.Flags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks to put in each merged blocks file of the destination store")
+}
+
+func unmergeE(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	bundleSize := mustGetUint64(cmd, "bundle-size")
+	suffix := mustGetString(cmd, "suffix")
+	verify := mustGetBool(cmd, "verify")
+
+	sourceStore, err := dstore.NewDBinStore(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to create source store at path %q: %w", args[0], err)
+	}
+
+	destinationStore, err := dstore.NewDBinStore(args[1])
+	if err != nil {
+		return fmt.Errorf("unable to create destination store at path %q: %w", args[1], err)
+	}
+	destinationStore.SetOverwrite(mustGetBool(cmd, "overwrite"))
+
+	return unmergeBundles(ctx, sourceStore, destinationStore, blockRange, bundleSize, suffix, mustGetInt(cmd, "workers"), verify)
+}
+
+// unmergeBundles splits the merged blocks files of `bundleSize` blocks of `sourceStore` covering the block
+// range into one-block files with the given suffix written to `destinationStore`.
+func unmergeBundles(ctx context.Context, sourceStore, destinationStore dstore.Store, blockRange sftools.BlockRange, bundleSize uint64, suffix string, workerCount int, verify bool) error {
+	if bundleSize == 0 {
+		return fmt.Errorf("invalid bundle size 0, must be greater than 0")
+	}
+
+	return processMergedBundles(ctx, sourceStore, blockRange, bundleSize, workerCount, func(ctx context.Context, baseBlockNum uint64) error {
+		var blocks []*bstream.Block
+		if err := readMergedBundleBlocks(ctx, sourceStore, baseBlockNum, blockRange, func(block *bstream.Block) error {
+			blocks = append(blocks, block)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if err := verifyBlocksContinuity(blocks); err != nil {
+			return fmt.Errorf("source merged blocks file: %w", err)
+		}
+
+		for _, block := range blocks {
+			filename := bstream.BlockFileNameWithSuffix(block, suffix)
+			if err := writeBlocksFile(ctx, destinationStore, filename, []*bstream.Block{block}, verify); err != nil {
+				return err
+			}
+		}
+
+		zlog.Info("unmerged bundle", zap.String("bundle", mergedBundleFilename(baseBlockNum)), zap.Int("block_count", len(blocks)))
+		return nil
+	})
+}
+
+func remergeE(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	sourceBundleSize := mustGetUint64(cmd, "source-bundle-size")
+	bundleSize := mustGetUint64(cmd, "bundle-size")
+	verify := mustGetBool(cmd, "verify")
+
+	sourceStore, err := dstore.NewDBinStore(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to create source store at path %q: %w", args[0], err)
+	}
+
+	destinationStore, err := dstore.NewDBinStore(args[1])
+	if err != nil {
+		return fmt.Errorf("unable to create destination store at path %q: %w", args[1], err)
+	}
+	destinationStore.SetOverwrite(mustGetBool(cmd, "overwrite"))
+
+	return remergeBundles(ctx, sourceStore, destinationStore, blockRange, sourceBundleSize, bundleSize, mustGetInt(cmd, "workers"), verify)
+}
+
+// remergeBundles re-bundles the merged blocks files of `sourceBundleSize` blocks of `sourceStore` covering
+// the block range into merged blocks files of `bundleSize` blocks written to `destinationStore`. Only complete
+// bundles are written: a bounded range must stop on the last block of a bundle, and without stop, the last
+// bundle is skipped when the source store does not have all of its blocks yet.
+func remergeBundles(ctx context.Context, sourceStore, destinationStore dstore.Store, blockRange sftools.BlockRange, sourceBundleSize, bundleSize uint64, workerCount int, verify bool) error {
+	if sourceBundleSize == 0 || bundleSize == 0 {
+		return fmt.Errorf("invalid bundle size 0, must be greater than 0")
+	}
+
+	if blockRange.Start%bundleSize != 0 && blockRange.Start != bstream.GetProtocolFirstStreamableBlock {
+		return fmt.Errorf("range start %d must be a multiple of the destination bundle size %d", blockRange.Start, bundleSize)
+	}
+
+	if !blockRange.Unbounded() && (blockRange.Stop+1)%bundleSize != 0 {
+		return fmt.Errorf("range stop %d must be the last block of a bundle (a multiple of the destination bundle size %d minus 1)", blockRange.Stop, bundleSize)
+	}
+
+	sourceBundles, err := listMergedBundles(ctx, sourceStore, blockRange, sourceBundleSize)
+	if err != nil {
+		return err
+	}
+
+	if len(sourceBundles) == 0 {
+		return fmt.Errorf("no merged blocks file found in source store for range %s", blockRange)
+	}
+
+	lastBlockNum := blockRange.Stop
+	if blockRange.Unbounded() {
+		// Up to the last complete destination bundle, the next one being written once the source store has all its blocks
+		sourceLastBlockNum := sourceBundles[len(sourceBundles)-1] + sourceBundleSize - 1
+		completeBlockCount := (sourceLastBlockNum + 1) / bundleSize * bundleSize
+		if completeBlockCount == 0 || completeBlockCount-1 < blockRange.Start {
+			return fmt.Errorf("source store only has blocks up to #%d, not enough for a complete bundle of %d blocks from block #%d", sourceLastBlockNum, bundleSize, blockRange.Start)
+		}
+
+		lastBlockNum = completeBlockCount - 1
+		if lastBlockNum != sourceLastBlockNum {
+			zlog.Warn("skipping incomplete last bundle", zap.String("bundle", mergedBundleFilename(lastBlockNum+1)), zap.Uint64("source_last_block_num", sourceLastBlockNum))
+		}
+	}
+
+	var destinationBundles []uint64
+	for baseBlockNum := blockRange.Start - (blockRange.Start % bundleSize); baseBlockNum <= lastBlockNum; baseBlockNum += bundleSize {
+		destinationBundles = append(destinationBundles, baseBlockNum)
+	}
+
+	zlog.Info("re-bundling merged blocks files",
+		zap.Stringer("range", blockRange),
+		zap.Uint64("source_bundle_size", sourceBundleSize),
+		zap.Uint64("bundle_size", bundleSize),
+		zap.Int("destination_bundle_count", len(destinationBundles)),
+	)
+
+	return processInParallel(ctx, destinationBundles, workerCount, func(ctx context.Context, baseBlockNum uint64) error {
+		bundleRange := sftools.BlockRange{Start: baseBlockNum, Stop: baseBlockNum + bundleSize - 1}
+		if bundleRange.Start < blockRange.Start {
+			bundleRange.Start = blockRange.Start
+		}
+
+		var blocks []*bstream.Block
+		for sourceBase := bundleRange.Start - (bundleRange.Start % sourceBundleSize); sourceBase <= bundleRange.Stop; sourceBase += sourceBundleSize {
+			if err := readMergedBundleBlocks(ctx, sourceStore, sourceBase, bundleRange, func(block *bstream.Block) error {
+				blocks = append(blocks, block)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		if err := verifyBlocksContinuity(blocks); err != nil {
+			return fmt.Errorf("bundle %s: %w", mergedBundleFilename(baseBlockNum), err)
+		}
+
+		if len(blocks) == 0 {
+			return fmt.Errorf("bundle %s: no blocks found in source store", mergedBundleFilename(baseBlockNum))
+		}
+
+		if first, last := blocks[0].Number, blocks[len(blocks)-1].Number; first != bundleRange.Start || last != bundleRange.Stop {
+			return fmt.Errorf("bundle %s: incomplete, source store has blocks #%d to #%d, expected #%d to #%d", mergedBundleFilename(baseBlockNum), first, last, bundleRange.Start, bundleRange.Stop)
+		}
+
+		if err := writeBlocksFile(ctx, destinationStore, mergedBundleFilename(baseBlockNum), blocks, verify); err != nil {
+			return err
+		}
+
+		zlog.Info("wrote merged blocks file", zap.String("bundle", mergedBundleFilename(baseBlockNum)), zap.Int("block_count", len(blocks)))
+		return nil
+	})
+}
+
+// verifyBlocksContinuity ensures that the blocks are strictly following each other, Aptos does not
+// have forks nor skipped heights so each block must be the child of the previous one.
+func verifyBlocksContinuity(blocks []*bstream.Block) error {
+	for i := 1; i < len(blocks); i++ {
+		previous, current := blocks[i-1], blocks[i]
+		if current.Number != previous.Number+1 {
+			return fmt.Errorf("block %s does not follow block %s, expected block #%d", current.AsRef(), previous.AsRef(), previous.Number+1)
+		}
+
+		if current.PreviousID() != previous.ID() {
+			return fmt.Errorf("block %s previous id %q does not match id of block %s", current.AsRef(), current.PreviousID(), previous.AsRef())
+		}
+	}
+
+	return nil
+}
+
+// writeBlocksFile writes the blocks in the store under the given filename using the registered
+// block writer, reading it back to ensure it contains exactly the written blocks when `verify` is true.
+func writeBlocksFile(ctx context.Context, store dstore.Store, filename string, blocks []*bstream.Block, verify bool) error {
+	buffer := bytes.NewBuffer(nil)
+	blockWriter, err := bstream.GetBlockWriterFactory.New(buffer)
+	if err != nil {
+		return fmt.Errorf("new block writer: %w", err)
+	}
+
+	for _, block := range blocks {
+		if err := blockWriter.Write(block); err != nil {
+			return fmt.Errorf("write block %s: %w", block.AsRef(), err)
+		}
+	}
+
+	if err := store.WriteObject(ctx, filename, buffer); err != nil {
+		return fmt.Errorf("write file %q: %w", filename, err)
+	}
+
+	if !verify {
+		return nil
+	}
+
+	if err := verifyBlocksFile(ctx, store, filename, blocks); err != nil {
+		return fmt.Errorf("verify file %q: %w", filename, err)
+	}
+
+	return nil
+}
+
+func verifyBlocksFile(ctx context.Context, store dstore.Store, filename string, expected []*bstream.Block) error {
+	reader, err := store.OpenObject(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer reader.Close()
+
+	blockReader, err := bstream.GetBlockReaderFactory.New(reader)
+	if err != nil {
+		return fmt.Errorf("new block reader: %w", err)
+	}
+
+	for i := 0; ; i++ {
+		block, err := blockReader.Read()
+		if err == io.EOF {
+			if i != len(expected) {
+				return fmt.Errorf("expected %d blocks, read back %d", len(expected), i)
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("read block: %w", err)
+		}
+
+		if i >= len(expected) {
+			return fmt.Errorf("expected %d blocks, read back more", len(expected))
+		}
+
+		if block.Number != expected[i].Number || block.ID() != expected[i].ID() {
+			return fmt.Errorf("read back block %s at position %d, expected %s", block.AsRef(), i, expected[i].AsRef())
+		}
+
+		actualPayload, err := block.Payload.Get()
+		if err != nil {
+			return fmt.Errorf("get read back block %s payload: %w", block.AsRef(), err)
+		}
+
+		expectedPayload, err := expected[i].Payload.Get()
+		if err != nil {
+			return fmt.Errorf("get block %s payload: %w", expected[i].AsRef(), err)
+		}
+
+		if !bytes.Equal(actualPayload, expectedPayload) {
+			return fmt.Errorf("read back block %s payload differs from written one", block.AsRef())
+		}
+	}
+}
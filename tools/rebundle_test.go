@@ -0,0 +1,125 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	sftools "github.com/streamingfast/sf-tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmergeBundles(t *testing.T) {
+	mergedBlocksStore := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, mergedBlocksStore, 0, 300, newTestBlock)
+
+	oneBlockStore := newTestEmptyStore(t)
+	require.NoError(t, unmergeBundles(context.Background(), mergedBlocksStore, oneBlockStore, sftools.BlockRange{Start: 50, Stop: 149}, 100, "unmerged", 2, true))
+
+	blocks, err := readOneBlocks(context.Background(), oneBlockStore, sftools.BlockRange{Start: 50, Stop: 149})
+	require.NoError(t, err)
+	require.Len(t, blocks, 100)
+	assert.Equal(t, uint64(50), blocks[0].Number)
+	assert.Equal(t, uint64(149), blocks[99].Number)
+
+	// The unmerged one-block files merge back into the same bundle
+	remergedStore := newTestEmptyStore(t)
+	writeTestOneBlocks(t, oneBlockStore, 0, 50, "reader", nil)
+	writeTestOneBlocks(t, oneBlockStore, 150, 200, "reader", nil)
+	require.NoError(t, MergeOneBlocks(context.Background(), oneBlockStore, remergedStore, sftools.BlockRange{Start: 100, Stop: 199}, 100, 1, true))
+	assertBundleBlocks(t, remergedStore, 100, 100, 199)
+}
+
+func TestRemergeBundles(t *testing.T) {
+	sourceStore := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, sourceStore, 0, 500, newTestBlock)
+
+	tests := []struct {
+		name            string
+		blockRange      sftools.BlockRange
+		expectedBundles []uint64
+	}{
+		{"unbounded, incomplete last bundle skipped", sftools.BlockRange{Start: 0}, []uint64{0, 200}},
+		{"bounded", sftools.BlockRange{Start: 200, Stop: 399}, []uint64{200}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destinationStore := newTestEmptyStore(t)
+			require.NoError(t, remergeBundles(context.Background(), sourceStore, destinationStore, test.blockRange, 100, 200, 2, true))
+
+			assertStoreBundles(t, destinationStore, test.expectedBundles)
+			for _, baseBlockNum := range test.expectedBundles {
+				assertBundleBlocks(t, destinationStore, baseBlockNum, baseBlockNum, baseBlockNum+199)
+			}
+		})
+	}
+}
+
+func TestRemergeBundles_Errors(t *testing.T) {
+	sourceStore := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, sourceStore, 0, 200, newTestBlock)
+	writeTestMergedBlocks(t, sourceStore, 300, 400, newTestBlock)
+
+	// The source bundle 100 only has its first half
+	partialSourceStore := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, partialSourceStore, 0, 100, newTestBlock)
+	require.NoError(t, writeBlocksFile(context.Background(), partialSourceStore, mergedBundleFilename(100), newTestBstreamBlocks(t, 100, 150, newTestBlock), false))
+
+	tests := []struct {
+		name          string
+		sourceStore   dstore.Store
+		blockRange    sftools.BlockRange
+		expectedError string
+	}{
+		{"unaligned start", sourceStore, sftools.BlockRange{Start: 100}, "range start 100 must be a multiple of the destination bundle size 200"},
+		{"unaligned stop", sourceStore, sftools.BlockRange{Start: 0, Stop: 299}, "range stop 299 must be the last block of a bundle (a multiple of the destination bundle size 200 minus 1)"},
+		{"missing source bundle", sourceStore, sftools.BlockRange{Start: 0, Stop: 399}, `open merged blocks file "0000000200"`},
+		{"stop after source store", sourceStore, sftools.BlockRange{Start: 400, Stop: 599}, "no merged blocks file found in source store for range #400 - #599"},
+		{"incomplete source bundle", partialSourceStore, sftools.BlockRange{Start: 0, Stop: 199}, "bundle 0000000000: incomplete, source store has blocks #0 to #149, expected #0 to #199"},
+		{"no complete bundle", partialSourceStore, sftools.BlockRange{Start: 0}, "source store only has blocks up to #199, not enough for a complete bundle of 400 blocks from block #0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundleSize := uint64(200)
+			if test.name == "no complete bundle" {
+				bundleSize = 400
+			}
+
+			err := remergeBundles(context.Background(), test.sourceStore, newTestEmptyStore(t), test.blockRange, 100, bundleSize, 1, false)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedError)
+		})
+	}
+}
+
+func TestVerifyBlocksContinuity(t *testing.T) {
+	blocks := newTestBstreamBlocks(t, 10, 13, newTestBlock)
+	require.NoError(t, verifyBlocksContinuity(blocks))
+
+	assert.EqualError(t, verifyBlocksContinuity([]*bstream.Block{blocks[0], blocks[2]}), "block "+blocks[2].AsRef().String()+" does not follow block "+blocks[0].AsRef().String()+", expected block #11")
+
+	forked := newTestBstreamBlocks(t, 11, 12, newTestBlock)[0]
+	forked.PreviousId = "00000000000000000000000000000000000000000000000000000000deadbeef"
+	err := verifyBlocksContinuity([]*bstream.Block{blocks[0], forked})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `previous id "00000000000000000000000000000000000000000000000000000000deadbeef" does not match id of block`)
+}
+
+func TestVerifyBlocksFile(t *testing.T) {
+	store := newTestEmptyStore(t)
+	blocks := newTestBstreamBlocks(t, 0, 3, newTestBlock)
+	require.NoError(t, writeBlocksFile(context.Background(), store, "0000000000", blocks, true))
+
+	require.NoError(t, verifyBlocksFile(context.Background(), store, "0000000000", blocks))
+	assert.EqualError(t, verifyBlocksFile(context.Background(), store, "0000000000", blocks[:2]), "expected 2 blocks, read back more")
+	assert.EqualError(t, verifyBlocksFile(context.Background(), store, "0000000000", append(blocks, newTestBstreamBlocks(t, 3, 4, newTestBlock)...)), "expected 4 blocks, read back 3")
+
+	other := newTestBstreamBlocks(t, 5, 8, newTestBlock)
+	err := verifyBlocksFile(context.Background(), store, "0000000000", other)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "read back block "+blocks[0].AsRef().String()+" at position 0")
+}
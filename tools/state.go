@@ -0,0 +1,195 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/state"
+	"go.uber.org/zap"
+)
+
+var stateCmd = &cobra.Command{Use: "state", Short: "Reconstructs the state of resources by replaying the write sets of merged blocks"}
+
+var stateReplayCmd = &cobra.Command{
+	Use:   "replay {merged-blocks-store-url}",
+	Short: "Replays the resource changes of merged blocks into a local state directory",
+	Long: string(cli.Description(`
+		Replays the 'WriteResource' and 'DeleteResource' changes of every transaction of the merged blocks, in
+		version order, into the state directory, keeping the full history of each resource selected by
+		'--address' and '--type' (all resources when none is provided).
+
+		Each applied block is committed to the state, so running the command again resumes from the block
+		following the last applied one, the filter must then be the same as the one used initially. The state
+		is snapshotted every '--snapshot-interval' blocks and when the replay completes or is interrupted,
+		'state get' reading the last snapshot so it can run while a replay is in progress.
+	`)),
+	Args: cobra.ExactArgs(1),
+	RunE: stateReplayE,
+	Example: ExamplePrefixed("fireaptos tools state replay", `
+		./firehose-data/storage/merged-blocks --state-dir ./state --range 0:99999
+		gs://<bucket>/merged-blocks --state-dir ./state --address 0x1 --type '0x1::coin::CoinStore<*>'
+	`),
+}
+
+var stateGetCmd = &cobra.Command{
+	Use:   "get <address> <resource-type>",
+	Short: "Prints the value of a resource at a given version from a replayed state directory",
+	Args:  cobra.ExactArgs(2),
+	RunE:  stateGetE,
+	Example: ExamplePrefixed("fireaptos tools state get", `
+		0x1 '0x1::coin::CoinInfo<0x1::aptos_coin::AptosCoin>' --state-dir ./state
+		0xa550c18 '0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>' --state-dir ./state --version 1200000
+	`),
+}
+
+func init() {
+	Cmd.AddCommand(stateCmd)
+	stateCmd.AddCommand(stateReplayCmd)
+	stateCmd.AddCommand(stateGetCmd)
+
+	stateCmd.PersistentFlags().String("state-dir", "", "Directory holding the replayed state")
+
+	stateReplayCmd.Flags().StringP("range", "r", "", "Block range to replay, in the form '<start>:<stop>' (inclusive, '<stop>' is optional), the start being ignored when resuming")
+	stateReplayCmd.Flags().StringSlice("address", nil, "Only track resources of these accounts, can be repeated")
+	stateReplayCmd.Flags().StringSlice("type", nil, "Only track resources whose type matches these patterns, '*' matching any sequence of characters, can be repeated")
+	stateReplayCmd.Flags().Uint64("snapshot-interval", 10000, "Amount of blocks replayed between two snapshots of the state")
+	stateReplayCmd.Flags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks contained in each merged blocks file")
+
+	stateGetCmd.Flags().Uint64("version", 0, "Version at which to get the resource value, 0 meaning the last replayed version")
+	stateGetCmd.Flags().Bool("history", false, "Print all the changes of the resource instead of its value at a given version")
+}
+
+func stateReplayE(cmd *cobra.Command, args []string) error {
+	stateDir := mustGetString(cmd, "state-dir")
+	if stateDir == "" {
+		return fmt.Errorf("the --state-dir flag is required")
+	}
+
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	mergedBlocksStore, err := dstore.NewDBinStore(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to create store at path %q: %w", args[0], err)
+	}
+
+	store, err := state.Open(stateDir, state.Options{
+		Filter: &state.Filter{
+			Addresses:    mustGetStringSlice(cmd, "address"),
+			TypePatterns: mustGetStringSlice(cmd, "type"),
+		},
+		SnapshotInterval: mustGetUint64(cmd, "snapshot-interval"),
+	})
+	if err != nil {
+		return fmt.Errorf("open state: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = applyMergedBlocks(ctx, mergedBlocksStore, store, blockRange, mustGetUint64(cmd, "bundle-size"))
+	if errors.Is(err, context.Canceled) {
+		zlog.Info("replay interrupted")
+		err = nil
+	}
+
+	// Closing the store snapshots it, so even an interrupted replay is fully visible to 'state get'
+	if closeErr := store.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close state: %w", closeErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lastBlock, _ := store.LastBlock()
+	fmt.Printf("State replayed up to block #%d (version %d)\n", lastBlock, store.LastVersion())
+	return nil
+}
+
+func stateGetE(cmd *cobra.Command, args []string) error {
+	stateDir := mustGetString(cmd, "state-dir")
+	if stateDir == "" {
+		return fmt.Errorf("the --state-dir flag is required")
+	}
+
+	if _, err := os.Stat(stateDir); err != nil {
+		return fmt.Errorf("invalid state directory: %w", err)
+	}
+
+	// Read-only from the last snapshot, the state may be concurrently replayed
+	store, err := state.Open(stateDir, state.Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("open state: %w", err)
+	}
+	defer store.Close()
+
+	address, typeStr := args[0], args[1]
+
+	if mustGetBool(cmd, "history") {
+		history, err := store.History(address, typeStr)
+		if err != nil {
+			return err
+		}
+
+		if len(history) == 0 {
+			return fmt.Errorf("no changes found for resource %s at %s", typeStr, address)
+		}
+
+		return printStateValues(history...)
+	}
+
+	version := mustGetUint64(cmd, "version")
+	if version == 0 {
+		version = store.LastVersion()
+	} else if version > store.LastVersion() {
+		zlog.Warn("requested version is past the last replayed version, value may be stale", zap.Uint64("version", version), zap.Uint64("last_version", store.LastVersion()))
+	}
+
+	value, err := store.Get(address, typeStr, version)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return fmt.Errorf("resource %s at %s not found at version %d", typeStr, address, version)
+		}
+
+		return err
+	}
+
+	return printStateValues(value)
+}
+
+func printStateValues(values ...*state.ResourceValue) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	for _, value := range values {
+		// The resource data is already JSON, it's embedded as-is instead of as an escaped string
+		out := struct {
+			*state.ResourceValue
+			Data json.RawMessage `json:"data,omitempty"`
+		}{ResourceValue: value}
+
+		if value.Data != "" {
+			out.Data = json.RawMessage(value.Data)
+			if !json.Valid(out.Data) {
+				out.Data, _ = json.Marshal(value.Data)
+			}
+		}
+
+		if err := encoder.Encode(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
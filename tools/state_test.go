@@ -0,0 +1,42 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/firehose-aptos/state"
+	sftools "github.com/streamingfast/sf-tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayState_Resume(t *testing.T) {
+	mergedBlocksStore := newTestAccountStore(t, 0, 400)
+	stateDir := t.TempDir()
+
+	store, err := state.Open(stateDir, state.Options{SnapshotInterval: 50})
+	require.NoError(t, err)
+	require.NoError(t, applyMergedBlocks(context.Background(), mergedBlocksStore, store, sftools.BlockRange{Start: 0, Stop: 249}, 100))
+	require.NoError(t, store.Close())
+
+	lastBlock, _ := store.LastBlock()
+	assert.Equal(t, uint64(249), lastBlock)
+
+	store, err = state.Open(stateDir, state.Options{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, err = store.Get("0xa1", "0x1::coin::CoinStore", 399)
+	assert.Equal(t, state.ErrNotFound, err)
+
+	// The range start is ignored when resuming, blocks already applied would otherwise be rejected
+	require.NoError(t, applyMergedBlocks(context.Background(), mergedBlocksStore, store, sftools.BlockRange{Start: 0}, 100))
+
+	lastBlock, _ = store.LastBlock()
+	assert.Equal(t, uint64(399), lastBlock)
+
+	value, err := store.Get("0xa1", "0x1::coin::CoinStore", 399)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(370), value.Version)
+	assert.True(t, value.Deleted)
+}
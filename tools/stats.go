@@ -0,0 +1,422 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/dstore"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats {store-url}",
+	Short: "Computes chain analytics (transaction types, failures, gas, top senders, etc.) over a range of merged blocks",
+	Args:  cobra.ExactArgs(1),
+	RunE:  statsE,
+	Example: ExamplePrefixed("fireaptos tools stats", `
+		"./firehose-data/storage/merged-blocks" --range 1000:2000
+		"gs://<project>/<bucket>/<path>" --range 1000:2000 --workers 16 --top 25 --output json
+	`),
+}
+
+func init() {
+	Cmd.AddCommand(statsCmd)
+
+	statsCmd.Flags().StringP("range", "r", "", "Block range to compute statistics for, in the form '<start>:<stop>' (inclusive, '<stop>' is optional)")
+	statsCmd.Flags().Int("workers", 8, "Amount of merged blocks files processed concurrently")
+	statsCmd.Flags().Int("top", 10, "Amount of entries to show in the top senders, entry functions and event types sections")
+	statsCmd.Flags().StringP("output", "o", "table", "Output format, either 'table' or 'json'")
+}
+
+func statsE(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	output := mustGetString(cmd, "output")
+	if output != "table" && output != "json" {
+		return fmt.Errorf("invalid output %q, accepting only 'table' or 'json'", output)
+	}
+
+	store, err := dstore.NewDBinStore(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to create store at path %q: %w", args[0], err)
+	}
+
+	stats := newChainStats()
+	lock := sync.Mutex{}
+
+	err = processMergedBundles(ctx, store, blockRange, defaultBundleSize, mustGetInt(cmd, "workers"), func(ctx context.Context, baseBlockNum uint64) error {
+		bundleStats := newChainStats()
+		if err := readMergedBundle(ctx, store, baseBlockNum, blockRange, func(block *pbaptos.Block) error {
+			bundleStats.AddBlock(block)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		stats.Merge(bundleStats)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	report := stats.Report(mustGetInt(cmd, "top"))
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(report)
+	}
+
+	report.PrintTable()
+	return nil
+}
+
+type chainStats struct {
+	BlockCount        uint64
+	TransactionCount  uint64
+	TransactionTypes  map[string]uint64
+	VMStatuses        map[string]*vmStatusCount
+	GasUsed           *distribution
+	Fees              *distribution
+	Senders           map[string]uint64
+	EntryFunctions    map[string]uint64
+	EventTypes        map[string]uint64
+	BlockSizes        *distribution
+	BlockTransactions *distribution
+}
+
+type vmStatusCount struct {
+	Success uint64 `json:"success"`
+	Failure uint64 `json:"failure"`
+}
+
+func newChainStats() *chainStats {
+	return &chainStats{
+		TransactionTypes:  map[string]uint64{},
+		VMStatuses:        map[string]*vmStatusCount{},
+		GasUsed:           newDistribution(),
+		Fees:              newDistribution(),
+		Senders:           map[string]uint64{},
+		EntryFunctions:    map[string]uint64{},
+		EventTypes:        map[string]uint64{},
+		BlockSizes:        newDistribution(),
+		BlockTransactions: newDistribution(),
+	}
+}
+
+func (s *chainStats) AddBlock(block *pbaptos.Block) {
+	s.BlockCount++
+	s.BlockSizes.Add(uint64(proto.Size(block)))
+	s.BlockTransactions.Add(uint64(len(block.Transactions)))
+
+	for _, trx := range block.Transactions {
+		s.addTransaction(trx)
+	}
+}
+
+func (s *chainStats) addTransaction(trx *pbaptos.Transaction) {
+	s.TransactionCount++
+	s.TransactionTypes[trx.Type.String()]++
+
+	if info := trx.Info; info != nil {
+		status := s.VMStatuses[info.VmStatus]
+		if status == nil {
+			status = &vmStatusCount{}
+			s.VMStatuses[info.VmStatus] = status
+		}
+
+		if info.Success {
+			status.Success++
+		} else {
+			status.Failure++
+		}
+
+		s.GasUsed.Add(info.GasUsed)
+	}
+
+	for _, event := range transactionEvents(trx) {
+		s.EventTypes[event.TypeStr]++
+	}
+
+	user := trx.GetUser()
+	if user == nil || user.Request == nil {
+		return
+	}
+
+	s.Senders[user.Request.Sender]++
+	if trx.Info != nil {
+		s.Fees.Add(trx.Info.GasUsed * user.Request.GasUnitPrice)
+	}
+
+	if entryFunction := user.Request.Payload.GetEntryFunctionPayload(); entryFunction != nil {
+		s.EntryFunctions[entryFunctionID(entryFunction.Function)]++
+	}
+}
+
+func (s *chainStats) Merge(other *chainStats) {
+	s.BlockCount += other.BlockCount
+	s.TransactionCount += other.TransactionCount
+	mergeCounts(s.TransactionTypes, other.TransactionTypes)
+	mergeCounts(s.Senders, other.Senders)
+	mergeCounts(s.EntryFunctions, other.EntryFunctions)
+	mergeCounts(s.EventTypes, other.EventTypes)
+
+	for vmStatus, count := range other.VMStatuses {
+		status := s.VMStatuses[vmStatus]
+		if status == nil {
+			status = &vmStatusCount{}
+			s.VMStatuses[vmStatus] = status
+		}
+
+		status.Success += count.Success
+		status.Failure += count.Failure
+	}
+
+	s.GasUsed.Merge(other.GasUsed)
+	s.Fees.Merge(other.Fees)
+	s.BlockSizes.Merge(other.BlockSizes)
+	s.BlockTransactions.Merge(other.BlockTransactions)
+}
+
+func (s *chainStats) Report(top int) *statsReport {
+	report := &statsReport{
+		BlockCount:        s.BlockCount,
+		TransactionCount:  s.TransactionCount,
+		TransactionTypes:  s.TransactionTypes,
+		VMStatuses:        s.VMStatuses,
+		GasUsed:           s.GasUsed.Summary(),
+		Fees:              s.Fees.Summary(),
+		TopSenders:        topCounts(s.Senders, top),
+		TopEntryFunctions: topCounts(s.EntryFunctions, top),
+		TopEventTypes:     topCounts(s.EventTypes, top),
+		BlockSizes:        s.BlockSizes.Summary(),
+		BlockTransactions: s.BlockTransactions.Summary(),
+	}
+
+	for _, status := range s.VMStatuses {
+		report.SuccessCount += status.Success
+		report.FailureCount += status.Failure
+	}
+
+	if total := report.SuccessCount + report.FailureCount; total > 0 {
+		report.FailureRate = float64(report.FailureCount) / float64(total)
+	}
+
+	return report
+}
+
+type statsReport struct {
+	BlockCount        uint64                    `json:"block_count"`
+	TransactionCount  uint64                    `json:"transaction_count"`
+	TransactionTypes  map[string]uint64         `json:"transaction_types"`
+	SuccessCount      uint64                    `json:"success_count"`
+	FailureCount      uint64                    `json:"failure_count"`
+	FailureRate       float64                   `json:"failure_rate"`
+	VMStatuses        map[string]*vmStatusCount `json:"vm_statuses"`
+	GasUsed           *distributionSummary      `json:"gas_used"`
+	Fees              *distributionSummary      `json:"fees"`
+	TopSenders        []*keyCount               `json:"top_senders"`
+	TopEntryFunctions []*keyCount               `json:"top_entry_functions"`
+	TopEventTypes     []*keyCount               `json:"top_event_types"`
+	BlockSizes        *distributionSummary      `json:"block_sizes"`
+	BlockTransactions *distributionSummary      `json:"block_transactions"`
+}
+
+func (r *statsReport) PrintTable() {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer writer.Flush()
+
+	fmt.Fprintf(writer, "Blocks\t%d\n", r.BlockCount)
+	fmt.Fprintf(writer, "Transactions\t%d\n", r.TransactionCount)
+	fmt.Fprintf(writer, "Success / Failure\t%d / %d (%.2f%% failure rate)\n", r.SuccessCount, r.FailureCount, r.FailureRate*100)
+
+	fmt.Fprintln(writer, "\nTransaction Types\tCount")
+	for _, entry := range topCounts(r.TransactionTypes, 0) {
+		fmt.Fprintf(writer, "  %s\t%d\n", entry.Key, entry.Count)
+	}
+
+	fmt.Fprintln(writer, "\nVM Status\tSuccess\tFailure")
+	vmStatuses := make([]string, 0, len(r.VMStatuses))
+	for vmStatus := range r.VMStatuses {
+		vmStatuses = append(vmStatuses, vmStatus)
+	}
+	sort.Strings(vmStatuses)
+	for _, vmStatus := range vmStatuses {
+		fmt.Fprintf(writer, "  %s\t%d\t%d\n", printableKey(vmStatus), r.VMStatuses[vmStatus].Success, r.VMStatuses[vmStatus].Failure)
+	}
+
+	printKeyCounts(writer, "Top Senders", r.TopSenders)
+	printKeyCounts(writer, "Top Entry Functions", r.TopEntryFunctions)
+	printKeyCounts(writer, "Top Event Types", r.TopEventTypes)
+
+	printDistribution(writer, "Gas Used", r.GasUsed)
+	printDistribution(writer, "Fees (octas)", r.Fees)
+	printDistribution(writer, "Block Sizes (bytes)", r.BlockSizes)
+	printDistribution(writer, "Block Transactions", r.BlockTransactions)
+}
+
+func printKeyCounts(writer *tabwriter.Writer, title string, entries []*keyCount) {
+	fmt.Fprintf(writer, "\n%s\tCount\n", title)
+	for _, entry := range entries {
+		fmt.Fprintf(writer, "  %s\t%d\n", printableKey(entry.Key), entry.Count)
+	}
+}
+
+func printDistribution(writer *tabwriter.Writer, title string, summary *distributionSummary) {
+	fmt.Fprintf(writer, "\n%s\tCount %d, Min %d, Max %d, Avg %.2f, Sum %d\n", title, summary.Count, summary.Min, summary.Max, summary.Average, summary.Sum)
+	for _, bucket := range summary.Histogram {
+		fmt.Fprintf(writer, "  <= %d\t%d\n", bucket.UpperBound, bucket.Count)
+	}
+}
+
+func printableKey(in string) string {
+	if in == "" {
+		return "<empty>"
+	}
+
+	return in
+}
+
+type keyCount struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// topCounts returns the `top` entries with the highest count, sorted by descending count
+// and then by key. All entries are returned when `top` is 0 or negative.
+func topCounts(counts map[string]uint64, top int) []*keyCount {
+	out := make([]*keyCount, 0, len(counts))
+	for key, count := range counts {
+		out = append(out, &keyCount{Key: key, Count: count})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count == out[j].Count {
+			return out[i].Key < out[j].Key
+		}
+
+		return out[i].Count > out[j].Count
+	})
+
+	if top > 0 && len(out) > top {
+		return out[:top]
+	}
+
+	return out
+}
+
+func mergeCounts(into map[string]uint64, from map[string]uint64) {
+	for key, count := range from {
+		into[key] += count
+	}
+}
+
+// distribution tracks the count, sum and extremes of a series of values alongside an histogram
+// of the values using power of two buckets.
+type distribution struct {
+	Count   uint64
+	Sum     uint64
+	Min     uint64
+	Max     uint64
+	Buckets [65]uint64
+}
+
+func newDistribution() *distribution {
+	return &distribution{Min: math.MaxUint64}
+}
+
+func (d *distribution) Add(value uint64) {
+	d.Count++
+	d.Sum += value
+	if value < d.Min {
+		d.Min = value
+	}
+	if value > d.Max {
+		d.Max = value
+	}
+
+	d.Buckets[bucketIndex(value)]++
+}
+
+func (d *distribution) Merge(other *distribution) {
+	d.Count += other.Count
+	d.Sum += other.Sum
+	if other.Min < d.Min {
+		d.Min = other.Min
+	}
+	if other.Max > d.Max {
+		d.Max = other.Max
+	}
+
+	for i, count := range other.Buckets {
+		d.Buckets[i] += count
+	}
+}
+
+func (d *distribution) Summary() *distributionSummary {
+	out := &distributionSummary{Count: d.Count, Sum: d.Sum, Max: d.Max}
+	if d.Count == 0 {
+		return out
+	}
+
+	out.Min = d.Min
+	out.Average = float64(d.Sum) / float64(d.Count)
+	for i, count := range d.Buckets {
+		if count > 0 {
+			out.Histogram = append(out.Histogram, &histogramBucket{UpperBound: bucketUpperBound(i), Count: count})
+		}
+	}
+
+	return out
+}
+
+// bucketIndex returns the index of the power of two bucket the value falls in, bucket 0
+// holds the value 0, bucket 1 the value 1, bucket 2 the values 2 to 3, bucket 3 the values
+// 4 to 7 and so forth.
+func bucketIndex(value uint64) int {
+	return bits.Len64(value)
+}
+
+func bucketUpperBound(index int) uint64 {
+	if index == 0 {
+		return 0
+	}
+
+	if index == 64 {
+		return math.MaxUint64
+	}
+
+	return (uint64(1) << index) - 1
+}
+
+type distributionSummary struct {
+	Count     uint64             `json:"count"`
+	Sum       uint64             `json:"sum"`
+	Min       uint64             `json:"min"`
+	Max       uint64             `json:"max"`
+	Average   float64            `json:"average"`
+	Histogram []*histogramBucket `json:"histogram,omitempty"`
+}
+
+type histogramBucket struct {
+	UpperBound uint64 `json:"le"`
+	Count      uint64 `json:"count"`
+}
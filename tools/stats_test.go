@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"math"
+	"testing"
+
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainStats_Report(t *testing.T) {
+	failed := newTestUserTransaction(3, "0xb2", nil)
+	failed.Info = &pbaptos.TransactionInfo{Success: false, VmStatus: "Out of gas", GasUsed: 100}
+	failed.GetUser().Request.GasUnitPrice = 2
+
+	withEvent := newTestUserTransaction(2, "0xa1", nil)
+	withEvent.GetUser().Request.GasUnitPrice = 5
+	withEvent.GetUser().Events = []*pbaptos.Event{{TypeStr: "0x1::coin::DepositEvent"}, {TypeStr: "0x1::coin::WithdrawEvent"}}
+
+	first := newTestBlock(1)
+	first.Transactions = append(first.Transactions, newTestUserTransaction(1, "0xa1", nil), withEvent)
+
+	second := newTestBlock(2)
+	second.Transactions = append(second.Transactions, failed)
+
+	stats := newChainStats()
+	stats.AddBlock(first)
+	stats.AddBlock(second)
+
+	report := stats.Report(1)
+	assert.Equal(t, uint64(2), report.BlockCount)
+	assert.Equal(t, uint64(5), report.TransactionCount)
+	assert.Equal(t, map[string]uint64{"BLOCK_METADATA": 2, "USER": 3}, report.TransactionTypes)
+	assert.Equal(t, map[string]*vmStatusCount{"": {Success: 2}, "Out of gas": {Failure: 1}}, report.VMStatuses)
+	assert.Equal(t, uint64(2), report.SuccessCount)
+	assert.Equal(t, uint64(1), report.FailureCount)
+	assert.InDelta(t, 1.0/3.0, report.FailureRate, 1e-9)
+
+	assert.Equal(t, []*keyCount{{Key: "0xa1", Count: 2}}, report.TopSenders)
+	assert.Equal(t, []*keyCount{{Key: "0x1::coin::transfer", Count: 3}}, report.TopEntryFunctions)
+	assert.Equal(t, []*keyCount{{Key: "0x1::coin::DepositEvent", Count: 1}}, report.TopEventTypes)
+
+	// Block metadata transactions have no info, only the user transactions are accounted
+	assert.Equal(t, uint64(3), report.GasUsed.Count)
+	assert.Equal(t, uint64(120), report.GasUsed.Sum)
+
+	// Fees are the gas used times the gas unit price, 0 when unset
+	assert.Equal(t, uint64(3), report.Fees.Count)
+	assert.Equal(t, uint64(0), report.Fees.Min)
+	assert.Equal(t, uint64(200), report.Fees.Max)
+	assert.Equal(t, uint64(250), report.Fees.Sum)
+
+	assert.Equal(t, &distributionSummary{
+		Count:     2,
+		Sum:       5,
+		Min:       2,
+		Max:       3,
+		Average:   2.5,
+		Histogram: []*histogramBucket{{UpperBound: 3, Count: 2}},
+	}, report.BlockTransactions)
+}
+
+func TestChainStats_Merge(t *testing.T) {
+	var blocks []*pbaptos.Block
+	for height := uint64(0); height < 10; height++ {
+		block := newTestBlock(height)
+		if height%3 == 0 {
+			block.Transactions = append(block.Transactions, newTestUserTransaction(height, "0xa1", nil))
+		}
+		blocks = append(blocks, block)
+	}
+
+	all := newChainStats()
+	for _, block := range blocks {
+		all.AddBlock(block)
+	}
+
+	// Bundles are computed concurrently and merged, which must give the same result
+	merged := newChainStats()
+	for _, bundle := range [][]*pbaptos.Block{blocks[:4], blocks[4:], nil} {
+		bundleStats := newChainStats()
+		for _, block := range bundle {
+			bundleStats.AddBlock(block)
+		}
+
+		merged.Merge(bundleStats)
+	}
+
+	assert.Equal(t, all.Report(0), merged.Report(0))
+}
+
+func TestChainStats_EmptyReport(t *testing.T) {
+	report := newChainStats().Report(10)
+
+	assert.Equal(t, uint64(0), report.BlockCount)
+	assert.Equal(t, float64(0), report.FailureRate)
+	assert.Equal(t, &distributionSummary{}, report.GasUsed)
+	assert.Empty(t, report.TopSenders)
+}
+
+func TestDistribution(t *testing.T) {
+	distribution := newDistribution()
+	for _, value := range []uint64{0, 1, 2, 3, 4, 7, 8, math.MaxUint64} {
+		distribution.Add(value)
+	}
+
+	summary := distribution.Summary()
+	assert.Equal(t, uint64(8), summary.Count)
+	assert.Equal(t, uint64(0), summary.Min)
+	assert.Equal(t, uint64(math.MaxUint64), summary.Max)
+	assert.Equal(t, []*histogramBucket{
+		{UpperBound: 0, Count: 1},
+		{UpperBound: 1, Count: 1},
+		{UpperBound: 3, Count: 2},
+		{UpperBound: 7, Count: 2},
+		{UpperBound: 15, Count: 1},
+		{UpperBound: math.MaxUint64, Count: 1},
+	}, summary.Histogram)
+}
+
+func TestTopCounts(t *testing.T) {
+	counts := map[string]uint64{"c": 1, "b": 5, "a": 5, "d": 2}
+
+	assert.Equal(t, []*keyCount{{Key: "a", Count: 5}, {Key: "b", Count: 5}, {Key: "d", Count: 2}}, topCounts(counts, 3))
+
+	all := topCounts(counts, 0)
+	require.Len(t, all, 4)
+	assert.Equal(t, "c", all[3].Key)
+}
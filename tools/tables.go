@@ -0,0 +1,253 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/tables"
+)
+
+var tablesCmd = &cobra.Command{Use: "tables", Short: "Tracks the content of Move tables and the resources owning them"}
+
+var tablesBuildCmd = &cobra.Command{
+	Use:   "build {merged-blocks-store-url}",
+	Short: "Builds or updates the table tracker from merged blocks",
+	Long: string(cli.Description(`
+		Replays the 'WriteTableItem' and 'DeleteTableItem' changes of the merged blocks into the tables directory,
+		keeping the full history of each item of the tables selected by '--handle' (all tables when none is
+		provided). Written resources are scanned to attribute each table handle to the resource and field
+		holding it.
+
+		The tracker is saved every '--save-interval' blocks and when the command exits, even interrupted, running
+		the command again resumes from the block following the last saved one. The tables directory can't be
+		read by 'list' or 'show' while it's being built.
+	`)),
+	Args: cobra.ExactArgs(1),
+	RunE: tablesBuildE,
+	Example: ExamplePrefixed("fireaptos tools tables build", `
+		./firehose-data/storage/merged-blocks --tables-dir ./tables --range 0:99999
+		./firehose-data/storage/merged-blocks --tables-dir ./tables --handle 0x1b854694ae746cdbd8d44186ca4929b2b337df21d1c74633be19b2710552fdca
+	`),
+}
+
+var tablesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the tracked tables with their owner, key and value types",
+	Args:  cobra.NoArgs,
+	RunE:  tablesListE,
+	Example: ExamplePrefixed("fireaptos tools tables list", `
+		--tables-dir ./tables
+	`),
+}
+
+var tablesShowCmd = &cobra.Command{
+	Use:   "show <handle> [<key>]",
+	Short: "Prints the owner and content of a table, or the value of one of its items, at a given version",
+	Long: string(cli.Description(`
+		Prints the owner and the items of the table live at '--version'. When a key is provided, either in its
+		hex encoded raw form or in its JSON decoded form, only the value of that item is printed, or all its
+		changes with '--history'.
+	`)),
+	Args: cobra.RangeArgs(1, 2),
+	RunE: tablesShowE,
+	Example: ExamplePrefixed("fireaptos tools tables show", `
+		0xabc --tables-dir ./tables --version 1200000
+		0xabc '"My Collection"' --tables-dir ./tables --history
+	`),
+}
+
+func init() {
+	Cmd.AddCommand(tablesCmd)
+	tablesCmd.AddCommand(tablesBuildCmd)
+	tablesCmd.AddCommand(tablesListCmd)
+	tablesCmd.AddCommand(tablesShowCmd)
+
+	tablesCmd.PersistentFlags().String("tables-dir", "", "Directory holding the table tracker")
+
+	tablesBuildCmd.Flags().StringP("range", "r", "", "Block range to process, in the form '<start>:<stop>' (inclusive, '<stop>' is optional), the start being ignored when resuming")
+	tablesBuildCmd.Flags().StringSlice("handle", nil, "Only track the items of these tables, can be repeated")
+	tablesBuildCmd.Flags().Uint64("save-interval", 10000, "Amount of blocks applied between two saves of the tracker")
+	tablesBuildCmd.Flags().Uint64("bundle-size", defaultBundleSize, "Amount of blocks contained in each merged blocks file")
+
+	tablesShowCmd.Flags().Uint64("version", 0, "Version at which to show the table, 0 meaning the last tracked version")
+	tablesShowCmd.Flags().Bool("history", false, "Print all the changes of the item instead of its value at a given version, requires a key")
+}
+
+func tablesBuildE(cmd *cobra.Command, args []string) error {
+	blockRange, err := getBlockRange(cmd, "range")
+	if err != nil {
+		return err
+	}
+
+	mergedBlocksStore, err := dstore.NewDBinStore(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to create store at path %q: %w", args[0], err)
+	}
+
+	tracker, err := openTableTracker(cmd, tables.Options{
+		Handles:      mustGetStringSlice(cmd, "handle"),
+		SaveInterval: mustGetUint64(cmd, "save-interval"),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = applyMergedBlocks(ctx, mergedBlocksStore, tracker, blockRange, mustGetUint64(cmd, "bundle-size"))
+	if errors.Is(err, context.Canceled) {
+		zlog.Info("build interrupted")
+		err = nil
+	}
+
+	var all []*tables.Table
+	if err == nil {
+		all, err = tracker.Tables()
+	}
+
+	// Closing the tracker saves it, so even an interrupted build resumes from its last applied block
+	if closeErr := tracker.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("save tables: %w", closeErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lastBlock, _ := tracker.LastBlock()
+	fmt.Printf("Table tracker built up to block #%d, %d tables\n", lastBlock, len(all))
+	return nil
+}
+
+func tablesListE(cmd *cobra.Command, args []string) error {
+	tracker, err := openExistingTableTracker(cmd)
+	if err != nil {
+		return err
+	}
+	defer tracker.Close()
+
+	all, err := tracker.Tables()
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "HANDLE\tOWNER\tKEY TYPE\tVALUE TYPE\tITEMS")
+
+	for _, table := range all {
+		owner := "unknown"
+		if table.Owner != nil {
+			owner = table.Owner.String()
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\n", table.Handle, owner, table.KeyType, table.ValueType, table.ItemCount)
+	}
+
+	writer.Flush()
+	fmt.Printf("\n%d table(s)\n", len(all))
+	return nil
+}
+
+func tablesShowE(cmd *cobra.Command, args []string) error {
+	tracker, err := openExistingTableTracker(cmd)
+	if err != nil {
+		return err
+	}
+	defer tracker.Close()
+
+	handle := args[0]
+	version := mustGetUint64(cmd, "version")
+	if version == 0 {
+		version = ^uint64(0)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if len(args) == 2 {
+		if mustGetBool(cmd, "history") {
+			history, err := tracker.History(handle, args[1])
+			if err != nil {
+				return err
+			}
+
+			if len(history) == 0 {
+				return fmt.Errorf("no changes found for key %s of table %s", args[1], handle)
+			}
+
+			return encoder.Encode(history)
+		}
+
+		value, err := tracker.Get(handle, args[1], version)
+		if err != nil {
+			if errors.Is(err, tables.ErrNotFound) {
+				return fmt.Errorf("key %s of table %s not found", args[1], handle)
+			}
+
+			return err
+		}
+
+		return encoder.Encode(value)
+	}
+
+	out := struct {
+		Handle string              `json:"handle"`
+		Owner  *tables.Owner       `json:"owner,omitempty"`
+		Items  []*tables.ItemValue `json:"items"`
+	}{Handle: handle}
+
+	if out.Owner, err = tracker.Owner(handle); err != nil {
+		return err
+	}
+
+	if out.Items, err = tracker.Content(handle, version); err != nil {
+		return err
+	}
+
+	return encoder.Encode(out)
+}
+
+func openTableTracker(cmd *cobra.Command, options tables.Options) (*tables.Tracker, error) {
+	tablesDir := mustGetString(cmd, "tables-dir")
+	if tablesDir == "" {
+		return nil, fmt.Errorf("the --tables-dir flag is required")
+	}
+
+	tracker, err := tables.Open(tablesDir, options)
+	if err != nil {
+		return nil, fmt.Errorf("open table tracker: %w", err)
+	}
+
+	return tracker, nil
+}
+
+func openExistingTableTracker(cmd *cobra.Command) (*tables.Tracker, error) {
+	// Opening the tracker creates it, a mistyped directory must not be
+	if tablesDir := mustGetString(cmd, "tables-dir"); tablesDir != "" {
+		if _, err := os.Stat(tablesDir); err != nil {
+			return nil, fmt.Errorf("invalid tables directory: %w", err)
+		}
+	}
+
+	tracker, err := openTableTracker(cmd, tables.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := tracker.LastBlock(); !ok {
+		tracker.Close()
+		return nil, fmt.Errorf("table tracker in %q is empty, build it first with 'fireaptos tools tables build'", mustGetString(cmd, "tables-dir"))
+	}
+
+	return tracker, nil
+}
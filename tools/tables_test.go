@@ -0,0 +1,110 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-aptos/tables"
+	pbaptos "github.com/streamingfast/firehose-aptos/types/pb/aptos/extractor/v1"
+	sftools "github.com/streamingfast/sf-tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTableTracker_Resume(t *testing.T) {
+	mergedBlocksStore := newTestTablesStore(t)
+	tablesDir := t.TempDir()
+
+	// Like an interrupted build, the tracker is closed before the range stop
+	tracker, err := tables.Open(tablesDir, tables.Options{Handles: []string{"0xabc"}, SaveInterval: 50})
+	require.NoError(t, err)
+	require.NoError(t, applyMergedBlocks(context.Background(), mergedBlocksStore, tracker, sftools.BlockRange{Start: 0, Stop: 179}, 100))
+	require.NoError(t, tracker.Close())
+
+	tracker, err = tables.Open(tablesDir, tables.Options{})
+	require.NoError(t, err)
+
+	lastBlock, _ := tracker.LastBlock()
+	assert.Equal(t, uint64(179), lastBlock)
+
+	value, err := tracker.Get("0xabc", `"Punks"`, 399)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(170), value.Version)
+
+	// The range start is ignored when resuming, blocks already applied would otherwise be rejected
+	require.NoError(t, applyMergedBlocks(context.Background(), mergedBlocksStore, tracker, sftools.BlockRange{Start: 0}, 100))
+	require.NoError(t, tracker.Close())
+
+	tracker, err = tables.Open(tablesDir, tables.Options{})
+	require.NoError(t, err)
+	defer tracker.Close()
+
+	lastBlock, _ = tracker.LastBlock()
+	assert.Equal(t, uint64(399), lastBlock)
+
+	value, err = tracker.Get("0xabc", `"Punks"`, 299)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(170), value.Version)
+	assert.Equal(t, `{"supply":"2"}`, value.Value)
+
+	value, err = tracker.Get("0xabc", `"Punks"`, 399)
+	require.NoError(t, err)
+	assert.True(t, value.Deleted)
+
+	// The handles restriction of the first build is kept when resuming
+	all, err := tracker.Tables()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "0xabc", all[0].Handle)
+
+	owner, err := tracker.Owner("0xdef")
+	require.NoError(t, err)
+	require.NotNil(t, owner)
+	assert.Equal(t, "tokens.inner", owner.Field)
+}
+
+// newTestTablesStore writes blocks `[0, 400[` in a temporary store, where account `0xa1` holds the
+// collections table `0xabc` and the tokens table `0xdef` from version 20. In table `0xabc`, key `0x01`
+// ("Punks") is written at versions 120 and 170 then deleted at version 300, key `0x03` of table `0xdef`
+// is written at version 260.
+func newTestTablesStore(t *testing.T) dstore.Store {
+	t.Helper()
+
+	changes := map[uint64][]*pbaptos.WriteSetChange{
+		20: {
+			{Change: &pbaptos.WriteSetChange_WriteResource{WriteResource: &pbaptos.WriteResource{
+				Address: "0xa1", TypeStr: "0x3::token::Collections", Data: `{"collection_data":{"handle":"0xabc"}}`,
+			}}},
+			{Change: &pbaptos.WriteSetChange_WriteResource{WriteResource: &pbaptos.WriteResource{
+				Address: "0xa1", TypeStr: "0x3::token::TokenStore", Data: `{"tokens":{"inner":{"handle":"0xdef"},"length":"0"}}`,
+			}}},
+		},
+		120: {newTestWriteTableItem("0xabc", "0x01", `"Punks"`, `{"supply":"1"}`)},
+		170: {newTestWriteTableItem("0xabc", "0x01", `"Punks"`, `{"supply":"2"}`)},
+		260: {newTestWriteTableItem("0xdef", "0x03", `{"name":"Punk #1"}`, `{"amount":"1"}`)},
+		300: {{Change: &pbaptos.WriteSetChange_DeleteTableItem{DeleteTableItem: &pbaptos.DeleteTableItem{
+			Handle: "0xabc", Key: "0x01", Data: &pbaptos.DeleteTableData{Key: `"Punks"`},
+		}}}},
+	}
+
+	store := newTestEmptyStore(t)
+	writeTestMergedBlocks(t, store, 0, 400, func(height uint64) *pbaptos.Block {
+		block := newTestBlock(height)
+		if blockChanges, found := changes[height]; found {
+			block.Transactions[0].Info = &pbaptos.TransactionInfo{Success: true, Changes: blockChanges}
+		}
+
+		return block
+	})
+
+	return store
+}
+
+func newTestWriteTableItem(handle, key, decodedKey, value string) *pbaptos.WriteSetChange {
+	return &pbaptos.WriteSetChange{Change: &pbaptos.WriteSetChange_WriteTableItem{WriteTableItem: &pbaptos.WriteTableItem{
+		Handle: handle,
+		Key:    key,
+		Data:   &pbaptos.WriteTableData{Key: decodedKey, Value: value},
+	}}}
+}